@@ -16,6 +16,8 @@
 package cmd
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"path"
 	"regexp"
@@ -207,3 +209,28 @@ func TestKafkaSuccess(t *testing.T) {
 	assert.Equal([]string{"broker1", "broker2"}, restGatewayConf.Kafka.Brokers)
 	test.Teardown(tmpdir)
 }
+
+func TestWalletMigrate(t *testing.T) {
+	assert := assert.New(t)
+
+	walletDir, _ := ioutil.TempDir("", "wallet")
+	defer os.RemoveAll(walletDir)
+	identity := `{"version":1,"mspId":"org1MSP","type":"X.509","credentials":{"certificate":"cert1","privateKey":"key1"}}`
+	assert.NoError(ioutil.WriteFile(path.Join(walletDir, "user1.id"), []byte(identity), 0600))
+
+	rootCmd, _ := newRootCmd()
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	rootCmd.SetArgs([]string{"wallet", "migrate", "--wallet-path", walletDir, "--wallet-passphrase", "pass1"})
+	err := rootCmd.Execute()
+	assert.NoError(err)
+	assert.Equal("Encrypted 1 identities in the wallet\n", out.String())
+	content, _ := ioutil.ReadFile(path.Join(walletDir, "user1.id"))
+	assert.Contains(string(content), "encryptedPrivateKey")
+	assert.NotContains(string(content), "key1")
+
+	rootCmd, _ = newRootCmd()
+	rootCmd.SetArgs([]string{"wallet", "migrate", "--wallet-path", walletDir})
+	err = rootCmd.Execute()
+	assert.Regexp("The wallet encryption is not configured", err)
+}
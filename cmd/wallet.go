@@ -0,0 +1,51 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/wallet"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func newWalletCmd(restGatewayConf *conf.RESTGatewayConf) *cobra.Command {
+	walletCmd := &cobra.Command{
+		Use:   "wallet",
+		Short: "Manage the wallet of the client-side gateway",
+	}
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Encrypt in place the private keys stored in plaintext in the wallet, with the configured rpc.wallet.encryption key",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := viper.Unmarshal(&restGatewayConf); err != nil {
+				return err
+			}
+			initLogging(rootConfig.DebugLevel)
+			migrated, err := wallet.Migrate(restGatewayConf.RPC.Wallet)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Encrypted %d identities in the wallet\n", migrated)
+			return nil
+		},
+	}
+	walletCmd.AddCommand(migrateCmd)
+	return walletCmd
+}
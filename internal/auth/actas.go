@@ -0,0 +1,107 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"path"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/signermap"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+)
+
+var serviceAccounts map[string][]string
+var signerMappings signermap.Store
+
+// RegisterSignerMappings sets the store of the Fabric identities each user may sign with,
+// see ResolveSigner
+func RegisterSignerMappings(store signermap.Store) {
+	signerMappings = store
+}
+
+// RegisterServiceAccounts sets the signers each service account may act as, replacing
+// any previous registration. See ResolveSigner
+func RegisterServiceAccounts(accounts []conf.ServiceAccount) {
+	serviceAccounts = make(map[string][]string, len(accounts))
+	for _, account := range accounts {
+		serviceAccounts[account.Username] = append(serviceAccounts[account.Username], account.ActAs...)
+	}
+}
+
+// ValidateServiceAccounts checks every service account has a username and valid act-as patterns
+func ValidateServiceAccounts(accounts []conf.ServiceAccount) error {
+	for i, account := range accounts {
+		if account.Username == "" || len(account.ActAs) == 0 {
+			return errors.Errorf(errors.ConfigServiceAccountInvalid, i)
+		}
+		for _, pattern := range account.ActAs {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return errors.Errorf(errors.ConfigServiceAccountPattern, pattern, account.Username)
+			}
+		}
+	}
+	return nil
+}
+
+// GetUsername extracts the username of the authenticated caller
+func GetUsername(ctx context.Context) string {
+	v, _ := ctx.Value(ContextKeyUsername).(string)
+	return v
+}
+
+// ResolveSigner returns the Fabric signer of a request made on a channel with the signer the
+// caller asked for, which may be empty. Authenticated callers sign with one of the identities
+// of their signer mapping when they have one, and as themselves otherwise. Service accounts may
+// also act as the signers in their allowlist. Without authentication the requested signer is
+// used as is, while an authenticated caller without a username cannot sign
+func ResolveSigner(ctx context.Context, requested, channel string) (string, error) {
+	username := GetUsername(ctx)
+	if username == "" {
+		if GetAuthContext(ctx) != nil {
+			return "", errors.Errorf(errors.SecurityNoUsername)
+		}
+		return requested, nil
+	}
+	if signerMappings != nil {
+		mapping, err := signerMappings.Get(username)
+		if err != nil {
+			return "", err
+		}
+		if mapping != nil && (requested == "" || !actsAs(username, requested)) {
+			return mapping.Select(requested, channel)
+		}
+	}
+	switch {
+	case requested == "":
+		return username, nil
+	case requested == username || actsAs(username, requested):
+		return requested, nil
+	default:
+		return "", errors.Errorf(errors.SecurityActAsForbidden, username, requested)
+	}
+}
+
+// actsAs checks the signer is in the allowlist of a service account
+func actsAs(username, signer string) bool {
+	for _, pattern := range serviceAccounts[username] {
+		if globMatches(pattern, signer) {
+			return true
+		}
+	}
+	return false
+}
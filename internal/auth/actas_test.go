@@ -0,0 +1,124 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/signermap"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	mocksignermap "github.com/hyperledger/firefly-fabconnect/mocks/auth/signermap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSigner(t *testing.T) {
+	assert := assert.New(t)
+	RegisterServiceAccounts([]conf.ServiceAccount{
+		{Username: "service-account-billing", ActAs: []string{"org1-pool-*", "alice"}},
+	})
+	defer RegisterServiceAccounts(nil)
+
+	// no authentication
+	signer, err := ResolveSigner(context.Background(), "bob", "ch1")
+	assert.NoError(err)
+	assert.Equal("bob", signer)
+
+	// authenticated, without a username
+	_, err = ResolveSigner(context.WithValue(context.Background(), ContextKeyAuthContext, true), "bob", "ch1")
+	assert.Regexp("The authenticated caller has no username to sign as", err)
+
+	ctx := context.WithValue(context.Background(), ContextKeyUsername, "user1")
+	signer, err = ResolveSigner(ctx, "", "ch1")
+	assert.NoError(err)
+	assert.Equal("user1", signer)
+	signer, err = ResolveSigner(ctx, "user1", "ch1")
+	assert.NoError(err)
+	assert.Equal("user1", signer)
+	_, err = ResolveSigner(ctx, "alice", "ch1")
+	assert.Regexp("'user1' is not allowed to act as signer 'alice'", err)
+
+	ctx = context.WithValue(context.Background(), ContextKeyUsername, "service-account-billing")
+	signer, err = ResolveSigner(ctx, "", "ch1")
+	assert.NoError(err)
+	assert.Equal("service-account-billing", signer)
+	signer, err = ResolveSigner(ctx, "alice", "ch1")
+	assert.NoError(err)
+	assert.Equal("alice", signer)
+	signer, err = ResolveSigner(ctx, "org1-pool-3", "ch1")
+	assert.NoError(err)
+	assert.Equal("org1-pool-3", signer)
+	_, err = ResolveSigner(ctx, "bob", "ch1")
+	assert.Regexp("'service-account-billing' is not allowed to act as signer 'bob'", err)
+}
+
+func TestValidateServiceAccounts(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateServiceAccounts([]conf.ServiceAccount{{Username: "svc", ActAs: []string{"*"}}}))
+	assert.Regexp("Service account 0 must have", ValidateServiceAccounts([]conf.ServiceAccount{{ActAs: []string{"*"}}}))
+	assert.Regexp("Service account 1 must have", ValidateServiceAccounts([]conf.ServiceAccount{
+		{Username: "svc", ActAs: []string{"*"}},
+		{Username: "svc2"},
+	}))
+	assert.Regexp("Invalid act-as pattern 'user\\[' for service account 'svc'", ValidateServiceAccounts([]conf.ServiceAccount{{Username: "svc", ActAs: []string{"user["}}}))
+}
+
+func TestResolveSignerMappings(t *testing.T) {
+	assert := assert.New(t)
+	store := &mocksignermap.Store{}
+	store.On("Get", "alice").Return(&signermap.Mapping{User: "alice", Identities: []signermap.Identity{
+		{Signer: "alice-ch1", Channels: []string{"ch1"}},
+		{Signer: "alice-approver"},
+	}}, nil)
+	store.On("Get", "bob").Return(nil, nil).Once()
+	store.On("Get", "bob").Return(nil, fmt.Errorf("pop"))
+	RegisterSignerMappings(store)
+	defer RegisterSignerMappings(nil)
+	RegisterServiceAccounts([]conf.ServiceAccount{{Username: "alice", ActAs: []string{"pool-*"}}})
+	defer RegisterServiceAccounts(nil)
+
+	ctx := context.WithValue(context.Background(), ContextKeyUsername, "alice")
+	signer, err := ResolveSigner(ctx, "", "ch1")
+	assert.NoError(err)
+	assert.Equal("alice-ch1", signer)
+	signer, err = ResolveSigner(ctx, "", "ch2")
+	assert.NoError(err)
+	assert.Equal("alice-approver", signer)
+	signer, err = ResolveSigner(ctx, "alice-approver", "ch1")
+	assert.NoError(err)
+	assert.Equal("alice-approver", signer)
+	_, err = ResolveSigner(ctx, "alice-ch1", "ch2")
+	assert.Regexp("'alice' is not allowed to sign as 'alice-ch1' on channel 'ch2'", err)
+	// the username is not an identity of the mapping
+	_, err = ResolveSigner(ctx, "alice", "ch1")
+	assert.Regexp("'alice' is not allowed to act as signer 'alice'", err)
+	// the act-as allowlist still applies
+	signer, err = ResolveSigner(ctx, "pool-1", "ch1")
+	assert.NoError(err)
+	assert.Equal("pool-1", signer)
+
+	// users without a mapping sign as themselves
+	ctx = context.WithValue(context.Background(), ContextKeyUsername, "bob")
+	signer, err = ResolveSigner(ctx, "", "ch1")
+	assert.NoError(err)
+	assert.Equal("bob", signer)
+
+	_, err = ResolveSigner(ctx, "", "ch1")
+	assert.Regexp("pop", err)
+}
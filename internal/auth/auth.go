@@ -18,15 +18,19 @@ package auth
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	jwt2 "github.com/hyperledger/firefly-fabconnect/internal/jwt"
+	"github.com/hyperledger/firefly-fabconnect/internal/utils"
 	"github.com/hyperledger/firefly-fabconnect/pkg/plugins"
+	log "github.com/sirupsen/logrus"
 )
 
 type ContextKey int
@@ -37,15 +41,33 @@ const (
 	ContextKeyAccessToken
 	ContextKeyUsername
 	ContextKeySubID
+	ContextKeyRoute
+	ContextKeyExpiry
+	ContextKeyClaims
 )
 
+// WebSocketNamespace is the namespace of AuthRPCSubscribe for listening on WebSocket topics
+const WebSocketNamespace = "ws"
+
 var securityModule plugins.SecurityModule
 
+// idpTimeout bounds the requests to the IdP, for discovery, keys and introspection
+const idpTimeout = 30 * time.Second
+
+var verifiersMux sync.Mutex
+var verifiers = make(map[string]*jwt2.JwtTokenVerifier)
+var introspectionVerifiers = make(map[string]*jwt2.IntrospectionVerifier)
+
 // RegisterSecurityModule is the plug point to register a security module
 func RegisterSecurityModule(sm plugins.SecurityModule) {
 	securityModule = sm
 }
 
+// SecurityModuleRegistered checks a plugin or policy authorizes the requests
+func SecurityModuleRegistered() bool {
+	return securityModule != nil
+}
+
 // NewSystemAuthContext creates a system background context
 func NewSystemAuthContext() context.Context {
 	return context.WithValue(context.Background(), ContextKeySystemAuth, true)
@@ -57,67 +79,246 @@ func IsSystemContext(ctx context.Context) bool {
 	return ok && b
 }
 
-// WithAuthContext adds an access token to a base context
-func WithAuthContext(ctx context.Context, url string, token string, config conf.OpenIDConfig) (context.Context, error) {
+// WithAuthContext adds an access token to a base context. The public paths are not authenticated,
+// see IsPublicPath
+func WithAuthContext(ctx context.Context, token string, config conf.OpenIDConfig) (context.Context, error) {
+	if securityModule != nil {
+		ctxValue, err := securityModule.VerifyToken(token)
+		if err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(ctx, ContextKeyAccessToken, token)
+		ctx = context.WithValue(ctx, ContextKeyAuthContext, ctxValue)
+		username, err := moduleUsername(ctxValue, config)
+		if err != nil {
+			return nil, err
+		}
+		if username != "" {
+			ctx = context.WithValue(ctx, ContextKeyUsername, username)
+		}
+		if identity, ok := ctxValue.(plugins.Identity); ok {
+			ctx = context.WithValue(ctx, ContextKeySubID, identity.Subject())
+		}
+		if expiring, ok := ctxValue.(plugins.Expiring); ok && !expiring.ExpiresAt().IsZero() {
+			ctx = context.WithValue(ctx, ContextKeyExpiry, expiring.ExpiresAt())
+		}
+		if claims, ok := ctxValue.(plugins.Claims); ok {
+			ctx = context.WithValue(ctx, ContextKeyClaims, claims.TokenClaims())
+		}
+		return ctx, nil
+	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	if !idpConfigured(config) {
+		// only reached when the auth mode is not none, a token cannot be trusted without an IdP
+		return nil, errors.Errorf(errors.SecurityModuleNoIdP)
+	}
+	claims, err := VerifyAccessToken(ctx, token, config)
+	if err != nil {
+		return nil, err
 	}
 
-	client := &http.Client{Transport: tr}
+	username, err := UsernameFromClaims(claims, config)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, ContextKeyAccessToken, token)
+	ctx = context.WithValue(ctx, ContextKeyUsername, username)
+	ctx = context.WithValue(ctx, ContextKeySubID, claims["sub"])
+	ctx = context.WithValue(ctx, ContextKeyAuthContext, true)
+	ctx = context.WithValue(ctx, ContextKeyClaims, map[string]interface{}(claims))
+	if exp, ok := claims["exp"].(float64); ok {
+		ctx = context.WithValue(ctx, ContextKeyExpiry, time.Unix(int64(exp), 0))
+	}
+	return ctx, nil
+}
 
-	httpClient := jwt2.JWKHttpClient{HttpClient: client}
+// moduleUsername maps the auth context of a security module to the username of the caller, like
+// the claims of a JWT. The openId.usernameClaim is read from the claims the module exposes, and the
+// username of its identity is used otherwise, both with the openId.usernameTransforms applied
+func moduleUsername(ctxValue interface{}, config conf.OpenIDConfig) (string, error) {
+	if claims, ok := ctxValue.(plugins.Claims); ok {
+		username, err := UsernameFromClaims(claims.TokenClaims(), config)
+		if err == nil || config.UsernameClaim != "" {
+			// a configured claim must be present, as with the tokens verified against the IdP
+			return username, err
+		}
+	}
+	if identity, ok := ctxValue.(plugins.Identity); ok && identity.Username() != "" {
+		return transformUsernames(identity.Username(), config.UsernameTransforms)
+	}
+	return "", nil
+}
 
-	claimsMap := make(map[string]interface{})
+// VerifyAccessToken verifies an access token against the configured IdP, and returns its claims.
+// Opaque tokens are introspected when introspection is configured, and JWTs are verified with the
+// keys of the IdP unless only introspection is configured
+func VerifyAccessToken(ctx context.Context, token string, config conf.OpenIDConfig) (jwt.MapClaims, error) {
+	if config.Introspection.ClientID != "" {
+		if !isJWT(token) || (issuerFromConfig(config) == "" && config.JWKSUri == "") {
+			return introspect(ctx, token, config)
+		}
+	}
+	return VerifyJWT(ctx, token, config)
+}
 
-	if url != "/api" && url != "/spec.yaml" && url != "/ws" {
+// VerifyJWT verifies a JWT access token against the configured IdP, and returns its claims
+func VerifyJWT(ctx context.Context, token string, config conf.OpenIDConfig) (jwt.MapClaims, error) {
+	issuer := issuerFromConfig(config)
+	if issuer == "" && config.JWKSUri == "" {
+		return nil, errors.Errorf(errors.SecurityModuleNoIdP)
+	}
+	verifier, err := getTokenVerifier(issuer, config)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	claims := verified.Claims.(jwt.MapClaims)
+	log.Debugf("Verified access token of %s issued by %s", claims["sub"], claims["iss"])
+	return claims, nil
+}
 
-		verifier := jwt2.JwtTokenVerifier{
-			// JWKSUri:          "https://iam.mgtappsrv.makeen.ye/realms/makeen/protocol/openid-connect/certs",
-			JWKSUri:          fmt.Sprintf("%s/realms/makeen/protocol/openid-connect/certs", config.Host),
-			HTTPClient:       &httpClient,
-			ClaimsToValidate: claimsMap,
-		}
+func introspect(ctx context.Context, token string, config conf.OpenIDConfig) (jwt.MapClaims, error) {
+	verifier, err := getIntrospectionVerifier(issuerFromConfig(config), config)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := verifier.Introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Introspected access token of %s", claims["sub"])
+	return claims, nil
+}
 
-		ctxValue, err := verifier.ValidateToken(ctx, token)
-		newToken, _ := verifier.Parse(ctx, token)
+// a JWT has a header, a payload and a signature separated by dots, opaque tokens are anything else
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
 
-		claims := newToken.Claims.(jwt.MapClaims)
+// idpConfigured checks there is an IdP to verify the access tokens with
+func idpConfigured(config conf.OpenIDConfig) bool {
+	issuer := issuerFromConfig(config)
+	introspection := config.Introspection.ClientID != "" && (config.Introspection.Endpoint != "" || issuer != "")
+	return issuer != "" || config.JWKSUri != "" || introspection
+}
 
-		username := claims["preferred_username"]
-		sub := claims["sub"]
+// the issuer is either configured explicitly, or derived from the Keycloak host and realm
+func issuerFromConfig(config conf.OpenIDConfig) string {
+	if config.Issuer != "" {
+		return strings.TrimSuffix(config.Issuer, "/")
+	}
+	if config.Host != "" && config.ClientRealm != "" {
+		return fmt.Sprintf("%s/realms/%s", strings.TrimSuffix(config.Host, "/"), config.ClientRealm)
+	}
+	return ""
+}
 
-		// claims = newToken.Claims.(jwt.MapClaims)
-		// data := claims["data"].(map[string]interface{})
-		// sid := data["sub"].(string)
+// verifiers are kept across requests, so the discovery document is only fetched once per issuer
+func getTokenVerifier(issuer string, config conf.OpenIDConfig) (*jwt2.JwtTokenVerifier, error) {
+	key := issuer + "|" + config.JWKSUri + "|" + strings.Join(config.Algorithms, ",")
+	verifiersMux.Lock()
+	defer verifiersMux.Unlock()
+	if verifier, ok := verifiers[key]; ok {
+		return verifier, nil
+	}
 
-		fmt.Println(newToken.Valid)
-		fmt.Println(username)
-		fmt.Println(sub)
+	if err := jwt2.CheckAlgorithms(config.Algorithms); err != nil {
+		return nil, err
+	}
+	httpClient, err := newIdPClient(config)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	keySets := jwt2.NewKeySetCache(context.Background(), httpClient, jwt2.KeySetCacheConfig{
+		RefreshInterval:    time.Duration(config.JWKSCache.RefreshInterval) * time.Second,
+		MinRefreshInterval: time.Duration(config.JWKSCache.MinRefreshInterval) * time.Second,
+		GracePeriod:        time.Duration(config.JWKSCache.GracePeriod) * time.Second,
+	})
+	verifier := &jwt2.JwtTokenVerifier{
+		HTTPClient:       httpClient,
+		KeySets:          keySets,
+		JWKSUri:          config.JWKSUri,
+		Issuer:           issuer,
+		ClaimsToValidate: claimsToValidate(issuer, config),
+		Leeway:           time.Duration(config.ClockSkew) * time.Second,
+		Algorithms:       config.Algorithms,
+	}
+	verifiers[key] = verifier
+	return verifier, nil
+}
 
-		ctx = context.WithValue(ctx, ContextKeyAccessToken, token)
-		ctx = context.WithValue(ctx, ContextKeyUsername, username)
-		ctx = context.WithValue(ctx, ContextKeySubID, sub)
-		ctx = context.WithValue(ctx, ContextKeyAuthContext, ctxValue)
+// introspection verifiers are kept across requests, to cache the active tokens
+func getIntrospectionVerifier(issuer string, config conf.OpenIDConfig) (*jwt2.IntrospectionVerifier, error) {
+	key := issuer + "|" + config.Introspection.Endpoint + "|" + config.Introspection.ClientID
+	verifiersMux.Lock()
+	defer verifiersMux.Unlock()
+	if verifier, ok := introspectionVerifiers[key]; ok {
+		return verifier, nil
+	}
 
+	httpClient, err := newIdPClient(config)
+	if err != nil {
+		return nil, err
 	}
+	verifier := &jwt2.IntrospectionVerifier{
+		HTTPClient:       httpClient,
+		Endpoint:         config.Introspection.Endpoint,
+		Issuer:           issuer,
+		ClientID:         config.Introspection.ClientID,
+		ClientSecret:     config.Introspection.ClientSecret,
+		ClaimsToValidate: claimsToValidate(issuer, config),
+		Leeway:           time.Duration(config.ClockSkew) * time.Second,
+		MaxCacheTime:     time.Duration(config.Introspection.MaxCacheTime) * time.Second,
+		MaxCacheSize:     config.Introspection.MaxCacheSize,
+	}
+	introspectionVerifiers[key] = verifier
+	return verifier, nil
+}
 
-	return ctx, nil
+func newIdPClient(config conf.OpenIDConfig) (*jwt2.JWKHttpClient, error) {
+	tlsConfig, err := utils.CreateTLSConfiguration(&config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &jwt2.JWKHttpClient{HttpClient: &http.Client{Transport: transport, Timeout: idpTimeout}}, nil
+}
+
+// CloseVerifiers stops the background refreshes of the IdP keys, the verifiers are created
+// again on the next verification
+func CloseVerifiers() {
+	verifiersMux.Lock()
+	defer verifiersMux.Unlock()
+	for key, verifier := range verifiers {
+		if verifier.KeySets != nil {
+			verifier.KeySets.Close()
+		}
+		delete(verifiers, key)
+	}
+}
 
-	// if securityModule != nil {
-	// 	ctxValue, err := securityModule.VerifyToken(token)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	ctx = context.WithValue(ctx, ContextKeyAccessToken, token)
-	// 	ctx = context.WithValue(ctx, ContextKeyAuthContext, ctxValue)
-	// 	return ctx, nil
-	// }
-	// return ctx, nil
+func claimsToValidate(issuer string, config conf.OpenIDConfig) map[string]interface{} {
+	claims := make(map[string]interface{})
+	for k, v := range config.RequiredClaims {
+		claims[k] = v
+	}
+	if issuer != "" {
+		claims["iss"] = issuer
+	}
+	if len(config.Audiences) > 0 {
+		claims["aud"] = config.Audiences
+	}
+	if config.AuthorizedParty != "" {
+		claims["azp"] = config.AuthorizedParty
+	}
+	return claims
 }
 
 // GetAuthContext extracts a previously stored auth context from the context
@@ -125,6 +326,37 @@ func GetAuthContext(ctx context.Context) interface{} {
 	return ctx.Value(ContextKeyAuthContext)
 }
 
+// WithRoute records the REST route being called, such as "POST /query", which is the method
+// passed to AuthRPC when the Fabric call made by the route is authorized
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, ContextKeyRoute, route)
+}
+
+// GetRoute extracts the REST route recorded by WithRoute
+func GetRoute(ctx context.Context) string {
+	v, _ := ctx.Value(ContextKeyRoute).(string)
+	return v
+}
+
+// GetSubject extracts the unique ID of the authenticated caller
+func GetSubject(ctx context.Context) string {
+	v, _ := ctx.Value(ContextKeySubID).(string)
+	return v
+}
+
+// GetExpiry extracts the time the credentials of the caller expire, which is zero when they do not
+func GetExpiry(ctx context.Context) time.Time {
+	v, _ := ctx.Value(ContextKeyExpiry).(time.Time)
+	return v
+}
+
+// GetClaims extracts the claims of the verified access token of the caller, which are nil when the
+// caller is not authenticated with a token or the security module does not expose them
+func GetClaims(ctx context.Context) map[string]interface{} {
+	v, _ := ctx.Value(ContextKeyClaims).(map[string]interface{})
+	return v
+}
+
 // GetAccessToken extracts a previously stored access token
 func GetAccessToken(ctx context.Context) string {
 	v, ok := ctx.Value(ContextKeyAccessToken).(string)
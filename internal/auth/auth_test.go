@@ -18,9 +18,18 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/hyperledger/firefly-fabconnect/internal/auth/authtest"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/lestrrat-go/jwx/jwk"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,14 +46,12 @@ func TestSystemContext(t *testing.T) {
 func TestAccessToken(t *testing.T) {
 	assert := assert.New(t)
 
-	ctx, err := WithAuthContext(context.Background(), "testat")
-	assert.NoError(err)
-	assert.Equal("", GetAccessToken(ctx))
-	assert.Equal(nil, GetAuthContext(ctx))
+	_, err := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
+	assert.Regexp("No OpenID issuer or JWKS URI configured", err)
 
 	RegisterSecurityModule(&authtest.TestSecurityModule{})
 
-	ctx, err = WithAuthContext(context.Background(), "testat")
+	ctx, err := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
 	assert.NoError(err)
 	assert.Equal("verified", GetAuthContext(ctx))
 	assert.Equal("testat", GetAccessToken(ctx))
@@ -52,12 +59,248 @@ func TestAccessToken(t *testing.T) {
 	assert.Equal(nil, GetAuthContext(context.Background()))
 	assert.Equal("", GetAccessToken(context.Background()))
 
-	_, err = WithAuthContext(context.Background(), "badone")
+	_, err = WithAuthContext(context.Background(), "badone", conf.OpenIDConfig{})
 	assert.EqualError(err, "badness")
 
 	RegisterSecurityModule(nil)
 }
 
+type testClaimsContext struct {
+	authtest.TestIdentity
+	claims map[string]interface{}
+}
+
+func (c testClaimsContext) TokenClaims() map[string]interface{} {
+	return c.claims
+}
+
+type testClaimsModule struct {
+	authtest.TestSecurityModule
+	claims map[string]interface{}
+}
+
+func (sm *testClaimsModule) VerifyToken(tok string) (interface{}, error) {
+	return testClaimsContext{TestIdentity: authtest.TestIdentity(sm.Username), claims: sm.claims}, nil
+}
+
+func TestAccessTokenModuleUsername(t *testing.T) {
+	assert := assert.New(t)
+	defer RegisterSecurityModule(nil)
+
+	// the username of the identity is transformed
+	RegisterSecurityModule(&authtest.TestSecurityModule{Username: "Alice@Example.com"})
+	config := conf.OpenIDConfig{UsernameTransforms: []string{"localpart", "lowercase"}}
+	ctx, err := WithAuthContext(context.Background(), "testat", config)
+	assert.NoError(err)
+	assert.Equal("alice", GetUsername(ctx))
+	assert.Equal("Alice@Example.com-id", GetSubject(ctx))
+
+	// the username claim is read from the claims of the module
+	module := &testClaimsModule{claims: map[string]interface{}{"email": "Bob@Example.com"}}
+	module.Username = "plugin-bob"
+	RegisterSecurityModule(module)
+	config.UsernameClaim = "email"
+	ctx, err = WithAuthContext(context.Background(), "testat", config)
+	assert.NoError(err)
+	assert.Equal("bob", GetUsername(ctx))
+
+	// the identity applies when the claims have no preferred_username and no claim is configured
+	config = conf.OpenIDConfig{}
+	ctx, err = WithAuthContext(context.Background(), "testat", config)
+	assert.NoError(err)
+	assert.Equal("plugin-bob", GetUsername(ctx))
+	module.claims["preferred_username"] = "bobby"
+	ctx, err = WithAuthContext(context.Background(), "testat", config)
+	assert.NoError(err)
+	assert.Equal("bobby", GetUsername(ctx))
+
+	// a configured claim must be present
+	config.UsernameClaim = "fabric.user"
+	_, err = WithAuthContext(context.Background(), "testat", config)
+	assert.Regexp("fabric.user", err)
+}
+
+func newTestKeycloak(t *testing.T, realm string) (*httptest.Server, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/"+realm+"/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL + "/realms/" + realm,
+			"jwks_uri":               server.URL + "/realms/" + realm + "/protocol/openid-connect/certs",
+			"introspection_endpoint": server.URL + "/realms/" + realm + "/protocol/openid-connect/token/introspect",
+		})
+	})
+	mux.HandleFunc("/realms/"+realm+"/protocol/openid-connect/token/introspect", func(w http.ResponseWriter, r *http.Request) {
+		if clientID, secret, _ := r.BasicAuth(); clientID != "fabconnect" || secret != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		response := map[string]interface{}{"active": false}
+		if r.FormValue("token") == "opaque-user2" {
+			response = map[string]interface{}{
+				"active":   true,
+				"iss":      server.URL + "/realms/" + realm,
+				"sub":      "user2-id",
+				"username": "user2",
+				"aud":      "fabconnect",
+				"exp":      time.Now().Add(time.Minute).Unix(),
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("/realms/"+realm+"/protocol/openid-connect/certs", func(w http.ResponseWriter, r *http.Request) {
+		pub, _ := jwk.New(&key.PublicKey)
+		_ = pub.Set(jwk.KeyIDKey, "key1")
+		set := jwk.NewSet()
+		set.Add(pub)
+		_ = json.NewEncoder(w).Encode(set)
+	})
+	server = httptest.NewServer(mux)
+	return server, key
+}
+
+func signTestToken(key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key1"
+	signed, _ := token.SignedString(key)
+	return signed
+}
+
+func TestAccessTokenJWT(t *testing.T) {
+	assert := assert.New(t)
+
+	server, key := newTestKeycloak(t, "org1")
+	defer server.Close()
+	config := conf.OpenIDConfig{
+		Host:           server.URL,
+		ClientRealm:    "org1",
+		Audiences:      []string{"fabconnect"},
+		RequiredClaims: map[string]interface{}{"typ": "Bearer"},
+	}
+	claims := jwt.MapClaims{
+		"iss":                server.URL + "/realms/org1",
+		"sub":                "user1-id",
+		"aud":                "fabconnect",
+		"typ":                "Bearer",
+		"preferred_username": "user1",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	}
+
+	ctx, err := WithAuthContext(context.Background(), signTestToken(key, claims), config)
+	assert.NoError(err)
+	assert.Equal("user1", ctx.Value(ContextKeyUsername))
+	assert.Equal("user1-id", GetSubject(ctx))
+	assert.NotEmpty(GetAccessToken(ctx))
+	assert.Equal(time.Unix(claims["exp"].(int64), 0), GetExpiry(ctx))
+	assert.Equal("Bearer", GetClaims(ctx)["typ"])
+
+	claims["aud"] = "account"
+	_, err = WithAuthContext(context.Background(), signTestToken(key, claims), config)
+	assert.Regexp("invalid claim: aud", err)
+
+	// a token issued by another realm of the same IdP is rejected
+	claims["aud"] = "fabconnect"
+	claims["iss"] = server.URL + "/realms/org2"
+	_, err = WithAuthContext(context.Background(), signTestToken(key, claims), config)
+	assert.Regexp("invalid claim: iss", err)
+
+	claims["iss"] = server.URL + "/realms/org1"
+	config.Algorithms = []string{"ES256"}
+	_, err = WithAuthContext(context.Background(), signTestToken(key, claims), config)
+	assert.Regexp("signing method RS256 is invalid", err)
+
+	config.Algorithms = []string{"HS256"}
+	_, err = WithAuthContext(context.Background(), signTestToken(key, claims), config)
+	assert.Regexp("unsupported token signing algorithm: HS256", err)
+}
+
+func TestAccessTokenIntrospection(t *testing.T) {
+	assert := assert.New(t)
+
+	server, key := newTestKeycloak(t, "org3")
+	defer server.Close()
+	config := conf.OpenIDConfig{
+		Host:        server.URL,
+		ClientRealm: "org3",
+		Audiences:   []string{"fabconnect"},
+		Introspection: conf.IntrospectionConf{
+			ClientID:     "fabconnect",
+			ClientSecret: "secret",
+		},
+	}
+
+	ctx, err := WithAuthContext(context.Background(), "opaque-user2", config)
+	assert.NoError(err)
+	assert.Equal("user2", ctx.Value(ContextKeyUsername))
+	assert.Equal("user2-id", GetSubject(ctx))
+	assert.False(GetExpiry(ctx).IsZero())
+
+	_, err = WithAuthContext(context.Background(), "opaque-revoked", config)
+	assert.Regexp("token is not active", err)
+
+	// JWTs are still verified with the keys of the IdP
+	ctx, err = WithAuthContext(context.Background(), signTestToken(key, jwt.MapClaims{
+		"iss":                server.URL + "/realms/org3",
+		"sub":                "user1-id",
+		"aud":                "fabconnect",
+		"preferred_username": "user1",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	}), config)
+	assert.NoError(err)
+	assert.Equal("user1", ctx.Value(ContextKeyUsername))
+}
+
+type testIdentity struct{}
+
+func (i *testIdentity) Subject() string  { return "user1-id" }
+func (i *testIdentity) Username() string { return "user1" }
+func (i *testIdentity) TokenClaims() map[string]interface{} {
+	return map[string]interface{}{"roles": []interface{}{"admin"}}
+}
+
+type testIdentityModule struct {
+	authtest.TestSecurityModule
+}
+
+func (sm *testIdentityModule) VerifyToken(tok string) (interface{}, error) {
+	return &testIdentity{}, nil
+}
+
+func TestAccessTokenIdentityFromSecurityModule(t *testing.T) {
+	assert := assert.New(t)
+
+	RegisterSecurityModule(&testIdentityModule{})
+	defer RegisterSecurityModule(nil)
+
+	ctx, err := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
+	assert.NoError(err)
+	assert.Equal("user1", ctx.Value(ContextKeyUsername))
+	assert.Equal("user1-id", ctx.Value(ContextKeySubID))
+	assert.IsType(&testIdentity{}, GetAuthContext(ctx))
+	assert.Equal([]string{"admin"}, ClaimStrings(GetClaims(ctx), "roles"))
+}
+
+func TestVerifyJWTNoIdP(t *testing.T) {
+	_, err := VerifyJWT(context.Background(), "token", conf.OpenIDConfig{Host: "https://kc"})
+	assert.Regexp(t, "No OpenID issuer or JWKS URI configured", err)
+}
+
+func TestRoute(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", GetRoute(context.Background()))
+	assert.Equal("POST /query", GetRoute(WithRoute(context.Background(), "POST /query")))
+}
+
+func TestIssuerFromConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("https://idp.example.com/oauth2", issuerFromConfig(conf.OpenIDConfig{Issuer: "https://idp.example.com/oauth2/", Host: "https://kc", ClientRealm: "r"}))
+	assert.Equal("https://kc/realms/r", issuerFromConfig(conf.OpenIDConfig{Host: "https://kc/", ClientRealm: "r"}))
+	assert.Equal("", issuerFromConfig(conf.OpenIDConfig{Host: "https://kc"}))
+}
+
 func TestAuthRPC(t *testing.T) {
 	assert := assert.New(t)
 
@@ -69,7 +312,7 @@ func TestAuthRPC(t *testing.T) {
 
 	assert.NoError(AuthRPC(NewSystemAuthContext(), "anything"))
 
-	ctx, _ := WithAuthContext(context.Background(), "testat")
+	ctx, _ := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
 	assert.NoError(AuthRPC(ctx, "testrpc"))
 	assert.EqualError(AuthRPC(ctx, "anything"), "badness")
 
@@ -88,7 +331,7 @@ func TestAuthRPCSubscribe(t *testing.T) {
 
 	assert.NoError(AuthRPCSubscribe(NewSystemAuthContext(), "anything", nil))
 
-	ctx, _ := WithAuthContext(context.Background(), "testat")
+	ctx, _ := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
 	assert.NoError(AuthRPCSubscribe(ctx, "testns", nil))
 	assert.EqualError(AuthRPCSubscribe(ctx, "anything", nil), "badness")
 
@@ -107,7 +350,7 @@ func TestAuthEventStreams(t *testing.T) {
 
 	assert.NoError(AuthEventStreams(NewSystemAuthContext()))
 
-	ctx, _ := WithAuthContext(context.Background(), "testat")
+	ctx, _ := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
 	assert.NoError(AuthEventStreams(ctx))
 
 	RegisterSecurityModule(nil)
@@ -125,7 +368,7 @@ func TestAuthListAsyncReplies(t *testing.T) {
 
 	assert.NoError(AuthListAsyncReplies(NewSystemAuthContext()))
 
-	ctx, _ := WithAuthContext(context.Background(), "testat")
+	ctx, _ := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
 	assert.NoError(AuthListAsyncReplies(ctx))
 
 	RegisterSecurityModule(nil)
@@ -143,7 +386,7 @@ func TestAuthReadAsyncReplyByUUID(t *testing.T) {
 
 	assert.NoError(AuthReadAsyncReplyByUUID(NewSystemAuthContext()))
 
-	ctx, _ := WithAuthContext(context.Background(), "testat")
+	ctx, _ := WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
 	assert.NoError(AuthReadAsyncReplyByUUID(ctx))
 
 	RegisterSecurityModule(nil)
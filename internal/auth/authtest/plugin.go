@@ -0,0 +1,37 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authtest
+
+import (
+	"os/exec"
+	"path"
+	"runtime"
+	"testing"
+)
+
+// BuildSamplePlugin builds the sample security module plugin in test/plugins/securitymodule
+// into dir, and returns the path of the .so. The test is skipped where plugins cannot be built
+func BuildSamplePlugin(t *testing.T, dir string) string {
+	_, thisFile, _, _ := runtime.Caller(0)
+	source := path.Join(path.Dir(thisFile), "..", "..", "..", "test", "plugins", "securitymodule")
+	output := path.Join(dir, "securitymodule.so")
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", output, source)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("Unable to build the sample security module plugin: %s\n%s", err, out)
+	}
+	return output
+}
@@ -18,24 +18,44 @@ package authtest
 
 import (
 	"fmt"
+	"strings"
 )
 
 // TestSecurityModule designed for unit testing - does not implement security
-type TestSecurityModule struct{}
+type TestSecurityModule struct {
+	// Username is the caller of the verified tokens, the auth context has no identity when empty
+	Username string
+}
+
+// TestIdentity is the auth context of the tokens verified by a TestSecurityModule with a Username
+type TestIdentity string
+
+// Subject of the TEST IDENTITY is derived from its username
+func (i TestIdentity) Subject() string {
+	return string(i) + "-id"
+}
+
+// Username of the TEST IDENTITY
+func (i TestIdentity) Username() string {
+	return string(i)
+}
 
 // VerifyToken of TEST MODULE checks if a token matches a fixed string
 func (sm *TestSecurityModule) VerifyToken(tok string) (interface{}, error) {
 	if tok == "testat" {
+		if sm.Username != "" {
+			return TestIdentity(sm.Username), nil
+		}
 		return "verified", nil
 	}
 	return nil, fmt.Errorf("badness")
 }
 
-// AuthRPC of TEST MODULE checks if a token matches a fixed string
+// AuthRPC of TEST MODULE checks if a method matches a fixed string, REST routes are all allowed
 func (sm *TestSecurityModule) AuthRPC(authCtx interface{}, method string, args ...interface{}) error {
 	switch authCtx.(type) {
-	case string:
-		if method == "testrpc" {
+	case string, TestIdentity:
+		if method == "testrpc" || strings.Contains(method, " /") {
 			return nil
 		}
 	}
@@ -45,7 +65,7 @@ func (sm *TestSecurityModule) AuthRPC(authCtx interface{}, method string, args .
 // AuthRPCSubscribe of TEST MODULE checks if a namespace matches a fixed string
 func (sm *TestSecurityModule) AuthRPCSubscribe(authCtx interface{}, namespace string, channel interface{}, args ...interface{}) error {
 	switch authCtx.(type) {
-	case string:
+	case string, TestIdentity:
 		if namespace == "testns" {
 			return nil
 		}
@@ -56,7 +76,7 @@ func (sm *TestSecurityModule) AuthRPCSubscribe(authCtx interface{}, namespace st
 // AuthEventStreams of TEST MODULE returns true if there is an auth context
 func (sm *TestSecurityModule) AuthEventStreams(authCtx interface{}) error {
 	switch authCtx.(type) {
-	case string:
+	case string, TestIdentity:
 		return nil
 	}
 	return fmt.Errorf("badness")
@@ -65,7 +85,7 @@ func (sm *TestSecurityModule) AuthEventStreams(authCtx interface{}) error {
 // AuthListAsyncReplies of TEST MODULE returns true if there is an auth context
 func (sm *TestSecurityModule) AuthListAsyncReplies(authCtx interface{}) error {
 	switch authCtx.(type) {
-	case string:
+	case string, TestIdentity:
 		return nil
 	}
 	return fmt.Errorf("badness")
@@ -74,7 +94,7 @@ func (sm *TestSecurityModule) AuthListAsyncReplies(authCtx interface{}) error {
 // AuthReadAsyncReplyByUUID of TEST MODULE returns true if there is an auth context
 func (sm *TestSecurityModule) AuthReadAsyncReplyByUUID(authCtx interface{}) error {
 	switch authCtx.(type) {
-	case string:
+	case string, TestIdentity:
 		return nil
 	}
 	return fmt.Errorf("badness")
@@ -0,0 +1,142 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+)
+
+// UsernameFromClaims maps the claims of a verified access token to the username of the caller,
+// with the claim and transforms of the configuration. The claim can be a dotted path into
+// nested objects, such as "fabric.user"
+func UsernameFromClaims(claims map[string]interface{}, config conf.OpenIDConfig) (string, error) {
+	var value string
+	if config.UsernameClaim == "" {
+		// introspection responses carry the "username" of RFC 7662 rather than preferred_username
+		value, _ = claims["preferred_username"].(string)
+		if value == "" {
+			value, _ = claims["username"].(string)
+		}
+		if value == "" {
+			return "", errors.Errorf(errors.SecurityNoUsernameClaim, "preferred_username")
+		}
+	} else {
+		value = claimString(claims, config.UsernameClaim)
+		if value == "" {
+			return "", errors.Errorf(errors.SecurityNoUsernameClaim, config.UsernameClaim)
+		}
+	}
+	return transformUsernames(value, config.UsernameTransforms)
+}
+
+// transformUsernames applies the openId.usernameTransforms to a username, in order
+func transformUsernames(value string, transforms []string) (string, error) {
+	for _, transform := range transforms {
+		var err error
+		if value, err = transformUsername(transform, value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// ValidateUsernameTransforms checks the transforms in openId.usernameTransforms are known
+func ValidateUsernameTransforms(transforms []string) error {
+	for _, transform := range transforms {
+		if _, err := transformUsername(transform, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transformUsername(transform, value string) (string, error) {
+	name, arg := transform, ""
+	if i := strings.Index(transform, ":"); i >= 0 {
+		name, arg = transform[:i], transform[i+1:]
+	}
+	switch name {
+	case "lowercase":
+		return strings.ToLower(value), nil
+	case "uppercase":
+		return strings.ToUpper(value), nil
+	case "localpart":
+		if i := strings.LastIndex(value, "@"); i >= 0 {
+			return value[:i], nil
+		}
+		return value, nil
+	case "prefix":
+		return arg + value, nil
+	case "suffix":
+		return value + arg, nil
+	default:
+		return "", errors.Errorf(errors.SecurityUsernameTransformUnknown, transform)
+	}
+}
+
+// claimString looks the claim up by its full name first, so claims with dots in their names
+// such as URLs work, then as a path into nested objects. Numbers are formatted as strings
+func claimString(claims map[string]interface{}, name string) string {
+	switch v := claimValue(claims, name).(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%v", v)
+	default:
+		return ""
+	}
+}
+
+// ClaimStrings returns a list claim, such as the roles of the caller, looked up like the username
+// claim. A string claim is split on spaces, like the "scope" claim
+func ClaimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claimValue(claims, name).(type) {
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	case string:
+		return strings.Fields(v)
+	default:
+		return nil
+	}
+}
+
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	if value, ok := claims[name]; ok {
+		return value
+	}
+	var current interface{} = claims
+	for _, segment := range strings.Split(name, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[segment]
+	}
+	return current
+}
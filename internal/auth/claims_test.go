@@ -0,0 +1,96 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsernameFromClaims(t *testing.T) {
+	assert := assert.New(t)
+	claims := map[string]interface{}{
+		"sub":                        "0c9d5c6e",
+		"preferred_username":         "Alice",
+		"email":                      "Alice.Smith@Example.com",
+		"employee_id":                float64(1234),
+		"https://example.com/fabric": "alice-fabric",
+		"fabric": map[string]interface{}{
+			"user": "alice-nested",
+		},
+	}
+
+	tests := []struct {
+		claim      string
+		transforms []string
+		username   string
+	}{
+		{"", nil, "Alice"},
+		{"sub", nil, "0c9d5c6e"},
+		{"email", []string{"localpart", "lowercase"}, "alice.smith"},
+		{"email", []string{"uppercase"}, "ALICE.SMITH@EXAMPLE.COM"},
+		{"employee_id", []string{"prefix:emp-", "suffix:@org1"}, "emp-1234@org1"},
+		{"https://example.com/fabric", nil, "alice-fabric"},
+		{"fabric.user", nil, "alice-nested"},
+	}
+	for _, test := range tests {
+		username, err := UsernameFromClaims(claims, conf.OpenIDConfig{UsernameClaim: test.claim, UsernameTransforms: test.transforms})
+		assert.NoError(err)
+		assert.Equal(test.username, username)
+	}
+
+	// introspection responses
+	username, err := UsernameFromClaims(map[string]interface{}{"username": "bob"}, conf.OpenIDConfig{})
+	assert.NoError(err)
+	assert.Equal("bob", username)
+
+	_, err = UsernameFromClaims(map[string]interface{}{"sub": "0c9d5c6e"}, conf.OpenIDConfig{})
+	assert.Regexp("The access token has no 'preferred_username' claim", err)
+	_, err = UsernameFromClaims(map[string]interface{}{"preferred_username": 42}, conf.OpenIDConfig{})
+	assert.Regexp("no 'preferred_username' claim", err)
+	_, err = UsernameFromClaims(claims, conf.OpenIDConfig{UsernameClaim: "fabric.user.name"})
+	assert.Regexp("no 'fabric.user.name' claim", err)
+	_, err = UsernameFromClaims(claims, conf.OpenIDConfig{UsernameClaim: "missing"})
+	assert.Regexp("no 'missing' claim", err)
+	_, err = UsernameFromClaims(claims, conf.OpenIDConfig{UsernameTransforms: []string{"reverse"}})
+	assert.Regexp("Unknown username transform 'reverse'", err)
+}
+
+func TestClaimStrings(t *testing.T) {
+	assert := assert.New(t)
+
+	claims := map[string]interface{}{
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"admin", 42, "auditor"}},
+		"scope":              "openid profile",
+		"https://example/ns": []string{"a"},
+		"exp":                1.0,
+	}
+	assert.Equal([]string{"admin", "auditor"}, ClaimStrings(claims, "realm_access.roles"))
+	assert.Equal([]string{"openid", "profile"}, ClaimStrings(claims, "scope"))
+	assert.Equal([]string{"a"}, ClaimStrings(claims, "https://example/ns"))
+	assert.Nil(ClaimStrings(claims, "exp"))
+	assert.Nil(ClaimStrings(claims, "realm_access.missing.roles"))
+	assert.Nil(ClaimStrings(nil, "roles"))
+}
+
+func TestValidateUsernameTransforms(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(ValidateUsernameTransforms([]string{"lowercase", "uppercase", "localpart", "prefix:a", "suffix:b"}))
+	assert.Regexp("Unknown username transform 'trim'", ValidateUsernameTransforms([]string{"lowercase", "trim"}))
+}
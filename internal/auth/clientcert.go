@@ -0,0 +1,99 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"path"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+)
+
+// WithClientCertContext authenticates the caller with the verified TLS client certificate of the
+// connection. The signer is the one of the first mapping matching the certificate, or its common
+// name when there are no mappings
+func WithClientCertContext(ctx context.Context, state *tls.ConnectionState, mappings []conf.ClientCertMapping) (context.Context, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, errors.Errorf(errors.SecurityModuleNoClientCert)
+	}
+	cert := state.VerifiedChains[0][0]
+	signer := cert.Subject.CommonName
+	if len(mappings) > 0 {
+		signer = mapClientCert(cert, mappings)
+		if signer == "" {
+			return nil, errors.Errorf(errors.SecurityClientCertNotMapped, cert.Subject)
+		}
+	}
+	ctx = context.WithValue(ctx, ContextKeyUsername, signer)
+	ctx = context.WithValue(ctx, ContextKeySubID, cert.Subject.String())
+	ctx = context.WithValue(ctx, ContextKeyAuthContext, cert)
+	ctx = context.WithValue(ctx, ContextKeyExpiry, cert.NotAfter)
+	return ctx, nil
+}
+
+// ValidateClientCertMappings checks every mapping has a valid pattern and a signer
+func ValidateClientCertMappings(mappings []conf.ClientCertMapping) error {
+	for i, m := range mappings {
+		if (m.Subject == "" && m.SAN == "") || m.Signer == "" {
+			return errors.Errorf(errors.ConfigClientCertMappingInvalid, i)
+		}
+		for _, pattern := range []string{m.Subject, m.SAN} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Errorf(errors.ConfigClientCertMappingPattern, pattern, i)
+			}
+		}
+	}
+	return nil
+}
+
+func mapClientCert(cert *x509.Certificate, mappings []conf.ClientCertMapping) string {
+	for _, m := range mappings {
+		if m.Subject != "" && !globMatches(m.Subject, cert.Subject.String()) {
+			continue
+		}
+		if m.SAN != "" && !sanMatches(m.SAN, cert) {
+			continue
+		}
+		return m.Signer
+	}
+	return ""
+}
+
+func sanMatches(pattern string, cert *x509.Certificate) bool {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if globMatches(pattern, san) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatches(pattern, value string) bool {
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
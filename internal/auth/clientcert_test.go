@@ -0,0 +1,103 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func verifiedState(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestWithClientCertContext(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := WithClientCertContext(context.Background(), nil, nil)
+	assert.Regexp("No verified TLS client certificate", err)
+	_, err = WithClientCertContext(context.Background(), &tls.ConnectionState{}, nil)
+	assert.Regexp("No verified TLS client certificate", err)
+
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "user1", Organization: []string{"org1"}},
+		NotAfter: notAfter,
+	}
+	ctx, err := WithClientCertContext(context.Background(), verifiedState(cert), nil)
+	assert.NoError(err)
+	assert.Equal("user1", ctx.Value(ContextKeyUsername))
+	assert.Equal("CN=user1,O=org1", ctx.Value(ContextKeySubID))
+	assert.Equal(cert, GetAuthContext(ctx))
+	assert.Equal(notAfter, GetExpiry(ctx))
+}
+
+func TestWithClientCertContextMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	spiffe, _ := url.Parse("spiffe://example.com/billing")
+	mappings := []conf.ClientCertMapping{
+		{Subject: "CN=ops*,OU=admins,O=org1", Signer: "admin"},
+		{SAN: "spiffe://example.com/*", Signer: "billing-signer"},
+		{SAN: "*.payments.example.com", Signer: "payments-signer"},
+		{SAN: "10.0.0.*", Signer: "internal-signer"},
+		{Subject: "*,O=org2", SAN: "*@org2.example.com", Signer: "org2-signer"},
+	}
+	tests := []struct {
+		cert   *x509.Certificate
+		signer string
+	}{
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "ops1", OrganizationalUnit: []string{"admins"}, Organization: []string{"org1"}}}, "admin"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "svc"}, URIs: []*url.URL{spiffe}}, "billing-signer"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "svc"}, DNSNames: []string{"api.payments.example.com"}}, "payments-signer"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "svc"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.7")}}, "internal-signer"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "bob", Organization: []string{"org2"}}, EmailAddresses: []string{"bob@org2.example.com"}}, "org2-signer"},
+	}
+	for _, test := range tests {
+		ctx, err := WithClientCertContext(context.Background(), verifiedState(test.cert), mappings)
+		assert.NoError(err)
+		assert.Equal(test.signer, ctx.Value(ContextKeyUsername))
+		assert.Equal(test.cert.Subject.String(), ctx.Value(ContextKeySubID))
+	}
+
+	// both patterns of a mapping must match
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "bob", Organization: []string{"org2"}}, EmailAddresses: []string{"bob@example.com"}}
+	_, err := WithClientCertContext(context.Background(), verifiedState(cert), mappings)
+	assert.Regexp("No signer is mapped to the client certificate of 'CN=bob,O=org2'", err)
+}
+
+func TestValidateClientCertMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateClientCertMappings(nil))
+	assert.NoError(ValidateClientCertMappings([]conf.ClientCertMapping{{Subject: "CN=*", Signer: "user1"}}))
+	assert.Regexp("Client certificate mapping 1 must have a subject or SAN pattern, and a signer", ValidateClientCertMappings([]conf.ClientCertMapping{
+		{SAN: "*.example.com", Signer: "user1"},
+		{Signer: "user2"},
+	}))
+	assert.Regexp("Client certificate mapping 0 must have", ValidateClientCertMappings([]conf.ClientCertMapping{{Subject: "CN=*"}}))
+	assert.Regexp("Invalid pattern 'CN=\\[' in client certificate mapping 0", ValidateClientCertMappings([]conf.ClientCertMapping{{Subject: "CN=[", Signer: "user1"}}))
+}
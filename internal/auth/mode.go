@@ -0,0 +1,148 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+)
+
+// The auth modes of a deployment, configured with security.authMode
+const (
+	// AuthModeNone does not authenticate the callers, for development and test setups without an IdP
+	AuthModeNone = "none"
+	// AuthModeJWT verifies the bearer access tokens against the OpenID IdP
+	AuthModeJWT = "jwt"
+	// AuthModePlugin verifies the bearer tokens with the security module plugin
+	AuthModePlugin = "plugin"
+	// AuthModeMTLS authenticates the callers with their TLS client certificates
+	AuthModeMTLS = "mtls"
+)
+
+// DefaultPublicPaths are served without authentication, unless security.publicPaths is configured
+var DefaultPublicPaths = []string{"/api", "/spec.yaml", "/ws", "/status"}
+
+// ResolveAuthMode checks the configured auth mode is consistent with the rest of the security
+// configuration. When no mode is configured, it is the plugin mode if a plugin is configured, and
+// the JWT mode if an IdP or a policy is configured. Serving the API without authentication must
+// be configured explicitly, so a typo in the security settings does not disable it
+func ResolveAuthMode(security conf.SecurityConf, openID conf.OpenIDConfig) (string, error) {
+	hasIdP := idpConfigured(openID)
+	switch security.AuthMode {
+	case "":
+		switch {
+		case security.PluginPath != "":
+			return AuthModePlugin, nil
+		case security.PolicyFile != "" || hasIdP:
+			return AuthModeJWT, nil
+		}
+		if setting := authSetting(security, openID); setting != "" {
+			return "", errors.Errorf(errors.SecurityAuthModePartial, setting)
+		}
+		return "", errors.Errorf(errors.SecurityAuthModeRequired)
+	case AuthModeNone:
+		if security.PluginPath != "" {
+			return "", errors.Errorf(errors.SecurityAuthModeConflict, "security.pluginPath", security.AuthMode)
+		}
+		if security.PolicyFile != "" {
+			return "", errors.Errorf(errors.SecurityAuthModeConflict, "security.policyFile", security.AuthMode)
+		}
+		if setting := authSetting(security, openID); setting != "" {
+			return "", errors.Errorf(errors.SecurityAuthModeConflict, setting, security.AuthMode)
+		}
+	case AuthModeMTLS:
+		if security.PluginPath != "" {
+			return "", errors.Errorf(errors.SecurityAuthModeConflict, "security.pluginPath", security.AuthMode)
+		}
+		if security.PolicyFile != "" {
+			return "", errors.Errorf(errors.SecurityAuthModeConflict, "security.policyFile", security.AuthMode)
+		}
+	case AuthModeJWT:
+		if security.PluginPath != "" {
+			return "", errors.Errorf(errors.SecurityAuthModeConflict, "security.pluginPath", security.AuthMode)
+		}
+		if !hasIdP {
+			return "", errors.Errorf(errors.SecurityModuleNoIdP)
+		}
+	case AuthModePlugin:
+		if security.PluginPath == "" {
+			return "", errors.Errorf(errors.SecurityAuthModePluginPath)
+		}
+	default:
+		return "", errors.Errorf(errors.SecurityAuthModeUnknown, security.AuthMode)
+	}
+	return security.AuthMode, nil
+}
+
+// authSetting returns the first setting that only applies to authenticated callers, which
+// has no effect when the callers are not authenticated. The IdP itself is not one, as it
+// may only be configured to provision the users
+func authSetting(security conf.SecurityConf, openID conf.OpenIDConfig) string {
+	switch {
+	case openID.Introspection.ClientID != "" || openID.Introspection.Endpoint != "":
+		return "openId.introspection"
+	case len(openID.Audiences) > 0:
+		return "openId.audiences"
+	case openID.AuthorizedParty != "":
+		return "openId.authorizedParty"
+	case len(openID.RequiredClaims) > 0:
+		return "openId.requiredClaims"
+	case openID.UsernameClaim != "":
+		return "openId.usernameClaim"
+	case len(openID.UsernameTransforms) > 0:
+		return "openId.usernameTransforms"
+	case openID.Admin.Role != "":
+		return "openId.admin.role"
+	case len(security.ClientCertMappings) > 0:
+		return "security.clientCertMappings"
+	case len(security.ServiceAccounts) > 0:
+		return "security.serviceAccounts"
+	}
+	return ""
+}
+
+// IsPublicPath checks a request path, without its query string, against route patterns such as
+// "/status" or "/receipts/:id". A ":name" or "*" segment matches any single segment, and a
+// trailing "**" matches the rest of the path
+func IsPublicPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if PathMatches(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// PathMatches compares a path to a single route pattern, see IsPublicPath
+func PathMatches(pattern, path string) bool {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range patternSegments {
+		if segment == "**" && i == len(patternSegments)-1 {
+			return true
+		}
+		if i >= len(pathSegments) {
+			return false
+		}
+		if segment != "*" && !strings.HasPrefix(segment, ":") && segment != pathSegments[i] {
+			return false
+		}
+	}
+	return len(patternSegments) == len(pathSegments)
+}
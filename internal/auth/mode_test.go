@@ -0,0 +1,85 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAuthMode(t *testing.T) {
+	idp := conf.OpenIDConfig{Issuer: "https://idp.example.com/realms/org1"}
+	tests := []struct {
+		name     string
+		security conf.SecurityConf
+		openID   conf.OpenIDConfig
+		mode     string
+		err      string
+	}{
+		{"default without IdP", conf.SecurityConf{}, conf.OpenIDConfig{}, "", "security.authMode must be configured, set it to 'none'"},
+		{"default with partial IdP", conf.SecurityConf{}, conf.OpenIDConfig{Audiences: []string{"fabconnect"}}, "", "openId.audiences is configured, but no IdP, policy or plugin"},
+		{"default with service accounts", conf.SecurityConf{ServiceAccounts: []conf.ServiceAccount{{Username: "billing"}}}, conf.OpenIDConfig{}, "", "security.serviceAccounts is configured, but no IdP"},
+		{"default with IdP", conf.SecurityConf{}, idp, AuthModeJWT, ""},
+		{"default with JWKS", conf.SecurityConf{}, conf.OpenIDConfig{JWKSUri: "https://idp.example.com/certs"}, AuthModeJWT, ""},
+		{"default with introspection", conf.SecurityConf{}, conf.OpenIDConfig{Introspection: conf.IntrospectionConf{Endpoint: "https://idp.example.com/introspect", ClientID: "fabconnect"}}, AuthModeJWT, ""},
+		{"default with policy", conf.SecurityConf{PolicyFile: "policy.yaml"}, conf.OpenIDConfig{}, AuthModeJWT, ""},
+		{"default with plugin", conf.SecurityConf{PluginPath: "sm.so"}, idp, AuthModePlugin, ""},
+		{"none", conf.SecurityConf{AuthMode: "none"}, idp, AuthModeNone, ""},
+		{"none with admin role", conf.SecurityConf{AuthMode: "none"}, conf.OpenIDConfig{Admin: conf.IdentityAdminConf{Role: "identity-admin"}}, "", "openId.admin.role cannot be configured with the 'none' auth mode"},
+		{"none with policy", conf.SecurityConf{AuthMode: "none", PolicyFile: "policy.yaml"}, idp, "", "security.policyFile cannot be configured with the 'none' auth mode"},
+		{"jwt", conf.SecurityConf{AuthMode: "jwt", PolicyFile: "policy.yaml"}, idp, AuthModeJWT, ""},
+		{"jwt without IdP", conf.SecurityConf{AuthMode: "jwt"}, conf.OpenIDConfig{}, "", "No OpenID issuer or JWKS URI configured"},
+		{"jwt with plugin", conf.SecurityConf{AuthMode: "jwt", PluginPath: "sm.so"}, idp, "", "security.pluginPath cannot be configured with the 'jwt' auth mode"},
+		{"plugin", conf.SecurityConf{AuthMode: "plugin", PluginPath: "sm.so"}, conf.OpenIDConfig{}, AuthModePlugin, ""},
+		{"plugin without path", conf.SecurityConf{AuthMode: "plugin"}, idp, "", "security.pluginPath must be configured"},
+		{"mtls", conf.SecurityConf{AuthMode: "mtls"}, conf.OpenIDConfig{}, AuthModeMTLS, ""},
+		{"mtls with plugin", conf.SecurityConf{AuthMode: "mtls", PluginPath: "sm.so"}, conf.OpenIDConfig{}, "", "security.pluginPath cannot be configured with the 'mtls' auth mode"},
+		{"unknown", conf.SecurityConf{AuthMode: "basic"}, idp, "", "Unknown auth mode 'basic'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mode, err := ResolveAuthMode(test.security, test.openID)
+			if test.err == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, test.mode, mode)
+			} else {
+				assert.Regexp(t, test.err, err)
+			}
+		})
+	}
+}
+
+func TestIsPublicPath(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsPublicPath(DefaultPublicPaths, "/status"))
+	assert.True(IsPublicPath(DefaultPublicPaths, "/ws"))
+	assert.True(IsPublicPath(DefaultPublicPaths, "/ws/"))
+	assert.False(IsPublicPath(DefaultPublicPaths, "/wsx"))
+	assert.False(IsPublicPath(DefaultPublicPaths, "/transactions"))
+	assert.False(IsPublicPath(nil, "/status"))
+
+	patterns := []string{"/receipts/:id", "/metrics/**", "/blocks/*/txs"}
+	assert.True(IsPublicPath(patterns, "/receipts/abc"))
+	assert.False(IsPublicPath(patterns, "/receipts"))
+	assert.False(IsPublicPath(patterns, "/receipts/abc/def"))
+	assert.True(IsPublicPath(patterns, "/metrics"))
+	assert.True(IsPublicPath(patterns, "/metrics/go/gc"))
+	assert.True(IsPublicPath(patterns, "/blocks/10/txs"))
+	assert.False(IsPublicPath(patterns, "/blocks/10"))
+}
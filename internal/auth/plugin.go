@@ -0,0 +1,49 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/pkg/plugins"
+)
+
+// LoadSecurityModule opens a Go plugin and returns its "SecurityModule" export, which is
+// either a variable of type plugins.SecurityModule or a value implementing it
+func LoadSecurityModule(path string) (plugins.SecurityModule, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errors.Errorf(errors.SecurityModulePluginLoad, err)
+	}
+	symbol, err := p.Lookup("SecurityModule")
+	if err != nil {
+		return nil, errors.Errorf(errors.SecurityModulePluginSymbol, path, err)
+	}
+	switch sm := symbol.(type) {
+	case *plugins.SecurityModule:
+		if *sm == nil {
+			return nil, errors.Errorf(errors.SecurityModulePluginSymbol, path, "nil")
+		}
+		return *sm, nil
+	case plugins.SecurityModule:
+		return sm, nil
+	default:
+		return nil, errors.Errorf(errors.SecurityModulePluginSymbol, path, fmt.Sprintf("%T does not implement plugins.SecurityModule", symbol))
+	}
+}
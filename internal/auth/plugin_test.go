@@ -0,0 +1,65 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/authtest"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSecurityModulePlugin(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := ioutil.TempDir("", "plugin")
+	defer os.RemoveAll(dir)
+
+	sm, err := LoadSecurityModule(authtest.BuildSamplePlugin(t, dir))
+	assert.NoError(err)
+	RegisterSecurityModule(sm)
+	defer RegisterSecurityModule(nil)
+
+	_, err = WithAuthContext(context.Background(), "testat", conf.OpenIDConfig{})
+	assert.EqualError(err, "invalid token")
+
+	ctx, err := WithAuthContext(context.Background(), "sample:user1", conf.OpenIDConfig{})
+	assert.NoError(err)
+	assert.Equal("user1", ctx.Value(ContextKeyUsername))
+	assert.Equal("sample|user1", ctx.Value(ContextKeySubID))
+	assert.NoError(AuthRPC(ctx, "POST /query"))
+	assert.EqualError(AuthRPC(ctx, "POST /transactions"), "POST /transactions is not allowed for user1")
+	assert.NoError(AuthRPCSubscribe(ctx, WebSocketNamespace, "user1"))
+	assert.Error(AuthRPCSubscribe(ctx, WebSocketNamespace, "user2"))
+	assert.EqualError(AuthEventStreams(ctx), "event streams are not allowed for user1")
+	assert.NoError(AuthListAsyncReplies(ctx))
+	assert.NoError(AuthReadAsyncReplyByUUID(ctx))
+
+	ctx, err = WithAuthContext(context.Background(), "sample:admin", conf.OpenIDConfig{})
+	assert.NoError(err)
+	assert.NoError(AuthRPC(ctx, "POST /transactions"))
+	assert.NoError(AuthEventStreams(ctx))
+}
+
+func TestLoadSecurityModuleMissing(t *testing.T) {
+	_, err := LoadSecurityModule(path.Join(os.TempDir(), "missing.so"))
+	assert.Regexp(t, "Failed to load plugin", err)
+}
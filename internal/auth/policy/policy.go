@@ -0,0 +1,291 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultRolesClaim       = "realm_access.roles"
+	defaultClientRolesClaim = "resource_access"
+	defaultGroupsClaim      = "groups"
+)
+
+// Policy is the role based access policy, loaded from YAML. Access is denied unless
+// one of the rules that apply to the caller allows it
+type Policy struct {
+	Claims ClaimsConf `yaml:"claims"`
+	Rules  []Rule     `yaml:"rules"`
+}
+
+// ClaimsConf names the JWT claims the roles and groups of the caller are read from,
+// a "." separates the levels of nested claims. The defaults match Keycloak access tokens
+type ClaimsConf struct {
+	// Roles is the list of realm roles, "realm_access.roles" by default
+	Roles string `yaml:"roles"`
+	// ClientRoles is an object with a "roles" list per client, "resource_access" by default
+	ClientRoles string `yaml:"clientRoles"`
+	// Groups is the list of groups, "groups" by default
+	Groups string `yaml:"groups"`
+}
+
+// Rule grants permissions to the callers that have any of its roles, client roles or groups.
+// A rule without any of them applies to every authenticated caller
+type Rule struct {
+	Name        string              `yaml:"name"`
+	Roles       []string            `yaml:"roles"`
+	ClientRoles map[string][]string `yaml:"clientRoles"`
+	Groups      []string            `yaml:"groups"`
+
+	// Routes are "<METHOD> <path>" patterns of the REST routes, such as "POST /query" or
+	// "GET /blocks/:blockNumber". "*" matches any method or a single path segment, and a
+	// trailing "**" matches the rest of the path
+	Routes []string `yaml:"routes"`
+	// Channels, Chaincodes and Functions restrict the Fabric calls made through the routes,
+	// any value is allowed when empty. Glob patterns like "asset*" are supported
+	Channels   []string `yaml:"channels"`
+	Chaincodes []string `yaml:"chaincodes"`
+	Functions  []string `yaml:"functions"`
+
+	// EventStreams allows managing event streams and subscriptions
+	EventStreams bool `yaml:"eventStreams"`
+	// Receipts allows reading the replies of asynchronous requests, through REST or WebSocket
+	Receipts bool `yaml:"receipts"`
+	// Topics are the WebSocket topics that can be listened on, glob patterns are supported
+	Topics []string `yaml:"topics"`
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(filename string) (*Policy, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Errorf(errors.SecurityPolicyLoad, filename, err)
+	}
+	return ParsePolicy(content)
+}
+
+// ParsePolicy parses and validates a YAML policy
+func ParsePolicy(content []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(content, &p); err != nil {
+		return nil, errors.Errorf(errors.SecurityPolicyParse, err)
+	}
+	if p.Claims.Roles == "" {
+		p.Claims.Roles = defaultRolesClaim
+	}
+	if p.Claims.ClientRoles == "" {
+		p.Claims.ClientRoles = defaultClientRolesClaim
+	}
+	if p.Claims.Groups == "" {
+		p.Claims.Groups = defaultGroupsClaim
+	}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			p.Rules[i].Name = fmt.Sprintf("rule%d", i)
+		}
+		for _, route := range rule.Routes {
+			parts := strings.Fields(route)
+			if len(parts) != 2 || (parts[1] != "*" && !strings.HasPrefix(parts[1], "/")) {
+				return nil, errors.Errorf(errors.SecurityPolicyInvalidRoute, route, p.Rules[i].Name)
+			}
+		}
+		for _, patterns := range [][]string{rule.Channels, rule.Chaincodes, rule.Functions, rule.Topics} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, errors.Errorf(errors.SecurityPolicyInvalidPattern, pattern, p.Rules[i].Name)
+				}
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Principal is the authenticated caller, as seen by the policy
+type Principal struct {
+	SubjectID         string
+	PreferredUsername string
+	Roles             []string
+	ClientRoles       map[string][]string
+	Groups            []string
+	Claims            map[string]interface{}
+}
+
+// TokenClaims implements plugins.Claims
+func (p *Principal) TokenClaims() map[string]interface{} {
+	return p.Claims
+}
+
+// Subject implements plugins.Identity
+func (p *Principal) Subject() string {
+	return p.SubjectID
+}
+
+// Username implements plugins.Identity
+func (p *Principal) Username() string {
+	return p.PreferredUsername
+}
+
+// ExpiresAt implements plugins.Expiring, with the "exp" claim of the token
+func (p *Principal) ExpiresAt() time.Time {
+	if exp, ok := p.Claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Time{}
+}
+
+func (p *Principal) String() string {
+	name := p.PreferredUsername
+	if name == "" {
+		name = p.SubjectID
+	}
+	roles := append([]string{}, p.Roles...)
+	for client, clientRoles := range p.ClientRoles {
+		for _, role := range clientRoles {
+			roles = append(roles, client+":"+role)
+		}
+	}
+	sort.Strings(roles)
+	return fmt.Sprintf("'%s' (roles=%v groups=%v)", name, roles, p.Groups)
+}
+
+// NewPrincipal reads the identity, roles and groups of the caller from the token claims
+func (p *Policy) NewPrincipal(claims map[string]interface{}) *Principal {
+	principal := &Principal{
+		Roles:       stringList(claimAt(claims, p.Claims.Roles)),
+		ClientRoles: make(map[string][]string),
+		Groups:      stringList(claimAt(claims, p.Claims.Groups)),
+		Claims:      claims,
+	}
+	principal.SubjectID, _ = claims["sub"].(string)
+	principal.PreferredUsername, _ = claims["preferred_username"].(string)
+	if principal.PreferredUsername == "" {
+		// the username of an introspected token (RFC 7662)
+		principal.PreferredUsername, _ = claims["username"].(string)
+	}
+	if clients, ok := claimAt(claims, p.Claims.ClientRoles).(map[string]interface{}); ok {
+		for client, access := range clients {
+			if access, ok := access.(map[string]interface{}); ok {
+				principal.ClientRoles[client] = stringList(access["roles"])
+			}
+		}
+	}
+	return principal
+}
+
+func (r *Rule) appliesTo(principal *Principal) bool {
+	if len(r.Roles) == 0 && len(r.ClientRoles) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	if intersects(r.Roles, principal.Roles) || intersects(r.Groups, principal.Groups) {
+		return true
+	}
+	for client, roles := range r.ClientRoles {
+		if intersects(roles, principal.ClientRoles[client]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) allowsRoute(route string) bool {
+	for _, pattern := range r.Routes {
+		if routeMatches(pattern, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMatches compares a policy pattern like "GET /blocks/*" to a route like "GET /blocks/:blockNumber"
+func routeMatches(pattern, route string) bool {
+	patternParts := strings.Fields(pattern)
+	routeParts := strings.Fields(route)
+	if len(patternParts) != 2 || len(routeParts) != 2 {
+		return false
+	}
+	if patternParts[0] != "*" && !strings.EqualFold(patternParts[0], routeParts[0]) {
+		return false
+	}
+	return patternParts[1] == "*" || auth.PathMatches(patternParts[1], routeParts[1])
+}
+
+// valueMatches checks a value against a list of glob patterns, an empty list allows any value
+func valueMatches(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimAt walks nested claims with a dotted name like "realm_access.roles"
+func claimAt(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(name, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func stringList(v interface{}) []string {
+	switch vv := v.(type) {
+	case []string:
+		return vv
+	case []interface{}:
+		list := make([]string, 0, len(vv))
+		for _, s := range vv {
+			if s, ok := s.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	case string:
+		// some IdPs send a space separated list, like the "scope" claim
+		return strings.Fields(vv)
+	default:
+		return nil
+	}
+}
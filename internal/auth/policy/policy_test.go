@@ -0,0 +1,252 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/pkg/plugins"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicy = `
+rules:
+  - name: auditors
+    roles: [auditor]
+    routes: ["POST /query", "GET /chaininfo", "GET /blocks/*"]
+    channels: [channel-x]
+  - name: operators
+    clientRoles:
+      fabconnect: [operator]
+    routes: ["POST /transactions", "POST /query"]
+    channels: [channel-x, channel-y]
+    chaincodes: ["asset*"]
+    functions: [CreateAsset, ReadAsset]
+    receipts: true
+  - name: event-admins
+    groups: [/event-admins]
+    routes: ["* /eventstreams/**", "* /subscriptions/**"]
+    eventStreams: true
+    topics: ["events-*"]
+  - name: everyone
+    routes: ["GET /identities/:username"]
+`
+
+func newTestModule(t *testing.T) *SecurityModule {
+	p, err := ParsePolicy([]byte(testPolicy))
+	assert.NoError(t, err)
+	return NewSecurityModule(p, func(token string) (map[string]interface{}, error) {
+		switch token {
+		case "auditor":
+			return map[string]interface{}{
+				"sub":                "auditor-id",
+				"preferred_username": "alice",
+				"realm_access":       map[string]interface{}{"roles": []interface{}{"auditor", "offline_access"}},
+			}, nil
+		case "operator":
+			return map[string]interface{}{
+				"sub": "operator-id",
+				"resource_access": map[string]interface{}{
+					"fabconnect": map[string]interface{}{"roles": []interface{}{"operator"}},
+					"account":    map[string]interface{}{"roles": []interface{}{"manage-account"}},
+				},
+			}, nil
+		case "eventadmin":
+			return map[string]interface{}{
+				"sub":    "eventadmin-id",
+				"groups": []interface{}{"/event-admins"},
+			}, nil
+		case "nobody":
+			return map[string]interface{}{"sub": "nobody-id"}, nil
+		}
+		return nil, fmt.Errorf("invalid token")
+	})
+}
+
+func verify(t *testing.T, sm *SecurityModule, token string) interface{} {
+	authCtx, err := sm.VerifyToken(token)
+	assert.NoError(t, err)
+	return authCtx
+}
+
+func TestVerifyToken(t *testing.T) {
+	assert := assert.New(t)
+	sm := newTestModule(t)
+
+	authCtx := verify(t, sm, "auditor")
+	principal := authCtx.(*Principal)
+	assert.Equal([]string{"auditor", "offline_access"}, principal.Roles)
+	identity := authCtx.(plugins.Identity)
+	assert.Equal("auditor-id", identity.Subject())
+	assert.Equal("alice", identity.Username())
+	assert.Equal(principal.Claims, authCtx.(plugins.Claims).TokenClaims())
+
+	principal = verify(t, sm, "operator").(*Principal)
+	assert.Equal([]string{"operator"}, principal.ClientRoles["fabconnect"])
+	assert.Equal("'operator-id' (roles=[account:manage-account fabconnect:operator] groups=[])", principal.String())
+
+	_, err := sm.VerifyToken("bad")
+	assert.EqualError(err, "invalid token")
+}
+
+func TestAuthRPC(t *testing.T) {
+	sm := newTestModule(t)
+
+	tests := []struct {
+		name  string
+		token string
+		route string
+		args  []interface{}
+		err   string
+	}{
+		{"auditor queries channel-x", "auditor", "POST /query", []interface{}{"channel-x", "asset", "ReadAsset"}, ""},
+		{"auditor queries channel-y", "auditor", "POST /query", []interface{}{"channel-y", "asset", "ReadAsset"}, "Access denied: channel 'channel-y' is not allowed on POST /query for 'alice'"},
+		{"auditor reads blocks", "auditor", "GET /blocks/:blockNumber", []interface{}{"channel-x", "", ""}, ""},
+		{"auditor submits", "auditor", "POST /transactions", nil, `Access denied: POST /transactions is not allowed for 'alice' \(roles=\[auditor offline_access\] groups=\[\]\)`},
+		{"operator submits", "operator", "POST /transactions", []interface{}{"channel-y", "asset-transfer", "CreateAsset"}, ""},
+		{"operator other chaincode", "operator", "POST /transactions", []interface{}{"channel-y", "token", "CreateAsset"}, "Access denied: chaincode 'token' is not allowed"},
+		{"operator other function", "operator", "POST /transactions", []interface{}{"channel-y", "asset", "DeleteAsset"}, "Access denied: function 'DeleteAsset' is not allowed"},
+		{"event admin streams", "eventadmin", "PATCH /eventstreams/:streamId", nil, ""},
+		{"event admin subscription reset", "eventadmin", "POST /subscriptions/:subscriptionId/reset", nil, ""},
+		{"event admin transactions", "eventadmin", "POST /transactions", nil, "Access denied: POST /transactions is not allowed"},
+		{"everyone reads identities", "nobody", "GET /identities/:username", nil, ""},
+		{"everyone lists identities", "nobody", "GET /identities", nil, "Access denied: GET /identities is not allowed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := sm.AuthRPC(verify(t, sm, test.token), test.route, test.args...)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Regexp(t, test.err, err)
+			}
+		})
+	}
+}
+
+func TestAuthRPCNoRule(t *testing.T) {
+	assert := assert.New(t)
+	p, err := ParsePolicy([]byte(`rules: [{roles: [admin], routes: ["* *"]}]`))
+	assert.NoError(err)
+	sm := NewSecurityModule(p, nil)
+
+	admin := p.NewPrincipal(map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}}})
+	assert.NoError(sm.AuthRPC(admin, "DELETE /eventstreams/:streamId"))
+
+	err = sm.AuthRPC(p.NewPrincipal(map[string]interface{}{"preferred_username": "bob"}), "GET /chaininfo")
+	assert.Regexp("Access denied: no policy rule applies to 'bob'", err)
+	assert.Regexp("Unexpected auth context: verified", sm.AuthRPC("verified", "GET /chaininfo"))
+}
+
+func TestAuthRPCSubscribe(t *testing.T) {
+	assert := assert.New(t)
+	sm := newTestModule(t)
+
+	assert.NoError(sm.AuthRPCSubscribe(verify(t, sm, "eventadmin"), auth.WebSocketNamespace, "events-1"))
+	assert.Regexp("Access denied: WebSocket topic 'other' is not allowed", sm.AuthRPCSubscribe(verify(t, sm, "eventadmin"), auth.WebSocketNamespace, "other"))
+	assert.Regexp("Access denied: WebSocket topic 'events-1' is not allowed", sm.AuthRPCSubscribe(verify(t, sm, "auditor"), auth.WebSocketNamespace, "events-1"))
+	assert.Regexp("unknown subscription namespace 'kafka'", sm.AuthRPCSubscribe(verify(t, sm, "eventadmin"), "kafka", "events-1"))
+	assert.Regexp("Unexpected auth context", sm.AuthRPCSubscribe(nil, auth.WebSocketNamespace, "events-1"))
+}
+
+func TestAuthEventStreamsAndReceipts(t *testing.T) {
+	assert := assert.New(t)
+	sm := newTestModule(t)
+
+	assert.NoError(sm.AuthEventStreams(verify(t, sm, "eventadmin")))
+	assert.Regexp("Access denied: event streams are not allowed for 'alice'", sm.AuthEventStreams(verify(t, sm, "auditor")))
+	assert.NoError(sm.AuthListAsyncReplies(verify(t, sm, "operator")))
+	assert.NoError(sm.AuthReadAsyncReplyByUUID(verify(t, sm, "operator")))
+	assert.Regexp("Access denied: receipts are not allowed for 'alice'", sm.AuthListAsyncReplies(verify(t, sm, "auditor")))
+	assert.Regexp("Access denied: receipts are not allowed", sm.AuthReadAsyncReplyByUUID(verify(t, sm, "eventadmin")))
+	assert.Regexp("Unexpected auth context", sm.AuthEventStreams(true))
+	assert.Regexp("Unexpected auth context", sm.AuthListAsyncReplies(true))
+}
+
+func TestCustomClaims(t *testing.T) {
+	assert := assert.New(t)
+	p, err := ParsePolicy([]byte(`
+claims:
+  roles: roles
+  groups: ext.teams
+rules:
+  - roles: [reader]
+    routes: ["GET /chaininfo"]
+  - groups: [ops]
+    routes: ["POST /transactions"]
+`))
+	assert.NoError(err)
+	sm := NewSecurityModule(p, nil)
+
+	principal := p.NewPrincipal(map[string]interface{}{
+		"roles": "reader writer",
+		"ext":   map[string]interface{}{"teams": []string{"ops"}},
+	})
+	assert.Equal([]string{"reader", "writer"}, principal.Roles)
+	assert.NoError(sm.AuthRPC(principal, "GET /chaininfo"))
+	assert.NoError(sm.AuthRPC(principal, "POST /transactions"))
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := ParsePolicy([]byte(`rules: [{routes: ["/query"]}]`))
+	assert.Regexp("Invalid route '/query' in security policy rule 'rule0'", err)
+	_, err = ParsePolicy([]byte(`rules: [{name: r, routes: ["POST query"]}]`))
+	assert.Regexp("Invalid route 'POST query' in security policy rule 'r'", err)
+	_, err = ParsePolicy([]byte(`rules: [{channels: ["[x"]}]`))
+	assert.Regexp("Invalid pattern", err)
+	_, err = ParsePolicy([]byte(`rules: [{rolez: [x]}]`))
+	assert.Regexp("Invalid security policy", err)
+	_, err = LoadPolicy("/does/not/exist.yaml")
+	assert.Regexp("Failed to load security policy", err)
+}
+
+func TestLoadPolicy(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := ioutil.TempDir("", "policy")
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "policy.yaml")
+	_ = ioutil.WriteFile(filename, []byte(testPolicy), 0644)
+
+	p, err := LoadPolicy(filename)
+	assert.NoError(err)
+	assert.Len(p.Rules, 4)
+	assert.Equal("realm_access.roles", p.Claims.Roles)
+	assert.Equal("resource_access", p.Claims.ClientRoles)
+	assert.Equal("groups", p.Claims.Groups)
+}
+
+func TestRouteMatches(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(routeMatches("POST /query", "POST /query"))
+	assert.True(routeMatches("post /query", "POST /query"))
+	assert.True(routeMatches("* /query", "GET /query"))
+	assert.True(routeMatches("GET *", "GET /receipts/:id"))
+	assert.True(routeMatches("GET /receipts/*", "GET /receipts/:id"))
+	assert.True(routeMatches("GET /eventstreams/**", "GET /eventstreams"))
+	assert.True(routeMatches("GET /eventstreams/**", "GET /eventstreams/:streamId/suspend"))
+	assert.False(routeMatches("GET /receipts/*", "GET /receipts"))
+	assert.False(routeMatches("GET /receipts", "GET /receipts/:id"))
+	assert.False(routeMatches("GET /query", "POST /query"))
+	assert.False(routeMatches("GET", "GET /query"))
+}
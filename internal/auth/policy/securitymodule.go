@@ -0,0 +1,163 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// TokenVerifier checks the signature and validity of an access token, and returns its claims
+type TokenVerifier func(token string) (map[string]interface{}, error)
+
+// SecurityModule is the built-in plugins.SecurityModule, enforcing a role based Policy
+// on the callers authenticated with JWT access tokens
+type SecurityModule struct {
+	policy *Policy
+	verify TokenVerifier
+}
+
+// NewSecurityModule creates the security module for a policy
+func NewSecurityModule(policy *Policy, verify TokenVerifier) *SecurityModule {
+	return &SecurityModule{
+		policy: policy,
+		verify: verify,
+	}
+}
+
+// VerifyToken verifies the access token, and returns the Principal it identifies as the auth context
+func (sm *SecurityModule) VerifyToken(token string) (interface{}, error) {
+	claims, err := sm.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return sm.policy.NewPrincipal(claims), nil
+}
+
+// AuthRPC authorizes a call to a REST route, where the method is the route such as "POST /query".
+// The optional args are the channel, chaincode and function of the Fabric call made by the route,
+// each one is only checked when the call targets one
+func (sm *SecurityModule) AuthRPC(authCtx interface{}, method string, args ...interface{}) error {
+	principal, err := toPrincipal(authCtx)
+	if err != nil {
+		return err
+	}
+	channel, chaincode, function := stringArg(args, 0), stringArg(args, 1), stringArg(args, 2)
+
+	var denied error
+	applies := false
+	for _, rule := range sm.policy.Rules {
+		if !rule.appliesTo(principal) {
+			continue
+		}
+		applies = true
+		if !rule.allowsRoute(method) {
+			continue
+		}
+		switch {
+		case channel != "" && !valueMatches(rule.Channels, channel):
+			denied = errors.Errorf(errors.SecurityPolicyResourceDenied, "channel", channel, method, principal)
+		case chaincode != "" && !valueMatches(rule.Chaincodes, chaincode):
+			denied = errors.Errorf(errors.SecurityPolicyResourceDenied, "chaincode", chaincode, method, principal)
+		case function != "" && !valueMatches(rule.Functions, function):
+			denied = errors.Errorf(errors.SecurityPolicyResourceDenied, "function", function, method, principal)
+		default:
+			log.Debugf("Access to %s allowed for %s by rule '%s'", method, principal, rule.Name)
+			return nil
+		}
+	}
+	if !applies {
+		return errors.Errorf(errors.SecurityPolicyNoRule, principal)
+	}
+	if denied != nil {
+		return denied
+	}
+	return errors.Errorf(errors.SecurityPolicyRouteDenied, method, principal)
+}
+
+// AuthRPCSubscribe authorizes listening on a WebSocket topic, passed as the channel
+func (sm *SecurityModule) AuthRPCSubscribe(authCtx interface{}, namespace string, channel interface{}, args ...interface{}) error {
+	principal, err := toPrincipal(authCtx)
+	if err != nil {
+		return err
+	}
+	if namespace != auth.WebSocketNamespace {
+		return errors.Errorf(errors.SecurityPolicyUnknownNamespace, namespace)
+	}
+	topic, _ := channel.(string)
+	for _, rule := range sm.policy.Rules {
+		if rule.appliesTo(principal) && len(rule.Topics) > 0 && valueMatches(rule.Topics, topic) {
+			return nil
+		}
+	}
+	return errors.Errorf(errors.SecurityPolicyTopicDenied, topic, principal)
+}
+
+// AuthEventStreams authorizes the management of event streams and subscriptions
+func (sm *SecurityModule) AuthEventStreams(authCtx interface{}) error {
+	principal, err := toPrincipal(authCtx)
+	if err != nil {
+		return err
+	}
+	for _, rule := range sm.policy.Rules {
+		if rule.appliesTo(principal) && rule.EventStreams {
+			return nil
+		}
+	}
+	return errors.Errorf(errors.SecurityPolicyEventStreamsDenied, principal)
+}
+
+// AuthListAsyncReplies authorizes listing the replies of asynchronous requests
+func (sm *SecurityModule) AuthListAsyncReplies(authCtx interface{}) error {
+	return sm.authReceipts(authCtx)
+}
+
+// AuthReadAsyncReplyByUUID authorizes reading a reply of an asynchronous request
+func (sm *SecurityModule) AuthReadAsyncReplyByUUID(authCtx interface{}) error {
+	return sm.authReceipts(authCtx)
+}
+
+func (sm *SecurityModule) authReceipts(authCtx interface{}) error {
+	principal, err := toPrincipal(authCtx)
+	if err != nil {
+		return err
+	}
+	for _, rule := range sm.policy.Rules {
+		if rule.appliesTo(principal) && rule.Receipts {
+			return nil
+		}
+	}
+	return errors.Errorf(errors.SecurityPolicyReceiptsDenied, principal)
+}
+
+func toPrincipal(authCtx interface{}) (*Principal, error) {
+	principal, ok := authCtx.(*Principal)
+	if !ok || principal == nil {
+		return nil, errors.Errorf(errors.SecurityPolicyAuthContext, authCtx)
+	}
+	return principal, nil
+}
+
+func stringArg(args []interface{}, i int) string {
+	if i < len(args) {
+		if s, ok := args[i].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signermap
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/kvstore"
+	log "github.com/sirupsen/logrus"
+)
+
+// Identity is a Fabric identity a user may sign with
+type Identity struct {
+	Signer string `json:"signer"`
+	// Channels restricts the identity to these channels, it signs on any channel when empty
+	Channels []string `json:"channels,omitempty"`
+	// Role describes what the identity is for, such as "approver"
+	Role string `json:"role,omitempty"`
+}
+
+// Mapping lists the Fabric identities of a user, one of which is selected with the signer fly param
+type Mapping struct {
+	User string `json:"user"`
+	// Default is the identity used when the request selects none, defaults to the first one
+	// allowed on the channel
+	Default    string     `json:"default,omitempty"`
+	Identities []Identity `json:"identities"`
+}
+
+// Validate checks the mapping has a user and identities, and a default among them
+func (m *Mapping) Validate() error {
+	if m.User == "" || len(m.Identities) == 0 {
+		return errors.Errorf(errors.SignerMappingInvalid)
+	}
+	for _, identity := range m.Identities {
+		if identity.Signer == "" {
+			return errors.Errorf(errors.SignerMappingInvalid)
+		}
+	}
+	if m.Default != "" && m.identity(m.Default) == nil {
+		return errors.Errorf(errors.SignerMappingDefaultUnknown, m.Default)
+	}
+	return nil
+}
+
+// Select returns the signer of a request made on a channel, which is the requested one
+// when it is an identity of the user allowed on the channel, or the default identity
+func (m *Mapping) Select(requested, channel string) (string, error) {
+	if requested == "" {
+		requested = m.Default
+	}
+	if requested == "" {
+		for _, identity := range m.Identities {
+			if identity.allowedOn(channel) {
+				return identity.Signer, nil
+			}
+		}
+		return "", errors.Errorf(errors.SecuritySignerChannelForbidden, m.User, m.Identities[0].Signer, channel)
+	}
+	identity := m.identity(requested)
+	if identity == nil {
+		return "", errors.Errorf(errors.SecurityActAsForbidden, m.User, requested)
+	}
+	if !identity.allowedOn(channel) {
+		return "", errors.Errorf(errors.SecuritySignerChannelForbidden, m.User, requested, channel)
+	}
+	return requested, nil
+}
+
+func (m *Mapping) identity(signer string) *Identity {
+	for i := range m.Identities {
+		if m.Identities[i].Signer == signer {
+			return &m.Identities[i]
+		}
+	}
+	return nil
+}
+
+func (i *Identity) allowedOn(channel string) bool {
+	if len(i.Channels) == 0 {
+		return true
+	}
+	for _, c := range i.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists the signer mappings, keyed by user
+type Store interface {
+	Init(mocked ...kvstore.KVStore) error
+	// Get returns nil when the user has no mapping
+	Get(user string) (*Mapping, error)
+	List() ([]*Mapping, error)
+	Put(m *Mapping) error
+	Delete(user string) error
+	Close()
+}
+
+type levelDBStore struct {
+	path string
+	db   kvstore.KVStore
+}
+
+// NewLevelDBStore constructor
+func NewLevelDBStore(path string) Store {
+	return &levelDBStore{path: path}
+}
+
+func (s *levelDBStore) Init(mocked ...kvstore.KVStore) error {
+	if mocked != nil {
+		// only used in tests to pass in a mocked impl
+		s.db = mocked[0]
+		return nil
+	}
+	s.db = kvstore.NewLDBKeyValueStore(s.path)
+	if err := s.db.Init(); err != nil {
+		return errors.Errorf(errors.SignerMappingsDBLoad, s.path, err)
+	}
+	return nil
+}
+
+func (s *levelDBStore) Get(user string) (*Mapping, error) {
+	b, err := s.db.Get(user)
+	if err == kvstore.ErrorNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Errorf(errors.SignerMappingStoreFailed, user, err)
+	}
+	var m Mapping
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Errorf(errors.SignerMappingStoreFailed, user, err)
+	}
+	return &m, nil
+}
+
+func (s *levelDBStore) List() ([]*Mapping, error) {
+	it := s.db.NewIterator()
+	defer it.Release()
+	mappings := []*Mapping{}
+	for it.Next() {
+		var m Mapping
+		if err := json.Unmarshal(it.Value(), &m); err != nil {
+			log.Warnf("Skipping the invalid signer mapping of '%s': %s", it.Key(), err)
+			continue
+		}
+		mappings = append(mappings, &m)
+	}
+	return mappings, nil
+}
+
+func (s *levelDBStore) Put(m *Mapping) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(m)
+	if err := s.db.Put(m.User, b); err != nil {
+		return errors.Errorf(errors.SignerMappingStoreFailed, m.User, err)
+	}
+	return nil
+}
+
+func (s *levelDBStore) Delete(user string) error {
+	if _, err := s.db.Get(user); err == kvstore.ErrorNotFound {
+		return errors.Errorf(errors.SignerMappingNotFound, user)
+	}
+	if err := s.db.Delete(user); err != nil {
+		return errors.Errorf(errors.SignerMappingStoreFailed, user, err)
+	}
+	return nil
+}
+
+func (s *levelDBStore) Close() {
+	if s.db != nil {
+		s.db.Close()
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signermap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	mockkvstore "github.com/hyperledger/firefly-fabconnect/mocks/kvstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func tempdir(t *testing.T) string {
+	dir, _ := ioutil.TempDir("", "fly")
+	t.Logf("tmpdir/create: %s", dir)
+	return dir
+}
+
+func cleanup(t *testing.T, dir string) {
+	t.Logf("tmpdir/cleanup: %s [dir]", dir)
+	os.RemoveAll(dir)
+}
+
+func testMapping() *Mapping {
+	return &Mapping{
+		User:    "alice",
+		Default: "alice-approver",
+		Identities: []Identity{
+			{Signer: "alice-ch1", Channels: []string{"ch1"}},
+			{Signer: "alice-approver", Role: "approver"},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(testMapping().Validate())
+	assert.Regexp("must have a user", (&Mapping{Identities: []Identity{{Signer: "a"}}}).Validate())
+	assert.Regexp("must have a user", (&Mapping{User: "alice"}).Validate())
+	assert.Regexp("must have a user", (&Mapping{User: "alice", Identities: []Identity{{Role: "a"}}}).Validate())
+	m := testMapping()
+	m.Default = "bob"
+	assert.Regexp("The default signer 'bob' is not one of the identities", m.Validate())
+}
+
+func TestSelect(t *testing.T) {
+	assert := assert.New(t)
+	m := testMapping()
+
+	signer, err := m.Select("", "ch1")
+	assert.NoError(err)
+	assert.Equal("alice-approver", signer)
+	signer, err = m.Select("alice-ch1", "ch1")
+	assert.NoError(err)
+	assert.Equal("alice-ch1", signer)
+	_, err = m.Select("alice-ch1", "ch2")
+	assert.Regexp("'alice' is not allowed to sign as 'alice-ch1' on channel 'ch2'", err)
+	_, err = m.Select("bob", "ch1")
+	assert.Regexp("'alice' is not allowed to act as signer 'bob'", err)
+
+	// the first identity allowed on the channel is the default
+	m.Default = ""
+	m.Identities[1].Channels = []string{"ch2"}
+	signer, err = m.Select("", "ch2")
+	assert.NoError(err)
+	assert.Equal("alice-approver", signer)
+	_, err = m.Select("", "ch3")
+	assert.Regexp("'alice' is not allowed to sign as 'alice-ch1' on channel 'ch3'", err)
+}
+
+func TestLevelDBStore(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir(t)
+	defer cleanup(t, dir)
+	s := NewLevelDBStore(path.Join(dir, "db"))
+	assert.NoError(s.Init())
+	defer s.Close()
+
+	m, err := s.Get("alice")
+	assert.NoError(err)
+	assert.Nil(m)
+	mappings, err := s.List()
+	assert.NoError(err)
+	assert.Empty(mappings)
+
+	assert.Regexp("must have a user", s.Put(&Mapping{User: "alice"}))
+	assert.NoError(s.Put(testMapping()))
+	assert.NoError(s.Put(&Mapping{User: "bob", Identities: []Identity{{Signer: "bob"}}}))
+
+	m, err = s.Get("alice")
+	assert.NoError(err)
+	assert.Equal(testMapping(), m)
+	mappings, err = s.List()
+	assert.NoError(err)
+	assert.Len(mappings, 2)
+	assert.Equal("alice", mappings[0].User)
+	assert.Equal("bob", mappings[1].User)
+
+	assert.NoError(s.Delete("alice"))
+	assert.Regexp("No signer mapping found for user 'alice'", s.Delete("alice"))
+	m, err = s.Get("alice")
+	assert.NoError(err)
+	assert.Nil(m)
+}
+
+func TestLevelDBStoreBadPath(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempdir(t)
+	defer cleanup(t, dir)
+	_ = ioutil.WriteFile(path.Join(dir, "db"), []byte("a file"), 0644)
+	s := NewLevelDBStore(path.Join(dir, "db"))
+	assert.Regexp("Failed to open the signer mappings DB", s.Init())
+}
+
+func TestStoreErrors(t *testing.T) {
+	assert := assert.New(t)
+	kv := &mockkvstore.KVStore{}
+	s := NewLevelDBStore("")
+	assert.NoError(s.Init(kv))
+
+	kv.On("Get", "alice").Return(nil, fmt.Errorf("pop")).Once()
+	_, err := s.Get("alice")
+	assert.Regexp("Failed to store the signer mapping of 'alice': pop", err)
+	kv.On("Get", "alice").Return([]byte("!json"), nil).Once()
+	_, err = s.Get("alice")
+	assert.Regexp("Failed to store the signer mapping of 'alice'", err)
+
+	kv.On("Put", "alice", mock.Anything).Return(fmt.Errorf("pop"))
+	assert.Regexp("Failed to store the signer mapping of 'alice': pop", s.Put(testMapping()))
+
+	kv.On("Get", "alice").Return([]byte("{}"), nil)
+	kv.On("Delete", "alice").Return(fmt.Errorf("pop"))
+	assert.Regexp("Failed to store the signer mapping of 'alice': pop", s.Delete("alice"))
+}
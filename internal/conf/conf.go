@@ -39,6 +39,54 @@ type RESTGatewayConf struct {
 	HTTP            HTTPConf        `mapstructure:"http"`
 	RPC             RPCConf         `mapstructure:"rpc"`
 	OpenID          OpenIDConfig    `mapstructure:"openId"`
+	Security        SecurityConf    `mapstructure:"security"`
+}
+
+// SecurityConf configures the authentication and authorization of the callers
+type SecurityConf struct {
+	// AuthMode is one of none, jwt, plugin or mtls. It is derived from the rest of the config when empty
+	AuthMode string `mapstructure:"authMode"`
+	// PublicPaths are route patterns served without authentication, such as "/status" or "/receipts/:id".
+	// Defaults to /api, /spec.yaml, /ws and /status
+	PublicPaths []string `mapstructure:"publicPaths"`
+	// PolicyFile is a YAML role based access policy, enforced on the callers authenticated
+	// with the access tokens of the OpenID IdP
+	PolicyFile string `mapstructure:"policyFile"`
+	// PluginPath is a Go plugin (.so) exporting a plugins.SecurityModule named "SecurityModule",
+	// used instead of the built-in verification of JWT access tokens
+	PluginPath string `mapstructure:"pluginPath"`
+	// ClientCertMappings map the TLS client certificates to Fabric signers in the mtls auth mode,
+	// the first match applies. The common name is the signer when none are configured
+	ClientCertMappings []ClientCertMapping `mapstructure:"clientCertMappings"`
+	// ServiceAccounts may submit on behalf of other signers, selected with the signer fly param
+	ServiceAccounts []ServiceAccount `mapstructure:"serviceAccounts"`
+	// SignerMappings stores the Fabric identities each user may sign with, managed through
+	// the /signermappings admin API
+	SignerMappings SignerMappingsConf `mapstructure:"signerMappings"`
+}
+
+// SignerMappingsConf is the configuration of the signer mapping store
+type SignerMappingsConf struct {
+	LevelDB LevelDBReceiptsConf `mapstructure:"leveldb"`
+}
+
+// ServiceAccount lists the signers an authenticated caller may act as
+type ServiceAccount struct {
+	// Username is the authenticated username of the service account, such as the
+	// "service-account-<clientId>" preferred_username of the Keycloak client credentials grant
+	Username string `mapstructure:"username"`
+	// ActAs are glob patterns of the signers the service account may select
+	ActAs []string `mapstructure:"actAs"`
+}
+
+// ClientCertMapping maps the certificates matching its subject or SAN to a Fabric signer
+type ClientCertMapping struct {
+	// Subject is a glob pattern of the distinguished name, such as "CN=billing,OU=services,O=Org1"
+	Subject string `mapstructure:"subject"`
+	// SAN is a glob pattern of a DNS name, email address, IP address or URI of the certificate
+	SAN string `mapstructure:"san"`
+	// Signer is the Fabric identity the requests of the caller are signed with
+	Signer string `mapstructure:"signer"`
 }
 
 // KafkaConf - Common configuration for Kafka
@@ -95,12 +143,65 @@ type RPCConf struct {
 	// only applicable to Fabric node 2.4 or later
 	UseGatewayServer bool   `mapstructure:"useGatewayServer"`
 	ConfigPath       string `mapstructure:"configPath"`
+	// CertExpiry monitors the enrollment certificates of the signers in the credential store
+	CertExpiry CertExpiryConf `mapstructure:"certExpiry"`
+	// OfflineSigning submits the transactions of the external identities, which are signed by the client
+	OfflineSigning OfflineSigningConf `mapstructure:"offlineSigning"`
+	// Wallet holds the identities of the signers in the client-side gateway mode
+	Wallet WalletConf `mapstructure:"wallet"`
+}
+
+// WalletConf configures the backend and the encryption of the wallet
+type WalletConf struct {
+	// Type is one of filesystem, leveldb or mongodb, defaults to filesystem
+	Type string `mapstructure:"type"`
+	// Path is the directory of the filesystem or LevelDB wallet, defaults to makeen-wallet
+	Path       string               `mapstructure:"path"`
+	MongoDB    MongoDBReceiptsConf  `mapstructure:"mongodb"`
+	Encryption WalletEncryptionConf `mapstructure:"encryption"`
+}
+
+// WalletEncryptionConf sets the key-encryption key of the private keys in the wallet, which are
+// stored in plaintext when neither is set
+type WalletEncryptionConf struct {
+	// Passphrase derives the key with scrypt
+	Passphrase string `mapstructure:"passphrase"`
+	// KeyFile holds a 32 byte key, raw or hex or base64 encoded
+	KeyFile string `mapstructure:"keyFile"`
+}
+
+// OfflineSigningConf configures the two-phase signing of the transactions of the external identities
+type OfflineSigningConf struct {
+	// Signer is a signer of the gateway, used to select the endorsers, reach the orderers and listen
+	// for the commit of the transactions. The offline signing is disabled when empty
+	Signer string `mapstructure:"signer"`
+	// Expiry is the number of seconds a prepared transaction waits for its signatures, 300 when 0
+	Expiry int `mapstructure:"expiry"`
+}
+
+// CertExpiryConf configures the monitoring of the enrollment certificates, all values in seconds
+type CertExpiryConf struct {
+	// Interval between the checks of the credential store, the monitoring is disabled when 0
+	Interval int `mapstructure:"interval"`
+	// ReenrollWindow re-enrolls the signers whose certificate expires within it, the signers are
+	// only monitored when 0
+	ReenrollWindow int `mapstructure:"reenrollWindow"`
 }
 
 type HTTPConf struct {
-	LocalAddr string    `mapstructure:"localAddr"`
-	Port      int       `mapstructure:"port"`
-	TLS       TLSConfig `mapstructure:"tls"`
+	LocalAddr   string          `mapstructure:"localAddr"`
+	Port        int             `mapstructure:"port"`
+	TLS         TLSConfig       `mapstructure:"tls"`
+	ClientCerts ClientCertsConf `mapstructure:"clientCerts"`
+}
+
+// ClientCertsConf controls how the HTTP listener verifies TLS client certificates. They are verified
+// when presented in the mtls auth mode, and can be required from every client on any auth mode
+type ClientCertsConf struct {
+	// Require rejects the TLS handshakes without a valid client certificate
+	Require bool `mapstructure:"require"`
+	// CACertsFile is the CA bundle the client certificates are verified against, defaults to tls.caCertsFile
+	CACertsFile string `mapstructure:"caCertsFile"`
 }
 
 // TLSConfig is the common TLS config
@@ -122,6 +223,137 @@ type OpenIDConfig struct {
 	AdminRealm    string `mapstructure:"adminRealm"`
 	ClientRealm   string `mapstructure:"clientRealm"`
 	Group         string `mapstructure:"group"`
+	// Provisioning is the template of the Keycloak users created when identities are enrolled
+	Provisioning ProvisioningConf `mapstructure:"provisioning"`
+	// Admin gates the identity administration operations by a role claim of the caller
+	Admin IdentityAdminConf `mapstructure:"admin"`
+	// Issuer is the OIDC issuer URL of the IdP, used to resolve the JWKS endpoint through
+	// "/.well-known/openid-configuration". Defaults to "<host>/realms/<clientRealm>" (Keycloak)
+	Issuer string `mapstructure:"issuer"`
+	// JWKSUri skips discovery and loads the token signing keys from this URL directly
+	JWKSUri string `mapstructure:"jwksUri"`
+	// Audiences lists the accepted "aud" values, a token must carry at least one of them
+	Audiences []string `mapstructure:"audiences"`
+	// AuthorizedParty, when set, must match the "azp" claim of the token
+	AuthorizedParty string `mapstructure:"authorizedParty"`
+	// RequiredClaims are additional claims that must be present in the token with the given value,
+	// or one of the values when a list is configured
+	RequiredClaims map[string]interface{} `mapstructure:"requiredClaims"`
+	// Algorithms is the allowlist of token signing algorithms (RS*, PS*, ES* and EdDSA),
+	// all of them are accepted when empty
+	Algorithms []string `mapstructure:"algorithms"`
+	// ClockSkew is the number of seconds tolerated when checking exp, nbf and iat
+	ClockSkew int           `mapstructure:"clockSkew"`
+	JWKSCache JWKSCacheConf `mapstructure:"jwksCache"`
+	// Introspection verifies opaque access tokens, which are not JWTs, with the IdP
+	Introspection IntrospectionConf `mapstructure:"introspection"`
+	// UsernameClaim is the claim the username of the caller is read from, such as "sub", "email"
+	// or a nested custom claim like "fabric.user". Defaults to preferred_username, then username
+	UsernameClaim string `mapstructure:"usernameClaim"`
+	// UsernameTransforms are applied in order to the claim value: lowercase, uppercase, localpart
+	// (the part before the @ of an email), prefix:<text> and suffix:<text>
+	UsernameTransforms []string  `mapstructure:"usernameTransforms"`
+	TLS                TLSConfig `mapstructure:"tls"`
+}
+
+// IdentityAdminConf restricts registering, modifying, revoking and removing identities, and
+// managing affiliations, to the callers with an admin role. These run with the registrar of the
+// connection profile, whatever the caller
+type IdentityAdminConf struct {
+	// Role is the role required for the operations. When empty they are open to any caller the
+	// security module or policy lets through, and a warning is logged at startup
+	Role string `mapstructure:"role"`
+	// RolesClaim is the claim the roles of the caller are read from, "realm_access.roles" by default
+	RolesClaim string `mapstructure:"rolesClaim"`
+	// Rules limit what the callers with their role may register, any identity when there are none
+	Rules []IdentityAdminRule `mapstructure:"rules"`
+	// AuditFile is appended a JSON line per operation, which are only logged when empty
+	AuditFile string `mapstructure:"auditFile"`
+}
+
+// IdentityAdminRule lists what the callers with a role may register or modify an identity with.
+// Each list allows any value when empty, and supports glob patterns like "org1.*"
+type IdentityAdminRule struct {
+	Role  string   `mapstructure:"role"`
+	Types []string `mapstructure:"types"`
+	// Affiliations also allow their child affiliations
+	Affiliations []string `mapstructure:"affiliations"`
+	// Attributes are the hf.* attributes that may be set, the other attributes are not restricted
+	Attributes []string `mapstructure:"attributes"`
+}
+
+// ProvisioningConf is the template of the IdP users created for the enrolled identities. The
+// strings are Go templates executed with the Username, Type, Affiliation and Attributes of the
+// CA identity, such as "{{.Username}}@example.com" or "{{index .Attributes \"email\"}}"
+type ProvisioningConf struct {
+	// Provider is the API the users are managed with, keycloak (the default) or scim
+	Provider string `mapstructure:"provider"`
+	// SCIM is the SCIM 2.0 service of the scim provider
+	SCIM          SCIMConf `mapstructure:"scim"`
+	Email         string   `mapstructure:"email"`
+	EmailVerified bool     `mapstructure:"emailVerified"`
+	FirstName     string   `mapstructure:"firstName"`
+	LastName      string   `mapstructure:"lastName"`
+	// Attributes are the templates of the user attributes, empty values are left out
+	Attributes map[string]string `mapstructure:"attributes"`
+	// Groups are group IDs, or group paths starting with "/", the user is added to
+	Groups []string `mapstructure:"groups"`
+	// RealmRoles are the names of the realm roles granted to the user
+	RealmRoles []string `mapstructure:"realmRoles"`
+	// RequiredActions are asked of the user on their first login, such as UPDATE_PASSWORD
+	RequiredActions []string `mapstructure:"requiredActions"`
+	// OneTimePassword sets a generated temporary password on the created users, which the
+	// enroll API returns once
+	OneTimePassword bool `mapstructure:"oneTimePassword"`
+	// Reconcile compares the CA identities with the IdP users
+	Reconcile ReconcileConf `mapstructure:"reconcile"`
+}
+
+// ReconcileConf configures the periodic comparison of the CA identities with the IdP users
+type ReconcileConf struct {
+	// Interval is the number of seconds between the runs, it only runs on demand when 0
+	Interval int `mapstructure:"interval"`
+	// Fix disables the IdP users without a CA identity, and those of the revoked identities.
+	// The drift is only reported otherwise
+	Fix bool `mapstructure:"fix"`
+	// IdentityTypes are the types of the CA identities expected to have an IdP user, defaults to client
+	IdentityTypes []string `mapstructure:"identityTypes"`
+	// Ignore are glob patterns of the usernames left out, such as the IdP admins
+	Ignore []string `mapstructure:"ignore"`
+}
+
+// SCIMConf is the SCIM 2.0 service the IdP users are provisioned with
+type SCIMConf struct {
+	// URL is the base URL of the service, that the /Users and /Groups endpoints are relative to
+	URL string `mapstructure:"url"`
+	// Token is the bearer token the requests are authorized with
+	Token string    `mapstructure:"token"`
+	TLS   TLSConfig `mapstructure:"tls"`
+}
+
+// IntrospectionConf configures the OAuth2 token introspection endpoint of the IdP (RFC 7662).
+// It is used when a client ID is configured, for the tokens that are not JWTs, or for all the
+// tokens when no issuer or JWKS URI is configured
+type IntrospectionConf struct {
+	// Endpoint defaults to the introspection_endpoint of the discovery document of the issuer
+	Endpoint     string `mapstructure:"endpoint"`
+	ClientID     string `mapstructure:"clientId"`
+	ClientSecret string `mapstructure:"clientSecret"`
+	// MaxCacheTime caps, in seconds, how long an active token is cached. Tokens are never cached
+	// past their expiry, and tokens without one are only cached when it is set
+	MaxCacheTime int `mapstructure:"maxCacheTime"`
+	// MaxCacheSize caps the number of active tokens cached, 10000 by default
+	MaxCacheSize int `mapstructure:"maxCacheSize"`
+}
+
+// JWKSCacheConf controls how the token signing keys of the IdP are cached, all values in seconds
+type JWKSCacheConf struct {
+	// RefreshInterval applies when the IdP does not send Cache-Control or Expires headers
+	RefreshInterval int `mapstructure:"refreshInterval"`
+	// MinRefreshInterval is the minimum time between two requests to the IdP
+	MinRefreshInterval int `mapstructure:"minRefreshInterval"`
+	// GracePeriod is how long expired keys are still used while the IdP is unreachable, -1 to disable
+	GracePeriod int `mapstructure:"gracePeriod"`
 }
 
 // CobraInitRPC sets the standard command-line parameters for RPC
@@ -134,6 +366,8 @@ func CobraInit(cmd *cobra.Command, conf *RESTGatewayConf) {
 	_ = viper.BindPFlag("http.localAddr", cmd.Flags().Lookup("listen-addr"))
 	cmd.Flags().IntVarP(&conf.HTTP.Port, "listen-port", "P", 8080, "Port to listen on")
 	_ = viper.BindPFlag("http.port", cmd.Flags().Lookup("listen-port"))
+	cmd.Flags().BoolVarP(&conf.HTTP.ClientCerts.Require, "listen-require-client-certs", "", false, "Reject the TLS connections without a valid client certificate")
+	_ = viper.BindPFlag("http.clientCerts.require", cmd.Flags().Lookup("listen-require-client-certs"))
 
 	cmd.Flags().IntVarP(&conf.Receipts.MaxDocs, "receipt-maxdocs", "x", 0, "Receipt store capped size (new collections only)")
 	_ = viper.BindPFlag("receipts.maxDocs", cmd.Flags().Lookup("receipt-maxdocs"))
@@ -192,6 +426,23 @@ func CobraInit(cmd *cobra.Command, conf *RESTGatewayConf) {
 	_ = viper.BindPFlag("rpc.useGatewayClient", cmd.Flags().Lookup("gateway-client"))
 	cmd.Flags().BoolVarP(&conf.RPC.UseGatewayServer, "gateway-server", "", false, "Whether to use the server-side gateway support when sending transactions (Fabric 2.4 or later only)")
 	_ = viper.BindPFlag("rpc.useGatewayServer", cmd.Flags().Lookup("gateway-server"))
+	cmd.Flags().IntVarP(&conf.RPC.CertExpiry.Interval, "cert-expiry-interval", "", 0, "Seconds between the expiry checks of the signer enrollment certificates, 0 to disable")
+	_ = viper.BindPFlag("rpc.certExpiry.interval", cmd.Flags().Lookup("cert-expiry-interval"))
+	cmd.Flags().IntVarP(&conf.RPC.CertExpiry.ReenrollWindow, "cert-reenroll-window", "", 0, "Re-enroll the signers whose enrollment certificate expires within this many seconds, 0 to disable")
+	_ = viper.BindPFlag("rpc.certExpiry.reenrollWindow", cmd.Flags().Lookup("cert-reenroll-window"))
+	cmd.Flags().StringVarP(&conf.RPC.OfflineSigning.Signer, "offline-signer", "", "", "Signer of the gateway used to endorse and submit the transactions signed by the clients, empty to disable offline signing")
+	_ = viper.BindPFlag("rpc.offlineSigning.signer", cmd.Flags().Lookup("offline-signer"))
+	cmd.Flags().IntVarP(&conf.RPC.OfflineSigning.Expiry, "offline-expiry", "", 0, "Seconds a prepared transaction waits for its signatures, 300 when 0")
+	_ = viper.BindPFlag("rpc.offlineSigning.expiry", cmd.Flags().Lookup("offline-expiry"))
+	// the wallet flags are shared with the wallet commands
+	cmd.PersistentFlags().StringVarP(&conf.RPC.Wallet.Type, "wallet-type", "", "", "Wallet backend of the client-side gateway: filesystem, leveldb or mongodb")
+	_ = viper.BindPFlag("rpc.wallet.type", cmd.PersistentFlags().Lookup("wallet-type"))
+	cmd.PersistentFlags().StringVarP(&conf.RPC.Wallet.Path, "wallet-path", "", "", "Directory of the filesystem or LevelDB wallet")
+	_ = viper.BindPFlag("rpc.wallet.path", cmd.PersistentFlags().Lookup("wallet-path"))
+	cmd.PersistentFlags().StringVarP(&conf.RPC.Wallet.Encryption.Passphrase, "wallet-passphrase", "", "", "Passphrase the key encrypting the private keys in the wallet is derived from")
+	_ = viper.BindPFlag("rpc.wallet.encryption.passphrase", cmd.PersistentFlags().Lookup("wallet-passphrase"))
+	cmd.PersistentFlags().StringVarP(&conf.RPC.Wallet.Encryption.KeyFile, "wallet-key-file", "", "", "File of the 32 byte key encrypting the private keys in the wallet")
+	_ = viper.BindPFlag("rpc.wallet.encryption.keyFile", cmd.PersistentFlags().Lookup("wallet-key-file"))
 
 	cmd.Flags().StringVarP(&conf.OpenID.Host, "openid-host", "", "", "OpenID host url endpoint with port number")
 	_ = viper.BindPFlag("openId.host", cmd.Flags().Lookup("openid-host"))
@@ -209,4 +460,32 @@ func CobraInit(cmd *cobra.Command, conf *RESTGatewayConf) {
 	_ = viper.BindPFlag("openId.keyFile", cmd.Flags().Lookup("openid-key-file"))
 	cmd.Flags().StringVarP(&conf.OpenID.Group, "openid-group", "", "", "OpenID realm client group")
 	_ = viper.BindPFlag("openId.group", cmd.Flags().Lookup("openid-group"))
+	cmd.Flags().StringVarP(&conf.OpenID.Issuer, "openid-issuer", "", "", "OpenID issuer URL used for discovery and to check the iss claim of access tokens")
+	_ = viper.BindPFlag("openId.issuer", cmd.Flags().Lookup("openid-issuer"))
+	cmd.Flags().StringVarP(&conf.OpenID.JWKSUri, "openid-jwks-uri", "", "", "OpenID JWKS URL, overrides the one resolved by discovery")
+	_ = viper.BindPFlag("openId.jwksUri", cmd.Flags().Lookup("openid-jwks-uri"))
+	cmd.Flags().StringSliceVarP(&conf.OpenID.Audiences, "openid-audiences", "", []string{}, "Accepted audiences of access tokens")
+	_ = viper.BindPFlag("openId.audiences", cmd.Flags().Lookup("openid-audiences"))
+	cmd.Flags().StringVarP(&conf.OpenID.AuthorizedParty, "openid-authorized-party", "", "", "Required azp claim of access tokens")
+	_ = viper.BindPFlag("openId.authorizedParty", cmd.Flags().Lookup("openid-authorized-party"))
+	cmd.Flags().StringVarP(&conf.Security.AuthMode, "security-auth-mode", "", "", "Authentication of the callers: none, jwt, plugin or mtls")
+	_ = viper.BindPFlag("security.authMode", cmd.Flags().Lookup("security-auth-mode"))
+	cmd.Flags().StringSliceVarP(&conf.Security.PublicPaths, "security-public-paths", "", nil, "Route patterns served without authentication")
+	_ = viper.BindPFlag("security.publicPaths", cmd.Flags().Lookup("security-public-paths"))
+	cmd.Flags().StringVarP(&conf.Security.PolicyFile, "security-policy-file", "", "", "YAML role based access policy for the REST, WebSocket and event stream APIs")
+	_ = viper.BindPFlag("security.policyFile", cmd.Flags().Lookup("security-policy-file"))
+	cmd.Flags().StringVarP(&conf.Security.PluginPath, "security-plugin-path", "", "", "Go plugin (.so) exporting a SecurityModule that authenticates and authorizes the callers")
+	_ = viper.BindPFlag("security.pluginPath", cmd.Flags().Lookup("security-plugin-path"))
+	cmd.Flags().StringVarP(&conf.Security.SignerMappings.LevelDB.Path, "signer-mappings-db", "", "", "Level DB location for the signer mappings of the users")
+	_ = viper.BindPFlag("security.signerMappings.leveldb.path", cmd.Flags().Lookup("signer-mappings-db"))
+	cmd.Flags().StringVarP(&conf.OpenID.Introspection.Endpoint, "openid-introspection-endpoint", "", "", "OAuth2 token introspection endpoint, for opaque access tokens")
+	_ = viper.BindPFlag("openId.introspection.endpoint", cmd.Flags().Lookup("openid-introspection-endpoint"))
+	cmd.Flags().StringVarP(&conf.OpenID.Introspection.ClientID, "openid-introspection-client-id", "", "", "Client ID used to call the token introspection endpoint")
+	_ = viper.BindPFlag("openId.introspection.clientId", cmd.Flags().Lookup("openid-introspection-client-id"))
+	cmd.Flags().StringSliceVarP(&conf.OpenID.Algorithms, "openid-algorithms", "", []string{}, "Accepted signing algorithms of access tokens")
+	_ = viper.BindPFlag("openId.algorithms", cmd.Flags().Lookup("openid-algorithms"))
+	cmd.Flags().IntVarP(&conf.OpenID.ClockSkew, "openid-clock-skew", "", 0, "Clock skew in seconds tolerated when checking token expiry")
+	_ = viper.BindPFlag("openId.clockSkew", cmd.Flags().Lookup("openid-clock-skew"))
+	cmd.Flags().IntVarP(&conf.OpenID.JWKSCache.GracePeriod, "openid-jwks-grace-period", "", 0, "Seconds the cached token signing keys are still used after expiry while the IdP is unreachable")
+	_ = viper.BindPFlag("openId.jwksCache.gracePeriod", cmd.Flags().Lookup("openid-jwks-grace-period"))
 }
@@ -54,8 +54,28 @@ const (
 	ConfigRESTGatewayRequiredRPCPath = "Must provide REST Gateway client configuration path"
 	// ConfigRESTGatewayRequiredReceiptStore need to enable params for REST Gatewya
 	ConfigRESTGatewayRequiredReceiptStore = "MongoDB URL, Database and Collection name must be specified to enable the receipt store"
+	// ConfigClientCertsNoTLS client certificates are configured on a plain HTTP listener
+	ConfigClientCertsNoTLS = "Client certificates can only be verified when TLS is enabled on the HTTP listener"
+	// ConfigClientCertsNoCA client certificates are verified, but no CA is configured to verify them against
+	ConfigClientCertsNoCA = "http.clientCerts.caCertsFile or http.tls.caCertsFile must be configured to verify the client certificates"
+	// ConfigClientCertMappingInvalid incomplete entry in security.clientCertMappings
+	ConfigClientCertMappingInvalid = "Client certificate mapping %d must have a subject or SAN pattern, and a signer"
+	// ConfigClientCertMappingPattern invalid glob pattern in security.clientCertMappings
+	ConfigClientCertMappingPattern = "Invalid pattern '%s' in client certificate mapping %d"
+	// ConfigServiceAccountInvalid incomplete entry in security.serviceAccounts
+	ConfigServiceAccountInvalid = "Service account %d must have a username and at least one act-as signer"
+	// ConfigServiceAccountPattern invalid glob pattern in security.serviceAccounts
+	ConfigServiceAccountPattern = "Invalid act-as pattern '%s' for service account '%s'"
+	// ConfigProvisioningTemplate unparseable template in openId.provisioning
+	ConfigProvisioningTemplate = "Invalid provisioning template for %s: %s"
+	// ConfigProvisioningProvider unknown openId.provisioning.provider
+	ConfigProvisioningProvider = "Unknown IdP provisioning provider '%s', must be keycloak or scim"
+	// ConfigProvisioningSCIMURL the scim provider is configured without a URL
+	ConfigProvisioningSCIMURL = "The scim provisioning provider requires openId.provisioning.scim.url"
 	// ConfigTLSCertOrKey incomplete TLS config
 	ConfigTLSCertOrKey = "Client private key and certificate must both be provided for mutual auth"
+	// ConfigTLSCACertsLoad unreadable CA bundle
+	ConfigTLSCACertsLoad = "Failed to load the CA certificates in %s: %s"
 
 	// SecurityModulePluginLoad failed to load .so
 	SecurityModulePluginLoad = "Failed to load plugin: %s"
@@ -63,6 +83,59 @@ const (
 	SecurityModulePluginSymbol = "Failed to load 'SecurityModule' symbol from '%s': %s"
 	// SecurityModuleNoAuthContext missing auth context in context object at point security module is invoked
 	SecurityModuleNoAuthContext = "No auth context"
+	// SecurityModuleNoIdP a security module needs to verify JWT access tokens, but no IdP is configured
+	SecurityModuleNoIdP = "No OpenID issuer or JWKS URI configured to verify access tokens"
+	// SecurityModuleConflict both a plugin and a policy are configured as the security module
+	SecurityModuleConflict = "Only one of security.pluginPath and security.policyFile can be configured"
+	// SecurityModuleNoClientCert the mtls auth mode requires a verified client certificate
+	SecurityModuleNoClientCert = "No verified TLS client certificate presented"
+	// SecurityClientCertNotMapped no mapping applies to the client certificate
+	SecurityClientCertNotMapped = "No signer is mapped to the client certificate of '%s'"
+	// SecurityNoUsername the authenticated caller has no username to resolve its signer with
+	SecurityNoUsername = "The authenticated caller has no username to sign as"
+	// SecurityActAsForbidden the caller selected a signer outside its act-as allowlist
+	SecurityActAsForbidden = "'%s' is not allowed to act as signer '%s'"
+	// SecurityNoUsernameClaim the access token lacks the configured username claim
+	SecurityNoUsernameClaim = "The access token has no '%s' claim to read the username from"
+	// SecurityUsernameTransformUnknown invalid entry in openId.usernameTransforms
+	SecurityUsernameTransformUnknown = "Unknown username transform '%s'"
+	// SecuritySignerChannelForbidden a mapped identity is restricted to other channels
+	SecuritySignerChannelForbidden = "'%s' is not allowed to sign as '%s' on channel '%s'"
+	// SecurityAuthModeUnknown invalid security.authMode
+	SecurityAuthModeUnknown = "Unknown auth mode '%s', must be one of none, jwt, plugin or mtls"
+	// SecurityAuthModeConflict a security setting is not used by the configured auth mode
+	SecurityAuthModeConflict = "%s cannot be configured with the '%s' auth mode"
+	// SecurityAuthModePluginPath the plugin auth mode requires a plugin
+	SecurityAuthModePluginPath = "security.pluginPath must be configured with the 'plugin' auth mode"
+	// SecurityAuthModeRequired nothing authenticates the callers, and the none auth mode is not explicit
+	SecurityAuthModeRequired = "security.authMode must be configured, set it to 'none' to serve the API without authentication"
+	// SecurityAuthModePartial an auth setting is configured without the IdP, policy or plugin it needs
+	SecurityAuthModePartial = "%s is configured, but no IdP, policy or plugin is configured to authenticate the callers"
+
+	// SecurityPolicyLoad failed to read the policy file
+	SecurityPolicyLoad = "Failed to load security policy %s: %s"
+	// SecurityPolicyParse invalid YAML in the policy file
+	SecurityPolicyParse = "Invalid security policy: %s"
+	// SecurityPolicyInvalidRoute a route pattern is not "<METHOD> <path>"
+	SecurityPolicyInvalidRoute = "Invalid route '%s' in security policy rule '%s', must be '<METHOD> <path>'"
+	// SecurityPolicyInvalidPattern a glob pattern does not compile
+	SecurityPolicyInvalidPattern = "Invalid pattern '%s' in security policy rule '%s'"
+	// SecurityPolicyAuthContext the auth context was not created by the policy security module
+	SecurityPolicyAuthContext = "Unexpected auth context: %v"
+	// SecurityPolicyNoRule none of the policy rules apply to the caller
+	SecurityPolicyNoRule = "Access denied: no policy rule applies to %s"
+	// SecurityPolicyRouteDenied the caller may not call the route
+	SecurityPolicyRouteDenied = "Access denied: %s is not allowed for %s"
+	// SecurityPolicyResourceDenied the caller may call the route, but not on the channel, chaincode or function
+	SecurityPolicyResourceDenied = "Access denied: %s '%s' is not allowed on %s for %s"
+	// SecurityPolicyUnknownNamespace subscription to something else than a WebSocket topic
+	SecurityPolicyUnknownNamespace = "Access denied: unknown subscription namespace '%s'"
+	// SecurityPolicyTopicDenied the caller may not listen on the WebSocket topic
+	SecurityPolicyTopicDenied = "Access denied: WebSocket topic '%s' is not allowed for %s"
+	// SecurityPolicyEventStreamsDenied the caller may not manage event streams
+	SecurityPolicyEventStreamsDenied = "Access denied: event streams are not allowed for %s"
+	// SecurityPolicyReceiptsDenied the caller may not read the replies of asynchronous requests
+	SecurityPolicyReceiptsDenied = "Access denied: receipts are not allowed for %s"
 
 	// RequestHandlerInvalidMsgTypeMissing need to specify a msg type in the header
 	RequestHandlerInvalidMsgTypeMissing = "Invalid message - missing 'headers.type' (or not a string)"
@@ -165,6 +238,166 @@ const (
 	// KVStoreMemFilteringUnsupported memory db is really just for testing. No filtering support
 	KVStoreMemFilteringUnsupported = "Memory receipts do not support filtering"
 
+	// SignerMappingsDBLoad failed to init the signer mappings DB
+	SignerMappingsDBLoad = "Failed to open the signer mappings DB at %s: %s"
+	// SignerMappingsNotConfigured the signer mappings admin API is called without a DB
+	SignerMappingsNotConfigured = "Signer mappings are not configured on this gateway"
+	// SignerMappingNotFound no mapping for the user
+	SignerMappingNotFound = "No signer mapping found for user '%s'"
+	// SignerMappingInvalid the mapping lacks the user or identities
+	SignerMappingInvalid = "A signer mapping must have a user and at least one identity with a signer"
+	// SignerMappingDefaultUnknown the default signer is not one of the identities
+	SignerMappingDefaultUnknown = "The default signer '%s' is not one of the identities of the mapping"
+	// SignerMappingStoreFailed problem reading or writing a mapping
+	SignerMappingStoreFailed = "Failed to store the signer mapping of '%s': %s"
+	// SignerMappingBadJSON unparseable mapping
+	SignerMappingBadJSON = "Invalid signer mapping: %s"
+
+	// WalletTypeUnknown the wallet type is not supported
+	WalletTypeUnknown = "Unknown wallet type '%s', must be one of filesystem, leveldb or mongodb"
+	// WalletInitFailed failed to open the wallet backend
+	WalletInitFailed = "Failed to open the %s wallet: %s"
+	// WalletMongoDBConfigMissing the MongoDB wallet lacks its connection details
+	WalletMongoDBConfigMissing = "The MongoDB wallet requires rpc.wallet.mongodb.url, database and collection"
+	// WalletEncryptionConfigInvalid both a passphrase and a key file are configured
+	WalletEncryptionConfigInvalid = "Set only one of rpc.wallet.encryption.passphrase and rpc.wallet.encryption.keyFile"
+	// WalletEncryptionNotConfigured the wallet has no key-encryption key
+	WalletEncryptionNotConfigured = "The wallet encryption is not configured, set rpc.wallet.encryption.passphrase or rpc.wallet.encryption.keyFile"
+	// WalletKeyFileInvalid the key file cannot be read or holds no 32 byte key
+	WalletKeyFileInvalid = "Invalid wallet key file '%s': %s"
+	// WalletIdentityInvalid an identity in the wallet cannot be parsed
+	WalletIdentityInvalid = "Invalid identity '%s' in the wallet: %s"
+	// WalletEncryptFailed problem encrypting the private key of an identity
+	WalletEncryptFailed = "Failed to encrypt the private key of '%s': %s"
+	// WalletDecryptFailed the private key of an identity cannot be decrypted with the configured key
+	WalletDecryptFailed = "Failed to decrypt the private key of '%s', check the wallet encryption key: %s"
+	// WalletStoreFailed problem reading or writing an identity
+	WalletStoreFailed = "Failed to store the identity '%s' in the wallet: %s"
+	// WalletImportMSPUnknown an imported identity belongs to an MSP of no org of the connection profile
+	WalletImportMSPUnknown = "The MSP ID '%s' of the imported identity is not the MSP of an organization of the connection profile"
+	// WalletImportMSPNotInChannels the MSP of an imported identity is in none of the channel configs
+	WalletImportMSPNotInChannels = "The MSP '%s' is not a member of any channel of the connection profile"
+	// WalletImportKeyInvalid the private key of an imported identity cannot be parsed
+	WalletImportKeyInvalid = "Invalid private key of '%s', must be a PEM encoded ECDSA key: %s"
+	// WalletImportKeyMismatch the private key of an imported identity is not the key of its certificate
+	WalletImportKeyMismatch = "The private key of '%s' does not match the public key of its certificate"
+	// WalletImportCertNotIssued the certificate of an imported identity does not chain to a CA of its MSP
+	WalletImportCertNotIssued = "The certificate of '%s' is not issued by a CA of the MSP '%s': %s"
+	// WalletImportCAChainFailed the CA certificates to verify an imported identity cannot be retrieved
+	WalletImportCAChainFailed = "Failed to retrieve the CA certificates of the MSP '%s': %s"
+	// WalletImportIdentityExists an imported identity would replace one in the wallet without overwrite
+	WalletImportIdentityExists = "The identity '%s' is already in the wallet, import it with overwrite=true to replace it"
+	// WalletImportFailed problem storing an imported identity
+	WalletImportFailed = "Failed to import the identity '%s': %s"
+	// WalletIdentityNotFound no identity of the client organization in the credential store
+	WalletIdentityNotFound = "No identity '%s' in the wallet"
+
+	// OpenIDAdminLogin failed to log in to the admin realm
+	OpenIDAdminLogin = "Failed to log in to the IdP admin realm: %s"
+	// OpenIDProvisioningFailed a step of the IdP user provisioning failed
+	OpenIDProvisioningFailed = "Failed to %s for the IdP user '%s': %s"
+	// OpenIDProvisioningTemplate a template failed for a user
+	OpenIDProvisioningTemplate = "Failed to apply the provisioning template for %s to '%s': %s"
+	// OpenIDProvisioningAfterEnroll the IdP user of an identity could not be provisioned after its enrollment
+	OpenIDProvisioningAfterEnroll = "'%s' is enrolled with the Fabric CA, but its IdP user could not be provisioned: %s"
+	// IdPNotConfigured an IdP operation is requested without an IdP
+	IdPNotConfigured = "No IdP is configured to provision the users in"
+	// SCIMRequestFailed the SCIM service returned an error
+	SCIMRequestFailed = "SCIM request %s %s failed with status %d: %s"
+	// SCIMGroupNotFound no SCIM group with the display name
+	SCIMGroupNotFound = "No SCIM group named '%s'"
+
+	// CertificateParseFailed an enrollment certificate is not a PEM encoded X.509 certificate
+	CertificateParseFailed = "Failed to parse the enrollment certificate of '%s': %s"
+	// CertificateAttributesParseFailed invalid Fabric attribute extension
+	CertificateAttributesParseFailed = "Failed to parse the attributes of the enrollment certificate of '%s': %s"
+	// IdentityAdminRoleRequired the caller lacks the admin role of the identity administration
+	IdentityAdminRoleRequired = "Access denied: the '%s' role is required to %s"
+	// IdentityAdminRoleNotConfigured an operation requires an admin role or a security module, and neither is configured
+	IdentityAdminRoleNotConfigured = "Access denied: openId.admin.role or a security module must be configured to %s"
+	// IdentityAdminNoRule no registration rule applies to the roles of the caller
+	IdentityAdminNoRule = "Access denied: no identity administration rule applies to the roles %v"
+	// IdentityAdminRuleDenied the rules of the roles of the caller do not allow a value of the identity
+	IdentityAdminRuleDenied = "Access denied: the roles %v may not %s an identity with the %s '%s'"
+	// IdentityAdminTargetLookup the identity targeted by an operation cannot be read from the CA to check the rules
+	IdentityAdminTargetLookup = "Failed to look up the identity '%s' to check the identity administration rules: %s"
+	// IdentityAdminAuditFile the audit file cannot be opened
+	IdentityAdminAuditFile = "Failed to open the identity audit file '%s': %s"
+	// IdentityAdminRuleInvalid a rule of the identity administration is not valid
+	IdentityAdminRuleInvalid = "Invalid identity administration rule %d: %s"
+	// CertificatesListFailed the Fabric CA failed to list its certificates
+	CertificatesListFailed = "Failed to list the certificates of the Fabric CA: %s"
+	// CertificatesFilterInvalid a filter of the certificate listing cannot be parsed
+	CertificatesFilterInvalid = "Invalid value '%s' of the filter '%s': %s"
+	// CRLGenerateFailed the Fabric CA failed to generate its CRL
+	CRLGenerateFailed = "Failed to generate the CRL of the Fabric CA: %s"
+	// CRLFormatUnknown the requested CRL encoding is not supported
+	CRLFormatUnknown = "Unknown CRL format '%s', must be pem or der"
+	// CARegistrarNotConfigured the CA has no registrar to authorize the audit requests
+	CARegistrarNotConfigured = "No registrar is configured for the Fabric CA '%s'"
+	// BulkIdentityContentType the bulk identities are neither CSV nor JSON
+	BulkIdentityContentType = "Unsupported content type '%s' of the identities, must be text/csv or application/json"
+	// BulkIdentityParseFailed the bulk identities cannot be parsed
+	BulkIdentityParseFailed = "Failed to parse the identities: %s"
+	// BulkIdentityRowInvalid a row of the bulk identities is not valid
+	BulkIdentityRowInvalid = "Invalid identity in row %d: %s"
+	// BulkIdentityDuplicate an identity appears twice in the bulk identities
+	BulkIdentityDuplicate = "Duplicate identity '%s' in rows %d and %d"
+	// BulkIdentityEmpty there are no bulk identities
+	BulkIdentityEmpty = "No identities to provision"
+	// BulkJobNotFound the resumed bulk job has no receipt
+	BulkJobNotFound = "Bulk identity job '%s' not found"
+	// BulkJobRunning the resumed bulk job has not stopped
+	BulkJobRunning = "Bulk identity job '%s' is still running"
+	// BulkJobMismatch the identities of a resumed bulk job are not those of the job
+	BulkJobMismatch = "The identities do not match bulk identity job '%s': %s"
+	// BulkJobSecretsNotFound the bulk job has no secrets left to return
+	BulkJobSecretsNotFound = "No secrets of bulk identity job '%s' are left to return"
+	// BulkJobSecretsDenied the secrets of a bulk job are requested by another caller than the one that started it
+	BulkJobSecretsDenied = "Access denied: the secrets of bulk identity job '%s' are only returned to the caller that started it"
+	// BulkJobResumeDenied a bulk job is resumed by another caller than the one that started it
+	BulkJobResumeDenied = "Access denied: bulk identity job '%s' is only resumed by the caller that started it"
+	// BulkJobNoReceiptStore the bulk jobs cannot report their progress
+	BulkJobNoReceiptStore = "No receipt store is available for the bulk identity jobs"
+	// CSREnrollInvalid the certificate signing request of an enrollment is not valid
+	CSREnrollInvalid = "Invalid certificate signing request: %s"
+	// CSREnrollCNMismatch the Fabric CA requires the CN of the request to be the enrollment ID
+	CSREnrollCNMismatch = "The common name '%s' of the certificate signing request must be the enrollment ID '%s'"
+	// CSREnrollNoCA no CA of the client org matches the requested CA name
+	CSREnrollNoCA = "No Fabric CA named '%s' is configured for the organization"
+	// CSREnrollFailed the Fabric CA rejected the enrollment
+	CSREnrollFailed = "Failed to enroll '%s' with the Fabric CA: %s"
+	// CSREnrollMarkFailed the identity enrolled with a CSR could not be marked as external in the CA
+	CSREnrollMarkFailed = "Failed to mark '%s' as an external identity, enroll it again: %s"
+	// ExternalSignerServerSigning the gateway holds no key for an identity enrolled with a CSR
+	ExternalSignerServerSigning = "'%s' is an external identity, its transactions must be signed by the client"
+	// IdentityAttributeReserved a registration or modification sets an attribute the gateway marks the identities with
+	IdentityAttributeReserved = "The attribute '%s' is reserved, the attributes prefixed with 'fabconnect.' are set by the gateway only"
+	// OfflineSigningNotConfigured no gateway signer is configured to reach the network for the external signers
+	OfflineSigningNotConfigured = "Offline signing is not configured, set rpc.offlineSigning.signer"
+	// OfflineSignerNotExternal a transaction is prepared for a signer whose transactions are signed by the gateway
+	OfflineSignerNotExternal = "'%s' is not an external identity, its transactions are signed by the gateway"
+	// OfflineSigningCertLookup the identity or the certificates of an external signer could not be read from the CA
+	OfflineSigningCertLookup = "Failed to look up the certificate of external signer '%s': %s"
+	// OfflineSigningNoCert the CA has no valid certificate issued to the external signer
+	OfflineSigningNoCert = "No valid certificate is issued to external signer '%s'"
+	// OfflineSigningCertInvalid the certificate of the signer of a prepared transaction cannot be used
+	OfflineSigningCertInvalid = "Invalid certificate of signer '%s': %s"
+	// OfflineSigningCertMismatch the certificate of the signer of a prepared transaction is not the one of the signer
+	OfflineSigningCertMismatch = "The common name '%s' of the certificate must be the signer '%s'"
+	// OfflineTxNotFound the prepared transaction does not exist, or was prepared for another signer or channel
+	OfflineTxNotFound = "Transaction '%s' is not prepared for signer '%s' on channel '%s', or has expired"
+	// OfflineTxWrongPhase the phases of a prepared transaction were called out of order
+	OfflineTxWrongPhase = "Transaction '%s' must be %s first"
+	// OfflineSignatureInvalid the signature supplied for a phase does not verify with the certificate of the signer
+	OfflineSignatureInvalid = "Invalid signature of transaction '%s'"
+	// OfflineEndorsementFailed the endorsers rejected the signed proposal
+	OfflineEndorsementFailed = "Failed to collect the endorsements of transaction '%s': %s"
+	// OfflineEndorsementMismatch the endorsers returned different results
+	OfflineEndorsementMismatch = "The endorsements of transaction '%s' do not match"
+	// OfflineSubmitFailed no orderer accepted the signed transaction
+	OfflineSubmitFailed = "Failed to send transaction '%s' to the orderers: %s"
+
 	// Unauthorized (401 error)
 	Unauthorized = "Unauthorized"
 
@@ -210,6 +443,14 @@ const (
 	EventStreamsWebSocketInterruptedSend = "Interrupted waiting for WebSocket connection to send event"
 	// EventStreamsWebSocketInterruptedReceive When we are interrupted waiting for a viable connection to send down
 	EventStreamsWebSocketInterruptedReceive = "Interrupted waiting for WebSocket acknowledgment"
+	// WebSocketNotAuthenticated a command was sent before the client authenticated
+	WebSocketNotAuthenticated = "Not authenticated, the first frame must be an auth frame with the access token"
+	// WebSocketNoToken the upgrade request had no access token
+	WebSocketNoToken = "No access token"
+	// WebSocketSubjectChanged a refreshed token was issued to another caller
+	WebSocketSubjectChanged = "The token of '%s' cannot be replaced by a token of '%s'"
+	// WebSocketTokenExpired the token bound to a connection expired
+	WebSocketTokenExpired = "Access token expired"
 	// EventStreamsWebSocketErrorFromClient Error message received from client
 	EventStreamsWebSocketErrorFromClient = "Error received from WebSocket client: %s"
 	// EventStreamsCannotUpdateType cannot change tyep
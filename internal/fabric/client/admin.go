@@ -0,0 +1,360 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultAdminRolesClaim = "realm_access.roles"
+
+// The identity administration operations recorded in the audit trail
+const (
+	adminOpRegister          = "register"
+	adminOpModify            = "modify"
+	adminOpEnroll            = "enroll"
+	adminOpReenroll          = "reenroll"
+	adminOpRevoke            = "revoke"
+	adminOpRemove            = "remove"
+	adminOpImport            = "import"
+	adminOpAddAffiliation    = "addAffiliation"
+	adminOpModifyAffiliation = "modifyAffiliation"
+	adminOpRemoveAffiliation = "removeAffiliation"
+	adminOpReconcile         = "reconcile"
+	adminOpBulkProvision     = "bulkProvision"
+	adminOpBulkSecrets       = "bulkSecrets"
+)
+
+// The results of the audited operations
+const (
+	adminResultSuccess = "success"
+	adminResultDenied  = "denied"
+	adminResultFailed  = "failed"
+)
+
+var adminActions = map[string]string{
+	adminOpRegister:          "register identities",
+	adminOpModify:            "modify identities",
+	adminOpRevoke:            "revoke identities",
+	adminOpRemove:            "remove identities",
+	adminOpImport:            "import identities",
+	adminOpAddAffiliation:    "manage affiliations",
+	adminOpModifyAffiliation: "manage affiliations",
+	adminOpRemoveAffiliation: "manage affiliations",
+	adminOpReconcile:         "reconcile identities",
+	adminOpBulkProvision:     "register identities",
+	adminOpBulkSecrets:       "register identities",
+
+	identity.AdminOpReadSignerMappings:  "read signer mappings",
+	identity.AdminOpPutSignerMapping:    "manage signer mappings",
+	identity.AdminOpDeleteSignerMapping: "manage signer mappings",
+}
+
+// identityAdmin authorizes the identity administration operations by the roles of the caller,
+// and keeps their audit trail
+type identityAdmin struct {
+	conf  conf.IdentityAdminConf
+	mux   sync.Mutex
+	audit io.WriteCloser
+}
+
+// adminAuditRecord is the audit trail entry of an identity administration operation
+type adminAuditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Target    string    `json:"target"`
+	CAName    string    `json:"caname,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// adminOperation is an identity administration operation requested by a caller
+type adminOperation struct {
+	admin    *identityAdmin
+	name     string
+	target   string
+	caName   string
+	username string
+	subject  string
+	roles    []string
+}
+
+func newIdentityAdmin(c conf.IdentityAdminConf) (*identityAdmin, error) {
+	for i, rule := range c.Rules {
+		if rule.Role == "" {
+			return nil, errors.Errorf(errors.IdentityAdminRuleInvalid, i, "missing role")
+		}
+		for _, patterns := range [][]string{rule.Types, rule.Affiliations, rule.Attributes} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+					return nil, errors.Errorf(errors.IdentityAdminRuleInvalid, i, "invalid pattern '"+pattern+"'")
+				}
+			}
+		}
+	}
+	if c.RolesClaim == "" {
+		c.RolesClaim = defaultAdminRolesClaim
+	}
+	if c.Role == "" {
+		log.Warnf("openId.admin.role is not set: registering, modifying, revoking and removing identities, managing affiliations and reconciling the IdP are open to any caller the security module or policy lets through")
+	}
+	a := &identityAdmin{conf: c}
+	if c.AuditFile != "" {
+		f, err := os.OpenFile(c.AuditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Errorf(errors.IdentityAdminAuditFile, c.AuditFile, err)
+		}
+		a.audit = f
+	}
+	return a, nil
+}
+
+// start returns the operation of the caller of a request on a target identity or affiliation
+func (a *identityAdmin) start(req *http.Request, name, target, caName string) *adminOperation {
+	if a == nil {
+		a = &identityAdmin{}
+	}
+	ctx := req.Context()
+	op := &adminOperation{
+		admin:    a,
+		name:     name,
+		target:   target,
+		caName:   caName,
+		username: auth.GetUsername(ctx),
+		subject:  auth.GetSubject(ctx),
+	}
+	if a.conf.RolesClaim != "" {
+		op.roles = auth.ClaimStrings(auth.GetClaims(ctx), a.conf.RolesClaim)
+	}
+	return op
+}
+
+// row returns an operation of the same caller on another target, for the rows of a bulk job
+func (op *adminOperation) row(name, target, caName string) *adminOperation {
+	row := *op
+	row.name = name
+	row.target = target
+	row.caName = caName
+	return &row
+}
+
+func (a *identityAdmin) close() {
+	if a != nil && a.audit != nil {
+		a.audit.Close()
+		a.audit = nil
+	}
+}
+
+// authorize checks the caller has the admin role, when one is configured
+func (op *adminOperation) authorize() *restutil.RestError {
+	role := op.admin.conf.Role
+	if role == "" || hasRole(op.roles, role) {
+		return nil
+	}
+	return restutil.NewRestError(errors.Errorf(errors.IdentityAdminRoleRequired, role, adminActions[op.name]).Error(), 403)
+}
+
+// authorizeExplicit checks the caller has the admin role. Without a role configured, the operation
+// is only allowed when a security module authorized its route, it is denied otherwise
+func (op *adminOperation) authorizeExplicit() *restutil.RestError {
+	if op.admin.conf.Role == "" {
+		if auth.SecurityModuleRegistered() {
+			return nil
+		}
+		return restutil.NewRestError(errors.Errorf(errors.IdentityAdminRoleNotConfigured, adminActions[op.name]).Error(), 403)
+	}
+	return op.authorize()
+}
+
+// authorizeIdentity checks one of the rules of the roles of the caller allows the type,
+// affiliation and hf.* attributes an identity is registered or modified with. The empty values
+// of a modification are left unchanged by the CA, and are not checked
+func (op *adminOperation) authorizeIdentity(idType, affiliation string, attributes map[string]string) *restutil.RestError {
+	return op.authorizeRules(idType, affiliation, attributes, op.name == adminOpModify)
+}
+
+// authorizeTarget checks one of the rules of the roles of the caller allows the type and
+// affiliation the target identity has at the CA, so it cannot modify, revoke or remove the
+// identities it could not register
+func (w *idClientWrapper) authorizeTarget(op *adminOperation) *restutil.RestError {
+	if len(op.admin.conf.Rules) == 0 {
+		return nil
+	}
+	existing, err := w.caClient.GetIdentity(op.target, op.caName)
+	if err != nil {
+		log.Errorf("Failed to look up user %s to authorize the %s. %s", op.target, op.name, err)
+		return restutil.NewRestError(errors.Errorf(errors.IdentityAdminTargetLookup, op.target, err).Error(), 500)
+	}
+	return op.authorizeRules(existing.Type, existing.Affiliation, nil, false)
+}
+
+func (op *adminOperation) authorizeRules(idType, affiliation string, attributes map[string]string, partial bool) *restutil.RestError {
+	rules := op.admin.conf.Rules
+	if len(rules) == 0 {
+		return nil
+	}
+	var denied error
+	for i := range rules {
+		rule := &rules[i]
+		if !hasRole(op.roles, rule.Role) {
+			continue
+		}
+		err := op.checkRule(rule, idType, affiliation, attributes, partial)
+		if err == nil {
+			return nil
+		}
+		if denied == nil {
+			denied = err
+		}
+	}
+	if denied == nil {
+		denied = errors.Errorf(errors.IdentityAdminNoRule, op.roles)
+	}
+	return restutil.NewRestError(denied.Error(), 403)
+}
+
+func (op *adminOperation) checkRule(rule *conf.IdentityAdminRule, idType, affiliation string, attributes map[string]string, partial bool) error {
+	if (idType != "" || !partial) && !valueMatches(rule.Types, idType) {
+		return errors.Errorf(errors.IdentityAdminRuleDenied, op.roles, op.name, "type", idType)
+	}
+	if (affiliation != "" || !partial) && !affiliationMatches(rule.Affiliations, affiliation) {
+		return errors.Errorf(errors.IdentityAdminRuleDenied, op.roles, op.name, "affiliation", affiliation)
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		if strings.HasPrefix(name, "hf.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(rule.Attributes) == 0 {
+			break
+		}
+		if !valueMatches(rule.Attributes, name) {
+			return errors.Errorf(errors.IdentityAdminRuleDenied, op.roles, op.name, "attribute", name)
+		}
+	}
+	return nil
+}
+
+// done records the result of the operation in the audit trail
+func (op *adminOperation) done(restErr *restutil.RestError) {
+	record := &adminAuditRecord{
+		Time:      time.Now().UTC(),
+		Operation: op.name,
+		Target:    op.target,
+		CAName:    op.caName,
+		Username:  op.username,
+		Subject:   op.subject,
+		Roles:     op.roles,
+		Result:    adminResultSuccess,
+	}
+	if restErr != nil {
+		record.Result = adminResultFailed
+		if restErr.StatusCode == 403 {
+			record.Result = adminResultDenied
+		}
+		record.Error = restErr.Error.Error()
+	}
+	log.Infof("Identity audit: %s of '%s' by '%s' (%s): %s", record.Operation, record.Target, record.Username, record.Subject, record.Result)
+
+	a := op.admin
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.audit == nil {
+		return
+	}
+	b, _ := json.Marshal(record)
+	if _, err := a.audit.Write(append(b, '\n')); err != nil {
+		log.Errorf("Failed to write the identity audit record of %s of '%s'. %s", record.Operation, record.Target, err)
+	}
+}
+
+// AuthorizeAdmin checks the caller may run an administration operation of the REST gateway,
+// which requires the admin role, or a security module when no role is configured. Denials are audited
+func (w *idClientWrapper) AuthorizeAdmin(req *http.Request, operation, target string) *restutil.RestError {
+	op := w.admin.start(req, operation, target, "")
+	restErr := op.authorizeExplicit()
+	if restErr != nil {
+		op.done(restErr)
+	}
+	return restErr
+}
+
+// AuditAdmin records the result of an administration operation authorized by AuthorizeAdmin
+func (w *idClientWrapper) AuditAdmin(req *http.Request, operation, target string, restErr *restutil.RestError) {
+	w.admin.start(req, operation, target, "").done(restErr)
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// valueMatches checks a value against a list of glob patterns, an empty list allows any value
+func valueMatches(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// affiliationMatches checks an affiliation against a list of glob patterns, which also allow
+// the child affiliations of the ones they match
+func affiliationMatches(patterns []string, affiliation string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for {
+		if valueMatches(patterns, affiliation) {
+			return true
+		}
+		i := strings.LastIndex(affiliation, ".")
+		if i < 0 {
+			return false
+		}
+		affiliation = affiliation[:i]
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/authtest"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type testAuditWriter struct {
+	bytes.Buffer
+}
+
+func (w *testAuditWriter) Close() error {
+	return nil
+}
+
+func (w *testAuditWriter) records() []*adminAuditRecord {
+	var records []*adminAuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(w.String()), "\n") {
+		record := &adminAuditRecord{}
+		_ = json.Unmarshal([]byte(line), record)
+		records = append(records, record)
+	}
+	return records
+}
+
+func newTestAdminClient(t *testing.T, c conf.IdentityAdminConf, mockCAClient *mockfabricdep.CAClient) (*idClientWrapper, *testAuditWriter) {
+	admin, err := newIdentityAdmin(c)
+	assert.NoError(t, err)
+	audit := &testAuditWriter{}
+	admin.audit = audit
+	return &idClientWrapper{caClient: mockCAClient, admin: admin}, audit
+}
+
+func newAdminRequest(method, url string, body interface{}, roles ...interface{}) *http.Request {
+	payload, _ := json.Marshal(body)
+	r := httptest.NewRequest(method, url, bytes.NewReader(payload))
+	ctx := context.WithValue(r.Context(), auth.ContextKeySubID, "sub-1")
+	ctx = context.WithValue(ctx, auth.ContextKeyUsername, "alice")
+	ctx = context.WithValue(ctx, auth.ContextKeyClaims, map[string]interface{}{
+		"realm_access": map[string]interface{}{"roles": roles},
+	})
+	return r.WithContext(ctx)
+}
+
+func registerAsAdmin(w *idClientWrapper, body map[string]interface{}, roles ...interface{}) *restutil.RestError {
+	_, restErr := w.Register(httptest.NewRecorder(), newAdminRequest(http.MethodPost, "/identities", body, roles...), nil)
+	return restErr
+}
+
+func TestNewIdentityAdminInvalidRule(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newIdentityAdmin(conf.IdentityAdminConf{Rules: []conf.IdentityAdminRule{{Types: []string{"client"}}}})
+	assert.Regexp("Invalid identity administration rule 0: missing role", err)
+
+	_, err = newIdentityAdmin(conf.IdentityAdminConf{Rules: []conf.IdentityAdminRule{{Role: "admin"}, {Role: "admin", Affiliations: []string{"org1["}}}})
+	assert.Regexp("Invalid identity administration rule 1: invalid pattern 'org1\\['", err)
+
+	_, err = newIdentityAdmin(conf.IdentityAdminConf{AuditFile: filepath.Join(t.TempDir(), "missing", "audit.log")})
+	assert.Regexp("Failed to open the identity audit file", err)
+}
+
+func TestIdentityAdminRoleRequired(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", mock.Anything).Return("secret1", nil)
+	mockCAClient.On("RemoveIdentity", mock.Anything).Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
+	w, audit := newTestAdminClient(t, conf.IdentityAdminConf{Role: "identity-admin"}, mockCAClient)
+
+	restErr := registerAsAdmin(w, map[string]interface{}{"name": "user1"}, "offline_access")
+	assert.Equal(403, restErr.StatusCode)
+	assert.Regexp("Access denied: the 'identity-admin' role is required to register identities", restErr.Error)
+	mockCAClient.AssertNotCalled(t, "Register", mock.Anything)
+
+	restErr = registerAsAdmin(w, map[string]interface{}{"name": "user1"}, "offline_access", "identity-admin")
+	assert.Nil(restErr)
+	mockCAClient.AssertNumberOfCalls(t, "Register", 1)
+
+	r := newAdminRequest(http.MethodDelete, "/identities/user1", nil)
+	_, restErr = w.Remove(httptest.NewRecorder(), r, httprouter.Params{{Key: "username", Value: "user1"}})
+	assert.Equal(403, restErr.StatusCode)
+	mockCAClient.AssertNotCalled(t, "RemoveIdentity", mock.Anything)
+
+	records := audit.records()
+	assert.Equal(3, len(records))
+	assert.Equal(adminOpRegister, records[0].Operation)
+	assert.Equal("user1", records[0].Target)
+	assert.Equal("alice", records[0].Username)
+	assert.Equal("sub-1", records[0].Subject)
+	assert.Equal([]string{"offline_access"}, records[0].Roles)
+	assert.Equal(adminResultDenied, records[0].Result)
+	assert.Regexp("role is required", records[0].Error)
+	assert.Equal(adminResultSuccess, records[1].Result)
+	assert.Empty(records[1].Error)
+	assert.Equal(adminOpRemove, records[2].Operation)
+	assert.Equal(adminResultDenied, records[2].Result)
+}
+
+func TestIdentityAdminGateDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", mock.Anything).Return("", fmt.Errorf("bang"))
+	w, audit := newTestAdminClient(t, conf.IdentityAdminConf{}, mockCAClient)
+
+	restErr := registerAsAdmin(w, map[string]interface{}{"name": "user1"})
+	assert.Equal(500, restErr.StatusCode)
+	mockCAClient.AssertNumberOfCalls(t, "Register", 1)
+
+	records := audit.records()
+	assert.Equal(1, len(records))
+	assert.Equal(adminResultFailed, records[0].Result)
+	assert.Equal("bang", records[0].Error)
+}
+
+func TestIdentityAdminAuthorizeAdmin(t *testing.T) {
+	assert := assert.New(t)
+
+	w, audit := newTestAdminClient(t, conf.IdentityAdminConf{}, &mockfabricdep.CAClient{})
+	r := newAdminRequest(http.MethodPut, "/signermappings/bob", nil)
+	restErr := w.AuthorizeAdmin(r, identity.AdminOpPutSignerMapping, "bob")
+	assert.Equal(403, restErr.StatusCode)
+	assert.Regexp("openId.admin.role or a security module must be configured to manage signer mappings", restErr.Error)
+
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+	assert.Nil(w.AuthorizeAdmin(r, identity.AdminOpPutSignerMapping, "bob"))
+	w.AuditAdmin(r, identity.AdminOpPutSignerMapping, "bob", nil)
+
+	w, _ = newTestAdminClient(t, conf.IdentityAdminConf{Role: "identity-admin"}, &mockfabricdep.CAClient{})
+	restErr = w.AuthorizeAdmin(r, identity.AdminOpReadSignerMappings, "bob")
+	assert.Regexp("the 'identity-admin' role is required to read signer mappings", restErr.Error)
+	r = newAdminRequest(http.MethodGet, "/signermappings/bob", nil, "identity-admin")
+	assert.Nil(w.AuthorizeAdmin(r, identity.AdminOpReadSignerMappings, "bob"))
+
+	records := audit.records()
+	assert.Equal(2, len(records))
+	assert.Equal(adminResultDenied, records[0].Result)
+	assert.Equal(identity.AdminOpPutSignerMapping, records[1].Operation)
+	assert.Equal(adminResultSuccess, records[1].Result)
+}
+
+func TestIdentityAdminRules(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", mock.Anything).Return("secret1", nil)
+	mockCAClient.On("ModifyIdentity", mock.Anything).Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
+	mockCAClient.On("GetIdentity", "user1", "").Return(&mspApi.IdentityResponse{ID: "user1", Type: "client", Affiliation: "org1.department1"}, nil)
+	mockCAClient.On("GetIdentity", "peer2", "").Return(&mspApi.IdentityResponse{ID: "peer2", Type: "peer", Affiliation: "org2"}, nil)
+	mockCAClient.On("GetIdentity", "missing", "").Return(nil, fmt.Errorf("bang"))
+	w, _ := newTestAdminClient(t, conf.IdentityAdminConf{
+		Role: "identity-admin",
+		Rules: []conf.IdentityAdminRule{
+			{Role: "org1-admin", Types: []string{"client"}, Affiliations: []string{"org1"}, Attributes: []string{"hf.Registrar.*"}},
+			{Role: "peer-admin", Types: []string{"peer", "orderer"}},
+		},
+	}, mockCAClient)
+
+	restErr := registerAsAdmin(w, map[string]interface{}{
+		"name":        "user1",
+		"affiliation": "org1.department1",
+		"attributes":  map[string]string{"hf.Registrar.Roles": "client", "email": "user1@example.com"},
+	}, "identity-admin", "org1-admin")
+	assert.Nil(restErr)
+
+	restErr = registerAsAdmin(w, map[string]interface{}{"name": "user1", "affiliation": "org2"}, "identity-admin", "org1-admin")
+	assert.Equal(403, restErr.StatusCode)
+	assert.Regexp("Access denied: the roles \\[identity-admin org1-admin\\] may not register an identity with the affiliation 'org2'", restErr.Error)
+
+	restErr = registerAsAdmin(w, map[string]interface{}{"name": "user1", "affiliation": "org1department"}, "identity-admin", "org1-admin")
+	assert.Regexp("affiliation 'org1department'", restErr.Error)
+
+	restErr = registerAsAdmin(w, map[string]interface{}{
+		"name":        "user1",
+		"affiliation": "org1",
+		"attributes":  map[string]string{"hf.Revoker": "true"},
+	}, "identity-admin", "org1-admin")
+	assert.Regexp("attribute 'hf.Revoker'", restErr.Error)
+
+	restErr = registerAsAdmin(w, map[string]interface{}{"name": "peer1", "type": "peer"}, "identity-admin", "org1-admin", "peer-admin")
+	assert.Nil(restErr)
+
+	restErr = registerAsAdmin(w, map[string]interface{}{"name": "user1", "affiliation": "org1"}, "identity-admin")
+	assert.Regexp("Access denied: no identity administration rule applies to the roles \\[identity-admin\\]", restErr.Error)
+	mockCAClient.AssertNumberOfCalls(t, "Register", 2)
+
+	// the values a modification leaves unchanged are not checked
+	r := newAdminRequest(http.MethodPut, "/identities/user1", map[string]interface{}{"maxEnrollments": 2}, "identity-admin", "org1-admin")
+	_, restErr = w.Modify(httptest.NewRecorder(), r, httprouter.Params{{Key: "username", Value: "user1"}})
+	assert.Nil(restErr)
+	r = newAdminRequest(http.MethodPut, "/identities/user1", map[string]interface{}{"type": "admin"}, "identity-admin", "org1-admin")
+	_, restErr = w.Modify(httptest.NewRecorder(), r, httprouter.Params{{Key: "username", Value: "user1"}})
+	assert.Regexp("may not modify an identity with the type 'admin'", restErr.Error)
+	mockCAClient.AssertNumberOfCalls(t, "ModifyIdentity", 1)
+
+	// the identities outside of the rules cannot be modified, revoked or removed
+	params := httprouter.Params{{Key: "username", Value: "peer2"}}
+	r = newAdminRequest(http.MethodPut, "/identities/peer2", map[string]interface{}{"maxEnrollments": 2}, "identity-admin", "org1-admin")
+	_, restErr = w.Modify(httptest.NewRecorder(), r, params)
+	assert.Equal(403, restErr.StatusCode)
+	assert.Regexp("may not modify an identity with the type 'peer'", restErr.Error)
+	r = newAdminRequest(http.MethodPost, "/identities/peer2/revoke", map[string]interface{}{}, "identity-admin", "org1-admin")
+	_, restErr = w.Revoke(httptest.NewRecorder(), r, params)
+	assert.Regexp("may not revoke an identity with the type 'peer'", restErr.Error)
+	r = newAdminRequest(http.MethodDelete, "/identities/peer2", nil, "identity-admin", "org1-admin")
+	_, restErr = w.Remove(httptest.NewRecorder(), r, params)
+	assert.Regexp("may not remove an identity with the type 'peer'", restErr.Error)
+	mockCAClient.AssertNumberOfCalls(t, "ModifyIdentity", 1)
+
+	r = newAdminRequest(http.MethodDelete, "/identities/missing", nil, "identity-admin", "org1-admin")
+	_, restErr = w.Remove(httptest.NewRecorder(), r, httprouter.Params{{Key: "username", Value: "missing"}})
+	assert.Equal(500, restErr.StatusCode)
+	assert.Regexp("Failed to look up the identity 'missing' to check the identity administration rules: bang", restErr.Error)
+}
+
+func TestIdentityAdminAuditFile(t *testing.T) {
+	assert := assert.New(t)
+
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	admin, err := newIdentityAdmin(conf.IdentityAdminConf{Role: "identity-admin", RolesClaim: "roles", AuditFile: auditFile})
+	assert.NoError(err)
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("AddAffiliation", mock.Anything).Return(&mspApi.AffiliationResponse{
+		AffiliationInfo: mspApi.AffiliationInfo{Name: "org1.department2"},
+	}, nil)
+	w := &idClientWrapper{caClient: mockCAClient, admin: admin}
+
+	payload, _ := json.Marshal(&identity.AffiliationRequest{Name: "org1.department2"})
+	r := httptest.NewRequest(http.MethodPost, "/affiliations", bytes.NewReader(payload))
+	r = r.WithContext(context.WithValue(r.Context(), auth.ContextKeyClaims, map[string]interface{}{"roles": "identity-admin other"}))
+	_, restErr := w.AddAffiliation(httptest.NewRecorder(), r, nil)
+	assert.Nil(restErr)
+	w.Close()
+	assert.Nil(w.admin.audit)
+
+	b, err := ioutil.ReadFile(auditFile)
+	assert.NoError(err)
+	record := &adminAuditRecord{}
+	assert.NoError(json.Unmarshal(b, record))
+	assert.Equal(adminOpAddAffiliation, record.Operation)
+	assert.Equal("org1.department2", record.Target)
+	assert.Equal([]string{"identity-admin", "other"}, record.Roles)
+	assert.Equal(adminResultSuccess, record.Result)
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+func (w *idClientWrapper) ListAffiliations(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *restutil.RestError) {
+	result, err := w.caClient.GetAllAffiliations(req.URL.Query().Get("caname"))
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	return toAffiliation(result), nil
+}
+
+func (w *idClientWrapper) GetAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *restutil.RestError) {
+	result, err := w.caClient.GetAffiliation(params.ByName("affiliation"), req.URL.Query().Get("caname"))
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	return toAffiliation(result), nil
+}
+
+func (w *idClientWrapper) AddAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.Affiliation, restErr *restutil.RestError) {
+	affreq, restErr := decodeAffiliationRequest(req)
+	if restErr != nil {
+		return nil, restErr
+	}
+	if affreq.Name == "" {
+		return nil, restutil.NewRestError(`missing required parameter "name"`, 400)
+	}
+	op := w.admin.start(req, adminOpAddAffiliation, affreq.Name, affreq.CAName)
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+
+	result, err := w.caClient.AddAffiliation(&mspApi.AffiliationRequest{
+		Name:   affreq.Name,
+		Force:  affreq.Force,
+		CAName: affreq.CAName,
+	})
+	if err != nil {
+		log.Errorf("Failed to add affiliation %s. %s", affreq.Name, err)
+		return nil, restutil.NewRestError(err.Error())
+	}
+	return toAffiliation(result), nil
+}
+
+// ModifyAffiliation renames an affiliation to the name of the request. The affiliations and
+// identities under it are only moved with force, and the certificates of the identities keep the
+// old affiliation until they are re-enrolled
+func (w *idClientWrapper) ModifyAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.Affiliation, restErr *restutil.RestError) {
+	name := params.ByName("affiliation")
+	affreq, restErr := decodeAffiliationRequest(req)
+	if restErr != nil {
+		return nil, restErr
+	}
+	if affreq.Name == "" {
+		return nil, restutil.NewRestError(`missing required parameter "name"`, 400)
+	}
+	op := w.admin.start(req, adminOpModifyAffiliation, name, affreq.CAName)
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+
+	result, err := w.caClient.ModifyAffiliation(&mspApi.ModifyAffiliationRequest{
+		AffiliationRequest: mspApi.AffiliationRequest{
+			Name:   name,
+			Force:  affreq.Force,
+			CAName: affreq.CAName,
+		},
+		NewName: affreq.Name,
+	})
+	if err != nil {
+		log.Errorf("Failed to modify affiliation %s. %s", name, err)
+		return nil, restutil.NewRestError(err.Error())
+	}
+	return toAffiliation(result), nil
+}
+
+// RemoveAffiliation removes an affiliation. With force the child affiliations and the
+// identities under them are removed as well, which requires the CA to allow the removals
+func (w *idClientWrapper) RemoveAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.Affiliation, restErr *restutil.RestError) {
+	name := params.ByName("affiliation")
+	op := w.admin.start(req, adminOpRemoveAffiliation, name, req.URL.Query().Get("caname"))
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	force, _ := strconv.ParseBool(req.URL.Query().Get("force"))
+	result, err := w.caClient.RemoveAffiliation(&mspApi.AffiliationRequest{
+		Name:   name,
+		Force:  force,
+		CAName: req.URL.Query().Get("caname"),
+	})
+	if err != nil {
+		log.Errorf("Failed to remove affiliation %s. %s", name, err)
+		return nil, restutil.NewRestError(err.Error())
+	}
+
+	affiliation := toAffiliation(result)
+	w.notifyAffiliationRemoved(affiliation)
+	return affiliation, nil
+}
+
+// notifyAffiliationRemoved drops the signers of the identities removed with an affiliation
+func (w *idClientWrapper) notifyAffiliationRemoved(affiliation *identity.Affiliation) {
+	for _, id := range affiliation.Identities {
+		w.notifySignerUpdate(id.Name)
+	}
+	for _, child := range affiliation.Affiliations {
+		w.notifyAffiliationRemoved(child)
+	}
+}
+
+func decodeAffiliationRequest(req *http.Request) (*identity.AffiliationRequest, *restutil.RestError) {
+	affreq := identity.AffiliationRequest{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&affreq); err != nil {
+		return nil, restutil.NewRestError(fmt.Sprintf("failed to decode JSON payload: %s", err), 400)
+	}
+	return &affreq, nil
+}
+
+func toAffiliation(result *mspApi.AffiliationResponse) *identity.Affiliation {
+	affiliation := toAffiliationInfo(&result.AffiliationInfo)
+	affiliation.CAName = result.CAName
+	return affiliation
+}
+
+func toAffiliationInfo(info *mspApi.AffiliationInfo) *identity.Affiliation {
+	affiliation := &identity.Affiliation{Name: info.Name}
+	for i := range info.Affiliations {
+		affiliation.Affiliations = append(affiliation.Affiliations, toAffiliationInfo(&info.Affiliations[i]))
+	}
+	for _, v := range info.Identities {
+		id := &identity.Identity{
+			MaxEnrollments: v.MaxEnrollments,
+			Type:           v.Type,
+			Affiliation:    v.Affiliation,
+		}
+		id.Name = v.ID
+		if len(v.Attributes) > 0 {
+			id.Attributes = make(map[string]string, len(v.Attributes))
+			for _, attr := range v.Attributes {
+				id.Attributes[attr.Name] = attr.Value
+			}
+		}
+		affiliation.Identities = append(affiliation.Identities, id)
+	}
+	return affiliation
+}
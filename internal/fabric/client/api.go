@@ -17,6 +17,8 @@
 package client
 
 import (
+	"context"
+
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
@@ -46,14 +48,32 @@ func (r *TxReceipt) IsSuccess() bool {
 	return r.Status == pb.TxValidationCode_VALID
 }
 
+// PreparedTx is a phase of a transaction signed by the client. The client signs the Digest, the
+// SHA-256 of the Payload, with the key of its certificate
+type PreparedTx struct {
+	TransactionID string `json:"transactionID"`
+	Digest        []byte `json:"digest"`
+	Payload       []byte `json:"payload"`
+}
+
 type RegistrationWrapper struct {
 	registration fab.Registration
 	eventClient  *event.Client
+	// set for subscriptions served by the gateway service, cancels the events stream
+	cancel context.CancelFunc
 }
 
 type RPCClient interface {
 	Invoke(channelId, signer, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) (*TxReceipt, error)
 	Query(channelId, signer, chaincodeName, method string, args []string, strongread bool) ([]byte, error)
+	// PrepareTransaction builds the proposal of a transaction, whose creator is the certificate the
+	// CA issued to an external signer, EndorseTransaction collects its endorsements with the signed
+	// proposal and SubmitTransaction sends the signed transaction to the orderers. The receipt of its
+	// commit is then returned by GetSubmittedTxReceipt, which returns nil until the commit is seen
+	PrepareTransaction(channelId, signer, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) (*PreparedTx, error)
+	EndorseTransaction(channelId, signer, txId string, signature []byte) (*PreparedTx, error)
+	SubmitTransaction(channelId, signer, txId string, signature []byte) (*TxReceipt, error)
+	GetSubmittedTxReceipt(channelId, signer, txId string) (*TxReceipt, error)
 	QueryChainInfo(channelId, signer string) (*fab.BlockchainInfoResponse, error)
 	QueryBlock(channelId string, signer string, blocknumber uint64, blockhash []byte) (*utils.RawBlock, *utils.Block, error)
 	QueryBlockByTxId(channelId string, signer string, txId string) (*utils.RawBlock, *utils.Block, error)
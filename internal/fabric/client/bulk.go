@@ -0,0 +1,514 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/messages"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	"github.com/hyperledger/firefly-fabconnect/internal/utils"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// bulkJobs are the bulk provisioning jobs running in the background
+type bulkJobs struct {
+	receipts identity.ReceiptStore
+	mux      sync.Mutex
+	running  map[string]bool
+	secrets  map[string]*bulkSecrets
+	stop     chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// bulkSecrets are the secrets generated by a job, which are only held in memory until they are
+// returned to the caller that started the job
+type bulkSecrets struct {
+	subject    string
+	identities []*identity.BulkIdentitySecret
+}
+
+// bulkJob registers the identities of a request in turn, and replaces its receipt after each one
+type bulkJob struct {
+	w          *idClientWrapper
+	op         *adminOperation
+	identities []*identity.BulkIdentity
+	receipt    *identity.BulkJobReceipt
+	started    time.Time
+}
+
+func newBulkJobs() *bulkJobs {
+	return &bulkJobs{
+		running: make(map[string]bool),
+		secrets: make(map[string]*bulkSecrets),
+		stop:    make(chan struct{}),
+	}
+}
+
+// start marks a job as running, it returns false when it already is
+func (b *bulkJobs) start(jobID string) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.running[jobID] {
+		return false
+	}
+	b.running[jobID] = true
+	b.wg.Add(1)
+	return true
+}
+
+func (b *bulkJobs) finish(jobID string) {
+	b.mux.Lock()
+	delete(b.running, jobID)
+	b.mux.Unlock()
+	b.wg.Done()
+}
+
+// addSecret keeps a secret generated for an identity of a job, merged with the ones not returned yet
+func (b *bulkJobs) addSecret(jobID, subject string, secret *identity.BulkIdentitySecret) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	secrets := b.secrets[jobID]
+	if secrets == nil {
+		secrets = &bulkSecrets{subject: subject}
+		b.secrets[jobID] = secrets
+	}
+	for _, existing := range secrets.identities {
+		if existing.Name == secret.Name {
+			if secret.Secret != "" {
+				existing.Secret = secret.Secret
+			}
+			if secret.OneTimePassword != "" {
+				existing.OneTimePassword = secret.OneTimePassword
+			}
+			return
+		}
+	}
+	secrets.identities = append(secrets.identities, secret)
+}
+
+// takeSecrets removes the secrets of a job, when the subject is the one that started it
+func (b *bulkJobs) takeSecrets(jobID, subject string) (*identity.BulkJobSecrets, *restutil.RestError) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	secrets := b.secrets[jobID]
+	if secrets == nil {
+		if b.running[jobID] {
+			return &identity.BulkJobSecrets{Job: jobID, Identities: []*identity.BulkIdentitySecret{}}, nil
+		}
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobSecretsNotFound, jobID).Error(), 404)
+	}
+	if secrets.subject != subject {
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobSecretsDenied, jobID).Error(), 403)
+	}
+	delete(b.secrets, jobID)
+	return &identity.BulkJobSecrets{Job: jobID, Identities: secrets.identities}, nil
+}
+
+// close stops the jobs after the identities they are provisioning, the others are left pending
+func (b *bulkJobs) close() {
+	b.mux.Lock()
+	if !b.stopped {
+		b.stopped = true
+		close(b.stop)
+	}
+	b.mux.Unlock()
+	b.wg.Wait()
+}
+
+func (w *idClientWrapper) SetReceiptStore(receipts identity.ReceiptStore) {
+	w.bulk.receipts = receipts
+}
+
+// BulkProvision registers the identities of a CSV or JSON request in the background, and enrolls
+// them with ?enroll=true. The progress is reported in the receipt of the job, and the secrets it
+// generates are returned by BulkSecrets. A job that stopped with failed or pending identities is
+// resumed with ?resume=<jobId> and the same identities, which only runs the steps that did not succeed
+func (w *idClientWrapper) BulkProvision(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *messages.AsyncSentMsg, restErr *restutil.RestError) {
+	if w.bulk.receipts == nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobNoReceiptStore).Error(), 500)
+	}
+	identities, err := parseBulkIdentities(req)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	jobID := req.URL.Query().Get("resume")
+	resume := jobID != ""
+	if !resume {
+		jobID = utils.UUIDv4()
+	}
+	op := w.admin.start(req, adminOpBulkProvision, jobID, "")
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+
+	if !w.bulk.start(jobID) {
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobRunning, jobID).Error(), 409)
+	}
+	job := &bulkJob{
+		w:          w,
+		op:         op,
+		identities: identities,
+		started:    time.Now(),
+	}
+	if resume {
+		job.receipt, restErr = w.resumedBulkJob(jobID, op.subject, identities)
+		if restErr != nil {
+			w.bulk.finish(jobID)
+			return nil, restErr
+		}
+	} else {
+		enroll, _ := strconv.ParseBool(req.URL.Query().Get("enroll"))
+		job.receipt = &identity.BulkJobReceipt{
+			Enroll: enroll,
+			Total:  len(identities),
+			Rows:   make([]*identity.BulkRowResult, len(identities)),
+		}
+		for i, id := range identities {
+			job.receipt.Rows[i] = &identity.BulkRowResult{Row: i + 1, Name: id.Name, Status: identity.BulkRowPending}
+		}
+	}
+	job.receipt.Headers.ReqID = jobID
+	job.report(messages.MsgTypeBulkIdentityProgress)
+	go job.run()
+
+	return &messages.AsyncSentMsg{
+		Sent:    true,
+		Request: jobID,
+	}, nil
+}
+
+// BulkSecrets returns the enrollment secrets generated by the CA and the one-time passwords of the
+// IdP users of a job, to the caller that started it. Each secret is only returned once
+func (w *idClientWrapper) BulkSecrets(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.BulkJobSecrets, restErr *restutil.RestError) {
+	jobID := req.URL.Query().Get("job")
+	if jobID == "" {
+		return nil, restutil.NewRestError(`missing required parameter "job"`, 400)
+	}
+	op := w.admin.start(req, adminOpBulkSecrets, jobID, "")
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	return w.bulk.takeSecrets(jobID, op.subject)
+}
+
+// resumedBulkJob loads the receipt of a job, whose identities must be the same as the request. Like
+// its secrets, a job is only resumed by the subject that started it
+func (w *idClientWrapper) resumedBulkJob(jobID, subject string, identities []*identity.BulkIdentity) (*identity.BulkJobReceipt, *restutil.RestError) {
+	stored, err := w.bulk.receipts.LookupReceipt(jobID)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	receipt := &identity.BulkJobReceipt{}
+	if stored != nil {
+		b, _ := json.Marshal(stored)
+		_ = json.Unmarshal(b, receipt)
+	}
+	switch receipt.Headers.MsgType {
+	case messages.MsgTypeBulkIdentityProgress, messages.MsgTypeBulkIdentitySuccess, messages.MsgTypeBulkIdentityFailure:
+	default:
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobNotFound, jobID).Error(), 404)
+	}
+	if receipt.Headers.Subject != subject {
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobResumeDenied, jobID).Error(), 403)
+	}
+	if len(receipt.Rows) != len(identities) {
+		return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobMismatch, jobID, fmt.Sprintf("%d identities instead of %d", len(identities), len(receipt.Rows))).Error(), 400)
+	}
+	for i, row := range receipt.Rows {
+		if row.Name != identities[i].Name {
+			return nil, restutil.NewRestError(errors.Errorf(errors.BulkJobMismatch, jobID, fmt.Sprintf("row %d is '%s' instead of '%s'", i+1, identities[i].Name, row.Name)).Error(), 400)
+		}
+	}
+	return receipt, nil
+}
+
+func (j *bulkJob) run() {
+	jobID := j.receipt.Headers.ReqID
+	defer j.w.bulk.finish(jobID)
+	log.Infof("Started bulk identity job %s of %d identities", jobID, len(j.identities))
+	for i, row := range j.receipt.Rows {
+		if row.Status == identity.BulkRowSucceeded {
+			continue
+		}
+		select {
+		case <-j.w.bulk.stop:
+			log.Warnf("Stopped bulk identity job %s before row %d", jobID, row.Row)
+			j.report(messages.MsgTypeBulkIdentityFailure)
+			return
+		default:
+		}
+		j.provision(j.identities[i], row)
+		j.report(messages.MsgTypeBulkIdentityProgress)
+	}
+	if j.receipt.Succeeded == j.receipt.Total {
+		j.report(messages.MsgTypeBulkIdentitySuccess)
+	} else {
+		j.report(messages.MsgTypeBulkIdentityFailure)
+	}
+	log.Infof("Finished bulk identity job %s: %d succeeded, %d failed", jobID, j.receipt.Succeeded, j.receipt.Failed)
+}
+
+// provision runs the steps of a row that did not succeed yet, each row is audited as a registration
+func (j *bulkJob) provision(id *identity.BulkIdentity, row *identity.BulkRowResult) {
+	op := j.op.row(adminOpRegister, id.Name, id.CAName)
+	restErr := j.provisionSteps(op, id, row)
+	op.done(restErr)
+	if restErr != nil {
+		row.Status = identity.BulkRowFailed
+		row.Error = restErr.Error.Error()
+		return
+	}
+	row.Status = identity.BulkRowSucceeded
+	row.Error = ""
+}
+
+func (j *bulkJob) provisionSteps(op *adminOperation, id *identity.BulkIdentity, row *identity.BulkRowResult) *restutil.RestError {
+	w := j.w
+	secret := id.Secret
+	if !row.Registered {
+		if restErr := op.authorizeIdentity(id.Type, id.Affiliation, id.Attributes); restErr != nil {
+			return restErr
+		}
+		rr := &mspApi.RegistrationRequest{
+			Name:           id.Name,
+			Type:           id.Type,
+			MaxEnrollments: id.MaxEnrollments,
+			Affiliation:    id.Affiliation,
+			CAName:         id.CAName,
+			Secret:         id.Secret,
+		}
+		for key, value := range id.Attributes {
+			rr.Attributes = append(rr.Attributes, mspApi.Attribute{Name: key, Value: value, ECert: true})
+		}
+		generated, err := w.caClient.Register(rr)
+		if err != nil {
+			log.Errorf("Failed to register user %s. %s", id.Name, err)
+			return restutil.NewRestError(err.Error())
+		}
+		row.Registered = true
+		if secret == "" {
+			secret = generated
+			j.addSecret(&identity.BulkIdentitySecret{Name: id.Name, Secret: generated})
+		}
+	}
+	if row.Enrolled {
+		return nil
+	}
+	if j.receipt.Enroll && secret == "" {
+		// the secrets generated by the CA are not kept for the resumed jobs, the identity gets a new one
+		var restErr *restutil.RestError
+		if secret, restErr = j.resetSecret(id); restErr != nil {
+			return restErr
+		}
+	}
+
+	user, restErr := w.provisionUser(id.Name, id.CAName)
+	if restErr != nil {
+		return restErr
+	}
+	if j.receipt.Enroll {
+		err := w.caClient.Enroll(&mspApi.EnrollmentRequest{
+			Name:   id.Name,
+			Secret: secret,
+			CAName: id.CAName,
+		})
+		if err != nil {
+			log.Errorf("Failed to enroll user %s. %s", id.Name, err)
+			w.rollbackUser(id.Name, user)
+			return restutil.NewRestError(err.Error())
+		}
+		row.Enrolled = true
+	}
+	userID := ""
+	if user != nil {
+		userID = user.ID
+		row.IdPUserID = user.ID
+		if user.OneTimePassword != "" {
+			j.addSecret(&identity.BulkIdentitySecret{Name: id.Name, OneTimePassword: user.OneTimePassword})
+		}
+	}
+	if row.Enrolled {
+		w.notifySignerIdUpdate(id.Name, userID)
+	}
+	return nil
+}
+
+// resetSecret sets a new enrollment secret on a registered identity
+func (j *bulkJob) resetSecret(id *identity.BulkIdentity) (string, *restutil.RestError) {
+	secret, err := generateSecret()
+	if err != nil {
+		return "", restutil.NewRestError(err.Error())
+	}
+	_, err = j.w.caClient.ModifyIdentity(&mspApi.IdentityRequest{
+		ID:     id.Name,
+		CAName: id.CAName,
+		Secret: secret,
+	})
+	if err != nil {
+		log.Errorf("Failed to reset the secret of user %s. %s", id.Name, err)
+		return "", restutil.NewRestError(err.Error())
+	}
+	j.addSecret(&identity.BulkIdentitySecret{Name: id.Name, Secret: secret})
+	return secret, nil
+}
+
+func (j *bulkJob) addSecret(secret *identity.BulkIdentitySecret) {
+	j.w.bulk.addSecret(j.receipt.Headers.ReqID, j.op.subject, secret)
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// report replaces the receipt of the job with its current state
+func (j *bulkJob) report(msgType string) {
+	r := j.receipt
+	r.Succeeded, r.Failed = 0, 0
+	for _, row := range r.Rows {
+		switch row.Status {
+		case identity.BulkRowSucceeded:
+			r.Succeeded++
+		case identity.BulkRowFailed:
+			r.Failed++
+		}
+	}
+	r.Headers.ID = utils.UUIDv4()
+	r.Headers.MsgType = msgType
+	r.Headers.Subject = j.op.subject
+	r.Headers.Received = j.started.UTC().Format(time.RFC3339Nano)
+	r.Headers.Elapsed = time.Since(j.started).Seconds()
+	b, _ := json.Marshal(r)
+	j.w.bulk.receipts.UpdateReceipt(b)
+}
+
+// parseBulkIdentities parses the identities of a text/csv or application/json request
+func parseBulkIdentities(req *http.Request) ([]*identity.BulkIdentity, error) {
+	mediaType := "application/json"
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+	var identities []*identity.BulkIdentity
+	var err error
+	switch mediaType {
+	case "text/csv":
+		identities, err = parseBulkCSV(req.Body)
+	case "application/json":
+		decoder := json.NewDecoder(req.Body)
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&identities); err != nil {
+			err = errors.Errorf(errors.BulkIdentityParseFailed, err)
+		}
+	default:
+		err = errors.Errorf(errors.BulkIdentityContentType, mediaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.Errorf(errors.BulkIdentityEmpty)
+	}
+	rows := make(map[string]int, len(identities))
+	for i, id := range identities {
+		if id == nil || id.Name == "" {
+			return nil, errors.Errorf(errors.BulkIdentityRowInvalid, i+1, `missing required parameter "name"`)
+		}
+		if err := checkReservedAttributes(id.Attributes); err != nil {
+			return nil, errors.Errorf(errors.BulkIdentityRowInvalid, i+1, err)
+		}
+		if previous, ok := rows[id.Name]; ok {
+			return nil, errors.Errorf(errors.BulkIdentityDuplicate, id.Name, previous, i+1)
+		}
+		rows[id.Name] = i + 1
+		if id.Type == "" {
+			id.Type = "client"
+		}
+	}
+	return identities, nil
+}
+
+// parseBulkCSV parses CSV identities with a header row. The name, secret, type, affiliation,
+// maxEnrollments and caname columns are the fields of the identities, the other columns are
+// attributes, which are not set when empty
+func parseBulkCSV(r io.Reader) ([]*identity.BulkIdentity, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Errorf(errors.BulkIdentityParseFailed, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	identities := make([]*identity.BulkIdentity, 0, len(records)-1)
+	for i, record := range records[1:] {
+		id := &identity.BulkIdentity{}
+		for c, value := range record {
+			column := strings.TrimSpace(header[c])
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(column) {
+			case "name":
+				id.Name = value
+			case "secret":
+				id.Secret = value
+			case "type":
+				id.Type = value
+			case "affiliation":
+				id.Affiliation = value
+			case "caname":
+				id.CAName = value
+			case "maxenrollments":
+				if value != "" {
+					if id.MaxEnrollments, err = strconv.Atoi(value); err != nil {
+						return nil, errors.Errorf(errors.BulkIdentityRowInvalid, i+1, err)
+					}
+				}
+			default:
+				if value != "" {
+					if id.Attributes == nil {
+						id.Attributes = make(map[string]string)
+					}
+					id.Attributes[column] = value
+				}
+			}
+		}
+		identities = append(identities, id)
+	}
+	return identities, nil
+}
@@ -0,0 +1,337 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/messages"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type testReceiptStore struct {
+	mux      sync.Mutex
+	receipts map[string]*map[string]interface{}
+	updates  int
+}
+
+func (s *testReceiptStore) UpdateReceipt(msgBytes []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	receipt := map[string]interface{}{}
+	_ = json.Unmarshal(msgBytes, &receipt)
+	requestID := receipt["headers"].(map[string]interface{})["requestId"].(string)
+	s.receipts[requestID] = &receipt
+	s.updates++
+}
+
+func (s *testReceiptStore) LookupReceipt(requestID string) (*map[string]interface{}, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.receipts[requestID], nil
+}
+
+func (s *testReceiptStore) job(jobID string) *identity.BulkJobReceipt {
+	receipt, _ := s.LookupReceipt(jobID)
+	b, _ := json.Marshal(receipt)
+	job := &identity.BulkJobReceipt{}
+	_ = json.Unmarshal(b, job)
+	return job
+}
+
+func newTestBulkClient(mockCAClient *mockfabricdep.CAClient) (*idClientWrapper, *testReceiptStore) {
+	receipts := &testReceiptStore{receipts: map[string]*map[string]interface{}{}}
+	w := &idClientWrapper{caClient: mockCAClient, bulk: newBulkJobs()}
+	w.SetReceiptStore(receipts)
+	return w, receipts
+}
+
+// bulkProvision starts a job and waits for it to stop
+func bulkProvision(w *idClientWrapper, query, contentType, body string) (*messages.AsyncSentMsg, *restutil.RestError) {
+	r := httptest.NewRequest(http.MethodPost, "/identities/bulk"+query, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	result, restErr := w.BulkProvision(httptest.NewRecorder(), r, nil)
+	w.bulk.wg.Wait()
+	return result, restErr
+}
+
+func registrationOf(name string) interface{} {
+	return mock.MatchedBy(func(rr *mspApi.RegistrationRequest) bool { return rr.Name == name })
+}
+
+func TestParseBulkIdentitiesCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	csv := "name,secret,type,affiliation,maxEnrollments,caname,email,hf.Revoker\n" +
+		"user1,pw1,,org1.department1,2,ca1,user1@example.com,true\n" +
+		" user2 ,,peer,org1,,,,\n"
+	r := httptest.NewRequest(http.MethodPost, "/identities/bulk", strings.NewReader(csv))
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	identities, err := parseBulkIdentities(r)
+	assert.NoError(err)
+	assert.Equal([]*identity.BulkIdentity{
+		{
+			Name:           "user1",
+			Secret:         "pw1",
+			Type:           "client",
+			Affiliation:    "org1.department1",
+			MaxEnrollments: 2,
+			CAName:         "ca1",
+			Attributes:     map[string]string{"email": "user1@example.com", "hf.Revoker": "true"},
+		},
+		{Name: "user2", Type: "peer", Affiliation: "org1"},
+	}, identities)
+}
+
+func TestParseBulkIdentitiesErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	parse := func(contentType, body string) error {
+		r := httptest.NewRequest(http.MethodPost, "/identities/bulk", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		_, err := parseBulkIdentities(r)
+		return err
+	}
+	assert.Regexp("Invalid identity in row 1: .*invalid syntax", parse("text/csv", "name,maxEnrollments\nuser1,two\n"))
+	assert.Regexp("Failed to parse the identities: .*wrong number of fields", parse("text/csv", "name,type\nuser1\n"))
+	assert.Regexp("No identities to provision", parse("text/csv", ""))
+	assert.Regexp("No identities to provision", parse("text/csv", "name\n"))
+	assert.Regexp("No identities to provision", parse("application/json", "[]"))
+	assert.Regexp("Invalid identity in row 2: missing required parameter \"name\"", parse("application/json", `[{"name":"user1"},{"type":"client"}]`))
+	assert.Regexp("Duplicate identity 'user1' in rows 1 and 3", parse("application/json", `[{"name":"user1"},{"name":"user2"},{"name":"user1"}]`))
+	assert.Regexp("Failed to parse the identities: .*unknown field", parse("application/json", `[{"name":"user1","bad":true}]`))
+	assert.Regexp("Unsupported content type 'text/plain'", parse("text/plain", "user1"))
+	assert.Regexp("Invalid identity in row 2: The attribute 'fabconnect.revoked' is reserved", parse("text/csv", "name,fabconnect.revoked\nuser1,\nuser2,false\n"))
+}
+
+func TestBulkProvisionResume(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", registrationOf("user1")).Return("secret1", nil)
+	mockCAClient.On("Register", registrationOf("user2")).Return("", fmt.Errorf("bang")).Once()
+	mockCAClient.On("Register", registrationOf("user2")).Return("", nil)
+	mockCAClient.On("Enroll", mock.Anything).Return(nil)
+	w, receipts := newTestBulkClient(mockCAClient)
+	idlistener := &testSignerIdListener{}
+	w.AddSignerIdUpdateListener(idlistener)
+
+	body := `[{"name":"user1","affiliation":"org1"},{"name":"user2","secret":"pw2"}]`
+	result, restErr := bulkProvision(w, "?enroll=true", "application/json", body)
+	assert.Nil(restErr)
+	assert.True(result.Sent)
+	job := receipts.job(result.Request)
+	assert.Equal(messages.MsgTypeBulkIdentityFailure, job.Headers.MsgType)
+	assert.Equal(result.Request, job.Headers.ReqID)
+	assert.True(job.Enroll)
+	assert.Equal(2, job.Total)
+	assert.Equal(1, job.Succeeded)
+	assert.Equal(1, job.Failed)
+	assert.Equal(&identity.BulkRowResult{Row: 1, Name: "user1", Status: identity.BulkRowSucceeded, Registered: true, Enrolled: true}, job.Rows[0])
+	assert.Equal(&identity.BulkRowResult{Row: 2, Name: "user2", Status: identity.BulkRowFailed, Error: "bang"}, job.Rows[1])
+	assert.Equal(4, receipts.updates)
+
+	_, restErr = bulkProvision(w, "?resume="+result.Request, "application/json", body)
+	assert.Nil(restErr)
+	job = receipts.job(result.Request)
+	assert.Equal(messages.MsgTypeBulkIdentitySuccess, job.Headers.MsgType)
+	assert.Equal(2, job.Succeeded)
+	assert.Equal(0, job.Failed)
+	assert.Equal(&identity.BulkRowResult{Row: 2, Name: "user2", Status: identity.BulkRowSucceeded, Registered: true, Enrolled: true}, job.Rows[1])
+	mockCAClient.AssertNumberOfCalls(t, "Register", 3)
+	mockCAClient.AssertNumberOfCalls(t, "Enroll", 2)
+	mockCAClient.AssertCalled(t, "Enroll", &mspApi.EnrollmentRequest{Name: "user1", Secret: "secret1"})
+	mockCAClient.AssertCalled(t, "Enroll", &mspApi.EnrollmentRequest{Name: "user2", Secret: "pw2"})
+	assert.Equal([]string{"user1", "user2"}, idlistener.updated)
+}
+
+func TestBulkProvisionResumeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", mock.Anything).Return("", fmt.Errorf("bang"))
+	w, receipts := newTestBulkClient(mockCAClient)
+
+	csv := "name\nuser1\nuser2\n"
+	result, restErr := bulkProvision(w, "", "text/csv", csv)
+	assert.Nil(restErr)
+
+	_, restErr = bulkProvision(w, "?resume="+result.Request, "text/csv", "name\nuser1\n")
+	assert.Equal(400, restErr.StatusCode)
+	assert.Regexp("The identities do not match bulk identity job '.*': 1 identities instead of 2", restErr.Error)
+	_, restErr = bulkProvision(w, "?resume="+result.Request, "text/csv", "name\nuser1\nuser3\n")
+	assert.Regexp("row 2 is 'user3' instead of 'user2'", restErr.Error)
+	_, restErr = bulkProvision(w, "?resume=job1", "text/csv", csv)
+	assert.Equal(404, restErr.StatusCode)
+	assert.Regexp("Bulk identity job 'job1' not found", restErr.Error)
+
+	// only the caller that started the job resumes it
+	headers := (*receipts.receipts[result.Request])["headers"].(map[string]interface{})
+	headers["subject"] = "other"
+	_, restErr = bulkProvision(w, "?resume="+result.Request, "text/csv", csv)
+	assert.Equal(403, restErr.StatusCode)
+	assert.Regexp("Access denied: bulk identity job '.*' is only resumed by the caller that started it", restErr.Error)
+	delete(headers, "subject")
+
+	w.bulk.running[result.Request] = true
+	_, restErr = bulkProvision(w, "?resume="+result.Request, "text/csv", csv)
+	assert.Equal(409, restErr.StatusCode)
+	assert.Regexp("is still running", restErr.Error)
+	delete(w.bulk.running, result.Request)
+
+	_, restErr = bulkProvision(w, "", "text/csv", "name\n")
+	assert.Equal(400, restErr.StatusCode)
+	w.bulk.receipts = nil
+	_, restErr = bulkProvision(w, "", "text/csv", csv)
+	assert.Regexp("No receipt store", restErr.Error)
+}
+
+func TestBulkProvisionStopped(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	w, receipts := newTestBulkClient(mockCAClient)
+	w.Close()
+
+	result, restErr := bulkProvision(w, "", "", `[{"name":"user1"}]`)
+	assert.Nil(restErr)
+	job := receipts.job(result.Request)
+	assert.Equal(messages.MsgTypeBulkIdentityFailure, job.Headers.MsgType)
+	assert.Equal(identity.BulkRowPending, job.Rows[0].Status)
+	mockCAClient.AssertNotCalled(t, "Register", mock.Anything)
+}
+
+func TestBulkProvisionAdminRules(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", mock.Anything).Return("secret1", nil)
+	w, receipts := newTestBulkClient(mockCAClient)
+	admin, audit := newTestAdminClient(t, conf.IdentityAdminConf{
+		Role:  "identity-admin",
+		Rules: []conf.IdentityAdminRule{{Role: "identity-admin", Affiliations: []string{"org1"}}},
+	}, mockCAClient)
+	w.admin = admin.admin
+
+	body := []map[string]interface{}{{"name": "user1", "affiliation": "org1"}, {"name": "user2", "affiliation": "org2"}}
+	r := newAdminRequest(http.MethodPost, "/identities/bulk", body, "offline_access")
+	_, restErr := w.BulkProvision(httptest.NewRecorder(), r, nil)
+	assert.Equal(403, restErr.StatusCode)
+
+	r = newAdminRequest(http.MethodPost, "/identities/bulk", body, "identity-admin")
+	result, restErr := w.BulkProvision(httptest.NewRecorder(), r, nil)
+	assert.Nil(restErr)
+	w.bulk.wg.Wait()
+	job := receipts.job(result.Request)
+	assert.Equal(identity.BulkRowSucceeded, job.Rows[0].Status)
+	secrets, restErr := w.BulkSecrets(httptest.NewRecorder(), newAdminRequest(http.MethodPost, "/identities/bulk/secrets?job="+result.Request, nil, "identity-admin"), nil)
+	assert.Nil(restErr)
+	assert.Equal([]*identity.BulkIdentitySecret{{Name: "user1", Secret: "secret1"}}, secrets.Identities)
+	assert.Regexp("may not register an identity with the affiliation 'org2'", job.Rows[1].Error)
+	mockCAClient.AssertNumberOfCalls(t, "Register", 1)
+
+	records := audit.records()
+	assert.Equal(5, len(records))
+	assert.Equal(adminOpBulkSecrets, records[4].Operation)
+	assert.Equal(adminOpBulkProvision, records[0].Operation)
+	assert.Equal(adminResultDenied, records[0].Result)
+	assert.Equal(adminOpBulkProvision, records[1].Operation)
+	assert.Equal(result.Request, records[1].Target)
+	assert.Equal(adminOpRegister, records[2].Operation)
+	assert.Equal("user1", records[2].Target)
+	assert.Equal(adminResultSuccess, records[2].Result)
+	assert.Equal(adminResultDenied, records[3].Result)
+}
+
+func TestBulkProvisionSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("Register", mock.Anything).Return("secret1", nil)
+	mockCAClient.On("Enroll", mock.Anything).Return(fmt.Errorf("bang")).Once()
+	mockCAClient.On("Enroll", mock.Anything).Return(nil)
+	mockCAClient.On("ModifyIdentity", mock.Anything).Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
+	w, receipts := newTestBulkClient(mockCAClient)
+	takeSecrets := func(query string) (*identity.BulkJobSecrets, *restutil.RestError) {
+		r := httptest.NewRequest(http.MethodPost, "/identities/bulk/secrets"+query, nil)
+		return w.BulkSecrets(httptest.NewRecorder(), r, nil)
+	}
+
+	body := `[{"name":"user1"}]`
+	result, restErr := bulkProvision(w, "?enroll=true", "application/json", body)
+	assert.Nil(restErr)
+	// the secrets never reach the receipt
+	receipt, _ := receipts.LookupReceipt(result.Request)
+	b, _ := json.Marshal(receipt)
+	assert.NotContains(string(b), "secret1")
+	assert.Equal(identity.BulkRowFailed, receipts.job(result.Request).Rows[0].Status)
+
+	secrets, restErr := takeSecrets("?job=" + result.Request)
+	assert.Nil(restErr)
+	assert.Equal(&identity.BulkJobSecrets{Job: result.Request, Identities: []*identity.BulkIdentitySecret{{Name: "user1", Secret: "secret1"}}}, secrets)
+	_, restErr = takeSecrets("?job=" + result.Request)
+	assert.Equal(404, restErr.StatusCode)
+	assert.Regexp("No secrets of bulk identity job '.*' are left to return", restErr.Error)
+	_, restErr = takeSecrets("")
+	assert.Equal(400, restErr.StatusCode)
+
+	// the resumed job enrolls the registered identity with a new secret
+	_, restErr = bulkProvision(w, "?resume="+result.Request, "application/json", body)
+	assert.Nil(restErr)
+	assert.Equal(identity.BulkRowSucceeded, receipts.job(result.Request).Rows[0].Status)
+	mockCAClient.AssertNumberOfCalls(t, "Register", 1)
+	newSecret := ""
+	for _, call := range mockCAClient.Calls {
+		if call.Method == "ModifyIdentity" {
+			newSecret = call.Arguments[0].(*mspApi.IdentityRequest).Secret
+		}
+	}
+	assert.NotEmpty(newSecret)
+	mockCAClient.AssertCalled(t, "Enroll", &mspApi.EnrollmentRequest{Name: "user1", Secret: newSecret})
+	receipt, _ = receipts.LookupReceipt(result.Request)
+	b, _ = json.Marshal(receipt)
+	assert.NotContains(string(b), newSecret)
+
+	// the secrets are only returned to the caller that started the job
+	w.bulk.secrets[result.Request].subject = "other"
+	_, restErr = takeSecrets("?job=" + result.Request)
+	assert.Equal(403, restErr.StatusCode)
+	w.bulk.secrets[result.Request].subject = ""
+	secrets, restErr = takeSecrets("?job=" + result.Request)
+	assert.Nil(restErr)
+	assert.Equal([]*identity.BulkIdentitySecret{{Name: "user1", Secret: newSecret}}, secrets.Identities)
+
+	// a running job without secrets yet returns none
+	w.bulk.running["job2"] = true
+	secrets, restErr = takeSecrets("?job=job2")
+	assert.Nil(restErr)
+	assert.Empty(secrets.Identities)
+}
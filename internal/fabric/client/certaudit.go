@@ -0,0 +1,347 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+type caResponse struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type caCertificatesResult struct {
+	CAName string `json:"caname"`
+	Certs  []struct {
+		PEM string `json:"PEM"`
+	} `json:"certs"`
+}
+
+type caGenCRLRequest struct {
+	CAName        string     `json:"caname,omitempty"`
+	RevokedAfter  *time.Time `json:"revokedafter,omitempty"`
+	RevokedBefore *time.Time `json:"revokedbefore,omitempty"`
+	ExpireAfter   *time.Time `json:"expireafter,omitempty"`
+	ExpireBefore  *time.Time `json:"expirebefore,omitempty"`
+}
+
+type caGenCRLResult struct {
+	// CRL is the base64 of the PEM CRL, which encoding/json decodes
+	CRL []byte `json:"CRL"`
+}
+
+// GetCertificates lists the certificates of the CA matching the filters, as the fabric-ca-client
+// certificate list command does
+func (c *caRESTClient) GetCertificates(req *dep.CertificatesRequest) (*dep.CertificatesResponse, error) {
+	caConfig, err := c.caConfig(req.CAName)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	for name, value := range map[string]string{"id": req.ID, "serial": req.Serial, "aki": req.AKI, "ca": caConfig.CAName} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	now := time.Now().UTC()
+	notAfterEnd := req.NotAfterEnd
+	if req.Expired != nil {
+		if !*req.Expired {
+			query.Set("notexpired", "true")
+		} else if notAfterEnd == nil || notAfterEnd.After(now) {
+			notAfterEnd = &now
+		}
+	}
+	if req.NotAfterStart != nil {
+		query.Set("expired_start", req.NotAfterStart.UTC().Format(time.RFC3339))
+	}
+	if notAfterEnd != nil {
+		query.Set("expired_end", notAfterEnd.UTC().Format(time.RFC3339))
+	}
+	if req.Revoked != nil {
+		if *req.Revoked {
+			// the certificates that are not revoked have the zero revocation time
+			query.Set("revoked_start", time.Unix(0, 0).UTC().Format(time.RFC3339))
+		} else {
+			query.Set("notrevoked", "true")
+		}
+	}
+
+	var result caCertificatesResult
+	if err := c.callWithToken(caConfig, http.MethodGet, "/api/v1/certificates?"+query.Encode(), nil, &result); err != nil {
+		return nil, errors.Errorf(errors.CertificatesListFailed, err)
+	}
+	certs := make([][]byte, len(result.Certs))
+	for i, cert := range result.Certs {
+		certs[i] = []byte(cert.PEM)
+	}
+	return &dep.CertificatesResponse{CAName: result.CAName, Certs: certs}, nil
+}
+
+// GenCRL generates a CRL of the certificates revoked and expiring in the ranges of the request
+func (c *caRESTClient) GenCRL(req *dep.GenCRLRequest) (*dep.GenCRLResponse, error) {
+	caConfig, err := c.caConfig(req.CAName)
+	if err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(&caGenCRLRequest{
+		CAName:        caConfig.CAName,
+		RevokedAfter:  req.RevokedAfter,
+		RevokedBefore: req.RevokedBefore,
+		ExpireAfter:   req.ExpireAfter,
+		ExpireBefore:  req.ExpireBefore,
+	})
+	var result caGenCRLResult
+	if err := c.callWithToken(caConfig, http.MethodPost, "/api/v1/gencrl", body, &result); err != nil {
+		return nil, errors.Errorf(errors.CRLGenerateFailed, err)
+	}
+	return &dep.GenCRLResponse{CRL: result.CRL}, nil
+}
+
+// callWithToken sends a request authorized with the token of the registrar of the CA, and decodes
+// the result of the response
+func (c *caRESTClient) callWithToken(caConfig *msp.CAConfig, method, uri string, body []byte, result interface{}) error {
+	if c.registrar == nil {
+		return errors.Errorf(errors.CARegistrarNotConfigured, caConfig.ID)
+	}
+	signer, err := c.registrar(caConfig)
+	if err != nil {
+		return err
+	}
+	httpClient, err := newCAHTTPClient(caConfig)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(method, strings.TrimSuffix(caConfig.URL, "/")+uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	token, err := caToken(signer, method, httpReq.URL.RequestURI(), body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", token)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpRes, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+
+	var res caResponse
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return fmt.Errorf("status %d", httpRes.StatusCode)
+	}
+	if !res.Success {
+		messages := make([]string, 0, len(res.Errors))
+		for _, e := range res.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("%s", strings.Join(messages, ", "))
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// caToken is the authorization token of a request to the Fabric CA, which is the certificate of
+// the signer and its signature of the request, see fabric-ca util.GenECDSAToken
+func caToken(signer msp.SigningIdentity, method, uri string, body []byte) (string, error) {
+	b64Cert := base64.StdEncoding.EncodeToString(signer.EnrollmentCertificate())
+	payload := method + "." + base64.StdEncoding.EncodeToString([]byte(uri)) + "." + base64.StdEncoding.EncodeToString(body) + "." + b64Cert
+	signature, err := signer.Sign([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return b64Cert + "." + base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// registrarIdentity returns the signer of the registrar of the CA, which is enrolled on first use
+// like the SDK does for its registrar requests
+func (w *idClientWrapper) registrarIdentity(caConfig *msp.CAConfig) (msp.SigningIdentity, error) {
+	registrar := caConfig.Registrar
+	if registrar.EnrollID == "" {
+		return nil, errors.Errorf(errors.CARegistrarNotConfigured, caConfig.ID)
+	}
+	signer, err := w.identityMgr.GetSigningIdentity(registrar.EnrollID)
+	if err != msp.ErrUserNotFound || registrar.EnrollSecret == "" {
+		return signer, err
+	}
+	err = w.caClient.Enroll(&mspApi.EnrollmentRequest{Name: registrar.EnrollID, Secret: registrar.EnrollSecret, CAName: caConfig.CAName})
+	if err != nil {
+		return nil, err
+	}
+	return w.identityMgr.GetSigningIdentity(registrar.EnrollID)
+}
+
+// defaultRegistrar returns the signer of the registrar of the default CA of the client org
+func (w *idClientWrapper) defaultRegistrar() (msp.SigningIdentity, error) {
+	caConfig, err := w.caConfig("")
+	if err != nil {
+		return nil, err
+	}
+	return w.registrarIdentity(caConfig)
+}
+
+// ListCertificates returns the certificates issued by the CA, filtered by enrollment ID, serial,
+// AKI, expiry, revocation and validity ranges
+func (w *idClientWrapper) ListCertificates(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*identity.IssuedCertificate, *restutil.RestError) {
+	query := req.URL.Query()
+	certReq := &dep.CertificatesRequest{
+		ID:     query.Get("id"),
+		Serial: query.Get("serial"),
+		AKI:    query.Get("aki"),
+		CAName: query.Get("caname"),
+	}
+	var restErr *restutil.RestError
+	if certReq.Expired, restErr = queryBool(query, "expired"); restErr != nil {
+		return nil, restErr
+	}
+	if certReq.Revoked, restErr = queryBool(query, "revoked"); restErr != nil {
+		return nil, restErr
+	}
+	if certReq.NotAfterStart, restErr = queryTime(query, "notAfterStart"); restErr != nil {
+		return nil, restErr
+	}
+	if certReq.NotAfterEnd, restErr = queryTime(query, "notAfterEnd"); restErr != nil {
+		return nil, restErr
+	}
+	// the CA filters by expiry only, the issue range is applied to the certificates it returns
+	notBeforeStart, restErr := queryTime(query, "notBeforeStart")
+	if restErr != nil {
+		return nil, restErr
+	}
+	notBeforeEnd, restErr := queryTime(query, "notBeforeEnd")
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	result, err := w.certAuditor.GetCertificates(certReq)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	now := time.Now()
+	certs := make([]*identity.IssuedCertificate, 0, len(result.Certs))
+	for _, certPEM := range result.Certs {
+		cert, err := parseCertificate(certReq.ID, certPEM)
+		if err != nil {
+			log.Warnf("Skipping a certificate returned by the Fabric CA. %s", err)
+			continue
+		}
+		if (notBeforeStart != nil && cert.NotBefore.Before(*notBeforeStart)) || (notBeforeEnd != nil && cert.NotBefore.After(*notBeforeEnd)) {
+			continue
+		}
+		decoded, err := decodeCertificate(cert.Subject.CommonName, certPEM)
+		if err != nil {
+			log.Warnf("Skipping a certificate returned by the Fabric CA. %s", err)
+			continue
+		}
+		certs = append(certs, &identity.IssuedCertificate{
+			Certificate:  *decoded,
+			EnrollmentID: cert.Subject.CommonName,
+			AKI:          hex.EncodeToString(cert.AuthorityKeyId),
+			Expired:      now.After(cert.NotAfter),
+			PEM:          string(certPEM),
+		})
+	}
+	return certs, nil
+}
+
+// GetCRL returns a CRL freshly generated by the CA, PEM encoded unless DER is requested
+func (w *idClientWrapper) GetCRL(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.CRL, *restutil.RestError) {
+	query := req.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = identity.CRLFormatPEM
+	}
+	if format != identity.CRLFormatPEM && format != identity.CRLFormatDER {
+		return nil, restutil.NewRestError(errors.Errorf(errors.CRLFormatUnknown, format).Error(), 400)
+	}
+	crlReq := &dep.GenCRLRequest{CAName: query.Get("caname")}
+	var restErr *restutil.RestError
+	for name, value := range map[string]**time.Time{
+		"revokedAfter":  &crlReq.RevokedAfter,
+		"revokedBefore": &crlReq.RevokedBefore,
+		"expireAfter":   &crlReq.ExpireAfter,
+		"expireBefore":  &crlReq.ExpireBefore,
+	} {
+		if *value, restErr = queryTime(query, name); restErr != nil {
+			return nil, restErr
+		}
+	}
+
+	result, err := w.certAuditor.GenCRL(crlReq)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	if format == identity.CRLFormatPEM {
+		return &identity.CRL{Format: format, Data: result.CRL}, nil
+	}
+	block, _ := pem.Decode(result.CRL)
+	if block == nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.CRLGenerateFailed, "no PEM block found").Error(), 500)
+	}
+	return &identity.CRL{Format: format, Data: block.Bytes}, nil
+}
+
+func queryBool(query url.Values, name string) (*bool, *restutil.RestError) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.CertificatesFilterInvalid, value, name, err).Error(), 400)
+	}
+	return &b, nil
+}
+
+func queryTime(query url.Values, name string) (*time.Time, *restutil.RestError) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.CertificatesFilterInvalid, value, name, err).Error(), 400)
+	}
+	return &t, nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testKeySigningIdentity signs with its ECDSA key, as the SDK signing identities do
+type testKeySigningIdentity struct {
+	testSigningIdentity
+	key *ecdsa.PrivateKey
+}
+
+func (s *testKeySigningIdentity) Sign(msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	return ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+}
+
+// verifyCAToken checks the token of a request the way the Fabric CA does
+func verifyCAToken(t *testing.T, r *http.Request, body []byte) {
+	parts := strings.Split(r.Header.Get("Authorization"), ".")
+	assert.Len(t, parts, 2)
+	certPEM, _ := base64.StdEncoding.DecodeString(parts[0])
+	cert, err := parseCertificate("admin", certPEM)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", cert.Subject.CommonName)
+	signature, _ := base64.StdEncoding.DecodeString(parts[1])
+	payload := r.Method + "." + base64.StdEncoding.EncodeToString([]byte(r.URL.RequestURI())) + "." + base64.StdEncoding.EncodeToString(body) + "." + parts[0]
+	digest := sha256.Sum256([]byte(payload))
+	assert.True(t, ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), digest[:], signature))
+}
+
+func newTestCAAuditClient(t *testing.T, handler http.HandlerFunc) (*caRESTClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	key, certPEM := newTestSignerKey(t, "admin")
+	c := newCARESTClient(&testIdentityConfig{cas: map[string]*msp.CAConfig{
+		"ca.org1.example.com": {ID: "ca.org1.example.com", URL: server.URL, CAName: "ca1"},
+	}}, []string{"ca.org1.example.com"})
+	c.registrar = func(caConfig *msp.CAConfig) (msp.SigningIdentity, error) {
+		return &testKeySigningIdentity{testSigningIdentity: testSigningIdentity{cert: certPEM}, key: key}, nil
+	}
+	return c, server
+}
+
+func TestCAClientGetCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	c, server := newTestCAAuditClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/api/v1/certificates", r.URL.Path)
+		assert.Equal("user1", r.URL.Query().Get("id"))
+		assert.Equal("ca1", r.URL.Query().Get("ca"))
+		assert.Equal("true", r.URL.Query().Get("notrevoked"))
+		assert.Equal("2026-01-01T00:00:00Z", r.URL.Query().Get("expired_start"))
+		assert.NotEmpty(r.URL.Query().Get("expired_end"))
+		verifyCAToken(t, r, nil)
+		_, _ = w.Write([]byte(`{"success":true,"result":{"caname":"ca1","certs":[{"PEM":"cert1"},{"PEM":"cert2"}]}}`))
+	})
+	defer server.Close()
+
+	expired, revoked := true, false
+	res, err := c.GetCertificates(&dep.CertificatesRequest{ID: "user1", Expired: &expired, Revoked: &revoked, NotAfterStart: &testCertNotBefore})
+	assert.NoError(err)
+	assert.Equal(&dep.CertificatesResponse{CAName: "ca1", Certs: [][]byte{[]byte("cert1"), []byte("cert2")}}, res)
+}
+
+func TestCAClientGenCRL(t *testing.T) {
+	assert := assert.New(t)
+
+	c, server := newTestCAAuditClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/api/v1/gencrl", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		verifyCAToken(t, r, body)
+		var received caGenCRLRequest
+		_ = json.Unmarshal(body, &received)
+		assert.Equal("ca1", received.CAName)
+		assert.True(testCertNotBefore.Equal(*received.RevokedAfter))
+		assert.Nil(received.ExpireBefore)
+		_, _ = w.Write([]byte(`{"success":true,"result":{"CRL":"` + base64.StdEncoding.EncodeToString([]byte("crl")) + `"}}`))
+	})
+	defer server.Close()
+
+	res, err := c.GenCRL(&dep.GenCRLRequest{RevokedAfter: &testCertNotBefore})
+	assert.NoError(err)
+	assert.Equal([]byte("crl"), res.CRL)
+}
+
+func TestCAClientAuditFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	c, server := newTestCAAuditClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":71,"message":"Authorization failure"}]}`))
+	})
+	defer server.Close()
+
+	_, err := c.GetCertificates(&dep.CertificatesRequest{})
+	assert.EqualError(err, "Failed to list the certificates of the Fabric CA: 71 Authorization failure")
+	_, err = c.GenCRL(&dep.GenCRLRequest{})
+	assert.EqualError(err, "Failed to generate the CRL of the Fabric CA: 71 Authorization failure")
+	_, err = c.GenCRL(&dep.GenCRLRequest{CAName: "ca2"})
+	assert.EqualError(err, "No Fabric CA named 'ca2' is configured for the organization")
+
+	c.registrar = func(caConfig *msp.CAConfig) (msp.SigningIdentity, error) {
+		return nil, fmt.Errorf("bang")
+	}
+	_, err = c.GetCertificates(&dep.CertificatesRequest{})
+	assert.EqualError(err, "Failed to list the certificates of the Fabric CA: bang")
+
+	c.registrar = nil
+	_, err = c.GetCertificates(&dep.CertificatesRequest{})
+	assert.EqualError(err, "Failed to list the certificates of the Fabric CA: No registrar is configured for the Fabric CA 'ca.org1.example.com'")
+}
+
+func TestRegistrarIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("Enroll", &mspApi.EnrollmentRequest{Name: "admin", Secret: "pwd", CAName: "ca1"}).Return(nil)
+	w := &idClientWrapper{
+		caClient:    &mockCAClient,
+		identityMgr: &testIdentityManager{identities: map[string]msp.SigningIdentity{}},
+	}
+	_, err := w.registrarIdentity(&msp.CAConfig{ID: "ca.org1.example.com"})
+	assert.EqualError(err, "No registrar is configured for the Fabric CA 'ca.org1.example.com'")
+
+	_, err = w.registrarIdentity(&msp.CAConfig{CAName: "ca1", Registrar: msp.EnrollCredentials{EnrollID: "admin", EnrollSecret: "pwd"}})
+	assert.Equal(msp.ErrUserNotFound, err)
+	mockCAClient.AssertExpectations(t)
+
+	registrar := &testSigningIdentity{cert: []byte("admin cert")}
+	w.identityMgr.(*testIdentityManager).identities["admin"] = registrar
+	signer, err := w.registrarIdentity(&msp.CAConfig{CAName: "ca1", Registrar: msp.EnrollCredentials{EnrollID: "admin", EnrollSecret: "pwd"}})
+	assert.NoError(err)
+	assert.Equal(registrar, signer)
+	mockCAClient.AssertNumberOfCalls(t, "Enroll", 1)
+}
+
+func TestListCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	expiredCert := newTestCertificate(t, "user1", testCertNotBefore.AddDate(0, 1, 0), nil)
+	validCert := newTestCertificate(t, "user1", time.Now().AddDate(1, 0, 0), nil)
+	mockAuditor := mockfabricdep.CertificateAuditor{}
+	mockAuditor.On("GetCertificates", mock.MatchedBy(func(req *dep.CertificatesRequest) bool {
+		return req.ID == "user1" && *req.Revoked && req.Expired == nil && req.NotAfterEnd != nil
+	})).Return(&dep.CertificatesResponse{Certs: [][]byte{expiredCert, []byte("bad"), validCert}}, nil)
+	w := &idClientWrapper{certAuditor: &mockAuditor}
+
+	r := httptest.NewRequest(http.MethodGet, "/certificates?id=user1&revoked=true&notAfterEnd=2030-01-01T00:00:00Z", nil)
+	certs, restErr := w.ListCertificates(httptest.NewRecorder(), r, nil)
+	assert.Nil(restErr)
+	assert.Len(certs, 2)
+	assert.Equal("user1", certs[0].EnrollmentID)
+	assert.True(certs[0].Expired)
+	assert.False(certs[1].Expired)
+	assert.Equal("1a2b3c", certs[1].Serial)
+	assert.Equal(string(validCert), certs[1].PEM)
+
+	// the issue range is applied by the gateway
+	r = httptest.NewRequest(http.MethodGet, "/certificates?id=user1&revoked=true&notAfterEnd=2030-01-01T00:00:00Z&notBeforeStart=2026-02-01T00:00:00Z", nil)
+	certs, restErr = w.ListCertificates(httptest.NewRecorder(), r, nil)
+	assert.Nil(restErr)
+	assert.Empty(certs)
+}
+
+func TestListCertificatesFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockAuditor := mockfabricdep.CertificateAuditor{}
+	mockAuditor.On("GetCertificates", mock.Anything).Return(nil, fmt.Errorf("bang"))
+	w := &idClientWrapper{certAuditor: &mockAuditor}
+
+	for query, message := range map[string]string{
+		"expired=maybe":         "Invalid value 'maybe' of the filter 'expired'",
+		"revoked=maybe":         "Invalid value 'maybe' of the filter 'revoked'",
+		"notAfterStart=today":   "Invalid value 'today' of the filter 'notAfterStart'",
+		"notAfterEnd=today":     "Invalid value 'today' of the filter 'notAfterEnd'",
+		"notBeforeStart=today":  "Invalid value 'today' of the filter 'notBeforeStart'",
+		"notBeforeEnd=tomorrow": "Invalid value 'tomorrow' of the filter 'notBeforeEnd'",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/certificates?"+query, nil)
+		_, restErr := w.ListCertificates(httptest.NewRecorder(), r, nil)
+		assert.Equal(400, restErr.StatusCode, query)
+		assert.Contains(restErr.Error.Error(), message)
+	}
+	mockAuditor.AssertNotCalled(t, "GetCertificates", mock.Anything)
+
+	r := httptest.NewRequest(http.MethodGet, "/certificates", nil)
+	_, restErr := w.ListCertificates(httptest.NewRecorder(), r, nil)
+	assert.Equal(500, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "bang")
+}
+
+func TestGetCRL(t *testing.T) {
+	assert := assert.New(t)
+
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: []byte("crl")})
+	mockAuditor := mockfabricdep.CertificateAuditor{}
+	mockAuditor.On("GenCRL", mock.MatchedBy(func(req *dep.GenCRLRequest) bool {
+		return req.CAName == "ca1" && req.ExpireAfter != nil && req.RevokedAfter == nil
+	})).Return(&dep.GenCRLResponse{CRL: crlPEM}, nil)
+	w := &idClientWrapper{certAuditor: &mockAuditor}
+
+	r := httptest.NewRequest(http.MethodGet, "/crl?caname=ca1&expireAfter=2026-01-01T00:00:00Z", nil)
+	crl, restErr := w.GetCRL(httptest.NewRecorder(), r, nil)
+	assert.Nil(restErr)
+	assert.Equal(&identity.CRL{Format: "pem", Data: crlPEM}, crl)
+
+	r = httptest.NewRequest(http.MethodGet, "/crl?caname=ca1&expireAfter=2026-01-01T00:00:00Z&format=DER", nil)
+	crl, restErr = w.GetCRL(httptest.NewRecorder(), r, nil)
+	assert.Nil(restErr)
+	assert.Equal(&identity.CRL{Format: "der", Data: []byte("crl")}, crl)
+}
+
+func TestGetCRLFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockAuditor := mockfabricdep.CertificateAuditor{}
+	mockAuditor.On("GenCRL", &dep.GenCRLRequest{CAName: "ca1"}).Return(nil, fmt.Errorf("bang"))
+	mockAuditor.On("GenCRL", &dep.GenCRLRequest{}).Return(&dep.GenCRLResponse{CRL: []byte("not pem")}, nil)
+	w := &idClientWrapper{certAuditor: &mockAuditor}
+
+	r := httptest.NewRequest(http.MethodGet, "/crl?format=json", nil)
+	_, restErr := w.GetCRL(httptest.NewRecorder(), r, nil)
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Unknown CRL format 'json', must be pem or der")
+
+	r = httptest.NewRequest(http.MethodGet, "/crl?revokedBefore=yesterday", nil)
+	_, restErr = w.GetCRL(httptest.NewRecorder(), r, nil)
+	assert.Equal(400, restErr.StatusCode)
+
+	r = httptest.NewRequest(http.MethodGet, "/crl?caname=ca1", nil)
+	_, restErr = w.GetCRL(httptest.NewRecorder(), r, nil)
+	assert.Equal(500, restErr.StatusCode)
+
+	r = httptest.NewRequest(http.MethodGet, "/crl?format=der", nil)
+	_, restErr = w.GetCRL(httptest.NewRecorder(), r, nil)
+	assert.Equal(500, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Failed to generate the CRL of the Fabric CA: no PEM block found")
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+)
+
+// attributesOID is the extension of the attributes the Fabric CA adds to the enrollment certificates
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// parseCertificate decodes a PEM encoded enrollment certificate
+func parseCertificate(username string, certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.Errorf(errors.CertificateParseFailed, username, "no PEM block found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Errorf(errors.CertificateParseFailed, username, err)
+	}
+	return cert, nil
+}
+
+// decodeCertificate returns the details of a PEM encoded enrollment certificate, with the
+// attributes of its Fabric extension
+func decodeCertificate(username string, certPEM []byte) (*identity.Certificate, error) {
+	cert, err := parseCertificate(username, certPEM)
+	if err != nil {
+		return nil, err
+	}
+	result := &identity.Certificate{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		Serial:         fmt.Sprintf("%x", cert.SerialNumber),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		result.IPAddresses = append(result.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		result.URIs = append(result.URIs, uri.String())
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(attributesOID) {
+			continue
+		}
+		// the extension holds the JSON of the attributes, see fabric-ca lib/attrmgr
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if err := json.Unmarshal(ext.Value, &attrs); err != nil {
+			return nil, errors.Errorf(errors.CertificateAttributesParseFailed, username, err)
+		}
+		result.Attributes = attrs.Attrs
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCertNotBefore = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestCertificate returns a PEM encoded certificate as issued by the Fabric CA
+func newTestCertificate(t *testing.T, username string, notAfter time.Time, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	uri, _ := url.Parse("spiffe://org1.example.com/" + username)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x1a2b3c),
+		Subject:        pkix.Name{CommonName: username, OrganizationalUnit: []string{"client", "org1"}},
+		NotBefore:      testCertNotBefore,
+		NotAfter:       notAfter,
+		DNSNames:       []string{"host1"},
+		EmailAddresses: []string{username + "@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{uri},
+	}
+	if attrs != nil {
+		value, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: attributesOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDecodeCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	notAfter := testCertNotBefore.AddDate(1, 0, 0)
+	certPEM := newTestCertificate(t, "user1", notAfter, map[string]string{"hf.EnrollmentID": "user1", "hf.Type": "client", "email": "user1@example.com"})
+	cert, err := decodeCertificate("user1", certPEM)
+	assert.NoError(err)
+	assert.Equal(&identity.Certificate{
+		Subject:        "CN=user1,OU=org1+OU=client",
+		Issuer:         "CN=user1,OU=org1+OU=client",
+		Serial:         "1a2b3c",
+		NotBefore:      testCertNotBefore,
+		NotAfter:       notAfter,
+		DNSNames:       []string{"host1"},
+		EmailAddresses: []string{"user1@example.com"},
+		IPAddresses:    []string{"10.0.0.1"},
+		URIs:           []string{"spiffe://org1.example.com/user1"},
+		Attributes:     map[string]string{"hf.EnrollmentID": "user1", "hf.Type": "client", "email": "user1@example.com"},
+	}, cert)
+
+	cert, err = decodeCertificate("user2", newTestCertificate(t, "user2", notAfter, nil))
+	assert.NoError(err)
+	assert.Nil(cert.Attributes)
+}
+
+func TestDecodeCertificateFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := decodeCertificate("user1", []byte("not a certificate"))
+	assert.EqualError(err, "Failed to parse the enrollment certificate of 'user1': no PEM block found")
+
+	_, err = decodeCertificate("user1", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0x30}}))
+	assert.Regexp("Failed to parse the enrollment certificate of 'user1'", err)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "user1"},
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: attributesOID, Value: []byte("{")}},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	_, err = decodeCertificate("user1", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	assert.Regexp("Failed to parse the attributes of the enrollment certificate of 'user1'", err)
+}
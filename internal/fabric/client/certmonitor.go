@@ -0,0 +1,112 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// certMonitor periodically checks the expiry of the enrollment certificates of the signers
+type certMonitor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (w *idClientWrapper) startCertMonitor() {
+	if w.certExpiryConf.Interval <= 0 {
+		return
+	}
+	w.certMonitor = &certMonitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.certMonitorLoop(w.certMonitor, time.Duration(w.certExpiryConf.Interval)*time.Second)
+}
+
+func (w *idClientWrapper) certMonitorLoop(m *certMonitor, interval time.Duration) {
+	defer close(m.done)
+	w.checkCertificates()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.checkCertificates()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// checkCertificates records the expiry of the certificates of the signers of the MSP of the
+// client org, and re-enrolls those expiring within the re-enrollment window
+func (w *idClientWrapper) checkCertificates() {
+	signers, err := w.store.List(w.mspID)
+	if err != nil {
+		log.Errorf("Failed to list the signers of %s in the credential store. %s", w.mspID, err)
+		return
+	}
+	window := time.Duration(w.certExpiryConf.ReenrollWindow) * time.Second
+	for _, signer := range signers {
+		notAfter, err := w.certExpiry(signer)
+		if err != nil {
+			log.Errorf("Failed to check the enrollment certificate of signer %s. %s", signer, err)
+			continue
+		}
+		metrics.SignerCertExpiry.WithLabelValues(signer, w.mspID).Set(float64(notAfter.Unix()))
+		remaining := time.Until(notAfter)
+		if window <= 0 || remaining > window {
+			continue
+		}
+		log.Infof("The enrollment certificate of signer %s expires at %s, re-enrolling", signer, notAfter)
+		w.reenrollSigner(signer)
+	}
+}
+
+// certExpiry returns the NotAfter of the enrollment certificate of a signer
+func (w *idClientWrapper) certExpiry(signer string) (time.Time, error) {
+	user, err := w.store.Load(msp.IdentityIdentifier{MSPID: w.mspID, ID: signer})
+	if err != nil {
+		return time.Time{}, err
+	}
+	cert, err := parseCertificate(signer, user.EnrollmentCertificate)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// reenrollSigner renews the certificate of a signer, and drops the clients cached for the signer
+// so they pick up the new certificate
+func (w *idClientWrapper) reenrollSigner(signer string) {
+	if err := w.caClient.Reenroll(&mspApi.ReenrollmentRequest{Name: signer}); err != nil {
+		log.Errorf("Failed to re-enroll signer %s. %s", signer, err)
+		metrics.SignerReenrollments.WithLabelValues(signer, "failure").Inc()
+		return
+	}
+	metrics.SignerReenrollments.WithLabelValues(signer, "success").Inc()
+	w.notifySignerUpdate(signer)
+	if notAfter, err := w.certExpiry(signer); err == nil {
+		metrics.SignerCertExpiry.WithLabelValues(signer, w.mspID).Set(float64(notAfter.Unix()))
+		log.Infof("Re-enrolled signer %s, the new enrollment certificate expires at %s", signer, notAfter)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	mspImpl "github.com/hyperledger/fabric-sdk-go/pkg/msp"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/metrics"
+	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testSignerStore is an in-memory credential store
+type testSignerStore struct {
+	certs map[msp.IdentityIdentifier][]byte
+}
+
+func newTestSignerStore() *testSignerStore {
+	return &testSignerStore{certs: map[msp.IdentityIdentifier][]byte{}}
+}
+
+func (s *testSignerStore) Store(user *msp.UserData) error {
+	s.certs[msp.IdentityIdentifier{MSPID: user.MSPID, ID: user.ID}] = user.EnrollmentCertificate
+	return nil
+}
+
+func (s *testSignerStore) Load(id msp.IdentityIdentifier) (*msp.UserData, error) {
+	cert, ok := s.certs[id]
+	if !ok {
+		return nil, msp.ErrUserNotFound
+	}
+	return &msp.UserData{MSPID: id.MSPID, ID: id.ID, EnrollmentCertificate: cert}, nil
+}
+
+func (s *testSignerStore) Delete(id msp.IdentityIdentifier) error {
+	delete(s.certs, id)
+	return nil
+}
+
+func (s *testSignerStore) List(mspID string) ([]string, error) {
+	var ids []string
+	for id := range s.certs {
+		if id.MSPID == mspID {
+			ids = append(ids, id.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func TestCertUserStoreList(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "certstore")
+	defer os.RemoveAll(dir)
+	userStore, _ := mspImpl.NewCertFileUserStore(dir)
+	store := &certUserStore{CertFileUserStore: userStore, path: dir}
+	for _, id := range []msp.IdentityIdentifier{{MSPID: "Org1MSP", ID: "user1"}, {MSPID: "Org1MSP", ID: "user@example.com"}, {MSPID: "Org2MSP", ID: "user2"}} {
+		assert.NoError(store.Store(&msp.UserData{MSPID: id.MSPID, ID: id.ID, EnrollmentCertificate: []byte("cert")}))
+	}
+	assert.NoError(os.Mkdir(path.Join(dir, "keystore"), 0700))
+
+	ids, err := store.List("Org1MSP")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"user1", "user@example.com"}, ids)
+
+	store.path = path.Join(dir, "missing")
+	ids, err = store.List("Org1MSP")
+	assert.NoError(err)
+	assert.Empty(ids)
+}
+
+func newCertMonitorClient(window int) (*idClientWrapper, *testSignerStore, *mockfabricdep.CAClient, *testSignerListener) {
+	store := newTestSignerStore()
+	caClient := &mockfabricdep.CAClient{}
+	listener := &testSignerListener{}
+	w := &idClientWrapper{
+		caClient:       caClient,
+		store:          store,
+		mspID:          "Org1MSP",
+		certExpiryConf: conf.CertExpiryConf{Interval: 3600, ReenrollWindow: window},
+	}
+	w.AddSignerUpdateListener(listener)
+	return w, store, caClient, listener
+}
+
+func storeTestCert(t *testing.T, store *testSignerStore, signer string, notAfter time.Time) {
+	_ = store.Store(&msp.UserData{MSPID: "Org1MSP", ID: signer, EnrollmentCertificate: newTestCertificate(t, signer, notAfter, nil)})
+}
+
+func TestCheckCertificatesReenrolls(t *testing.T) {
+	assert := assert.New(t)
+
+	w, store, caClient, listener := newCertMonitorClient(86400)
+	expiring := time.Now().Add(time.Hour).Truncate(time.Second)
+	renewed := time.Now().AddDate(1, 0, 0).Truncate(time.Second)
+	valid := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	storeTestCert(t, store, "monitor-expiring", expiring)
+	storeTestCert(t, store, "monitor-valid", valid)
+	_ = store.Store(&msp.UserData{MSPID: "Org2MSP", ID: "monitor-other", EnrollmentCertificate: []byte("other org")})
+	caClient.On("Reenroll", &mspApi.ReenrollmentRequest{Name: "monitor-expiring"}).Run(func(args mock.Arguments) {
+		storeTestCert(t, store, "monitor-expiring", renewed)
+	}).Return(nil).Once()
+
+	w.checkCertificates()
+	caClient.AssertExpectations(t)
+	assert.Equal([]string{"monitor-expiring"}, listener.updated)
+	assert.Equal(float64(renewed.Unix()), testutil.ToFloat64(metrics.SignerCertExpiry.WithLabelValues("monitor-expiring", "Org1MSP")))
+	assert.Equal(float64(valid.Unix()), testutil.ToFloat64(metrics.SignerCertExpiry.WithLabelValues("monitor-valid", "Org1MSP")))
+	assert.Equal(float64(1), testutil.ToFloat64(metrics.SignerReenrollments.WithLabelValues("monitor-expiring", "success")))
+
+	// the renewed certificate is outside of the window
+	w.checkCertificates()
+	assert.Len(listener.updated, 1)
+}
+
+func TestCheckCertificatesReenrollFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	w, store, caClient, listener := newCertMonitorClient(86400)
+	storeTestCert(t, store, "monitor-failing", time.Now().Add(time.Hour))
+	_ = store.Store(&msp.UserData{MSPID: "Org1MSP", ID: "monitor-bad", EnrollmentCertificate: []byte("bad cert")})
+	caClient.On("Reenroll", mock.Anything).Return(fmt.Errorf("Authentication failure"))
+
+	w.checkCertificates()
+	caClient.AssertNumberOfCalls(t, "Reenroll", 1)
+	assert.Empty(listener.updated)
+	assert.Equal(float64(1), testutil.ToFloat64(metrics.SignerReenrollments.WithLabelValues("monitor-failing", "failure")))
+}
+
+func TestCheckCertificatesMonitorOnly(t *testing.T) {
+	w, store, caClient, _ := newCertMonitorClient(0)
+	expired := time.Now().Add(-time.Hour).Truncate(time.Second)
+	storeTestCert(t, store, "monitor-expired", expired)
+
+	w.checkCertificates()
+	caClient.AssertNotCalled(t, "Reenroll", mock.Anything)
+	assert.Equal(t, float64(expired.Unix()), testutil.ToFloat64(metrics.SignerCertExpiry.WithLabelValues("monitor-expired", "Org1MSP")))
+}
+
+func TestCertMonitorLoop(t *testing.T) {
+	w, store, _, _ := newCertMonitorClient(0)
+	notAfter := time.Now().AddDate(0, 0, 7).Truncate(time.Second)
+	storeTestCert(t, store, "monitor-loop", notAfter)
+
+	w.startCertMonitor()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.SignerCertExpiry.WithLabelValues("monitor-loop", "Org1MSP")) == float64(notAfter.Unix())
+	}, 5*time.Second, 10*time.Millisecond)
+	w.Close()
+	assert.Nil(t, w.certMonitor)
+
+	// disabled without an interval
+	w.certExpiryConf.Interval = 0
+	w.startCertMonitor()
+	assert.Nil(t, w.certMonitor)
+}
@@ -50,7 +50,7 @@ type ccpRPCWrapper struct {
 	mu             sync.Mutex
 }
 
-func newRPCClientFromCCP(configProvider core.ConfigProvider, txTimeout int, userStore msp.UserStore, idClient IdentityClient, ledgerClientWrapper *ledgerClientWrapper, eventClientWrapper *eventClientWrapper) (RPCClient, error) {
+func newRPCClientFromCCP(configProvider core.ConfigProvider, txTimeout int, userStore msp.UserStore, idClient IdentityClient, ledgerClientWrapper *ledgerClientWrapper, eventClientWrapper *eventClientWrapper, offlineSigner *offlineSigner) (RPCClient, error) {
 	configBackend, _ := configProvider()
 	cryptoConfig := cryptosuite.ConfigFromBackend(configBackend...)
 	identityConfig, err := mspImpl.ConfigFromBackend(configBackend...)
@@ -70,6 +70,7 @@ func newRPCClientFromCCP(configProvider core.ConfigProvider, txTimeout int, user
 			idClient:            idClient,
 			ledgerClientWrapper: ledgerClientWrapper,
 			eventClientWrapper:  eventClientWrapper,
+			offlineSigner:       offlineSigner,
 			channelCreator:      createChannelClient,
 			txTimeout:           txTimeout,
 		},
@@ -157,7 +158,8 @@ func (w *ccpRPCWrapper) getChannelClient(channelId string, signer string) (*ccpC
 	}
 	clientOfUser := w.channelClients[channelId][id.Identifier().ID]
 	if clientOfUser == nil {
-		channelProvider := w.sdk.ChannelContext(channelId, fabsdk.WithOrg(w.idClient.GetClientOrg()), fabsdk.WithUser(id.Identifier().ID))
+		// the signer may be an identity imported for another MSP, which the SDK cannot load by name
+		channelProvider := w.sdk.ChannelContext(channelId, fabsdk.WithIdentity(id))
 		cClient, err := w.channelCreator(channelProvider)
 		if err != nil {
 			return nil, err
@@ -23,6 +23,7 @@ type commonRPCWrapper struct {
 	ledgerClientWrapper *ledgerClientWrapper
 	eventClientWrapper  *eventClientWrapper
 	channelCreator      channelCreator
+	offlineSigner       *offlineSigner
 }
 
 func getOrgFromConfig(config core.ConfigProvider) (string, error) {
@@ -70,12 +71,13 @@ func createChannelClient(channelProvider context.ChannelProvider) (*channel.Clie
 
 func newReceipt(responsePayload []byte, status *fab.TxStatusEvent, signerID *msp.IdentityIdentifier) *TxReceipt {
 	return &TxReceipt{
-		SignerMSP:     signerID.MSPID,
-		Signer:        signerID.ID,
-		TransactionID: status.TxID,
-		Status:        status.TxValidationCode,
-		BlockNumber:   status.BlockNumber,
-		SourcePeer:    status.SourceURL,
+		SignerMSP:       signerID.MSPID,
+		Signer:          signerID.ID,
+		TransactionID:   status.TxID,
+		Status:          status.TxValidationCode,
+		BlockNumber:     status.BlockNumber,
+		SourcePeer:      status.SourceURL,
+		ResponsePayload: responsePayload,
 	}
 }
 
@@ -95,6 +97,49 @@ func convertStringMap(_map map[string]string) map[string][]byte {
 	return result
 }
 
+func (w *commonRPCWrapper) PrepareTransaction(channelId, signer, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) (*PreparedTx, error) {
+	log.Tracef("RPC [%s:%s:%s:isInit=%t] --> prepare %+v", channelId, chaincodeName, method, isInit, args)
+
+	result, err := w.offlineSigner.prepare(channelId, signer, chaincodeName, method, args, transientMap, isInit)
+	if err != nil {
+		log.Errorf("Failed to prepare transaction [%s:%s:%s:isInit=%t]. %s", channelId, chaincodeName, method, isInit, err)
+		return nil, err
+	}
+
+	log.Tracef("RPC [%s:%s:%s:isInit=%t] <-- %s", channelId, chaincodeName, method, isInit, result.TransactionID)
+	return result, nil
+}
+
+func (w *commonRPCWrapper) EndorseTransaction(channelId, signer, txId string, signature []byte) (*PreparedTx, error) {
+	log.Tracef("RPC [%s] --> endorse %s", channelId, txId)
+
+	result, err := w.offlineSigner.endorse(channelId, signer, txId, signature)
+	if err != nil {
+		log.Errorf("Failed to endorse transaction %s on channel %s. %s", txId, channelId, err)
+		return nil, err
+	}
+
+	log.Tracef("RPC [%s] <-- endorsed %s", channelId, txId)
+	return result, nil
+}
+
+func (w *commonRPCWrapper) SubmitTransaction(channelId, signer, txId string, signature []byte) (*TxReceipt, error) {
+	log.Tracef("RPC [%s] --> submit %s", channelId, txId)
+
+	result, err := w.offlineSigner.submit(channelId, signer, txId, signature)
+	if err != nil {
+		log.Errorf("Failed to submit transaction %s on channel %s. %s", txId, channelId, err)
+		return nil, err
+	}
+
+	log.Tracef("RPC [%s] <-- %+v", channelId, result)
+	return result, nil
+}
+
+func (w *commonRPCWrapper) GetSubmittedTxReceipt(channelId, signer, txId string) (*TxReceipt, error) {
+	return w.offlineSigner.committed(channelId, signer, txId)
+}
+
 func (w *commonRPCWrapper) QueryChainInfo(channelId, signer string) (*fab.BlockchainInfoResponse, error) {
 	log.Tracef("RPC [%s] --> QueryChainInfo", channelId)
 
@@ -18,7 +18,6 @@ package client
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"time"
 
@@ -29,6 +28,7 @@ import (
 	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/wallet"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -44,6 +44,9 @@ type gwRPCWrapper struct {
 	networkCreator networkCreator
 	txPreparer     txPreparer
 	txSubmitter    txSubmitter
+	// the identities of the signers the gateway clients connect with
+	wallet      *gateway.Wallet
+	walletStore wallet.Store
 	// networkCreator networkC
 	// one gateway client per signer
 	gwClients map[string]*gateway.Gateway
@@ -54,7 +57,7 @@ type gwRPCWrapper struct {
 	mu               sync.Mutex
 }
 
-func newRPCClientWithClientSideGateway(configProvider core.ConfigProvider, txTimeout int, idClient IdentityClient, ledgerClientWrapper *ledgerClientWrapper, eventClientWrapper *eventClientWrapper) (RPCClient, error) {
+func newRPCClientWithClientSideGateway(configProvider core.ConfigProvider, txTimeout int, idClient IdentityClient, ledgerClientWrapper *ledgerClientWrapper, eventClientWrapper *eventClientWrapper, offlineSigner *offlineSigner, walletStore wallet.Store) (RPCClient, error) {
 	w := &gwRPCWrapper{
 		commonRPCWrapper: &commonRPCWrapper{
 			txTimeout:           txTimeout,
@@ -62,17 +65,21 @@ func newRPCClientWithClientSideGateway(configProvider core.ConfigProvider, txTim
 			idClient:            idClient,
 			ledgerClientWrapper: ledgerClientWrapper,
 			eventClientWrapper:  eventClientWrapper,
+			offlineSigner:       offlineSigner,
 			channelCreator:      createChannelClient,
 		},
 		gatewayCreator:   createGateway,
 		networkCreator:   getNetwork,
 		txPreparer:       prepareTx,
 		txSubmitter:      submitTx,
+		wallet:           gateway.NewWalletWithStore(walletStore),
+		walletStore:      walletStore,
 		gwClients:        make(map[string]*gateway.Gateway),
 		gwGatewayClients: make(map[string]map[string]*gateway.Network),
 		gwChannelClients: make(map[string]map[string]*channel.Client),
 	}
 
+	idClient.AddSignerUpdateListener(w)
 	idClient.AddSignerIdUpdateListener(w)
 	return w, nil
 }
@@ -86,32 +93,6 @@ func (w *gwRPCWrapper) Invoke(channelId, signer, chaincodeName, method string, a
 		return nil, err
 	}
 
-	// wallet, err := gateway.NewFileSystemWallet("makeen-wallet")
-	// if err != nil {
-	// 	fmt.Printf("Failed to create wallet: %s\n", err)
-	// }
-
-	// signid, _ := wallet.Get(signer)
-
-	// certBytes := []byte(signid.(*gateway.X509Identity).Certificate())
-
-	// block, _ := pem.Decode(certBytes)
-	// if block == nil {
-	// 	fmt.Println("Failed to decode certificate PEM")
-	// 	return nil, nil
-	// }
-
-	// // Parse the certificate
-	// cert, err := x509.ParseCertificate(block.Bytes)
-	// if err != nil {
-	// 	fmt.Println("Failed to parse certificate:", err)
-	// 	return nil, nil
-	// }
-
-	// // Get the Common Name (CN) from the Subject field
-	// signerId := cert.Subject.CommonName
-	// fmt.Println("Common Name (CN):", signerId)
-
 	// TODO: change signer to signerId
 	signingId, err := w.idClient.GetSigningIdentity(signer)
 	if err != nil {
@@ -168,25 +149,26 @@ func (w *gwRPCWrapper) Query(channelId, signer, chaincodeName, method string, ar
 }
 
 func (w *gwRPCWrapper) SignerIdUpdated(signer string, signerId string) {
-	// fmt.Println("HERE - GW SIGNER")
-	// fmt.Println("GATEWAY SIGNER: ", signer)
+	// the gateway clients connect with the identity of the signer in the wallet
+	if err := w.putIdentity(signer); err != nil {
+		log.Errorf("Failed to store the identity of signer %s in the wallet. %s", signer, err)
+	}
+	w.SignerUpdated(signer)
+}
 
-	wallet, err := gateway.NewFileSystemWallet("makeen-wallet")
+func (w *gwRPCWrapper) putIdentity(signer string) error {
+	si, err := w.idClient.GetSigningIdentity(signer)
 	if err != nil {
-		fmt.Printf("Failed to create wallet: %s\n", err)
+		return err
 	}
+	pv, err := si.PrivateKey().Bytes()
+	if err != nil {
+		return err
+	}
+	return w.wallet.Put(signer, gateway.NewX509Identity(si.Identifier().MSPID, string(si.EnrollmentCertificate()), string(pv)))
+}
 
-	si, _ := w.idClient.GetSigningIdentity(signer)
-	pv, _ := si.PrivateKey().Bytes()
-
-	// privateKeyString := base64.StdEncoding.EncodeToString(pv)
-	// fmt.Println("ID: ", si.Identifier().ID)
-	// fmt.Println("CERT: ", string(si.EnrollmentCertificate()))
-
-	// fmt.Println("KEY: ", string(pv))
-	wallet.Put(signer, gateway.NewX509Identity(si.Identifier().MSPID, string(si.EnrollmentCertificate()), string(pv)))
-	// wallet.Get(signer)
-
+func (w *gwRPCWrapper) SignerUpdated(signer string) {
 	w.mu.Lock()
 	w.gwClients[signer] = nil
 	w.gwGatewayClients[signer] = nil
@@ -198,7 +180,7 @@ func (w *gwRPCWrapper) Close() error {
 	// the ledgerClientWrapper and the eventClientWrapper share the same sdk instance
 	// only need to close it from one of them
 	w.ledgerClientWrapper.sdk.Close()
-	return nil
+	return w.walletStore.Close()
 }
 
 func (w *gwRPCWrapper) sendTransaction(signer, channelId, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) ([]byte, *fab.TxStatusEvent, error) {
@@ -233,34 +215,7 @@ func (w *gwRPCWrapper) getGatewayClient(channelId, signer string) (gatewayClient
 	if gatewayClientsForSigner == nil {
 		// no channel clients have been created for this signer at all
 		// we will not have created a gateway client for this user either
-
-		wallet, err := gateway.NewFileSystemWallet("makeen-wallet")
-		if err != nil {
-			fmt.Printf("Failed to create wallet: %s\n", err)
-		}
-
-		// signid, _ := wallet.Get(signer)
-
-		// certBytes := []byte(signid.(*gateway.X509Identity).Certificate())
-
-		// block, _ := pem.Decode(certBytes)
-		// if block == nil {
-		// 	fmt.Println("Failed to decode certificate PEM")
-		// 	return nil, nil
-		// }
-
-		// // Parse the certificate
-		// cert, err := x509.ParseCertificate(block.Bytes)
-		// if err != nil {
-		// 	fmt.Println("Failed to parse certificate:", err)
-		// 	return nil, nil
-		// }
-
-		// // Get the Common Name (CN) from the Subject field
-		// signerId := cert.Subject.CommonName
-		// fmt.Println("Common Name (CN):", signerId)
-
-		gatewayClient, err := w.gatewayCreator(w.configProvider, wallet, signer, w.txTimeout)
+		gatewayClient, err := w.gatewayCreator(w.configProvider, w.wallet, signer, w.txTimeout)
 		if err != nil {
 			return nil, err
 		}
@@ -293,32 +248,6 @@ func (w *gwRPCWrapper) getChannelClient(channelId, signer string) (channelClient
 		w.gwChannelClients[signer] = channelClientsForSigner
 	}
 
-	// wallet, err := gateway.NewFileSystemWallet("makeen-wallet")
-	// if err != nil {
-	// 	fmt.Printf("Failed to create wallet: %s\n", err)
-	// }
-
-	// signid, _ := wallet.Get(signer)
-
-	// certBytes := []byte(signid.(*gateway.X509Identity).Certificate())
-
-	// block, _ := pem.Decode(certBytes)
-	// if block == nil {
-	// 	fmt.Println("Failed to decode certificate PEM")
-	// 	return nil, nil
-	// }
-
-	// // Parse the certificate
-	// cert, err := x509.ParseCertificate(block.Bytes)
-	// if err != nil {
-	// 	fmt.Println("Failed to parse certificate:", err)
-	// 	return nil, nil
-	// }
-
-	// // Get the Common Name (CN) from the Subject field
-	// signerId := cert.Subject.CommonName
-	// fmt.Println("Common Name (CN):", signerId)
-
 	channelClient = channelClientsForSigner[channelId]
 	if channelClient == nil {
 		sdk := w.ledgerClientWrapper.sdk
@@ -338,18 +267,6 @@ func (w *gwRPCWrapper) getChannelClient(channelId, signer string) (channelClient
 }
 
 func createGateway(configProvider core.ConfigProvider, wallet *gateway.Wallet, signerId string, txTimeout int) (*gateway.Gateway, error) {
-
-	// return gateway.Connect(gateway.WithConfig(configProvider), gateway.WithUser(signer), gateway.WithTimeout(time.Duration(txTimeout)*time.Second))
-	// wallet, err := gateway.NewFileSystemWallet("makeen-wallet")
-	// if err != nil {
-	// 	fmt.Printf("Failed to create wallet: %s\n", err)
-	// }
-	// signid, _ := wallet.Get(signer)
-
-	// // si, _ := w.idClient.GetSigningIdentity(signer)
-
-	// log.Println("WALLET ID: ", signid)
-
 	return gateway.Connect(gateway.WithConfig(configProvider), gateway.WithIdentity(wallet, signerId), gateway.WithTimeout(time.Duration(txTimeout)*time.Second))
 }
 
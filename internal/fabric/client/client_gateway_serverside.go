@@ -0,0 +1,476 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	"github.com/hyperledger/fabric-protos-go/orderer"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config/comm"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config/endpoint"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/cryptosuite"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/cryptosuite/bccsp/sw"
+	fabImpl "github.com/hyperledger/fabric-sdk-go/pkg/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/signingmgr"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/txn"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	eventsapi "github.com/hyperledger/firefly-fabconnect/internal/events/api"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// defined to allow mocking in tests
+type gatewayServerConnector func(peer *fab.PeerConfig, endpointConfig fab.EndpointConfig) (*grpc.ClientConn, error)
+
+type gwServerRPCWrapper struct {
+	*commonRPCWrapper
+	endpointConfig   fab.EndpointConfig
+	signingMgr       *signingmgr.SigningManager
+	gatewayConnector gatewayServerConnector
+	// a single connection to the gateway service of the peer this instance is attached to,
+	// shared by all the signers as the identity travels inside the signed requests
+	conn          *grpc.ClientConn
+	gatewayClient gateway.GatewayClient
+	peerURL       string
+	mu            sync.Mutex
+}
+
+// implements fab.TransactionHeader for proposals that are built locally and sent to the gateway service
+type gwTxHeader struct {
+	id        fab.TransactionID
+	creator   []byte
+	nonce     []byte
+	channelID string
+}
+
+func (h *gwTxHeader) TransactionID() fab.TransactionID { return h.id }
+func (h *gwTxHeader) Creator() []byte                  { return h.creator }
+func (h *gwTxHeader) Nonce() []byte                    { return h.nonce }
+func (h *gwTxHeader) ChannelID() string                { return h.channelID }
+
+func newRPCClientWithServerSideGateway(configProvider core.ConfigProvider, txTimeout int, idClient IdentityClient, ledgerClientWrapper *ledgerClientWrapper, eventClientWrapper *eventClientWrapper, offlineSigner *offlineSigner) (RPCClient, error) {
+	configBackend, err := configProvider()
+	if err != nil {
+		return nil, errors.Errorf("Failed to read config: %s", err)
+	}
+	endpointConfig, err := fabImpl.ConfigFromBackend(configBackend...)
+	if err != nil {
+		return nil, errors.Errorf("Failed to read config: %s", err)
+	}
+	cs, err := sw.GetSuiteByConfig(cryptosuite.ConfigFromBackend(configBackend...))
+	if err != nil {
+		return nil, errors.Errorf("Failed to get suite by config: %s", err)
+	}
+	signingMgr, err := signingmgr.New(cs)
+	if err != nil {
+		return nil, errors.Errorf("Failed to create signing manager: %s", err)
+	}
+	w := &gwServerRPCWrapper{
+		commonRPCWrapper: &commonRPCWrapper{
+			txTimeout:           txTimeout,
+			configProvider:      configProvider,
+			idClient:            idClient,
+			ledgerClientWrapper: ledgerClientWrapper,
+			eventClientWrapper:  eventClientWrapper,
+			offlineSigner:       offlineSigner,
+			channelCreator:      createChannelClient,
+		},
+		endpointConfig:   endpointConfig,
+		signingMgr:       signingMgr,
+		gatewayConnector: connectGatewayServer,
+	}
+	return w, nil
+}
+
+func (w *gwServerRPCWrapper) Invoke(channelId, signer, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) (*TxReceipt, error) {
+	log.Tracef("RPC [%s:%s:%s:isInit=%t] --> %+v", channelId, chaincodeName, method, isInit, args)
+
+	signingId, result, txStatus, err := w.sendTransaction(channelId, signer, chaincodeName, method, args, transientMap, isInit)
+	if err != nil {
+		log.Errorf("Failed to send transaction [%s:%s:%s:isInit=%t]. %s", channelId, chaincodeName, method, isInit, err)
+		return nil, err
+	}
+
+	log.Tracef("RPC [%s:%s:%s:isInit=%t] <-- %+v", channelId, chaincodeName, method, isInit, txStatus)
+	return newReceipt(result, txStatus, signingId.Identifier()), nil
+}
+
+func (w *gwServerRPCWrapper) Query(channelId, signer, chaincodeName, method string, args []string, strongread bool) ([]byte, error) {
+	log.Tracef("RPC [%s:%s:%s] --> %+v", channelId, chaincodeName, method, args)
+
+	client, err := w.getGatewayServerClient()
+	if err != nil {
+		return nil, errors.Errorf("Failed to get gateway client. %s", err)
+	}
+	signingId, txId, signedProposal, err := w.prepareProposal(channelId, signer, chaincodeName, method, args, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &gateway.EvaluateRequest{
+		TransactionId:       txId,
+		ChannelId:           channelId,
+		ProposedTransaction: signedProposal,
+	}
+	if !strongread {
+		// keep the query on the peers of our own organization, instead of letting
+		// the gateway pick the peer with the highest block height across the network
+		req.TargetOrganizations = []string{signingId.Identifier().MSPID}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.txTimeout)*time.Second)
+	defer cancel()
+	result, err := client.Evaluate(ctx, req)
+	if err != nil {
+		log.Errorf("Failed to send query [%s:%s:%s]. %s", channelId, chaincodeName, method, err)
+		return nil, err
+	}
+
+	log.Tracef("RPC [%s:%s:%s] <-- %+v", channelId, chaincodeName, method, result)
+	return result.GetResult().GetPayload(), nil
+}
+
+// The returned registration must be closed when done
+func (w *gwServerRPCWrapper) SubscribeEvent(subInfo *eventsapi.SubscriptionInfo, since uint64) (*RegistrationWrapper, <-chan *fab.BlockEvent, <-chan *fab.CCEvent, error) {
+	if subInfo.Filter.ChaincodeId == "" {
+		// the gateway service only delivers chaincode events, block events still go through the event client
+		return w.commonRPCWrapper.SubscribeEvent(subInfo, since)
+	}
+
+	client, err := w.getGatewayServerClient()
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to get gateway client. %s", err)
+	}
+	var eventFilter *regexp.Regexp
+	if subInfo.Filter.EventFilter != "" {
+		eventFilter, err = regexp.Compile(subInfo.Filter.EventFilter)
+		if err != nil {
+			return nil, nil, nil, errors.Errorf("Failed to compile event filter %s. %s", subInfo.Filter.EventFilter, err)
+		}
+	}
+	signingId, err := w.idClient.GetSigningIdentity(subInfo.Signer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	creator, err := signingId.Serialize()
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to serialize identity %s. %s", subInfo.Signer, err)
+	}
+
+	req := &gateway.ChaincodeEventsRequest{
+		ChannelId:   subInfo.ChannelId,
+		ChaincodeId: subInfo.Filter.ChaincodeId,
+		Identity:    creator,
+		StartPosition: &orderer.SeekPosition{
+			Type: &orderer.SeekPosition_Specified{
+				Specified: &orderer.SeekSpecified{Number: since},
+			},
+		},
+	}
+	reqBytes, err := proto.Marshal(req)
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to marshal chaincode events request. %s", err)
+	}
+	signature, err := w.signingMgr.Sign(reqBytes, signingId.PrivateKey())
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to sign chaincode events request. %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.ChaincodeEvents(ctx, &gateway.SignedChaincodeEventsRequest{Request: reqBytes, Signature: signature})
+	if err != nil {
+		cancel()
+		log.Errorf("Failed to subscribe to event [%s:%s:%s]. %s", subInfo.Stream, subInfo.ChannelId, subInfo.Filter.ChaincodeId, err)
+		return nil, nil, nil, errors.Errorf("Failed to subscribe to chaincode %s events. %s", subInfo.Filter.ChaincodeId, err)
+	}
+	log.Infof("Subscribed to events in channel %s chaincode %s from block %d", subInfo.ChannelId, subInfo.Filter.ChaincodeId, since)
+
+	notifier := make(chan *fab.CCEvent)
+	go w.relayChaincodeEvents(ctx, stream, eventFilter, notifier)
+	regWrapper := &RegistrationWrapper{
+		cancel: cancel,
+	}
+	return regWrapper, nil, notifier, nil
+}
+
+func (w *gwServerRPCWrapper) Unregister(regWrapper *RegistrationWrapper) {
+	if regWrapper.cancel != nil {
+		regWrapper.cancel()
+		return
+	}
+	w.commonRPCWrapper.Unregister(regWrapper)
+}
+
+func (w *gwServerRPCWrapper) Close() error {
+	w.mu.Lock()
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+		w.gatewayClient = nil
+	}
+	w.mu.Unlock()
+	// the ledgerClientWrapper and the eventClientWrapper share the same sdk instance
+	// only need to close it from one of them
+	w.ledgerClientWrapper.sdk.Close()
+	return nil
+}
+
+// runs the endorse -> submit -> commit status sequence against the gateway service
+func (w *gwServerRPCWrapper) sendTransaction(channelId, signer, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) (msp.SigningIdentity, []byte, *fab.TxStatusEvent, error) {
+	client, err := w.getGatewayServerClient()
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to get gateway client. %s", err)
+	}
+	signingId, txId, signedProposal, err := w.prepareProposal(channelId, signer, chaincodeName, method, args, transientMap, isInit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.txTimeout)*time.Second)
+	defer cancel()
+	endorsement, err := client.Endorse(ctx, &gateway.EndorseRequest{
+		TransactionId:       txId,
+		ChannelId:           channelId,
+		ProposedTransaction: signedProposal,
+	})
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to endorse transaction %s. %s", txId, err)
+	}
+	envelope := endorsement.GetPreparedTransaction()
+	if envelope == nil {
+		return nil, nil, nil, errors.Errorf("Gateway returned no prepared transaction for %s", txId)
+	}
+	result, err := transactionResult(envelope)
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to decode the result of transaction %s. %s", txId, err)
+	}
+	envelope.Signature, err = w.signingMgr.Sign(envelope.Payload, signingId.PrivateKey())
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to sign transaction %s. %s", txId, err)
+	}
+	_, err = client.Submit(ctx, &gateway.SubmitRequest{
+		TransactionId:       txId,
+		ChannelId:           channelId,
+		PreparedTransaction: envelope,
+	})
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to submit transaction %s. %s", txId, err)
+	}
+
+	creator, err := signingId.Serialize()
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to serialize identity %s. %s", signer, err)
+	}
+	statusReq, err := proto.Marshal(&gateway.CommitStatusRequest{
+		TransactionId: txId,
+		ChannelId:     channelId,
+		Identity:      creator,
+	})
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to marshal commit status request. %s", err)
+	}
+	signature, err := w.signingMgr.Sign(statusReq, signingId.PrivateKey())
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to sign commit status request. %s", err)
+	}
+	status, err := client.CommitStatus(ctx, &gateway.SignedCommitStatusRequest{Request: statusReq, Signature: signature})
+	if err != nil {
+		return nil, nil, nil, errors.Errorf("Failed to get status event for transaction (channel=%s, chaincode=%s, func=%s). %s", channelId, chaincodeName, method, err)
+	}
+
+	txStatus := &fab.TxStatusEvent{
+		TxID:             txId,
+		TxValidationCode: status.Result,
+		BlockNumber:      status.BlockNumber,
+		SourceURL:        w.peerURL,
+	}
+	return signingId, result, txStatus, nil
+}
+
+// transactionResult decodes the chaincode response payload from the transaction the gateway prepared,
+// as the gateway service only returns it inside the endorsed transaction
+func transactionResult(envelope *common.Envelope) ([]byte, error) {
+	payload := &common.Payload{}
+	if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+		return nil, err
+	}
+	transaction := &pb.Transaction{}
+	if err := proto.Unmarshal(payload.Data, transaction); err != nil {
+		return nil, err
+	}
+	if len(transaction.Actions) == 0 {
+		return nil, errors.Errorf("no transaction action")
+	}
+	actionPayload := &pb.ChaincodeActionPayload{}
+	if err := proto.Unmarshal(transaction.Actions[0].Payload, actionPayload); err != nil {
+		return nil, err
+	}
+	if actionPayload.Action == nil {
+		return nil, errors.Errorf("no endorsed action")
+	}
+	responsePayload := &pb.ProposalResponsePayload{}
+	if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload); err != nil {
+		return nil, err
+	}
+	chaincodeAction := &pb.ChaincodeAction{}
+	if err := proto.Unmarshal(responsePayload.Extension, chaincodeAction); err != nil {
+		return nil, err
+	}
+	return chaincodeAction.GetResponse().GetPayload(), nil
+}
+
+// builds a chaincode proposal for the signer and signs it, ready to be sent to either Evaluate or Endorse
+func (w *gwServerRPCWrapper) prepareProposal(channelId, signer, chaincodeName, method string, args []string, transientMap map[string]string, isInit bool) (msp.SigningIdentity, string, *pb.SignedProposal, error) {
+	signingId, err := w.idClient.GetSigningIdentity(signer)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	creator, err := signingId.Serialize()
+	if err != nil {
+		return nil, "", nil, errors.Errorf("Failed to serialize identity %s. %s", signer, err)
+	}
+	txh, err := newGatewayTxHeader(channelId, creator)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	proposal, err := txn.CreateChaincodeInvokeProposal(txh, fab.ChaincodeInvokeRequest{
+		ChaincodeID:  chaincodeName,
+		Fcn:          method,
+		Args:         convertStringArray(args),
+		TransientMap: convertStringMap(transientMap),
+		IsInit:       isInit,
+	})
+	if err != nil {
+		return nil, "", nil, errors.Errorf("Failed to create chaincode proposal. %s", err)
+	}
+	proposalBytes, err := proto.Marshal(proposal.Proposal)
+	if err != nil {
+		return nil, "", nil, errors.Errorf("Failed to marshal chaincode proposal. %s", err)
+	}
+	signature, err := w.signingMgr.Sign(proposalBytes, signingId.PrivateKey())
+	if err != nil {
+		return nil, "", nil, errors.Errorf("Failed to sign chaincode proposal. %s", err)
+	}
+	signedProposal := &pb.SignedProposal{
+		ProposalBytes: proposalBytes,
+		Signature:     signature,
+	}
+	return signingId, string(proposal.TxnID), signedProposal, nil
+}
+
+func (w *gwServerRPCWrapper) relayChaincodeEvents(ctx context.Context, stream gateway.Gateway_ChaincodeEventsClient, eventFilter *regexp.Regexp, notifier chan<- *fab.CCEvent) {
+	defer close(notifier)
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			log.Infof("Chaincode events stream from the gateway closed. %s", err)
+			return
+		}
+		for _, ccEvent := range res.Events {
+			if eventFilter != nil && !eventFilter.MatchString(ccEvent.EventName) {
+				continue
+			}
+			event := &fab.CCEvent{
+				TxID:        ccEvent.TxId,
+				ChaincodeID: ccEvent.ChaincodeId,
+				EventName:   ccEvent.EventName,
+				Payload:     ccEvent.Payload,
+				BlockNumber: res.BlockNumber,
+				SourceURL:   w.peerURL,
+			}
+			select {
+			case notifier <- event:
+			case <-ctx.Done():
+				// the subscription was unregistered while the event was pending delivery
+				return
+			}
+		}
+	}
+}
+
+func (w *gwServerRPCWrapper) getGatewayServerClient() (gateway.GatewayClient, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.gatewayClient != nil {
+		return w.gatewayClient, nil
+	}
+	peerName, err := getFirstPeerEndpointFromConfig(w.configProvider)
+	if err != nil {
+		return nil, err
+	}
+	peerConfig, ok := w.endpointConfig.PeerConfig(peerName)
+	if !ok {
+		return nil, errors.Errorf("No configuration found for peer %s", peerName)
+	}
+	conn, err := w.gatewayConnector(peerConfig, w.endpointConfig)
+	if err != nil {
+		return nil, errors.Errorf("Failed to connect to the gateway service on peer %s. %s", peerConfig.URL, err)
+	}
+	w.conn = conn
+	w.gatewayClient = gateway.NewGatewayClient(conn)
+	w.peerURL = peerConfig.URL
+	return w.gatewayClient, nil
+}
+
+func connectGatewayServer(peer *fab.PeerConfig, endpointConfig fab.EndpointConfig) (*grpc.ClientConn, error) {
+	allowInsecure, _ := peer.GRPCOptions["allow-insecure"].(bool)
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	if endpoint.AttemptSecured(peer.URL, allowInsecure) {
+		serverName, _ := peer.GRPCOptions["ssl-target-name-override"].(string)
+		tlsConfig, err := comm.TLSConfig(peer.TLSCACert, serverName, endpointConfig)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), endpointConfig.Timeout(fab.PeerConnection))
+	defer cancel()
+	return grpc.DialContext(ctx, endpoint.ToAddress(peer.URL), opts...)
+}
+
+func newGatewayTxHeader(channelId string, creator []byte) (*gwTxHeader, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Errorf("Failed to generate nonce. %s", err)
+	}
+	// the transaction ID is computed the same way the peer validates it: hex(sha256(nonce + creator))
+	h := sha256.New()
+	h.Write(nonce)
+	h.Write(creator)
+	return &gwTxHeader{
+		id:        fab.TransactionID(hex.EncodeToString(h.Sum(nil))),
+		creator:   creator,
+		nonce:     nonce,
+		channelID: channelId,
+	}, nil
+}
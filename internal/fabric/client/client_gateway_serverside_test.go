@@ -0,0 +1,352 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	eventsapi "github.com/hyperledger/firefly-fabconnect/internal/events/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeGatewayServer stands in for the gateway service of a Fabric 2.4 peer
+type fakeGatewayServer struct {
+	gateway.UnimplementedGatewayServer
+	endorseErr      error
+	lastProposal    *pb.Proposal
+	lastSubmitted   *common.Envelope
+	lastPrepared    *common.Envelope
+	lastEventsReq   *gateway.ChaincodeEventsRequest
+	lastTargetOrgs  []string
+	commitStatus    pb.TxValidationCode
+	commitBlock     uint64
+	evaluatePayload []byte
+	endorsePayload  []byte
+	badPrepared     bool
+}
+
+// preparedTransaction wraps the chaincode response in a transaction, as the gateway service does
+func preparedTransaction(txID string, result []byte) *common.Envelope {
+	action, _ := proto.Marshal(&pb.ChaincodeAction{Response: &pb.Response{Status: 200, Payload: result}})
+	responsePayload, _ := proto.Marshal(&pb.ProposalResponsePayload{ProposalHash: []byte(txID), Extension: action})
+	actionPayload, _ := proto.Marshal(&pb.ChaincodeActionPayload{Action: &pb.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload}})
+	transaction, _ := proto.Marshal(&pb.Transaction{Actions: []*pb.TransactionAction{{Payload: actionPayload}}})
+	payload, _ := proto.Marshal(&common.Payload{Data: transaction})
+	return &common.Envelope{Payload: payload}
+}
+
+func (s *fakeGatewayServer) recordProposal(signed *pb.SignedProposal) error {
+	proposal := &pb.Proposal{}
+	if err := proto.Unmarshal(signed.ProposalBytes, proposal); err != nil {
+		return err
+	}
+	if len(signed.Signature) == 0 {
+		return fmt.Errorf("unsigned proposal")
+	}
+	s.lastProposal = proposal
+	return nil
+}
+
+func (s *fakeGatewayServer) Evaluate(ctx context.Context, req *gateway.EvaluateRequest) (*gateway.EvaluateResponse, error) {
+	if err := s.recordProposal(req.ProposedTransaction); err != nil {
+		return nil, err
+	}
+	s.lastTargetOrgs = req.TargetOrganizations
+	return &gateway.EvaluateResponse{
+		Result: &pb.Response{Status: 200, Payload: s.evaluatePayload},
+	}, nil
+}
+
+func (s *fakeGatewayServer) Endorse(ctx context.Context, req *gateway.EndorseRequest) (*gateway.EndorseResponse, error) {
+	if s.endorseErr != nil {
+		return nil, s.endorseErr
+	}
+	if err := s.recordProposal(req.ProposedTransaction); err != nil {
+		return nil, err
+	}
+	s.lastPrepared = preparedTransaction(req.TransactionId, s.endorsePayload)
+	if s.badPrepared {
+		s.lastPrepared = &common.Envelope{Payload: []byte("!protobuf")}
+	}
+	return &gateway.EndorseResponse{
+		PreparedTransaction: s.lastPrepared,
+	}, nil
+}
+
+func (s *fakeGatewayServer) Submit(ctx context.Context, req *gateway.SubmitRequest) (*gateway.SubmitResponse, error) {
+	if len(req.PreparedTransaction.Signature) == 0 {
+		return nil, fmt.Errorf("unsigned transaction")
+	}
+	s.lastSubmitted = req.PreparedTransaction
+	return &gateway.SubmitResponse{}, nil
+}
+
+func (s *fakeGatewayServer) CommitStatus(ctx context.Context, req *gateway.SignedCommitStatusRequest) (*gateway.CommitStatusResponse, error) {
+	statusReq := &gateway.CommitStatusRequest{}
+	if err := proto.Unmarshal(req.Request, statusReq); err != nil {
+		return nil, err
+	}
+	return &gateway.CommitStatusResponse{Result: s.commitStatus, BlockNumber: s.commitBlock}, nil
+}
+
+func (s *fakeGatewayServer) ChaincodeEvents(req *gateway.SignedChaincodeEventsRequest, stream gateway.Gateway_ChaincodeEventsServer) error {
+	eventsReq := &gateway.ChaincodeEventsRequest{}
+	if err := proto.Unmarshal(req.Request, eventsReq); err != nil {
+		return err
+	}
+	s.lastEventsReq = eventsReq
+	err := stream.Send(&gateway.ChaincodeEventsResponse{
+		BlockNumber: 12,
+		Events: []*pb.ChaincodeEvent{
+			{ChaincodeId: eventsReq.ChaincodeId, TxId: "tx1", EventName: "AssetCreated", Payload: []byte("asset1")},
+			{ChaincodeId: eventsReq.ChaincodeId, TxId: "tx1", EventName: "Ignored", Payload: []byte("other")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func newTestGatewayServerClient(t *testing.T, fake *fakeGatewayServer) (*gwServerRPCWrapper, func()) {
+	config := conf.RPCConf{
+		UseGatewayServer: true,
+		ConfigPath:       tmpCCPFile,
+	}
+	rpc, _, err := RPCConnect(config, testOpenIDConf, 5)
+	assert.NoError(t, err)
+	wrapper, ok := rpc.(*gwServerRPCWrapper)
+	assert.True(t, ok)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	gateway.RegisterGatewayServer(server, fake)
+	go func() { _ = server.Serve(listener) }()
+
+	wrapper.gatewayConnector = func(peer *fab.PeerConfig, endpointConfig fab.EndpointConfig) (*grpc.ClientConn, error) {
+		dialer := func(ctx context.Context, _ string) (net.Conn, error) { return listener.Dial() }
+		return grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	}
+	return wrapper, func() {
+		wrapper.Close()
+		server.Stop()
+	}
+}
+
+func TestGatewayServerClientInstantiation(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, done := newTestGatewayServerClient(t, &fakeGatewayServer{})
+	defer done()
+
+	client, err := wrapper.getGatewayServerClient()
+	assert.NoError(err)
+	assert.NotNil(client)
+	assert.Equal("peer1.org1.com:443", wrapper.peerURL)
+
+	again, err := wrapper.getGatewayServerClient()
+	assert.NoError(err)
+	assert.Equal(client, again)
+}
+
+func TestGatewayServerInvoke(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := &fakeGatewayServer{
+		commitStatus:   pb.TxValidationCode_VALID,
+		commitBlock:    10,
+		endorsePayload: []byte(`{"id":"asset1"}`),
+	}
+	wrapper, done := newTestGatewayServerClient(t, fake)
+	defer done()
+
+	receipt, err := wrapper.Invoke("default-channel", "user1", "asset_transfer", "CreateAsset", []string{"asset1"}, map[string]string{"secret": "value"}, false)
+	assert.NoError(err)
+	assert.True(receipt.IsSuccess())
+	assert.Equal(uint64(10), receipt.BlockNumber)
+	assert.Equal("user1", receipt.Signer)
+	assert.Equal("org1MSP", receipt.SignerMSP)
+	assert.Equal([]byte(`{"id":"asset1"}`), receipt.ResponsePayload)
+	assert.Equal(fake.lastPrepared.Payload, fake.lastSubmitted.Payload)
+	assert.NotEmpty(fake.lastSubmitted.Signature)
+
+	payload := &pb.ChaincodeProposalPayload{}
+	assert.NoError(proto.Unmarshal(fake.lastProposal.Payload, payload))
+	assert.Equal([]byte("value"), payload.TransientMap["secret"])
+	header := &common.Header{}
+	assert.NoError(proto.Unmarshal(fake.lastProposal.Header, header))
+	channelHeader := &common.ChannelHeader{}
+	assert.NoError(proto.Unmarshal(header.ChannelHeader, channelHeader))
+	assert.Equal("default-channel", channelHeader.ChannelId)
+	assert.Equal(receipt.TransactionID, channelHeader.TxId)
+}
+
+func TestGatewayServerInvokeInvalidTx(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := &fakeGatewayServer{
+		commitStatus: pb.TxValidationCode_MVCC_READ_CONFLICT,
+		commitBlock:  11,
+	}
+	wrapper, done := newTestGatewayServerClient(t, fake)
+	defer done()
+
+	receipt, err := wrapper.Invoke("default-channel", "user1", "asset_transfer", "CreateAsset", []string{"asset1"}, nil, false)
+	assert.NoError(err)
+	assert.False(receipt.IsSuccess())
+	assert.Equal(pb.TxValidationCode_MVCC_READ_CONFLICT, receipt.Status)
+}
+
+func TestGatewayServerInvokeEndorseFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, done := newTestGatewayServerClient(t, &fakeGatewayServer{endorseErr: fmt.Errorf("bang")})
+	defer done()
+
+	_, err := wrapper.Invoke("default-channel", "user1", "asset_transfer", "CreateAsset", []string{"asset1"}, nil, false)
+	assert.Regexp("Failed to endorse transaction .*bang", err)
+}
+
+func TestGatewayServerInvokeUnknownSigner(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, done := newTestGatewayServerClient(t, &fakeGatewayServer{})
+	defer done()
+
+	_, err := wrapper.Invoke("default-channel", "unknown-user", "asset_transfer", "CreateAsset", []string{"asset1"}, nil, false)
+	assert.Error(err)
+}
+
+func TestGatewayServerInvokeBadPreparedTx(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := &fakeGatewayServer{badPrepared: true}
+	wrapper, done := newTestGatewayServerClient(t, fake)
+	defer done()
+
+	_, err := wrapper.Invoke("default-channel", "user1", "asset_transfer", "CreateAsset", []string{"asset1"}, nil, false)
+	assert.Regexp("Failed to decode the result of transaction", err)
+	assert.Nil(fake.lastSubmitted)
+}
+
+func TestGatewayServerQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := &fakeGatewayServer{evaluatePayload: []byte(`{"ID":"asset1"}`)}
+	wrapper, done := newTestGatewayServerClient(t, fake)
+	defer done()
+
+	result, err := wrapper.Query("default-channel", "user1", "asset_transfer", "ReadAsset", []string{"asset1"}, false)
+	assert.NoError(err)
+	assert.Equal(`{"ID":"asset1"}`, string(result))
+	assert.Equal([]string{"org1MSP"}, fake.lastTargetOrgs)
+
+	_, err = wrapper.Query("default-channel", "user1", "asset_transfer", "ReadAsset", []string{"asset1"}, true)
+	assert.NoError(err)
+	assert.Empty(fake.lastTargetOrgs)
+}
+
+func TestGatewayServerSubscribeChaincodeEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := &fakeGatewayServer{}
+	wrapper, done := newTestGatewayServerClient(t, fake)
+	defer done()
+
+	subInfo := &eventsapi.SubscriptionInfo{
+		ChannelId: "default-channel",
+		Signer:    "user1",
+	}
+	subInfo.Filter.ChaincodeId = "asset_transfer"
+	subInfo.Filter.EventFilter = "Asset.*"
+	reg, blockEvents, ccEvents, err := wrapper.SubscribeEvent(subInfo, 5)
+	assert.NoError(err)
+	assert.Nil(blockEvents)
+	assert.NotNil(reg.cancel)
+
+	event := <-ccEvents
+	assert.Equal("asset_transfer", event.ChaincodeID)
+	assert.Equal("AssetCreated", event.EventName)
+	assert.Equal(uint64(12), event.BlockNumber)
+	assert.Equal([]byte("asset1"), event.Payload)
+	assert.Equal(uint64(5), fake.lastEventsReq.StartPosition.GetSpecified().Number)
+
+	wrapper.Unregister(reg)
+	_, ok := <-ccEvents
+	assert.False(ok)
+}
+
+// fakeEventsStream delivers the same chaincode events on every Recv
+type fakeEventsStream struct {
+	grpc.ClientStream
+}
+
+func (s *fakeEventsStream) Recv() (*gateway.ChaincodeEventsResponse, error) {
+	return &gateway.ChaincodeEventsResponse{
+		BlockNumber: 3,
+		Events:      []*pb.ChaincodeEvent{{ChaincodeId: "asset_transfer", EventName: "AssetCreated"}},
+	}, nil
+}
+
+func TestGatewayServerRelayStopsWhenCancelled(t *testing.T) {
+	wrapper := &gwServerRPCWrapper{}
+	ctx, cancel := context.WithCancel(context.Background())
+	notifier := make(chan *fab.CCEvent)
+	done := make(chan struct{})
+	go func() {
+		wrapper.relayChaincodeEvents(ctx, &fakeEventsStream{}, nil, notifier)
+		close(done)
+	}()
+
+	event := <-notifier
+	assert.Equal(t, "AssetCreated", event.EventName)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay did not stop once the subscription was cancelled")
+	}
+}
+
+func TestGatewayServerSubscribeBadEventFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	wrapper, done := newTestGatewayServerClient(t, &fakeGatewayServer{})
+	defer done()
+
+	subInfo := &eventsapi.SubscriptionInfo{
+		ChannelId: "default-channel",
+		Signer:    "user1",
+	}
+	subInfo.Filter.ChaincodeId = "asset_transfer"
+	subInfo.Filter.EventFilter = "["
+	_, _, _, err := wrapper.SubscribeEvent(subInfo, 0)
+	assert.Regexp("Failed to compile event filter", err)
+}
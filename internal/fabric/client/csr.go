@@ -0,0 +1,193 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+)
+
+// externalAttribute marks the CA identities enrolled with the CSR of their owner, whose private
+// key the gateway never holds
+const externalAttribute = reservedAttributePrefix + "external"
+
+const caRequestTimeout = 30 * time.Second
+
+// caRESTClient calls the endpoints the SDK lacks of the Fabric CA servers of the client org
+// directly, with the connection settings of the SDK config
+type caRESTClient struct {
+	identityConfig msp.IdentityConfig
+	caIDs          []string
+	// registrar authorizes the requests that need a token, enroll uses basic auth
+	registrar func(caConfig *msp.CAConfig) (msp.SigningIdentity, error)
+}
+
+type caEnrollRequest struct {
+	CertificateRequest string           `json:"certificate_request"`
+	CAName             string           `json:"caname,omitempty"`
+	Profile            string           `json:"profile,omitempty"`
+	AttrReqs           []caAttributeReq `json:"attr_reqs,omitempty"`
+}
+
+type caAttributeReq struct {
+	Name     string `json:"name"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+type caEnrollResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		Cert       string `json:"Cert"`
+		ServerInfo struct {
+			CAName  string `json:"CAName"`
+			CAChain string `json:"CAChain"`
+		} `json:"ServerInfo"`
+	} `json:"result"`
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newCARESTClient(identityConfig msp.IdentityConfig, caIDs []string) *caRESTClient {
+	return &caRESTClient{
+		identityConfig: identityConfig,
+		caIDs:          caIDs,
+	}
+}
+
+func (c *caRESTClient) EnrollCSR(req *dep.CSREnrollmentRequest) (*dep.CSREnrollmentResponse, error) {
+	caConfig, err := c.caConfig(req.CAName)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := newCAHTTPClient(caConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	body := caEnrollRequest{
+		CertificateRequest: string(req.CSR),
+		CAName:             caConfig.CAName,
+		Profile:            req.Profile,
+	}
+	for _, attr := range req.AttrReqs {
+		body.AttrReqs = append(body.AttrReqs, caAttributeReq{Name: attr.Name, Optional: attr.Optional})
+	}
+	payload, _ := json.Marshal(&body)
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(caConfig.URL, "/")+"/api/v1/enroll", bytes.NewReader(payload))
+	if err != nil {
+		return nil, errors.Errorf(errors.CSREnrollFailed, req.Name, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.SetBasicAuth(req.Name, req.Secret)
+	httpRes, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.Errorf(errors.CSREnrollFailed, req.Name, err)
+	}
+	defer httpRes.Body.Close()
+
+	var res caEnrollResponse
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf(errors.CSREnrollFailed, req.Name, fmt.Sprintf("status %d", httpRes.StatusCode))
+	}
+	if !res.Success {
+		messages := make([]string, 0, len(res.Errors))
+		for _, e := range res.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return nil, errors.Errorf(errors.CSREnrollFailed, req.Name, strings.Join(messages, ", "))
+	}
+	cert, err := base64.StdEncoding.DecodeString(res.Result.Cert)
+	if err != nil {
+		return nil, errors.Errorf(errors.CSREnrollFailed, req.Name, err)
+	}
+	caChain, err := base64.StdEncoding.DecodeString(res.Result.ServerInfo.CAChain)
+	if err != nil {
+		return nil, errors.Errorf(errors.CSREnrollFailed, req.Name, err)
+	}
+	return &dep.CSREnrollmentResponse{
+		Cert:    cert,
+		CAChain: caChain,
+		CAName:  res.Result.ServerInfo.CAName,
+	}, nil
+}
+
+// caConfig returns the CA of the org with the CA name, or its first CA without a name
+func (c *caRESTClient) caConfig(caName string) (*msp.CAConfig, error) {
+	for _, id := range c.caIDs {
+		caConfig, ok := c.identityConfig.CAConfig(id)
+		if ok && (caName == "" || caName == caConfig.CAName || caName == id) {
+			return caConfig, nil
+		}
+	}
+	return nil, errors.Errorf(errors.CSREnrollNoCA, caName)
+}
+
+func newCAHTTPClient(caConfig *msp.CAConfig) (*http.Client, error) {
+	client := &http.Client{Timeout: caRequestTimeout}
+	if !strings.HasPrefix(caConfig.URL, "https://") {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{}
+	if len(caConfig.TLSCAServerCerts) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		for _, cert := range caConfig.TLSCAServerCerts {
+			tlsConfig.RootCAs.AppendCertsFromPEM(cert)
+		}
+	}
+	if len(caConfig.TLSCAClientCert) > 0 {
+		clientCert, err := tls.X509KeyPair(caConfig.TLSCAClientCert, caConfig.TLSCAClientKey)
+		if err != nil {
+			return nil, errors.Errorf(errors.CSREnrollFailed, caConfig.ID, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	return client, nil
+}
+
+// parseCSR checks a PEM encoded certificate signing request is signed, and is for the identity
+func parseCSR(username string, csrPEM []byte) error {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return errors.Errorf(errors.CSREnrollInvalid, "no CERTIFICATE REQUEST PEM block found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return errors.Errorf(errors.CSREnrollInvalid, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return errors.Errorf(errors.CSREnrollInvalid, err)
+	}
+	if csr.Subject.CommonName != username {
+		return errors.Errorf(errors.CSREnrollCNMismatch, csr.Subject.CommonName, username)
+	}
+	return nil
+}
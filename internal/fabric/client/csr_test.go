@@ -0,0 +1,131 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCSR(t *testing.T, cn string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}, key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// testIdentityConfig serves the CA configs of the test
+type testIdentityConfig struct {
+	msp.IdentityConfig
+	cas map[string]*msp.CAConfig
+}
+
+func (c *testIdentityConfig) CAConfig(caID string) (*msp.CAConfig, bool) {
+	caConfig, ok := c.cas[caID]
+	return caConfig, ok
+}
+
+func TestParseCSR(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(parseCSR("user1", newTestCSR(t, "user1")))
+
+	err := parseCSR("user1", newTestCSR(t, "user2"))
+	assert.EqualError(err, "The common name 'user2' of the certificate signing request must be the enrollment ID 'user1'")
+
+	err = parseCSR("user1", []byte("not a csr"))
+	assert.EqualError(err, "Invalid certificate signing request: no CERTIFICATE REQUEST PEM block found")
+
+	err = parseCSR("user1", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: []byte{0x30}}))
+	assert.Regexp("Invalid certificate signing request: ", err)
+}
+
+func TestCAClientEnrollCSR(t *testing.T) {
+	assert := assert.New(t)
+
+	csr := newTestCSR(t, "user1")
+	var received caEnrollRequest
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/api/v1/enroll", r.URL.Path)
+		username, password, _ := r.BasicAuth()
+		assert.Equal("user1", username)
+		assert.Equal("user1pw", password)
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"success":true,"result":{"Cert":"` + base64.StdEncoding.EncodeToString([]byte("cert")) +
+			`","ServerInfo":{"CAName":"ca1","CAChain":"` + base64.StdEncoding.EncodeToString([]byte("chain")) + `"}}}`))
+	}))
+	defer server.Close()
+	serverCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	c := newCARESTClient(&testIdentityConfig{cas: map[string]*msp.CAConfig{
+		"ca.org1.example.com": {ID: "ca.org1.example.com", URL: server.URL, CAName: "ca1", TLSCAServerCerts: [][]byte{serverCert}},
+	}}, []string{"ca.org1.example.com"})
+	res, err := c.EnrollCSR(&dep.CSREnrollmentRequest{
+		Name:     "user1",
+		Secret:   "user1pw",
+		CSR:      csr,
+		Profile:  "tls",
+		AttrReqs: []*mspApi.AttributeRequest{{Name: "email", Optional: true}},
+	})
+	assert.NoError(err)
+	assert.Equal(&dep.CSREnrollmentResponse{Cert: []byte("cert"), CAChain: []byte("chain"), CAName: "ca1"}, res)
+	assert.Equal(caEnrollRequest{
+		CertificateRequest: string(csr),
+		CAName:             "ca1",
+		Profile:            "tls",
+		AttrReqs:           []caAttributeReq{{Name: "email", Optional: true}},
+	}, received)
+}
+
+func TestCAClientEnrollCSRFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":20,"message":"Authentication failure"}]}`))
+	}))
+	defer server.Close()
+
+	c := newCARESTClient(&testIdentityConfig{cas: map[string]*msp.CAConfig{
+		"ca.org1.example.com": {ID: "ca.org1.example.com", URL: server.URL, CAName: "ca1"},
+	}}, []string{"ca.org1.example.com"})
+	_, err := c.EnrollCSR(&dep.CSREnrollmentRequest{Name: "user1", Secret: "wrong"})
+	assert.EqualError(err, "Failed to enroll 'user1' with the Fabric CA: 20 Authentication failure")
+
+	_, err = c.EnrollCSR(&dep.CSREnrollmentRequest{Name: "user1", CAName: "ca2"})
+	assert.EqualError(err, "No Fabric CA named 'ca2' is configured for the organization")
+
+	server.Close()
+	_, err = c.EnrollCSR(&dep.CSREnrollmentRequest{Name: "user1", CAName: "ca.org1.example.com"})
+	assert.Regexp("Failed to enroll 'user1' with the Fabric CA", err)
+}
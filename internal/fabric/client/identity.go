@@ -17,12 +17,18 @@
 package client
 
 import (
+	"crypto/x509"
 	// "crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
 	fabcontext "github.com/hyperledger/fabric-sdk-go/pkg/context"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/cryptosuite"
@@ -30,6 +36,7 @@ import (
 	fabImpl "github.com/hyperledger/fabric-sdk-go/pkg/fab"
 	mspImpl "github.com/hyperledger/fabric-sdk-go/pkg/msp"
 	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
@@ -53,12 +60,32 @@ type idClientWrapper struct {
 	identityConfig msp.IdentityConfig
 	identityMgr    msp.IdentityManager
 	caClient       dep.CAClient
-	openidClient   openid.OpenidClientWrapper
+	csrEnroller    dep.CSREnroller
+	certAuditor    dep.CertificateAuditor
+	store          signerStore
+	cryptoSuite    core.CryptoSuite
+	mspID          string
+	// caNames are the CAs of the client org, the first one is the default CA
+	caNames  []string
+	caConfig func(caName string) (*msp.CAConfig, error)
+	// otherMSPs are the MSPs of the other orgs of the connection profile, whose identities can be
+	// imported, and channels the channels of the profile whose configs hold the CAs of these MSPs
+	otherMSPs      []string
+	channels       []string
+	channelMSPs    func(channelId string) ([]*mspproto.MSPConfig, error)
+	newUser        func(userData *msp.UserData) (msp.SigningIdentity, error)
+	provisioner    openid.IdPProvisioner
+	admin          *identityAdmin
+	bulk           *bulkJobs
+	reconcileConf  conf.ReconcileConf
+	reconciler     *reconciler
+	certExpiryConf conf.CertExpiryConf
+	certMonitor    *certMonitor
 	listeners      []SignerUpdateListener
 	idlisteners    []SignerIdUpdateListener
 }
 
-func newIdentityClient(o conf.OpenIDConfig, configProvider core.ConfigProvider, userStore msp.UserStore) (*idClientWrapper, error) {
+func newIdentityClient(o conf.OpenIDConfig, certExpiry conf.CertExpiryConf, configProvider core.ConfigProvider, userStore signerStore) (*idClientWrapper, error) {
 	configBackend, _ := configProvider()
 	cryptoConfig := cryptosuite.ConfigFromBackend(configBackend...)
 	cs, err := sw.GetSuiteByConfig(cryptoConfig)
@@ -82,7 +109,16 @@ func newIdentityClient(o conf.OpenIDConfig, configProvider core.ConfigProvider,
 		return nil, errors.Errorf("Identity manager creation failed. %s", err)
 	}
 
-	openIdClient, _ := openid.NewOpenIdClient(o)
+	// the IdP users are provisioned for the enrolled identities when an IdP is configured
+	provisioner, err := openid.NewProvisioner(o)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := newIdentityAdmin(o.Admin)
+	if err != nil {
+		return nil, err
+	}
 
 	identityManagerProvider := &identityManagerProvider{
 		identityManager: mgr,
@@ -106,19 +142,150 @@ func newIdentityClient(o conf.OpenIDConfig, configProvider core.ConfigProvider,
 	var listeners []SignerUpdateListener
 	var idlisteners []SignerIdUpdateListener
 
+	orgCAs := caIDs(endpointConfig, clientConfig.Organization)
+	caRESTClient := newCARESTClient(identityConfig, orgCAs)
+	clientMSP := mspID(endpointConfig, clientConfig.Organization)
 	idc := &idClientWrapper{
 		identityConfig: identityConfig,
 		identityMgr:    mgr,
 		caClient:       caClient,
-		openidClient:   *openIdClient,
+		csrEnroller:    caRESTClient,
+		certAuditor:    caRESTClient,
+		store:          userStore,
+		cryptoSuite:    cs,
+		mspID:          clientMSP,
+		caNames:        caNames(identityConfig, orgCAs),
+		caConfig:       caRESTClient.caConfig,
+		otherMSPs:      otherMSPs(endpointConfig, clientMSP),
+		channels:       channelNames(endpointConfig),
+		newUser: func(userData *msp.UserData) (msp.SigningIdentity, error) {
+			return mgr.NewUser(userData)
+		},
+		provisioner:    provisioner,
+		admin:          admin,
+		bulk:           newBulkJobs(),
+		reconcileConf:  o.Provisioning.Reconcile,
+		certExpiryConf: certExpiry,
 		listeners:      listeners,
 		idlisteners:    idlisteners,
 	}
+	caRESTClient.registrar = idc.registrarIdentity
+	idc.startReconciler()
+	idc.startCertMonitor()
 	return idc, nil
 }
 
+// mspID returns the MSP of the client org, whose signers are enrolled with the CA of the org
+func mspID(endpointConfig fab.EndpointConfig, org string) string {
+	return endpointConfig.NetworkConfig().Organizations[strings.ToLower(org)].MSPID
+}
+
+// otherMSPs returns the MSPs of the orgs of the connection profile other than the client org
+func otherMSPs(endpointConfig fab.EndpointConfig, clientMSP string) []string {
+	var result []string
+	for _, org := range endpointConfig.NetworkConfig().Organizations {
+		if org.MSPID != "" && org.MSPID != clientMSP {
+			result = append(result, org.MSPID)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// channelNames returns the channels of the connection profile, in a stable order
+func channelNames(endpointConfig fab.EndpointConfig) []string {
+	result := make([]string, 0, len(endpointConfig.NetworkConfig().Channels))
+	for name := range endpointConfig.NetworkConfig().Channels {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// caIDs returns the CAs of the client org, the first one is the default CA
+func caIDs(endpointConfig fab.EndpointConfig, org string) []string {
+	return endpointConfig.NetworkConfig().Organizations[strings.ToLower(org)].CertificateAuthorities
+}
+
+// caNames returns the names the CAs are addressed with in the requests, their caname or ID
+func caNames(identityConfig msp.IdentityConfig, ids []string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if caConfig, ok := identityConfig.CAConfig(id); ok && caConfig.CAName != "" {
+			names = append(names, caConfig.CAName)
+		} else {
+			names = append(names, id)
+		}
+	}
+	return names
+}
+
+// GetSigningIdentity returns the signer from the store, the external identities enrolled with
+// a CSR have none and cannot sign on the server. The identity manager only loads the signers of
+// the client org, the identities imported for the other MSPs are loaded from the store directly
 func (w *idClientWrapper) GetSigningIdentity(name string) (msp.SigningIdentity, error) {
-	return w.identityMgr.GetSigningIdentity(name)
+	signer, err := w.identityMgr.GetSigningIdentity(name)
+	if err == msp.ErrUserNotFound && w.newUser != nil {
+		if userData, loadErr := w.loadOtherMSPUser(name); loadErr == nil {
+			return w.newUser(userData)
+		}
+	}
+	if err == msp.ErrUserNotFound && w.isExternal(name) {
+		return nil, errors.Errorf(errors.ExternalSignerServerSigning, name)
+	}
+	return signer, err
+}
+
+func (w *idClientWrapper) isExternal(name string) bool {
+	caIdentity, err := w.caClient.GetIdentity(name, "")
+	if err != nil {
+		return false
+	}
+	for _, attr := range caIdentity.Attributes {
+		if attr.Name == externalAttribute {
+			external, _ := strconv.ParseBool(attr.Value)
+			return external
+		}
+	}
+	return false
+}
+
+// externalCertificate returns the latest valid certificate the CA issued to an external identity,
+// the transactions prepared for it are created with that certificate and signed by the client
+func (w *idClientWrapper) externalCertificate(name string) ([]byte, error) {
+	caIdentity, err := w.caClient.GetIdentity(name, "")
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineSigningCertLookup, name, err)
+	}
+	external := false
+	for _, attr := range caIdentity.Attributes {
+		if attr.Name == externalAttribute {
+			external, _ = strconv.ParseBool(attr.Value)
+		}
+	}
+	if !external {
+		return nil, errors.Errorf(errors.OfflineSignerNotExternal, name)
+	}
+	expired, revoked := false, false
+	result, err := w.certAuditor.GetCertificates(&dep.CertificatesRequest{ID: name, Expired: &expired, Revoked: &revoked})
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineSigningCertLookup, name, err)
+	}
+	var latest *x509.Certificate
+	var latestPEM []byte
+	for _, certPEM := range result.Certs {
+		cert, err := parseCertificate(name, certPEM)
+		if err != nil || cert.Subject.CommonName != name {
+			continue
+		}
+		if latest == nil || cert.NotBefore.After(latest.NotBefore) {
+			latest, latestPEM = cert, certPEM
+		}
+	}
+	if latest == nil {
+		return nil, errors.Errorf(errors.OfflineSigningNoCert, name)
+	}
+	return latestPEM, nil
 }
 
 func (w *idClientWrapper) GetClientOrg() string {
@@ -126,7 +293,7 @@ func (w *idClientWrapper) GetClientOrg() string {
 }
 
 // the rpcWrapper is also an implementation of the interface internal/rest/idenity/IdentityClient
-func (w *idClientWrapper) Register(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.RegisterResponse, *restutil.RestError) {
+func (w *idClientWrapper) Register(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.RegisterResponse, restErr *restutil.RestError) {
 	regreq := identity.Identity{}
 	decoder := json.NewDecoder(req.Body)
 	decoder.DisallowUnknownFields()
@@ -140,6 +307,17 @@ func (w *idClientWrapper) Register(res http.ResponseWriter, req *http.Request, p
 	if regreq.Type == "" {
 		regreq.Type = "client"
 	}
+	if err := checkReservedAttributes(regreq.Attributes); err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	op := w.admin.start(req, adminOpRegister, regreq.Name, regreq.CAName)
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	if restErr = op.authorizeIdentity(regreq.Type, regreq.Affiliation, regreq.Attributes); restErr != nil {
+		return nil, restErr
+	}
 
 	rr := &mspApi.RegistrationRequest{
 		Name:           regreq.Name,
@@ -156,9 +334,6 @@ func (w *idClientWrapper) Register(res http.ResponseWriter, req *http.Request, p
 		}
 	}
 
-	// userid, err := w.openidClient.CreateUser(regreq.Name, "makeen")
-	// rr.Attributes = append(rr.Attributes, mspApi.Attribute{Name: "sub_id", Value: *userid, ECert: true})
-
 	secret, err := w.caClient.Register(rr)
 	if err != nil {
 		log.Errorf("Failed to register user %s. %s", regreq.Name, err)
@@ -172,7 +347,18 @@ func (w *idClientWrapper) Register(res http.ResponseWriter, req *http.Request, p
 	return &result, nil
 }
 
-func (w *idClientWrapper) Modify(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.RegisterResponse, *restutil.RestError) {
+// checkReservedAttributes rejects the attributes the gateway marks the identities with, a caller
+// setting them could hide an identity revoked through the gateway, or fake an external one
+func checkReservedAttributes(attributes map[string]string) error {
+	for name := range attributes {
+		if strings.HasPrefix(name, reservedAttributePrefix) {
+			return errors.Errorf(errors.IdentityAttributeReserved, name)
+		}
+	}
+	return nil
+}
+
+func (w *idClientWrapper) Modify(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.RegisterResponse, restErr *restutil.RestError) {
 	username := params.ByName("username")
 	regreq := identity.Identity{}
 	decoder := json.NewDecoder(req.Body)
@@ -181,6 +367,20 @@ func (w *idClientWrapper) Modify(res http.ResponseWriter, req *http.Request, par
 	if err != nil {
 		return nil, restutil.NewRestError(fmt.Sprintf("failed to decode JSON payload: %s", err), 400)
 	}
+	if err := checkReservedAttributes(regreq.Attributes); err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	op := w.admin.start(req, adminOpModify, username, regreq.CAName)
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	if restErr = op.authorizeIdentity(regreq.Type, regreq.Affiliation, regreq.Attributes); restErr != nil {
+		return nil, restErr
+	}
+	if restErr = w.authorizeTarget(op); restErr != nil {
+		return nil, restErr
+	}
 
 	rr := &mspApi.IdentityRequest{
 		ID:             username,
@@ -209,7 +409,7 @@ func (w *idClientWrapper) Modify(res http.ResponseWriter, req *http.Request, par
 	return &result, nil
 }
 
-func (w *idClientWrapper) Enroll(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.IdentityResponse, *restutil.RestError) {
+func (w *idClientWrapper) Enroll(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.IdentityResponse, restErr *restutil.RestError) {
 	username := params.ByName("username")
 	enreq := identity.EnrollRequest{}
 	decoder := json.NewDecoder(req.Body)
@@ -221,6 +421,11 @@ func (w *idClientWrapper) Enroll(res http.ResponseWriter, req *http.Request, par
 	if enreq.Secret == "" {
 		return nil, restutil.NewRestError(`missing required parameter "secret"`, 400)
 	}
+	op := w.admin.start(req, adminOpEnroll, username, enreq.CAName)
+	defer func() { op.done(restErr) }()
+	if enreq.CSR != "" {
+		return w.enrollCSR(username, &enreq)
+	}
 
 	input := mspApi.EnrollmentRequest{
 		Name:    username,
@@ -237,25 +442,136 @@ func (w *idClientWrapper) Enroll(res http.ResponseWriter, req *http.Request, par
 
 	err = w.caClient.Enroll(&input)
 	if err != nil {
-		log.Errorf("Failed to enroll user %s. %s", enreq.Name, err)
+		log.Errorf("Failed to enroll user %s. %s", username, err)
 		return nil, restutil.NewRestError(err.Error())
 	}
-
-	userid, err := w.openidClient.CreateUser(username)
-	// si, err := w.identityMgr.GetSigningIdentity(username)
-	// cert, err := x509.ParseCertificate(si.EnrollmentCertificate())
-	// cert.
+	// the IdP user is provisioned once the CA accepted the enrollment, so a rejected secret
+	// never creates one
+	user, restErr := w.provisionAfterEnroll(username, enreq.CAName)
+	if restErr != nil {
+		return nil, restErr
+	}
 
 	result := identity.IdentityResponse{
-		Name:    enreq.Name,
+		Name:    username,
 		Success: true,
 	}
+	userID := ""
+	if user != nil {
+		userID = user.ID
+		result.OneTimePassword = user.OneTimePassword
+	}
+	w.notifySignerIdUpdate(username, userID)
+	return &result, nil
+}
+
+// enrollCSR enrolls an external identity with the CSR of the client, the gateway never holds
+// its private key so the transactions of the identity must be signed by the client
+func (w *idClientWrapper) enrollCSR(username string, enreq *identity.EnrollRequest) (*identity.IdentityResponse, *restutil.RestError) {
+	csr := []byte(enreq.CSR)
+	if err := parseCSR(username, csr); err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	input := &dep.CSREnrollmentRequest{
+		Name:    username,
+		Secret:  enreq.Secret,
+		CAName:  enreq.CAName,
+		CSR:     csr,
+		Profile: enreq.Profile,
+	}
+	for attr, optional := range enreq.AttrReqs {
+		input.AttrReqs = append(input.AttrReqs, &mspApi.AttributeRequest{Name: attr, Optional: optional})
+	}
 
-	w.notifySignerIdUpdate(username, *userid)
+	enrollment, err := w.csrEnroller.EnrollCSR(input)
+	if err != nil {
+		log.Errorf("Failed to enroll user %s with a CSR. %s", username, err)
+		return nil, restutil.NewRestError(err.Error())
+	}
+
+	// a signer enrolled before by the gateway must not sign with the replaced certificate
+	if err := w.store.Delete(msp.IdentityIdentifier{ID: username, MSPID: w.mspID}); err != nil {
+		log.Warnf("Failed to delete the stored signer of external user %s. %s", username, err)
+	}
+	w.notifySignerUpdate(username)
+	// without the marker the identity is not known to be external, and its transactions would
+	// not be rejected for server signing, so the enrollment must be retried
+	_, err = w.caClient.ModifyIdentity(&mspApi.IdentityRequest{
+		ID:         username,
+		CAName:     enreq.CAName,
+		Attributes: []mspApi.Attribute{{Name: externalAttribute, Value: "true"}},
+	})
+	if err != nil {
+		log.Errorf("Failed to mark user %s as external. %s", username, err)
+		return nil, restutil.NewRestError(errors.Errorf(errors.CSREnrollMarkFailed, username, err).Error(), 500)
+	}
+	user, restErr := w.provisionAfterEnroll(username, enreq.CAName)
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	result := identity.IdentityResponse{
+		Name:        username,
+		Success:     true,
+		Certificate: string(enrollment.Cert),
+		CAChain:     string(enrollment.CAChain),
+		External:    true,
+	}
+	if user != nil {
+		result.OneTimePassword = user.OneTimePassword
+	}
 	return &result, nil
 }
 
-func (w *idClientWrapper) Reenroll(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.IdentityResponse, *restutil.RestError) {
+// provisionUser makes sure the identity has an IdP user, which is created from the provisioning
+// template with the details of the CA identity when missing. It is a no-op without an IdP
+func (w *idClientWrapper) provisionUser(username, caName string) (*openid.ProvisionedUser, *restutil.RestError) {
+	if w.provisioner == nil {
+		return nil, nil
+	}
+	caIdentity, err := w.caClient.GetIdentity(username, caName)
+	if err != nil {
+		log.Errorf("Failed to look up user %s for the IdP provisioning. %s", username, err)
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	data := &openid.ProvisioningData{
+		Username:    username,
+		Type:        caIdentity.Type,
+		Affiliation: caIdentity.Affiliation,
+		Attributes:  make(map[string]string, len(caIdentity.Attributes)),
+	}
+	for _, attr := range caIdentity.Attributes {
+		data.Attributes[attr.Name] = attr.Value
+	}
+	user, err := w.provisioner.ProvisionUser(data)
+	if err != nil {
+		log.Errorf("Failed to provision the IdP user of %s. %s", username, err)
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	return user, nil
+}
+
+// provisionAfterEnroll provisions the IdP user of an enrolled identity. The enrollment stands when
+// the provisioning fails, which the error says, and the reconciliation reports the missing user
+func (w *idClientWrapper) provisionAfterEnroll(username, caName string) (*openid.ProvisionedUser, *restutil.RestError) {
+	user, restErr := w.provisionUser(username, caName)
+	if restErr != nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.OpenIDProvisioningAfterEnroll, username, restErr.Error).Error(), restErr.StatusCode)
+	}
+	return user, nil
+}
+
+// rollbackUser deletes the IdP user created for an identity whose enrollment failed
+func (w *idClientWrapper) rollbackUser(username string, user *openid.ProvisionedUser) {
+	if user == nil || !user.Created {
+		return
+	}
+	if err := w.provisioner.DeleteUser(user.ID); err != nil {
+		log.Errorf("Failed to delete the IdP user %s of %s after the failed enrollment. %s", user.ID, username, err)
+	}
+}
+
+func (w *idClientWrapper) Reenroll(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.IdentityResponse, restErr *restutil.RestError) {
 	username := params.ByName("username")
 	enreq := identity.EnrollRequest{}
 	decoder := json.NewDecoder(req.Body)
@@ -264,6 +580,8 @@ func (w *idClientWrapper) Reenroll(res http.ResponseWriter, req *http.Request, p
 	if err != nil {
 		return nil, restutil.NewRestError(fmt.Sprintf("failed to decode JSON payload: %s", err), 400)
 	}
+	op := w.admin.start(req, adminOpReenroll, username, enreq.CAName)
+	defer func() { op.done(restErr) }()
 
 	input := mspApi.ReenrollmentRequest{
 		Name:    username,
@@ -277,8 +595,8 @@ func (w *idClientWrapper) Reenroll(res http.ResponseWriter, req *http.Request, p
 		}
 	}
 
-	userid, err := w.openidClient.CreateUser(username)
-
+	// the IdP user is provisioned by the enrollment, which proves the secret of the identity.
+	// A re-enrollment does not, and must not create a user or return its one-time password
 	err = w.caClient.Reenroll(&input)
 	if err != nil {
 		log.Errorf("Failed to re-enroll user %s. %s", username, err)
@@ -289,12 +607,11 @@ func (w *idClientWrapper) Reenroll(res http.ResponseWriter, req *http.Request, p
 		Name:    username,
 		Success: true,
 	}
-
-	w.notifySignerIdUpdate(username, *userid)
+	w.notifySignerIdUpdate(username, "")
 	return &result, nil
 }
 
-func (w *idClientWrapper) Revoke(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.RevokeResponse, *restutil.RestError) {
+func (w *idClientWrapper) Revoke(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.RevokeResponse, restErr *restutil.RestError) {
 	username := params.ByName("username")
 	enreq := identity.RevokeRequest{}
 	decoder := json.NewDecoder(req.Body)
@@ -303,6 +620,14 @@ func (w *idClientWrapper) Revoke(res http.ResponseWriter, req *http.Request, par
 	if err != nil {
 		return nil, restutil.NewRestError(fmt.Sprintf("failed to decode JSON payload: %s", err), 400)
 	}
+	op := w.admin.start(req, adminOpRevoke, username, enreq.CAName)
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	if restErr = w.authorizeTarget(op); restErr != nil {
+		return nil, restErr
+	}
 
 	input := mspApi.RevocationRequest{
 		Name:   username,
@@ -316,9 +641,11 @@ func (w *idClientWrapper) Revoke(res http.ResponseWriter, req *http.Request, par
 		log.Errorf("Failed to revoke certificate for user %s. %s", enreq.Name, err)
 		return nil, restutil.NewRestError(err.Error())
 	}
+	w.notifySignerUpdate(username)
 
 	result := identity.RevokeResponse{
-		CRL: response.CRL,
+		CRL:             response.CRL,
+		IdPUserDisabled: w.disableRevokedUser(username, enreq.CAName),
 	}
 	if len(response.RevokedCerts) > 0 {
 		result.RevokedCerts = []map[string]string{}
@@ -332,6 +659,82 @@ func (w *idClientWrapper) Revoke(res http.ResponseWriter, req *http.Request, par
 	return &result, nil
 }
 
+// disableRevokedUser marks the CA identity as revoked, as the CA does not return the revocation
+// state of the identities, and disables its IdP user. The failures are left to the reconciler
+func (w *idClientWrapper) disableRevokedUser(username, caName string) bool {
+	_, err := w.caClient.ModifyIdentity(&mspApi.IdentityRequest{
+		ID:         username,
+		CAName:     caName,
+		Attributes: []mspApi.Attribute{{Name: revokedAttribute, Value: "true"}},
+	})
+	if err != nil {
+		log.Warnf("Failed to mark user %s as revoked. %s", username, err)
+	}
+	if w.provisioner == nil {
+		return false
+	}
+	user, err := w.provisioner.GetUser(username)
+	if err != nil {
+		log.Errorf("Failed to look up the IdP user of revoked user %s. %s", username, err)
+		return false
+	}
+	if user == nil {
+		return false
+	}
+	if user.Enabled {
+		if err := w.provisioner.DisableUser(user.ID); err != nil {
+			log.Errorf("Failed to disable the IdP user of revoked user %s. %s", username, err)
+			return false
+		}
+	}
+	return true
+}
+
+// Remove deletes the identity from the CA, and its IdP user. The CA must allow the removal of
+// identities (cfg.identities.allowremove)
+func (w *idClientWrapper) Remove(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.RemoveResponse, restErr *restutil.RestError) {
+	username := params.ByName("username")
+	op := w.admin.start(req, adminOpRemove, username, req.URL.Query().Get("caname"))
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	if restErr = w.authorizeTarget(op); restErr != nil {
+		return nil, restErr
+	}
+	// force is required by the CA for the identity the gateway registers with
+	force, _ := strconv.ParseBool(req.URL.Query().Get("force"))
+	input := mspApi.RemoveIdentityRequest{
+		ID:     username,
+		Force:  force,
+		CAName: req.URL.Query().Get("caname"),
+	}
+	_, err := w.caClient.RemoveIdentity(&input)
+	if err != nil {
+		log.Errorf("Failed to remove user %s. %s", username, err)
+		return nil, restutil.NewRestError(err.Error())
+	}
+	w.notifySignerUpdate(username)
+
+	result := identity.RemoveResponse{Name: username}
+	if w.provisioner == nil {
+		return &result, nil
+	}
+	user, err := w.provisioner.GetUser(username)
+	if err != nil {
+		log.Errorf("Failed to look up the IdP user of removed user %s. %s", username, err)
+		return &result, nil
+	}
+	if user != nil {
+		if err := w.provisioner.DeleteUser(user.ID); err != nil {
+			log.Errorf("Failed to delete the IdP user of removed user %s. %s", username, err)
+			return &result, nil
+		}
+		result.IdPUserRemoved = true
+	}
+	return &result, nil
+}
+
 func (w *idClientWrapper) List(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*identity.Identity, *restutil.RestError) {
 	result, err := w.caClient.GetAllIdentities(params.ByName("caname"))
 	if err != nil {
@@ -357,8 +760,36 @@ func (w *idClientWrapper) List(res http.ResponseWriter, req *http.Request, param
 }
 
 func (w *idClientWrapper) Get(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Identity, *restutil.RestError) {
-	username := params.ByName("username")
-	result, err := w.caClient.GetIdentity(username, params.ByName("caname"))
+	return w.getIdentity(params.ByName("username"), params.ByName("caname"))
+}
+
+// GetCurrent returns the identity the caller signs with, and its decoded enrollment certificate
+// when it is enrolled through this gateway
+func (w *idClientWrapper) GetCurrent(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.CurrentIdentity, *restutil.RestError) {
+	signer, restErr := restutil.GetRequestSigner(req)
+	if restErr != nil {
+		return nil, restErr
+	}
+	id, restErr := w.getIdentity(signer, req.URL.Query().Get("caname"))
+	if restErr != nil {
+		return nil, restErr
+	}
+	result := &identity.CurrentIdentity{
+		Identity: *id,
+		Username: auth.GetUsername(req.Context()),
+	}
+	if len(id.EnrollmentCert) > 0 {
+		cert, err := decodeCertificate(signer, id.EnrollmentCert)
+		if err != nil {
+			return nil, restutil.NewRestError(err.Error(), 500)
+		}
+		result.Certificate = cert
+	}
+	return result, nil
+}
+
+func (w *idClientWrapper) getIdentity(username, caName string) (*identity.Identity, *restutil.RestError) {
+	result, err := w.caClient.GetIdentity(username, caName)
 	if err != nil {
 		return nil, restutil.NewRestError(err.Error(), 500)
 	}
@@ -388,6 +819,11 @@ func (w *idClientWrapper) Get(res http.ResponseWriter, req *http.Request, params
 		mspId := si.Identifier().MSPID
 		newId.MSPID = mspId
 		newId.EnrollmentCert = ecert
+		if cert, err := parseCertificate(username, ecert); err != nil {
+			log.Warnf("Failed to read the expiry of the enrollment certificate of %s. %s", username, err)
+		} else {
+			newId.CertExpiry = &cert.NotAfter
+		}
 	}
 	newId.Organization = w.identityConfig.Client().Organization
 
@@ -410,6 +846,24 @@ func (w *idClientWrapper) getCACert() ([]byte, error) {
 	return result.CAChain, nil
 }
 
+// Close stops the periodic reconciliation and the certificate monitoring
+func (w *idClientWrapper) Close() {
+	if w.reconciler != nil {
+		close(w.reconciler.stop)
+		<-w.reconciler.done
+		w.reconciler = nil
+	}
+	if w.certMonitor != nil {
+		close(w.certMonitor.stop)
+		<-w.certMonitor.done
+		w.certMonitor = nil
+	}
+	if w.bulk != nil {
+		w.bulk.close()
+	}
+	w.admin.close()
+}
+
 func (w *idClientWrapper) AddSignerIdUpdateListener(idlistener SignerIdUpdateListener) {
 	w.idlisteners = append(w.idlisteners, idlistener)
 }
@@ -20,10 +20,12 @@ import (
 	"sync"
 
 	"github.com/hyperledger/fabric-protos-go/common"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/ledger"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/hyperledger/firefly-fabconnect/internal/fabric/utils"
@@ -118,6 +120,21 @@ func (l *ledgerClientWrapper) queryTransaction(channelId, signer, txId string) (
 	return ret, nil
 }
 
+// queryChannelMSPs returns the MSPs of the config of the channel, read with an identity that is not
+// cached like the signers, as the config is only read to verify the identities of other MSPs
+func (l *ledgerClientWrapper) queryChannelMSPs(channelId string, signer msp.SigningIdentity) ([]*mspproto.MSPConfig, error) {
+	channelProvider := l.sdk.ChannelContext(channelId, fabsdk.WithIdentity(signer))
+	client, err := l.ledgerClientCreator(channelProvider)
+	if err != nil {
+		return nil, errors.Errorf("Failed to get channel client. %s", err)
+	}
+	result, err := client.QueryConfig()
+	if err != nil {
+		return nil, err
+	}
+	return result.MSPs(), nil
+}
+
 func (l *ledgerClientWrapper) getLedgerClient(channelId, signer string) (ledgerClient *ledger.Client, err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -0,0 +1,492 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	contextApi "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	contextImpl "github.com/hyperledger/fabric-sdk-go/pkg/context"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fab/txn"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultOfflineExpiry = 300
+	phaseEndorse         = "endorsed"
+	phaseSubmit          = "submitted"
+)
+
+// channelNetwork is the part of a channel the offline signer reaches with the context of the
+// signer of the gateway, the proposals and transactions are only signed by the clients
+type channelNetwork interface {
+	endorsers(chaincode string) ([]fab.ProposalProcessor, error)
+	orderers() ([]fab.Orderer, error)
+	eventService() (fab.EventService, error)
+	requestContext(timeout time.Duration) (context.Context, context.CancelFunc)
+}
+
+// offlineTx is a transaction prepared for an external signer, waiting for its next signature
+type offlineTx struct {
+	channelID string
+	signer    string
+	chaincode string
+	publicKey *ecdsa.PublicKey
+	proposal  *fab.TransactionProposal
+	// payload is signed for the next phase, the proposal before the endorsement and the transaction
+	// before the submission
+	payload   []byte
+	endorsed  bool
+	submitted bool
+	// receipt is set once the submitted transaction is committed
+	receipt *TxReceipt
+	expiry  time.Time
+}
+
+// offlineSigner runs the phases of the transactions of the external identities, which are kept
+// in memory between the phases
+type offlineSigner struct {
+	mspID   string
+	timeout time.Duration
+	expiry  time.Duration
+	network func(channelID string) (channelNetwork, error)
+	// certificate returns the certificate the CA issued to an external signer, and fails for the
+	// signers of the gateway
+	certificate func(signer string) ([]byte, error)
+	mu          sync.Mutex
+	prepared    map[string]*offlineTx
+	configErr   error
+}
+
+func newOfflineSigner(c conf.OfflineSigningConf, txTimeout int, sdk *fabsdk.FabricSDK, org, mspID string, certificate func(signer string) ([]byte, error)) *offlineSigner {
+	expiry := c.Expiry
+	if expiry <= 0 {
+		expiry = defaultOfflineExpiry
+	}
+	s := &offlineSigner{
+		mspID:       mspID,
+		timeout:     time.Duration(txTimeout) * time.Second,
+		expiry:      time.Duration(expiry) * time.Second,
+		certificate: certificate,
+		prepared:    make(map[string]*offlineTx),
+	}
+	if c.Signer == "" {
+		s.configErr = errors.Errorf(errors.OfflineSigningNotConfigured)
+	}
+	s.network = func(channelID string) (channelNetwork, error) {
+		ctx, err := sdk.ChannelContext(channelID, fabsdk.WithOrg(org), fabsdk.WithUser(c.Signer))()
+		if err != nil {
+			return nil, err
+		}
+		return &sdkChannelNetwork{ctx: ctx}, nil
+	}
+	return s
+}
+
+func (s *offlineSigner) prepare(channelID, signer, chaincode, method string, args []string, transientMap map[string]string, isInit bool) (*PreparedTx, error) {
+	if s.configErr != nil {
+		return nil, s.configErr
+	}
+	// the creator is the certificate on record, a client cannot pick the one it signs with
+	certificate, err := s.certificate(signer)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := signerPublicKey(signer, certificate)
+	if err != nil {
+		return nil, err
+	}
+	creator, err := proto.Marshal(&mspproto.SerializedIdentity{Mspid: s.mspID, IdBytes: certificate})
+	if err != nil {
+		return nil, errors.Errorf("Failed to serialize identity %s. %s", signer, err)
+	}
+	txh, err := newGatewayTxHeader(channelID, creator)
+	if err != nil {
+		return nil, err
+	}
+	proposal, err := txn.CreateChaincodeInvokeProposal(txh, fab.ChaincodeInvokeRequest{
+		ChaincodeID:  chaincode,
+		Fcn:          method,
+		Args:         convertStringArray(args),
+		TransientMap: convertStringMap(transientMap),
+		IsInit:       isInit,
+	})
+	if err != nil {
+		return nil, errors.Errorf("Failed to create chaincode proposal. %s", err)
+	}
+	payload, err := proto.Marshal(proposal.Proposal)
+	if err != nil {
+		return nil, errors.Errorf("Failed to marshal chaincode proposal. %s", err)
+	}
+
+	tx := &offlineTx{
+		channelID: channelID,
+		signer:    signer,
+		chaincode: chaincode,
+		publicKey: publicKey,
+		proposal:  proposal,
+		payload:   payload,
+		expiry:    time.Now().Add(s.expiry),
+	}
+	txID := string(proposal.TxnID)
+	s.mu.Lock()
+	s.removeExpired()
+	s.prepared[txID] = tx
+	s.mu.Unlock()
+	log.Infof("Prepared transaction %s of external signer %s", txID, signer)
+	return newPreparedTx(txID, payload), nil
+}
+
+func (s *offlineSigner) endorse(channelID, signer, txID string, signature []byte) (*PreparedTx, error) {
+	tx, signature, err := s.signedTx(channelID, signer, txID, signature, false)
+	if err != nil {
+		return nil, err
+	}
+	network, err := s.network(channelID)
+	if err != nil {
+		return nil, err
+	}
+	endorsers, err := network.endorsers(tx.chaincode)
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineEndorsementFailed, txID, err)
+	}
+	ctx, cancel := network.requestContext(s.timeout)
+	defer cancel()
+	responses, err := processProposal(ctx, &pb.SignedProposal{ProposalBytes: tx.payload, Signature: signature}, endorsers)
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineEndorsementFailed, txID, err)
+	}
+	for _, r := range responses[1:] {
+		if !bytes.Equal(r.ProposalResponse.Payload, responses[0].ProposalResponse.Payload) {
+			return nil, errors.Errorf(errors.OfflineEndorsementMismatch, txID)
+		}
+	}
+	transaction, err := txn.New(fab.TransactionRequest{Proposal: tx.proposal, ProposalResponses: responses})
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineEndorsementFailed, txID, err)
+	}
+	payload, err := transactionPayload(tx.proposal, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	tx.payload = payload
+	tx.endorsed = true
+	s.mu.Unlock()
+	log.Infof("Endorsed transaction %s of external signer %s by %d peers", txID, signer, len(responses))
+	return newPreparedTx(txID, payload), nil
+}
+
+func (s *offlineSigner) submit(channelID, signer, txID string, signature []byte) (*TxReceipt, error) {
+	tx, signature, err := s.signedTx(channelID, signer, txID, signature, true)
+	if err != nil {
+		return nil, err
+	}
+	network, err := s.network(channelID)
+	if err != nil {
+		return nil, err
+	}
+	orderers, err := network.orderers()
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineSubmitFailed, txID, err)
+	}
+	events, err := network.eventService()
+	if err != nil {
+		return nil, err
+	}
+	// the commit is only seen when the listener is registered before the transaction is ordered
+	reg, statusNotifier, err := events.RegisterTxStatusEvent(txID)
+	if err != nil {
+		return nil, errors.Errorf("Error registering for TxStatus event for transaction %s. %s", txID, err)
+	}
+
+	ctx, cancel := network.requestContext(s.timeout)
+	defer cancel()
+	if err := broadcast(ctx, &fab.SignedEnvelope{Payload: tx.payload, Signature: signature}, orderers); err != nil {
+		events.Unregister(reg)
+		return nil, errors.Errorf(errors.OfflineSubmitFailed, txID, err)
+	}
+	s.mu.Lock()
+	tx.submitted = true
+	tx.expiry = time.Now().Add(s.expiry)
+	s.mu.Unlock()
+	go s.waitForCommit(events, reg, statusNotifier, tx, s.expiry)
+
+	// the receipt is completed by the commit, which the tx processor waits for like the others
+	return &TxReceipt{
+		SignerMSP:     s.mspID,
+		Signer:        signer,
+		TransactionID: txID,
+	}, nil
+}
+
+// waitForCommit records the commit of a submitted transaction, until it expires
+func (s *offlineSigner) waitForCommit(events fab.EventService, reg fab.Registration, statusNotifier <-chan *fab.TxStatusEvent, tx *offlineTx, wait time.Duration) {
+	defer events.Unregister(reg)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	txID := string(tx.proposal.TxnID)
+	select {
+	case txStatus, ok := <-statusNotifier:
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		tx.receipt = &TxReceipt{
+			SignerMSP:     s.mspID,
+			Signer:        tx.signer,
+			TransactionID: txStatus.TxID,
+			Status:        txStatus.TxValidationCode,
+			BlockNumber:   txStatus.BlockNumber,
+			SourcePeer:    txStatus.SourceURL,
+		}
+		s.mu.Unlock()
+		log.Infof("Transaction %s of external signer %s committed in block %d", txID, tx.signer, txStatus.BlockNumber)
+	case <-timer.C:
+		log.Warnf("The commit of transaction %s of external signer %s was not seen", txID, tx.signer)
+	}
+}
+
+// committed returns the receipt of a submitted transaction once it is committed, and nil until then
+func (s *offlineSigner) committed(channelID, signer, txID string) (*TxReceipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.prepared[txID]
+	if tx == nil || !tx.submitted || tx.channelID != channelID || tx.signer != signer {
+		return nil, errors.Errorf(errors.OfflineTxNotFound, txID, signer, channelID)
+	}
+	if tx.receipt != nil {
+		delete(s.prepared, txID)
+	}
+	return tx.receipt, nil
+}
+
+// signedTx returns the prepared transaction of the signer in the phase, and the signature of
+// its payload in the low-S form required by Fabric
+func (s *offlineSigner) signedTx(channelID, signer, txID string, signature []byte, endorsed bool) (*offlineTx, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.prepared[txID]
+	if tx == nil || tx.submitted || tx.channelID != channelID || tx.signer != signer || time.Now().After(tx.expiry) {
+		return nil, nil, errors.Errorf(errors.OfflineTxNotFound, txID, signer, channelID)
+	}
+	if tx.endorsed != endorsed {
+		if endorsed {
+			return nil, nil, errors.Errorf(errors.OfflineTxWrongPhase, txID, phaseEndorse)
+		}
+		return nil, nil, errors.Errorf(errors.OfflineTxWrongPhase, txID, phaseSubmit)
+	}
+	digest := sha256.Sum256(tx.payload)
+	signature, ok := lowSSignature(tx.publicKey, signature)
+	if !ok || !ecdsa.VerifyASN1(tx.publicKey, digest[:], signature) {
+		return nil, nil, errors.Errorf(errors.OfflineSignatureInvalid, txID)
+	}
+	return tx, signature, nil
+}
+
+// removeExpired drops the transactions whose signatures never came, under the lock
+func (s *offlineSigner) removeExpired() {
+	now := time.Now()
+	for txID, tx := range s.prepared {
+		if now.After(tx.expiry) {
+			log.Warnf("Prepared transaction %s of external signer %s expired", txID, tx.signer)
+			delete(s.prepared, txID)
+		}
+	}
+}
+
+func newPreparedTx(txID string, payload []byte) *PreparedTx {
+	digest := sha256.Sum256(payload)
+	return &PreparedTx{
+		TransactionID: txID,
+		Digest:        digest[:],
+		Payload:       payload,
+	}
+}
+
+// signerPublicKey returns the ECDSA key of the PEM certificate of the signer
+func signerPublicKey(signer string, certificate []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.Errorf(errors.OfflineSigningCertInvalid, signer, "no CERTIFICATE PEM block found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Errorf(errors.OfflineSigningCertInvalid, signer, err)
+	}
+	if cert.Subject.CommonName != signer {
+		return nil, errors.Errorf(errors.OfflineSigningCertMismatch, cert.Subject.CommonName, signer)
+	}
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf(errors.OfflineSigningCertInvalid, signer, "the key is not an ECDSA key")
+	}
+	return publicKey, nil
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// lowSSignature returns the DER signature with the S value in the lower half of the order of the
+// curve, the peers and orderers reject the other, equally valid, form
+func lowSSignature(publicKey *ecdsa.PublicKey, signature []byte) ([]byte, bool) {
+	var sig ecdsaSignature
+	if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 || sig.R == nil || sig.S == nil {
+		return nil, false
+	}
+	n := publicKey.Curve.Params().N
+	if sig.S.Cmp(new(big.Int).Rsh(n, 1)) <= 0 {
+		return signature, true
+	}
+	sig.S = new(big.Int).Sub(n, sig.S)
+	lowS, err := asn1.Marshal(sig)
+	return lowS, err == nil
+}
+
+// transactionPayload returns the bytes of the payload of the transaction envelope
+func transactionPayload(proposal *fab.TransactionProposal, transaction *fab.Transaction) ([]byte, error) {
+	hdr := &common.Header{}
+	if err := proto.Unmarshal(proposal.Header, hdr); err != nil {
+		return nil, err
+	}
+	txBytes, err := proto.Marshal(transaction.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&common.Payload{Header: hdr, Data: txBytes})
+}
+
+// processProposal sends the signed proposal to all the endorsers concurrently
+func processProposal(ctx context.Context, signedProposal *pb.SignedProposal, endorsers []fab.ProposalProcessor) ([]*fab.TransactionProposalResponse, error) {
+	if len(endorsers) == 0 {
+		return nil, errors.Errorf("no endorsers found")
+	}
+	responses := make([]*fab.TransactionProposalResponse, len(endorsers))
+	errs := make([]string, len(endorsers))
+	var wg sync.WaitGroup
+	for i, endorser := range endorsers {
+		wg.Add(1)
+		go func(i int, endorser fab.ProposalProcessor) {
+			defer wg.Done()
+			response, err := endorser.ProcessTransactionProposal(ctx, fab.ProcessProposalRequest{SignedProposal: signedProposal})
+			if err != nil {
+				errs[i] = err.Error()
+				return
+			}
+			responses[i] = response
+		}(i, endorser)
+	}
+	wg.Wait()
+	var failures []string
+	for _, e := range errs {
+		if e != "" {
+			failures = append(failures, e)
+		}
+	}
+	if len(failures) > 0 {
+		return nil, errors.Errorf("%s", strings.Join(failures, ", "))
+	}
+	return responses, nil
+}
+
+// broadcast sends the envelope to the orderers in turn, until one accepts it
+func broadcast(ctx context.Context, envelope *fab.SignedEnvelope, orderers []fab.Orderer) error {
+	if len(orderers) == 0 {
+		return errors.Errorf("no orderers found")
+	}
+	var failures []string
+	for _, orderer := range orderers {
+		if _, err := orderer.SendBroadcast(ctx, envelope); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		return nil
+	}
+	return errors.Errorf("%s", strings.Join(failures, ", "))
+}
+
+// sdkChannelNetwork reaches the channel with the SDK context of the signer of the gateway
+type sdkChannelNetwork struct {
+	ctx contextApi.Channel
+}
+
+func (n *sdkChannelNetwork) endorsers(chaincode string) ([]fab.ProposalProcessor, error) {
+	selection, err := n.ctx.ChannelService().Selection()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := selection.GetEndorsersForChaincode([]*fab.ChaincodeCall{{ID: chaincode}})
+	if err != nil {
+		return nil, err
+	}
+	endorsers := make([]fab.ProposalProcessor, len(peers))
+	for i, peer := range peers {
+		endorsers[i] = peer
+	}
+	return endorsers, nil
+}
+
+// orderers returns the orderers of the channel in the connection profile, or all the orderers
+func (n *sdkChannelNetwork) orderers() ([]fab.Orderer, error) {
+	endpointConfig := n.ctx.EndpointConfig()
+	var configs []fab.OrdererConfig
+	for _, name := range endpointConfig.ChannelConfig(n.ctx.ChannelID()).Orderers {
+		if config, found, ignore := endpointConfig.OrdererConfig(name); found && !ignore {
+			configs = append(configs, *config)
+		}
+	}
+	if len(configs) == 0 {
+		configs = endpointConfig.OrderersConfig()
+	}
+	orderers := make([]fab.Orderer, 0, len(configs))
+	for i := range configs {
+		orderer, err := n.ctx.InfraProvider().CreateOrdererFromConfig(&configs[i])
+		if err != nil {
+			return nil, err
+		}
+		orderers = append(orderers, orderer)
+	}
+	return orderers, nil
+}
+
+func (n *sdkChannelNetwork) eventService() (fab.EventService, error) {
+	return n.ctx.ChannelService().EventService()
+}
+
+func (n *sdkChannelNetwork) requestContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return contextImpl.NewRequest(n.ctx, contextImpl.WithTimeout(timeout))
+}
@@ -0,0 +1,345 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeEndorser struct {
+	payload []byte
+	err     error
+	signed  *pb.SignedProposal
+}
+
+func (e *fakeEndorser) ProcessTransactionProposal(ctx context.Context, req fab.ProcessProposalRequest) (*fab.TransactionProposalResponse, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.signed = req.SignedProposal
+	return &fab.TransactionProposalResponse{
+		Endorser: "peer0",
+		Status:   200,
+		ProposalResponse: &pb.ProposalResponse{
+			Payload:     e.payload,
+			Response:    &pb.Response{Status: 200},
+			Endorsement: &pb.Endorsement{Endorser: []byte("peer0"), Signature: []byte("sig")},
+		},
+	}, nil
+}
+
+type fakeOrderer struct {
+	fab.Orderer
+	err      error
+	envelope *fab.SignedEnvelope
+}
+
+func (o *fakeOrderer) SendBroadcast(ctx context.Context, envelope *fab.SignedEnvelope) (*common.Status, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	o.envelope = envelope
+	status := common.Status_SUCCESS
+	return &status, nil
+}
+
+type fakeEventService struct {
+	fab.EventService
+	events chan *fab.TxStatusEvent
+}
+
+func (e *fakeEventService) RegisterTxStatusEvent(txID string) (fab.Registration, <-chan *fab.TxStatusEvent, error) {
+	return txID, e.events, nil
+}
+
+func (e *fakeEventService) Unregister(reg fab.Registration) {}
+
+type fakeChannelNetwork struct {
+	endorserList []fab.ProposalProcessor
+	ordererList  []fab.Orderer
+	events       *fakeEventService
+	timeout      time.Duration
+}
+
+func (n *fakeChannelNetwork) endorsers(chaincode string) ([]fab.ProposalProcessor, error) {
+	return n.endorserList, nil
+}
+
+func (n *fakeChannelNetwork) orderers() ([]fab.Orderer, error) {
+	return n.ordererList, nil
+}
+
+func (n *fakeChannelNetwork) eventService() (fab.EventService, error) {
+	return n.events, nil
+}
+
+func (n *fakeChannelNetwork) requestContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if n.timeout > 0 {
+		timeout = n.timeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// newTestSignerKey returns the key and the PEM certificate of an external signer
+func newTestSignerKey(t *testing.T, username string) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: username},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newTestOfflineSigner(network *fakeChannelNetwork, certificates map[string][]byte) *offlineSigner {
+	s := newOfflineSigner(conf.OfflineSigningConf{Signer: "gateway"}, 5, nil, "org1", "org1MSP", func(signer string) ([]byte, error) {
+		if certificate, ok := certificates[signer]; ok {
+			return certificate, nil
+		}
+		return nil, fmt.Errorf("'%s' is not an external identity", signer)
+	})
+	s.network = func(channelID string) (channelNetwork, error) {
+		return network, nil
+	}
+	return s
+}
+
+func signDigest(t *testing.T, key *ecdsa.PrivateKey, prepared *PreparedTx) []byte {
+	signature, err := ecdsa.SignASN1(rand.Reader, key, prepared.Digest)
+	assert.NoError(t, err)
+	return signature
+}
+
+func TestOfflineSigning(t *testing.T) {
+	assert := assert.New(t)
+
+	endorser1 := &fakeEndorser{payload: []byte("rwset")}
+	endorser2 := &fakeEndorser{payload: []byte("rwset")}
+	orderer := &fakeOrderer{}
+	events := &fakeEventService{events: make(chan *fab.TxStatusEvent, 1)}
+	key, certificate := newTestSignerKey(t, "user1")
+	s := newTestOfflineSigner(&fakeChannelNetwork{
+		endorserList: []fab.ProposalProcessor{endorser1, endorser2},
+		ordererList:  []fab.Orderer{orderer},
+		events:       events,
+	}, map[string][]byte{"user1": certificate})
+
+	prepared, err := s.prepare("default-channel", "user1", "asset_transfer", "CreateAsset", []string{"asset1"}, nil, false)
+	assert.NoError(err)
+	assert.NotEmpty(prepared.TransactionID)
+	digest := sha256.Sum256(prepared.Payload)
+	assert.Equal(digest[:], prepared.Digest)
+	proposal := &pb.Proposal{}
+	assert.NoError(proto.Unmarshal(prepared.Payload, proposal))
+
+	_, err = s.submit("default-channel", "user1", prepared.TransactionID, signDigest(t, key, prepared))
+	assert.EqualError(err, fmt.Sprintf("Transaction '%s' must be endorsed first", prepared.TransactionID))
+	_, err = s.endorse("default-channel", "user2", prepared.TransactionID, signDigest(t, key, prepared))
+	assert.Regexp("is not prepared for signer 'user2'", err)
+	otherKey, _ := newTestSignerKey(t, "user1")
+	_, err = s.endorse("default-channel", "user1", prepared.TransactionID, signDigest(t, otherKey, prepared))
+	assert.EqualError(err, fmt.Sprintf("Invalid signature of transaction '%s'", prepared.TransactionID))
+
+	proposalSignature := signDigest(t, key, prepared)
+	endorsed, err := s.endorse("default-channel", "user1", prepared.TransactionID, proposalSignature)
+	assert.NoError(err)
+	assert.Equal(prepared.TransactionID, endorsed.TransactionID)
+	assert.Equal(prepared.Payload, endorser1.signed.ProposalBytes)
+	payload := &common.Payload{}
+	assert.NoError(proto.Unmarshal(endorsed.Payload, payload))
+	channelHeader := &common.ChannelHeader{}
+	assert.NoError(proto.Unmarshal(payload.Header.ChannelHeader, channelHeader))
+	assert.Equal(prepared.TransactionID, channelHeader.TxId)
+
+	_, err = s.endorse("default-channel", "user1", prepared.TransactionID, proposalSignature)
+	assert.EqualError(err, fmt.Sprintf("Transaction '%s' must be submitted first", prepared.TransactionID))
+
+	receipt, err := s.submit("default-channel", "user1", prepared.TransactionID, signDigest(t, key, endorsed))
+	assert.NoError(err)
+	assert.Equal(&TxReceipt{SignerMSP: "org1MSP", Signer: "user1", TransactionID: prepared.TransactionID}, receipt)
+	assert.Equal(endorsed.Payload, orderer.envelope.Payload)
+	_, err = s.submit("default-channel", "user1", prepared.TransactionID, signDigest(t, key, endorsed))
+	assert.Regexp("is not prepared for signer 'user1'", err)
+
+	// the receipt is completed by the commit
+	receipt, err = s.committed("default-channel", "user1", prepared.TransactionID)
+	assert.NoError(err)
+	assert.Nil(receipt)
+	_, err = s.committed("default-channel", "user2", prepared.TransactionID)
+	assert.Regexp("is not prepared for signer 'user2'", err)
+	events.events <- &fab.TxStatusEvent{TxID: prepared.TransactionID, TxValidationCode: pb.TxValidationCode_VALID, BlockNumber: 10, SourceURL: "peer0"}
+	assert.Eventually(func() bool {
+		receipt, err = s.committed("default-channel", "user1", prepared.TransactionID)
+		return err == nil && receipt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(&TxReceipt{
+		SignerMSP:     "org1MSP",
+		Signer:        "user1",
+		TransactionID: prepared.TransactionID,
+		Status:        pb.TxValidationCode_VALID,
+		BlockNumber:   10,
+		SourcePeer:    "peer0",
+	}, receipt)
+	_, err = s.committed("default-channel", "user1", prepared.TransactionID)
+	assert.Regexp("is not prepared for signer 'user1'", err)
+}
+
+func TestOfflineSigningFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newOfflineSigner(conf.OfflineSigningConf{}, 5, nil, "org1", "org1MSP", nil)
+	_, err := s.prepare("default-channel", "user1", "asset_transfer", "CreateAsset", nil, nil, false)
+	assert.EqualError(err, "Offline signing is not configured, set rpc.offlineSigning.signer")
+
+	network := &fakeChannelNetwork{
+		endorserList: []fab.ProposalProcessor{&fakeEndorser{payload: []byte("rwset1")}, &fakeEndorser{payload: []byte("rwset2")}},
+		ordererList:  []fab.Orderer{&fakeOrderer{err: fmt.Errorf("pop")}},
+		events:       &fakeEventService{events: make(chan *fab.TxStatusEvent)},
+	}
+	key, certificate := newTestSignerKey(t, "user1")
+	s = newTestOfflineSigner(network, map[string][]byte{"user1": certificate, "user2": certificate, "user3": []byte("not a certificate")})
+
+	_, err = s.prepare("default-channel", "gateway-user", "asset_transfer", "CreateAsset", nil, nil, false)
+	assert.EqualError(err, "'gateway-user' is not an external identity")
+	_, err = s.prepare("default-channel", "user3", "asset_transfer", "CreateAsset", nil, nil, false)
+	assert.EqualError(err, "Invalid certificate of signer 'user3': no CERTIFICATE PEM block found")
+	_, err = s.prepare("default-channel", "user2", "asset_transfer", "CreateAsset", nil, nil, false)
+	assert.EqualError(err, "The common name 'user1' of the certificate must be the signer 'user2'")
+
+	prepared, err := s.prepare("default-channel", "user1", "asset_transfer", "CreateAsset", nil, nil, false)
+	assert.NoError(err)
+	_, err = s.endorse("default-channel", "user1", prepared.TransactionID, []byte("not a signature"))
+	assert.EqualError(err, fmt.Sprintf("Invalid signature of transaction '%s'", prepared.TransactionID))
+	_, err = s.endorse("default-channel", "user1", prepared.TransactionID, signDigest(t, key, prepared))
+	assert.EqualError(err, fmt.Sprintf("The endorsements of transaction '%s' do not match", prepared.TransactionID))
+
+	network.endorserList = []fab.ProposalProcessor{&fakeEndorser{err: fmt.Errorf("pop")}}
+	_, err = s.endorse("default-channel", "user1", prepared.TransactionID, signDigest(t, key, prepared))
+	assert.EqualError(err, fmt.Sprintf("Failed to collect the endorsements of transaction '%s': pop", prepared.TransactionID))
+
+	network.endorserList = []fab.ProposalProcessor{&fakeEndorser{payload: []byte("rwset")}}
+	endorsed, err := s.endorse("default-channel", "user1", prepared.TransactionID, signDigest(t, key, prepared))
+	assert.NoError(err)
+	_, err = s.submit("default-channel", "user1", prepared.TransactionID, signDigest(t, key, endorsed))
+	assert.EqualError(err, fmt.Sprintf("Failed to send transaction '%s' to the orderers: pop", prepared.TransactionID))
+
+	// the commit is never seen
+	network.ordererList = []fab.Orderer{&fakeOrderer{}}
+	_, err = s.submit("default-channel", "user1", prepared.TransactionID, signDigest(t, key, endorsed))
+	assert.NoError(err)
+	close(network.events.events)
+	receipt, err := s.committed("default-channel", "user1", prepared.TransactionID)
+	assert.NoError(err)
+	assert.Nil(receipt)
+
+	s.expiry = -time.Second
+	prepared, err = s.prepare("default-channel", "user1", "asset_transfer", "CreateAsset", nil, nil, false)
+	assert.NoError(err)
+	_, err = s.endorse("default-channel", "user1", prepared.TransactionID, signDigest(t, key, prepared))
+	assert.Regexp("or has expired", err)
+}
+
+func TestExternalCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user1", "").Return(&mspApi.IdentityResponse{
+		ID:         "user1",
+		Attributes: []mspApi.Attribute{{Name: "fabconnect.external", Value: "true"}},
+	}, nil)
+	mockCAClient.On("GetIdentity", "user2", "").Return(&mspApi.IdentityResponse{ID: "user2"}, nil)
+	mockCAClient.On("GetIdentity", "user3", "").Return(nil, fmt.Errorf("pop"))
+	olderCert := newTestCertificate(t, "user1", time.Now().AddDate(1, 0, 0), nil)
+	_, latestCert := newTestSignerKey(t, "user1")
+	mockAuditor := &mockfabricdep.CertificateAuditor{}
+	mockAuditor.On("GetCertificates", mock.MatchedBy(func(req *dep.CertificatesRequest) bool {
+		return req.ID == "user1" && !*req.Expired && !*req.Revoked
+	})).Return(&dep.CertificatesResponse{Certs: [][]byte{olderCert, []byte("bad"), latestCert}}, nil).Once()
+	w := &idClientWrapper{caClient: mockCAClient, certAuditor: mockAuditor}
+
+	certificate, err := w.externalCertificate("user1")
+	assert.NoError(err)
+	assert.Equal(latestCert, certificate)
+
+	mockAuditor.On("GetCertificates", mock.Anything).Return(&dep.CertificatesResponse{}, nil).Once()
+	_, err = w.externalCertificate("user1")
+	assert.EqualError(err, "No valid certificate is issued to external signer 'user1'")
+	mockAuditor.On("GetCertificates", mock.Anything).Return(nil, fmt.Errorf("bang")).Once()
+	_, err = w.externalCertificate("user1")
+	assert.EqualError(err, "Failed to look up the certificate of external signer 'user1': bang")
+
+	_, err = w.externalCertificate("user2")
+	assert.EqualError(err, "'user2' is not an external identity, its transactions are signed by the gateway")
+	_, err = w.externalCertificate("user3")
+	assert.EqualError(err, "Failed to look up the certificate of external signer 'user3': pop")
+	mockAuditor.AssertNumberOfCalls(t, "GetCertificates", 3)
+}
+
+func TestLowSSignature(t *testing.T) {
+	assert := assert.New(t)
+
+	key, _ := newTestSignerKey(t, "user1")
+	digest := sha256.Sum256([]byte("payload"))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	assert.NoError(err)
+	var sig ecdsaSignature
+	_, err = asn1.Unmarshal(signature, &sig)
+	assert.NoError(err)
+	n := key.Curve.Params().N
+	halfN := new(big.Int).Rsh(n, 1)
+	if sig.S.Cmp(halfN) <= 0 {
+		sig.S = new(big.Int).Sub(n, sig.S)
+	}
+	highS, _ := asn1.Marshal(sig)
+
+	lowS, ok := lowSSignature(&key.PublicKey, highS)
+	assert.True(ok)
+	assert.True(ecdsa.VerifyASN1(&key.PublicKey, digest[:], lowS))
+	_, err = asn1.Unmarshal(lowS, &sig)
+	assert.NoError(err)
+	assert.True(sig.S.Cmp(halfN) <= 0)
+
+	_, ok = lowSSignature(&key.PublicKey, []byte{0x30})
+	assert.False(ok)
+}
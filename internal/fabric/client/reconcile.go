@@ -0,0 +1,261 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+	"github.com/hyperledger/firefly-fabconnect/internal/openid"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// reservedAttributePrefix prefixes the attributes the gateway marks the CA identities with
+const reservedAttributePrefix = "fabconnect."
+
+// revokedAttribute marks the CA identities revoked through the gateway
+const revokedAttribute = reservedAttributePrefix + "revoked"
+
+// reconciler periodically compares the CA identities with the IdP users
+type reconciler struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (w *idClientWrapper) startReconciler() {
+	if w.reconcileConf.Interval <= 0 || w.provisioner == nil {
+		return
+	}
+	w.reconciler = &reconciler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.reconcileLoop(w.reconciler, time.Duration(w.reconcileConf.Interval)*time.Second)
+}
+
+func (w *idClientWrapper) reconcileLoop(r *reconciler, interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := w.reconcile("", w.reconcileConf.Fix); err != nil {
+				log.Errorf("IdP reconciliation failed. %s", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Reconcile runs the reconciliation on demand. The drift is only fixed with ?fix=true
+func (w *idClientWrapper) Reconcile(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.ReconcileReport, restErr *restutil.RestError) {
+	op := w.admin.start(req, adminOpReconcile, "", req.URL.Query().Get("caname"))
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorize(); restErr != nil {
+		return nil, restErr
+	}
+	if w.provisioner == nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.IdPNotConfigured).Error(), 405)
+	}
+	fix, _ := strconv.ParseBool(req.URL.Query().Get("fix"))
+	report, err := w.reconcile(req.URL.Query().Get("caname"), fix)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	return report, nil
+}
+
+// reconcile reports the enabled IdP users without a CA identity or of a revoked identity, which
+// are disabled when fixing, and the identities without an IdP user. The IdP users are shared by
+// the CAs of the org, so the identities of every CA are collected before a user is found without
+// an identity or only with revoked ones. With a CA name, the identities of the other CAs are only
+// used for that, and the drifts of their own identities are not reported
+func (w *idClientWrapper) reconcile(caName string, fix bool) (*identity.ReconcileReport, error) {
+	identities, err := w.caClient.GetAllIdentities(caName)
+	if err != nil {
+		return nil, err
+	}
+	// the names of the identities of the other CAs, and of the identities that are not revoked
+	known := make(map[string]bool)
+	active := make(map[string]bool, len(identities))
+	if err := w.collectActive(caName, identities, active); err != nil {
+		return nil, err
+	}
+	for i, name := range w.caNames {
+		if (i == 0 && caName == "") || name == caName {
+			// already collected
+			continue
+		}
+		others, err := w.caClient.GetAllIdentities(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.collectActive(name, others, active); err != nil {
+			return nil, err
+		}
+		if caName == "" {
+			identities = append(identities, others...)
+			continue
+		}
+		for _, id := range others {
+			known[id.ID] = true
+		}
+	}
+	users, err := w.provisioner.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	c := w.reconcileConf
+	types := c.IdentityTypes
+	if len(types) == 0 {
+		types = []string{"client"}
+	}
+	usersByName := make(map[string]*openid.IdPUser, len(users))
+	for _, user := range users {
+		usersByName[user.Username] = user
+	}
+	report := &identity.ReconcileReport{
+		Identities: len(identities),
+		IdPUsers:   len(users),
+		Drifts:     []*identity.Drift{},
+	}
+	identityNames := make(map[string]bool, len(identities))
+	for _, id := range identities {
+		if identityNames[id.ID] {
+			// the same name in several CAs has one IdP user
+			continue
+		}
+		identityNames[id.ID] = true
+		if ignored(c.Ignore, id.ID) {
+			continue
+		}
+		user := usersByName[id.ID]
+		switch {
+		case user == nil:
+			if contains(types, id.Type) {
+				report.Drifts = append(report.Drifts, &identity.Drift{Username: id.ID, Kind: identity.DriftIdentityWithoutIdPUser})
+			}
+		case user.Enabled && !active[id.ID]:
+			report.Drifts = append(report.Drifts, w.userDrift(identity.DriftRevokedIdentityEnabled, user, fix))
+		}
+	}
+	for _, user := range users {
+		if user.Enabled && !identityNames[user.Username] && !known[user.Username] && !ignored(c.Ignore, user.Username) {
+			report.Drifts = append(report.Drifts, w.userDrift(identity.DriftIdPUserWithoutIdentity, user, fix))
+		}
+	}
+	sort.Slice(report.Drifts, func(i, j int) bool {
+		return report.Drifts[i].Username < report.Drifts[j].Username
+	})
+
+	for _, drift := range report.Drifts {
+		log.Warnf("IdP drift %s of %s (fixed=%t) %s", drift.Kind, drift.Username, drift.Fixed, drift.Error)
+	}
+	log.Infof("Reconciled %d identities with %d IdP users, %d drifts", report.Identities, report.IdPUsers, len(report.Drifts))
+	return report, nil
+}
+
+func (w *idClientWrapper) userDrift(kind string, user *openid.IdPUser, fix bool) *identity.Drift {
+	drift := &identity.Drift{Username: user.Username, Kind: kind, IdPUserID: user.ID}
+	if fix {
+		if err := w.provisioner.DisableUser(user.ID); err != nil {
+			drift.Error = err.Error()
+		} else {
+			drift.Fixed = true
+		}
+	}
+	return drift
+}
+
+// collectActive adds the identities of a CA that are not revoked to the active ones. An identity
+// is revoked when the gateway marked it, or when the CA revoked its certificates and it has no
+// other, as the fabric-ca-client revoke command does. The identities without any certificate are
+// registered but not enrolled yet, and stay active
+func (w *idClientWrapper) collectActive(caName string, identities []*mspApi.IdentityResponse, active map[string]bool) error {
+	revoked, err := w.certificateIDs(caName, true)
+	if err != nil {
+		return err
+	}
+	unrevoked, err := w.certificateIDs(caName, false)
+	if err != nil {
+		return err
+	}
+	for _, id := range identities {
+		if !isRevoked(id.Attributes) && (!revoked[id.ID] || unrevoked[id.ID]) {
+			active[id.ID] = true
+		}
+	}
+	return nil
+}
+
+// certificateIDs returns the enrollment IDs of the certificates of a CA that are revoked or not
+func (w *idClientWrapper) certificateIDs(caName string, revoked bool) (map[string]bool, error) {
+	result, err := w.certAuditor.GetCertificates(&dep.CertificatesRequest{CAName: caName, Revoked: &revoked})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(result.Certs))
+	for _, certPEM := range result.Certs {
+		cert, err := parseCertificate("", certPEM)
+		if err != nil {
+			log.Warnf("Skipping a certificate returned by the Fabric CA. %s", err)
+			continue
+		}
+		ids[cert.Subject.CommonName] = true
+	}
+	return ids, nil
+}
+
+func isRevoked(attributes []mspApi.Attribute) bool {
+	for _, attr := range attributes {
+		if attr.Name == revokedAttribute {
+			revoked, _ := strconv.ParseBool(attr.Value)
+			return revoked
+		}
+	}
+	return false
+}
+
+func ignored(patterns []string, username string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, username); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
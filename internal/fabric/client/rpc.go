@@ -17,10 +17,12 @@
 package client
 
 import (
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
 	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/wallet"
 	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
 	log "github.com/sirupsen/logrus"
 )
@@ -37,7 +39,7 @@ func RPCConnect(c conf.RPCConf, o conf.OpenIDConfig, txTimeout int) (RPCClient,
 		return nil, nil, errors.Errorf("User credentials store creation failed. %s", err)
 	}
 
-	identityClient, err := newIdentityClient(o, configProvider, userStore)
+	identityClient, err := newIdentityClient(o, c.CertExpiry, configProvider, userStore)
 
 	if err != nil {
 		return nil, nil, err
@@ -48,20 +50,39 @@ func RPCConnect(c conf.RPCConf, o conf.OpenIDConfig, txTimeout int) (RPCClient,
 		return nil, nil, errors.Errorf("Failed to initialize a new SDK instance. %s", err)
 	}
 	ledgerClient := newLedgerClient(configProvider, sdk, identityClient)
+	// the CAs of the other MSPs, whose identities can be imported, are read from the channel configs
+	identityClient.channelMSPs = func(channelId string) ([]*mspproto.MSPConfig, error) {
+		signer, err := identityClient.defaultRegistrar()
+		if err != nil {
+			return nil, err
+		}
+		return ledgerClient.queryChannelMSPs(channelId, signer)
+	}
 	eventClient := newEventClient(configProvider, sdk, identityClient)
+	offlineSigner := newOfflineSigner(c.OfflineSigning, txTimeout, sdk, identityClient.GetClientOrg(), identityClient.mspID, identityClient.externalCertificate)
 	var rpcClient RPCClient
 	if !c.UseGatewayClient && !c.UseGatewayServer {
-		rpcClient, err = newRPCClientFromCCP(configProvider, txTimeout, userStore, identityClient, ledgerClient, eventClient)
+		rpcClient, err = newRPCClientFromCCP(configProvider, txTimeout, userStore, identityClient, ledgerClient, eventClient, offlineSigner)
 		if err != nil {
 			return nil, nil, err
 		}
 		log.Info("Using static connection profile mode of the RPC client")
 	} else if c.UseGatewayClient {
-		rpcClient, err = newRPCClientWithClientSideGateway(configProvider, txTimeout, identityClient, ledgerClient, eventClient)
+		walletStore, err := wallet.NewStore(c.Wallet)
+		if err != nil {
+			return nil, nil, err
+		}
+		rpcClient, err = newRPCClientWithClientSideGateway(configProvider, txTimeout, identityClient, ledgerClient, eventClient, offlineSigner, walletStore)
 		if err != nil {
 			return nil, nil, err
 		}
 		log.Info("Using client-side gateway mode of the RPC client")
+	} else {
+		rpcClient, err = newRPCClientWithServerSideGateway(configProvider, txTimeout, identityClient, ledgerClient, eventClient, offlineSigner)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Info("Using server-side gateway mode of the RPC client")
 	}
 	return rpcClient, identityClient, nil
 }
@@ -17,6 +17,8 @@
 package client
 
 import (
+	gocontext "context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -25,6 +27,7 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
@@ -33,10 +36,16 @@ import (
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/context"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/fabric/dep"
+	"github.com/hyperledger/firefly-fabconnect/internal/openid"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
 	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	mockopenid "github.com/hyperledger/firefly-fabconnect/mocks/openid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/otiai10/copy"
 	"github.com/stretchr/testify/assert"
@@ -48,6 +57,8 @@ var tmpConfigFile string
 var tmpCCPFile string
 var tmpShortConfigFile string
 var tmpShortCCPFile string
+var testOpenIDServer *httptest.Server
+var testOpenIDConf conf.OpenIDConfig
 
 func TestMain(m *testing.M) {
 	var code int
@@ -99,6 +110,13 @@ func setup() error {
 	}
 	tmpShortCCPFile = configFile
 
+	testOpenIDServer = newTestOpenIDServer()
+	testOpenIDConf = conf.OpenIDConfig{
+		Host:        testOpenIDServer.URL,
+		AdminRealm:  "master",
+		ClientRealm: "fabconnect",
+	}
+
 	err = copy.Copy(path.Join(sourcedir, "../../../../test/fixture/nodeMSPs"), path.Join(tmpdir, "nodeMSPs"))
 	if err != nil {
 		return err
@@ -112,9 +130,89 @@ func setup() error {
 }
 
 func teardown() {
+	testOpenIDServer.Close()
 	cleanup(tmpdir)
 }
 
+// a minimal Keycloak stand-in that knows about user1 and user2, and records the users it
+// provisions and deletes. Requests for the role "missing" fail
+type testKeycloak struct {
+	mux     sync.Mutex
+	created map[string]string
+	deleted []string
+	calls   []string
+}
+
+var testOpenIDUsers *testKeycloak
+
+func newTestOpenIDServer() *httptest.Server {
+	testOpenIDUsers = &testKeycloak{created: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/master/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"admin-token","token_type":"bearer","expires_in":300}`))
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var user map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&user)
+			username := user["username"].(string)
+			testOpenIDUsers.record("create " + username)
+			testOpenIDUsers.mux.Lock()
+			testOpenIDUsers.created[username] = fmt.Sprintf("%v", user)
+			testOpenIDUsers.mux.Unlock()
+			w.Header().Set("Location", fmt.Sprintf("http://%s/admin/realms/fabconnect/users/%s-id", r.Host, username))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		username := r.URL.Query().Get("username")
+		w.Header().Set("Content-Type", "application/json")
+		if username != "user1" && username != "user2" {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf(`[{"id":"%s-id","username":"%s"}]`, username, username)))
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/users/", func(w http.ResponseWriter, r *http.Request) {
+		testOpenIDUsers.record(r.Method + " " + strings.TrimPrefix(r.URL.Path, "/admin/realms/fabconnect/users/"))
+		if r.Method == http.MethodDelete {
+			testOpenIDUsers.mux.Lock()
+			testOpenIDUsers.deleted = append(testOpenIDUsers.deleted, strings.TrimPrefix(r.URL.Path, "/admin/realms/fabconnect/users/"))
+			testOpenIDUsers.mux.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/roles/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/admin/realms/fabconnect/roles/")
+		if name == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"Could not find role"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"id":"%s-id","name":"%s"}`, name, name)))
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/group-by-path/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"group-id","name":"members","path":"/org1/members"}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func (k *testKeycloak) record(call string) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.calls = append(k.calls, call)
+}
+
+func (k *testKeycloak) reset() {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.created = map[string]string{}
+	k.deleted = nil
+	k.calls = nil
+}
+
 func setupConfigFile(filename, sourcedir, targetdir string) (string, error) {
 	configFile := path.Join(sourcedir, "../../../../test/fixture", filename)
 	content, err := ioutil.ReadFile(configFile)
@@ -134,7 +232,7 @@ func createMockChannelClient(channelProvider context.ChannelProvider) (*channel.
 	return &channel.Client{}, nil
 }
 
-func createMockGateway(configProvider core.ConfigProvider, signer string, txTimeout int) (*gateway.Gateway, error) {
+func createMockGateway(configProvider core.ConfigProvider, wallet *gateway.Wallet, signer string, txTimeout int) (*gateway.Gateway, error) {
 
 	return &gateway.Gateway{}, nil
 }
@@ -157,7 +255,7 @@ func TestCCPClientInstantiation(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -187,7 +285,7 @@ func TestGatewayClientInstantiation(t *testing.T) {
 		UseGatewayClient: true,
 		ConfigPath:       tmpShortCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -224,7 +322,7 @@ func TestGatewayClientSendTx(t *testing.T) {
 		UseGatewayClient: true,
 		ConfigPath:       tmpShortCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -260,7 +358,7 @@ func TestGatewayClientSendInitTx(t *testing.T) {
 		UseGatewayClient: true,
 		ConfigPath:       tmpShortCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -296,7 +394,7 @@ func TestChannelClientInstantiation(t *testing.T) {
 		UseGatewayClient: true,
 		ConfigPath:       tmpShortCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -328,7 +426,7 @@ func TestEventClientInstantiation(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -367,7 +465,7 @@ func TestLedgerClientInstantiation(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -397,7 +495,7 @@ func TestIdentityRegister(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -415,6 +513,13 @@ func TestIdentityRegister(t *testing.T) {
 	assert.Empty(restErr)
 	assert.Equal("user1", res.Name)
 	assert.Equal("mysecret", res.Secret)
+
+	// the attributes the gateway marks the identities with cannot be set
+	r = httptest.NewRequest(http.MethodPost, "/identities", strings.NewReader(`{"name":"user1","attributes":{"fabconnect.external":"true"}}`))
+	_, restErr = idclient.Register(w, r, httprouter.Params{})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "The attribute 'fabconnect.external' is reserved, the attributes prefixed with 'fabconnect.' are set by the gateway only")
+	mockCAClient.AssertNumberOfCalls(t, "Register", 1)
 }
 
 func TestIdentityModify(t *testing.T) {
@@ -423,7 +528,7 @@ func TestIdentityModify(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -440,6 +545,12 @@ func TestIdentityModify(t *testing.T) {
 	res, restErr := idclient.Modify(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user1"}})
 	assert.Empty(restErr)
 	assert.Equal("user1", res.Name)
+
+	r = httptest.NewRequest(http.MethodPut, "/identities/user1", strings.NewReader(`{"attributes":{"fabconnect.revoked":"false"}}`))
+	_, restErr = idclient.Modify(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user1"}})
+	assert.Equal(400, restErr.StatusCode)
+	assert.Regexp("The attribute 'fabconnect.revoked' is reserved", restErr.Error)
+	mockCAClient.AssertNumberOfCalls(t, "ModifyIdentity", 1)
 }
 
 func TestIdentityEnroll(t *testing.T) {
@@ -448,13 +559,14 @@ func TestIdentityEnroll(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
 
 	idcWrapper := idclient.(*idClientWrapper)
 	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user1", "").Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
 	mockCAClient.On("Enroll", mock.Anything).Return(nil)
 	idcWrapper.caClient = &mockCAClient
 
@@ -474,7 +586,7 @@ func TestIdentityReenroll(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -494,13 +606,266 @@ func TestIdentityReenroll(t *testing.T) {
 	assert.Equal(true, res.Success)
 }
 
+func newProvisioningIdClient(t *testing.T, provisioning conf.ProvisioningConf, mockCAClient *mockfabricdep.CAClient) identity.IdentityClient {
+	config := conf.RPCConf{
+		ConfigPath: tmpCCPFile,
+	}
+	openIDConf := testOpenIDConf
+	openIDConf.Provisioning = provisioning
+	_, idclient, err := RPCConnect(config, openIDConf, 5)
+	assert.NoError(t, err)
+	idclient.(*idClientWrapper).caClient = mockCAClient
+	testOpenIDUsers.reset()
+	return idclient
+}
+
+func TestIdentityEnrollProvisionsUser(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user3", "").Return(&mspApi.IdentityResponse{
+		ID:          "user3",
+		Type:        "client",
+		Affiliation: "org1",
+		Attributes:  []mspApi.Attribute{{Name: "email", Value: "user3@example.com"}},
+	}, nil)
+	mockCAClient.On("Enroll", mock.Anything).Return(nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{
+		Email:           "{{.Attributes.email}}",
+		FirstName:       "{{.Username}}",
+		Attributes:      map[string]string{"affiliation": "{{.Affiliation}}"},
+		Groups:          []string{"/org1/members"},
+		RealmRoles:      []string{"fabric-client"},
+		RequiredActions: []string{"UPDATE_PASSWORD"},
+		OneTimePassword: true,
+	}, &mockCAClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(`{"secret":"mysecret"}`))
+	res, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Empty(restErr)
+	assert.True(res.Success)
+	assert.Len(res.OneTimePassword, 22)
+
+	created := testOpenIDUsers.created["user3"]
+	assert.Contains(created, "email:user3@example.com")
+	assert.Contains(created, "firstName:user3")
+	assert.Contains(created, "affiliation:[org1]")
+	assert.Contains(created, "requiredActions:[UPDATE_PASSWORD]")
+	assert.Equal([]string{
+		"create user3",
+		"PUT user3-id/groups/group-id",
+		"POST user3-id/role-mappings/realm",
+		"PUT user3-id/reset-password",
+	}, testOpenIDUsers.calls)
+	assert.Empty(testOpenIDUsers.deleted)
+}
+
+func TestIdentityEnrollExistingUserNotProvisioned(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user2", "").Return(&mspApi.IdentityResponse{ID: "user2"}, nil)
+	mockCAClient.On("Enroll", mock.Anything).Return(nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{OneTimePassword: true}, &mockCAClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user2/enroll", strings.NewReader(`{"secret":"mysecret"}`))
+	res, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user2"}})
+	assert.Empty(restErr)
+	assert.Empty(res.OneTimePassword)
+	assert.Empty(testOpenIDUsers.calls)
+}
+
+func TestIdentityEnrollProvisioningFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user3", "").Return(&mspApi.IdentityResponse{ID: "user3"}, nil)
+	mockCAClient.On("Enroll", mock.Anything).Return(nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{RealmRoles: []string{"missing"}}, &mockCAClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(`{"secret":"mysecret"}`))
+	_, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Equal(500, restErr.StatusCode)
+	assert.Regexp("'user3' is enrolled with the Fabric CA, but its IdP user could not be provisioned: Failed to look up the realm role missing for the IdP user 'user3'", restErr.Error)
+	// the provisioner deletes the user it created
+	assert.Equal([]string{"user3-id"}, testOpenIDUsers.deleted)
+	mockCAClient.AssertCalled(t, "Enroll", mock.Anything)
+}
+
+func TestIdentityEnrollFailureNotProvisioned(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("Enroll", mock.Anything).Return(fmt.Errorf("bang"))
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(`{"secret":"mysecret"}`))
+	_, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.EqualError(restErr.Error, "bang")
+	assert.Empty(testOpenIDUsers.calls)
+	mockCAClient.AssertNotCalled(t, "GetIdentity", mock.Anything, mock.Anything)
+}
+
+func TestIdentityEnrollCAIdentityNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user3", "").Return(nil, fmt.Errorf("not found"))
+	mockCAClient.On("Enroll", mock.Anything).Return(nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(`{"secret":"mysecret"}`))
+	_, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.EqualError(restErr.Error, "'user3' is enrolled with the Fabric CA, but its IdP user could not be provisioned: not found")
+	assert.Empty(testOpenIDUsers.calls)
+}
+
+func TestIdentityEnrollCSR(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user3", "").Return(&mspApi.IdentityResponse{ID: "user3"}, nil)
+	mockCAClient.On("ModifyIdentity", &mspApi.IdentityRequest{
+		ID:         "user3",
+		Attributes: []mspApi.Attribute{{Name: "fabconnect.external", Value: "true"}},
+	}).Return(&mspApi.IdentityResponse{ID: "user3"}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	store := newTestSignerStore()
+	store.certs[msp.IdentityIdentifier{MSPID: "org1MSP", ID: "user3"}] = []byte("old cert")
+	idclient.(*idClientWrapper).store = store
+	csr := newTestCSR(t, "user3")
+	mockEnroller := mockfabricdep.CSREnroller{}
+	mockEnroller.On("EnrollCSR", &dep.CSREnrollmentRequest{
+		Name:     "user3",
+		Secret:   "mysecret",
+		CSR:      csr,
+		AttrReqs: []*mspApi.AttributeRequest{{Name: "email", Optional: true}},
+	}).Return(&dep.CSREnrollmentResponse{Cert: []byte("cert"), CAChain: []byte("chain")}, nil)
+	idclient.(*idClientWrapper).csrEnroller = &mockEnroller
+	listener := &testSignerListener{}
+	idclient.(*idClientWrapper).AddSignerUpdateListener(listener)
+
+	body, _ := json.Marshal(map[string]interface{}{"secret": "mysecret", "csr": string(csr), "attributes": map[string]bool{"email": true}})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(string(body)))
+	res, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Empty(restErr)
+	assert.Equal(&identity.IdentityResponse{Name: "user3", Success: true, Certificate: "cert", CAChain: "chain", External: true}, res)
+	assert.Empty(store.certs)
+	assert.Equal([]string{"user3"}, listener.updated)
+	mockCAClient.AssertExpectations(t)
+	mockEnroller.AssertExpectations(t)
+}
+
+func TestIdentityEnrollCSRFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user3", "").Return(&mspApi.IdentityResponse{ID: "user3"}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	mockEnroller := mockfabricdep.CSREnroller{}
+	mockEnroller.On("EnrollCSR", mock.Anything).Return(nil, fmt.Errorf("bang"))
+	idclient.(*idClientWrapper).csrEnroller = &mockEnroller
+
+	body, _ := json.Marshal(map[string]string{"secret": "mysecret", "csr": string(newTestCSR(t, "user4"))})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(string(body)))
+	_, restErr := idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "The common name 'user4' of the certificate signing request must be the enrollment ID 'user3'")
+	mockEnroller.AssertNotCalled(t, "EnrollCSR", mock.Anything)
+
+	body, _ = json.Marshal(map[string]string{"secret": "mysecret", "csr": string(newTestCSR(t, "user3"))})
+	r = httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(string(body)))
+	_, restErr = idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.EqualError(restErr.Error, "bang")
+	assert.Empty(testOpenIDUsers.calls)
+
+	// the identity cannot be marked as external
+	testOpenIDUsers.reset()
+	mockEnroller.ExpectedCalls = nil
+	mockEnroller.On("EnrollCSR", mock.Anything).Return(&dep.CSREnrollmentResponse{Cert: []byte("cert")}, nil)
+	mockCAClient.On("ModifyIdentity", mock.Anything).Return(nil, fmt.Errorf("pop"))
+	store := newTestSignerStore()
+	store.certs[msp.IdentityIdentifier{MSPID: "org1MSP", ID: "user3"}] = []byte("old cert")
+	idclient.(*idClientWrapper).store = store
+	r = httptest.NewRequest(http.MethodPost, "/identities/user3/enroll", strings.NewReader(string(body)))
+	_, restErr = idclient.Enroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Equal(500, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Failed to mark 'user3' as an external identity, enroll it again: pop")
+	assert.Empty(testOpenIDUsers.calls)
+	assert.Empty(store.certs)
+}
+
+func TestGetSigningIdentityExternal(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user3", "").Return(&mspApi.IdentityResponse{
+		ID:         "user3",
+		Attributes: []mspApi.Attribute{{Name: "fabconnect.external", Value: "true"}},
+	}, nil)
+	mockCAClient.On("GetIdentity", "user4", "").Return(&mspApi.IdentityResponse{ID: "user4"}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	w := idclient.(*idClientWrapper)
+	w.identityMgr = &testIdentityManager{identities: map[string]msp.SigningIdentity{}}
+
+	_, err := w.GetSigningIdentity("user3")
+	assert.EqualError(err, "'user3' is an external identity, its transactions must be signed by the client")
+
+	_, err = w.GetSigningIdentity("user4")
+	assert.Equal(msp.ErrUserNotFound, err)
+}
+
+func TestIdentityReenrollNoProvisioning(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("Reenroll", mock.Anything).Return(nil).Once()
+	mockCAClient.On("Reenroll", mock.Anything).Return(fmt.Errorf("bang"))
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	mockProvisioner := mockopenid.IdPProvisioner{}
+	idclient.(*idClientWrapper).provisioner = &mockProvisioner
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user3/reenroll", strings.NewReader(`{"caname":"ca1"}`))
+	res, restErr := idclient.Reenroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Nil(restErr)
+	assert.Empty(res.OneTimePassword)
+
+	r = httptest.NewRequest(http.MethodPost, "/identities/user3/reenroll", strings.NewReader(`{"caname":"ca1"}`))
+	_, restErr = idclient.Reenroll(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.EqualError(restErr.Error, "bang")
+	mockProvisioner.AssertNotCalled(t, "ProvisionUser", mock.Anything)
+	mockCAClient.AssertNotCalled(t, "GetIdentity", mock.Anything, mock.Anything)
+}
+
+func TestIdentityClientUnknownProvider(t *testing.T) {
+	openIDConf := testOpenIDConf
+	openIDConf.Provisioning = conf.ProvisioningConf{Provider: "ldap"}
+	_, _, err := RPCConnect(conf.RPCConf{ConfigPath: tmpCCPFile}, openIDConf, 5)
+	assert.Regexp(t, "Unknown IdP provisioning provider 'ldap'", err)
+}
+
+func TestIdentityClientBadProvisioningTemplate(t *testing.T) {
+	openIDConf := testOpenIDConf
+	openIDConf.Provisioning = conf.ProvisioningConf{Email: "{{.Username"}
+	_, _, err := RPCConnect(conf.RPCConf{ConfigPath: tmpCCPFile}, openIDConf, 5)
+	assert.Regexp(t, "Invalid provisioning template for email", err)
+}
+
 func TestIdentityRevoke(t *testing.T) {
 	assert := assert.New(t)
 
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
@@ -516,7 +881,12 @@ func TestIdentityRevoke(t *testing.T) {
 			},
 		},
 	}, nil)
+	mockCAClient.On("ModifyIdentity", &mspApi.IdentityRequest{
+		ID:         "user1",
+		Attributes: []mspApi.Attribute{{Name: "fabconnect.revoked", Value: "true"}},
+	}).Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
 	idcWrapper.caClient = &mockCAClient
+	testOpenIDUsers.reset()
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodPost, "/identities/user1/revoke", strings.NewReader(`{"reason":"getting old"}`))
@@ -528,6 +898,485 @@ func TestIdentityRevoke(t *testing.T) {
 	assert.Equal(1, len(res.RevokedCerts))
 	assert.Equal("some-serial", res.RevokedCerts[0]["serial"])
 	assert.Equal("some-aki", res.RevokedCerts[0]["aki"])
+	assert.True(res.IdPUserDisabled)
+	assert.Equal([]string{"PUT user1-id"}, testOpenIDUsers.calls)
+	mockCAClient.AssertExpectations(t)
+}
+
+func TestIdentityRevokeIdPFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("Revoke", mock.Anything).Return(&mspApi.RevocationResponse{}, nil)
+	mockCAClient.On("ModifyIdentity", mock.Anything).Return(nil, fmt.Errorf("not allowed"))
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	mockProvisioner := mockopenid.IdPProvisioner{}
+	mockProvisioner.On("GetUser", "user1").Return(&openid.IdPUser{ID: "id1", Username: "user1", Enabled: true}, nil)
+	mockProvisioner.On("DisableUser", "id1").Return(fmt.Errorf("pop"))
+	idclient.(*idClientWrapper).provisioner = &mockProvisioner
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/identities/user1/revoke", strings.NewReader(`{}`))
+	res, restErr := idclient.Revoke(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user1"}})
+	assert.Empty(restErr)
+	assert.False(res.IdPUserDisabled)
+	mockProvisioner.AssertExpectations(t)
+}
+
+func TestIdentityRemove(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("RemoveIdentity", &mspApi.RemoveIdentityRequest{ID: "user1", CAName: "ca1"}).Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/identities/user1?caname=ca1", nil)
+	res, restErr := idclient.Remove(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user1"}})
+	assert.Empty(restErr)
+	assert.Equal(&identity.RemoveResponse{Name: "user1", IdPUserRemoved: true}, res)
+	assert.Equal([]string{"user1-id"}, testOpenIDUsers.deleted)
+
+	// no IdP user
+	testOpenIDUsers.reset()
+	mockCAClient.On("RemoveIdentity", &mspApi.RemoveIdentityRequest{ID: "user3"}).Return(&mspApi.IdentityResponse{ID: "user3"}, nil)
+	r = httptest.NewRequest(http.MethodDelete, "/identities/user3", nil)
+	res, restErr = idclient.Remove(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user3"}})
+	assert.Empty(restErr)
+	assert.False(res.IdPUserRemoved)
+	assert.Empty(testOpenIDUsers.deleted)
+
+	mockCAClient.On("RemoveIdentity", &mspApi.RemoveIdentityRequest{ID: "admin", Force: true}).Return(&mspApi.IdentityResponse{ID: "admin"}, nil)
+	r = httptest.NewRequest(http.MethodDelete, "/identities/admin?force=true", nil)
+	_, restErr = idclient.Remove(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "admin"}})
+	assert.Empty(restErr)
+	mockCAClient.AssertExpectations(t)
+}
+
+func TestIdentityRemoveFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("RemoveIdentity", &mspApi.RemoveIdentityRequest{ID: "user1"}).Return(nil, fmt.Errorf("Identity removal is disabled"))
+	mockCAClient.On("RemoveIdentity", &mspApi.RemoveIdentityRequest{ID: "user2"}).Return(&mspApi.IdentityResponse{ID: "user2"}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	mockProvisioner := mockopenid.IdPProvisioner{}
+	mockProvisioner.On("GetUser", "user2").Return(&openid.IdPUser{ID: "id2", Username: "user2"}, nil)
+	mockProvisioner.On("DeleteUser", "id2").Return(fmt.Errorf("pop"))
+	idclient.(*idClientWrapper).provisioner = &mockProvisioner
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/identities/user1", nil)
+	_, restErr := idclient.Remove(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user1"}})
+	assert.EqualError(restErr.Error, "Identity removal is disabled")
+	mockProvisioner.AssertNotCalled(t, "GetUser", "user1")
+
+	r = httptest.NewRequest(http.MethodDelete, "/identities/user2", nil)
+	res, restErr := idclient.Remove(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "user2"}})
+	assert.Empty(restErr)
+	assert.False(res.IdPUserRemoved)
+}
+
+type testSignerListener struct {
+	updated []string
+}
+
+func (l *testSignerListener) SignerUpdated(signer string) {
+	l.updated = append(l.updated, signer)
+}
+
+func TestAffiliations(t *testing.T) {
+	assert := assert.New(t)
+
+	tree := &mspApi.AffiliationResponse{
+		AffiliationInfo: mspApi.AffiliationInfo{
+			Name: "org1",
+			Affiliations: []mspApi.AffiliationInfo{
+				{
+					Name:       "org1.department1",
+					Identities: []mspApi.IdentityInfo{{ID: "user1", Type: "client", Affiliation: "org1.department1", Attributes: []mspApi.Attribute{{Name: "email", Value: "user1@example.com"}}}},
+				},
+			},
+			Identities: []mspApi.IdentityInfo{{ID: "user2", Type: "client", Affiliation: "org1", MaxEnrollments: -1}},
+		},
+		CAName: "ca1",
+	}
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetAllAffiliations", "ca1").Return(tree, nil)
+	mockCAClient.On("GetAffiliation", "org1", "").Return(tree, nil)
+	mockCAClient.On("AddAffiliation", &mspApi.AffiliationRequest{Name: "org2.department1", Force: true}).Return(&mspApi.AffiliationResponse{AffiliationInfo: mspApi.AffiliationInfo{Name: "org2.department1"}}, nil)
+	mockCAClient.On("ModifyAffiliation", &mspApi.ModifyAffiliationRequest{
+		AffiliationRequest: mspApi.AffiliationRequest{Name: "org2", CAName: "ca1"},
+		NewName:            "org3",
+	}).Return(&mspApi.AffiliationResponse{AffiliationInfo: mspApi.AffiliationInfo{Name: "org3"}, CAName: "ca1"}, nil)
+	mockCAClient.On("RemoveAffiliation", &mspApi.AffiliationRequest{Name: "org1", Force: true}).Return(tree, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	listener := &testSignerListener{}
+	idclient.(*idClientWrapper).AddSignerUpdateListener(listener)
+
+	expected := &identity.Affiliation{
+		Name: "org1",
+		Affiliations: []*identity.Affiliation{
+			{
+				Name:       "org1.department1",
+				Identities: []*identity.Identity{{RegisterResponse: identity.RegisterResponse{Name: "user1"}, Type: "client", Affiliation: "org1.department1", Attributes: map[string]string{"email": "user1@example.com"}}},
+			},
+		},
+		Identities: []*identity.Identity{{RegisterResponse: identity.RegisterResponse{Name: "user2"}, Type: "client", Affiliation: "org1", MaxEnrollments: -1}},
+		CAName:     "ca1",
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/affiliations?caname=ca1", nil)
+	res, restErr := idclient.ListAffiliations(w, r, httprouter.Params{})
+	assert.Empty(restErr)
+	assert.Equal(expected, res)
+
+	r = httptest.NewRequest(http.MethodGet, "/affiliations/org1", nil)
+	res, restErr = idclient.GetAffiliation(w, r, httprouter.Params{httprouter.Param{Key: "affiliation", Value: "org1"}})
+	assert.Empty(restErr)
+	assert.Equal(expected, res)
+
+	r = httptest.NewRequest(http.MethodPost, "/affiliations", strings.NewReader(`{"name":"org2.department1","force":true}`))
+	res, restErr = idclient.AddAffiliation(w, r, httprouter.Params{})
+	assert.Empty(restErr)
+	assert.Equal(&identity.Affiliation{Name: "org2.department1"}, res)
+
+	r = httptest.NewRequest(http.MethodPut, "/affiliations/org2", strings.NewReader(`{"name":"org3","caname":"ca1"}`))
+	res, restErr = idclient.ModifyAffiliation(w, r, httprouter.Params{httprouter.Param{Key: "affiliation", Value: "org2"}})
+	assert.Empty(restErr)
+	assert.Equal(&identity.Affiliation{Name: "org3", CAName: "ca1"}, res)
+
+	r = httptest.NewRequest(http.MethodDelete, "/affiliations/org1?force=true", nil)
+	res, restErr = idclient.RemoveAffiliation(w, r, httprouter.Params{httprouter.Param{Key: "affiliation", Value: "org1"}})
+	assert.Empty(restErr)
+	assert.Equal(expected, res)
+	assert.ElementsMatch([]string{"user1", "user2"}, listener.updated)
+	mockCAClient.AssertExpectations(t)
+}
+
+func TestAffiliationsFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetAllAffiliations", "").Return(nil, fmt.Errorf("Authorization failure"))
+	mockCAClient.On("GetAffiliation", "org9", "").Return(nil, fmt.Errorf("Affiliation 'org9' not found"))
+	mockCAClient.On("AddAffiliation", mock.Anything).Return(nil, fmt.Errorf("Affiliation already exists"))
+	mockCAClient.On("ModifyAffiliation", mock.Anything).Return(nil, fmt.Errorf("Affiliation 'org9' not found"))
+	mockCAClient.On("RemoveAffiliation", mock.Anything).Return(nil, fmt.Errorf("Affiliation removal is disabled"))
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	params := httprouter.Params{httprouter.Param{Key: "affiliation", Value: "org9"}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/affiliations", nil)
+	_, restErr := idclient.ListAffiliations(w, r, httprouter.Params{})
+	assert.EqualError(restErr.Error, "Authorization failure")
+
+	r = httptest.NewRequest(http.MethodGet, "/affiliations/org9", nil)
+	_, restErr = idclient.GetAffiliation(w, r, params)
+	assert.EqualError(restErr.Error, "Affiliation 'org9' not found")
+
+	r = httptest.NewRequest(http.MethodPost, "/affiliations", strings.NewReader(`{"name":"org1"}`))
+	_, restErr = idclient.AddAffiliation(w, r, httprouter.Params{})
+	assert.EqualError(restErr.Error, "Affiliation already exists")
+
+	r = httptest.NewRequest(http.MethodPost, "/affiliations", strings.NewReader(`{"force":true}`))
+	_, restErr = idclient.AddAffiliation(w, r, httprouter.Params{})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, `missing required parameter "name"`)
+
+	r = httptest.NewRequest(http.MethodPost, "/affiliations", strings.NewReader(`{"name":"org1","bad":true}`))
+	_, restErr = idclient.AddAffiliation(w, r, httprouter.Params{})
+	assert.Equal(400, restErr.StatusCode)
+
+	r = httptest.NewRequest(http.MethodPut, "/affiliations/org9", strings.NewReader(`{"name":"org10"}`))
+	_, restErr = idclient.ModifyAffiliation(w, r, params)
+	assert.EqualError(restErr.Error, "Affiliation 'org9' not found")
+
+	r = httptest.NewRequest(http.MethodPut, "/affiliations/org9", strings.NewReader(`{}`))
+	_, restErr = idclient.ModifyAffiliation(w, r, params)
+	assert.Equal(400, restErr.StatusCode)
+
+	r = httptest.NewRequest(http.MethodPut, "/affiliations/org9", strings.NewReader(`{`))
+	_, restErr = idclient.ModifyAffiliation(w, r, params)
+	assert.Equal(400, restErr.StatusCode)
+
+	r = httptest.NewRequest(http.MethodDelete, "/affiliations/org9", nil)
+	_, restErr = idclient.RemoveAffiliation(w, r, params)
+	assert.EqualError(restErr.Error, "Affiliation removal is disabled")
+}
+
+func TestIdentityReconcile(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetAllIdentities", "").Return([]*mspApi.IdentityResponse{
+		{ID: "admin", Type: "client"},
+		{ID: "alice", Type: "client"},
+		{ID: "bob", Type: "client", Attributes: []mspApi.Attribute{{Name: "fabconnect.revoked", Value: "true"}}},
+		{ID: "carol", Type: "client"},
+		{ID: "frank", Type: "client"},
+		{ID: "grace", Type: "client"},
+		{ID: "heidi", Type: "client"},
+		{ID: "peer0", Type: "peer"},
+	}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{
+		Reconcile: conf.ReconcileConf{Ignore: []string{"admin", "service-account-*"}},
+	}, &mockCAClient)
+	// frank was revoked with the fabric-ca-client, grace was enrolled again after a revocation,
+	// and heidi is not enrolled yet
+	idclient.(*idClientWrapper).certAuditor = newTestRevocationAuditor(t, []string{"frank", "grace"}, []string{"alice", "grace"})
+	mockProvisioner := mockopenid.IdPProvisioner{}
+	mockProvisioner.On("ListUsers").Return([]*openid.IdPUser{
+		{ID: "id-alice", Username: "alice", Enabled: true},
+		{ID: "id-bob", Username: "bob", Enabled: true},
+		{ID: "id-dave", Username: "dave", Enabled: true},
+		{ID: "id-erin", Username: "erin", Enabled: false},
+		{ID: "id-frank", Username: "frank", Enabled: true},
+		{ID: "id-grace", Username: "grace", Enabled: true},
+		{ID: "id-heidi", Username: "heidi", Enabled: true},
+		{ID: "id-sa", Username: "service-account-fabconnect", Enabled: true},
+	}, nil)
+	mockProvisioner.On("DisableUser", "id-bob").Return(nil)
+	mockProvisioner.On("DisableUser", "id-dave").Return(fmt.Errorf("pop"))
+	mockProvisioner.On("DisableUser", "id-frank").Return(nil)
+	idclient.(*idClientWrapper).provisioner = &mockProvisioner
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/idp/reconcile", nil)
+	report, restErr := idclient.Reconcile(w, r, nil)
+	assert.Empty(restErr)
+	assert.Equal(&identity.ReconcileReport{
+		Identities: 8,
+		IdPUsers:   8,
+		Drifts: []*identity.Drift{
+			{Username: "bob", Kind: identity.DriftRevokedIdentityEnabled, IdPUserID: "id-bob"},
+			{Username: "carol", Kind: identity.DriftIdentityWithoutIdPUser},
+			{Username: "dave", Kind: identity.DriftIdPUserWithoutIdentity, IdPUserID: "id-dave"},
+			{Username: "frank", Kind: identity.DriftRevokedIdentityEnabled, IdPUserID: "id-frank"},
+		},
+	}, report)
+	mockProvisioner.AssertNotCalled(t, "DisableUser", mock.Anything)
+
+	r = httptest.NewRequest(http.MethodPost, "/idp/reconcile?fix=true", nil)
+	report, restErr = idclient.Reconcile(w, r, nil)
+	assert.Empty(restErr)
+	assert.True(report.Drifts[0].Fixed)
+	assert.False(report.Drifts[1].Fixed)
+	assert.False(report.Drifts[2].Fixed)
+	assert.Equal("pop", report.Drifts[2].Error)
+	assert.True(report.Drifts[3].Fixed)
+}
+
+// newTestRevocationAuditor serves certificates of the enrollment IDs that are revoked and not
+func newTestRevocationAuditor(t *testing.T, revoked, unrevoked []string) *mockfabricdep.CertificateAuditor {
+	auditor := &mockfabricdep.CertificateAuditor{}
+	for _, certs := range []struct {
+		revoked bool
+		ids     []string
+	}{{true, revoked}, {false, unrevoked}} {
+		result := &dep.CertificatesResponse{}
+		for _, id := range certs.ids {
+			_, certPEM := newTestSignerKey(t, id)
+			result.Certs = append(result.Certs, certPEM)
+		}
+		revokedCerts := certs.revoked
+		auditor.On("GetCertificates", mock.MatchedBy(func(req *dep.CertificatesRequest) bool {
+			return req.Revoked != nil && *req.Revoked == revokedCerts
+		})).Return(result, nil)
+	}
+	return auditor
+}
+
+func TestIdentityReconcileCAs(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	ca1 := []*mspApi.IdentityResponse{
+		{ID: "alice", Type: "client"},
+		{ID: "bob", Type: "client", Attributes: []mspApi.Attribute{{Name: "fabconnect.revoked", Value: "true"}}},
+	}
+	mockCAClient.On("GetAllIdentities", "").Return(ca1, nil)
+	mockCAClient.On("GetAllIdentities", "ca1").Return(ca1, nil)
+	mockCAClient.On("GetAllIdentities", "ca2").Return([]*mspApi.IdentityResponse{
+		{ID: "bob", Type: "client"},
+		{ID: "carol", Type: "client"},
+		{ID: "dave", Type: "client", Attributes: []mspApi.Attribute{{Name: "fabconnect.revoked", Value: "true"}}},
+	}, nil)
+	mockCAClient.On("GetAllIdentities", "ca3").Return(nil, fmt.Errorf("bang"))
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	w := idclient.(*idClientWrapper)
+	w.caNames = []string{"ca1", "ca2"}
+	w.certAuditor = newTestRevocationAuditor(t, nil, nil)
+	mockProvisioner := mockopenid.IdPProvisioner{}
+	mockProvisioner.On("ListUsers").Return([]*openid.IdPUser{
+		{ID: "id-alice", Username: "alice", Enabled: true},
+		{ID: "id-bob", Username: "bob", Enabled: true},
+		{ID: "id-carol", Username: "carol", Enabled: true},
+		{ID: "id-dave", Username: "dave", Enabled: true},
+	}, nil)
+	w.provisioner = &mockProvisioner
+
+	// the identities of both CAs are reconciled, bob is not revoked in ca2
+	report, err := w.reconcile("", false)
+	assert.NoError(err)
+	assert.Equal(5, report.Identities)
+	assert.Equal([]*identity.Drift{
+		{Username: "dave", Kind: identity.DriftRevokedIdentityEnabled, IdPUserID: "id-dave"},
+	}, report.Drifts)
+
+	// only the drifts of the identities of ca1 are reported, the users of ca2 are known
+	report, err = w.reconcile("ca1", false)
+	assert.NoError(err)
+	assert.Equal(2, report.Identities)
+	assert.Empty(report.Drifts)
+
+	w.caNames = []string{"ca1", "ca2", "ca3"}
+	_, err = w.reconcile("ca2", false)
+	assert.EqualError(err, "bang")
+}
+
+func TestIdentityReconcileFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetAllIdentities", "ca1").Return(nil, fmt.Errorf("bang"))
+	mockCAClient.On("GetAllIdentities", "").Return([]*mspApi.IdentityResponse{}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	mockProvisioner := mockopenid.IdPProvisioner{}
+	mockProvisioner.On("ListUsers").Return(nil, fmt.Errorf("pop"))
+	idclient.(*idClientWrapper).provisioner = &mockProvisioner
+	mockAuditor := &mockfabricdep.CertificateAuditor{}
+	mockAuditor.On("GetCertificates", mock.Anything).Return(nil, fmt.Errorf("boom")).Once()
+	idclient.(*idClientWrapper).certAuditor = mockAuditor
+
+	w := httptest.NewRecorder()
+	_, restErr := idclient.Reconcile(w, httptest.NewRequest(http.MethodPost, "/idp/reconcile?caname=ca1", nil), nil)
+	assert.EqualError(restErr.Error, "bang")
+	_, restErr = idclient.Reconcile(w, httptest.NewRequest(http.MethodPost, "/idp/reconcile", nil), nil)
+	assert.EqualError(restErr.Error, "boom")
+	mockAuditor.On("GetCertificates", mock.Anything).Return(&dep.CertificatesResponse{}, nil)
+	_, restErr = idclient.Reconcile(w, httptest.NewRequest(http.MethodPost, "/idp/reconcile", nil), nil)
+	assert.EqualError(restErr.Error, "pop")
+
+	idclient.(*idClientWrapper).provisioner = nil
+	_, restErr = idclient.Reconcile(w, httptest.NewRequest(http.MethodPost, "/idp/reconcile", nil), nil)
+	assert.Equal(405, restErr.StatusCode)
+}
+
+func TestIdentityReconcileLoop(t *testing.T) {
+	mockCAClient := mockfabricdep.CAClient{}
+	reconciled := make(chan bool, 1)
+	mockCAClient.On("GetAllIdentities", "").Return([]*mspApi.IdentityResponse{}, nil).Run(func(args mock.Arguments) {
+		select {
+		case reconciled <- true:
+		default:
+		}
+	})
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	w := idclient.(*idClientWrapper)
+	w.certAuditor = newTestRevocationAuditor(t, nil, nil)
+	w.reconcileConf.Interval = 1
+	w.startReconciler()
+	<-reconciled
+	w.Close()
+	assert.Nil(t, w.reconciler)
+	// closing again is a no-op
+	w.Close()
+}
+
+type testSigningIdentity struct {
+	msp.SigningIdentity
+	id   *msp.IdentityIdentifier
+	cert []byte
+}
+
+func (s *testSigningIdentity) Identifier() *msp.IdentityIdentifier {
+	return s.id
+}
+
+func (s *testSigningIdentity) EnrollmentCertificate() []byte {
+	return s.cert
+}
+
+// testIdentityManager serves the signing identities of the test, as if they were enrolled
+type testIdentityManager struct {
+	msp.IdentityManager
+	identities map[string]msp.SigningIdentity
+}
+
+func (m *testIdentityManager) GetSigningIdentity(name string) (msp.SigningIdentity, error) {
+	if si, ok := m.identities[name]; ok {
+		return si, nil
+	}
+	return nil, msp.ErrUserNotFound
+}
+
+func TestIdentityGetCurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	notAfter := testCertNotBefore.AddDate(1, 0, 0)
+	certPEM := newTestCertificate(t, "user1", notAfter, map[string]string{"hf.EnrollmentID": "user1", "hf.Type": "client"})
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user1", "").Return(&mspApi.IdentityResponse{ID: "user1", Type: "client", Affiliation: "org1"}, nil)
+	mockCAClient.On("GetIdentity", "user3", "ca1").Return(&mspApi.IdentityResponse{ID: "user3", Type: "client", CAName: "ca1"}, nil)
+	mockCAClient.On("GetCAInfo").Return(&mspApi.GetCAInfoResponse{CAChain: []byte("ca-chain")}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	idclient.(*idClientWrapper).identityMgr = &testIdentityManager{identities: map[string]msp.SigningIdentity{
+		"user1": &testSigningIdentity{id: &msp.IdentityIdentifier{MSPID: "Org1MSP", ID: "user1"}, cert: certPEM},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/identities/me", nil)
+	r = r.WithContext(gocontext.WithValue(r.Context(), auth.ContextKeyUsername, "user1"))
+	res, restErr := idclient.GetCurrent(w, r, httprouter.Params{httprouter.Param{Key: "username", Value: "me"}})
+	assert.Empty(restErr)
+	assert.Equal("user1", res.Name)
+	assert.Equal("user1", res.Username)
+	assert.Equal("Org1MSP", res.MSPID)
+	assert.Equal("org1", res.Affiliation)
+	assert.Equal([]byte("ca-chain"), res.CACert)
+	assert.Equal("1a2b3c", res.Certificate.Serial)
+	assert.Equal(notAfter, res.Certificate.NotAfter)
+	assert.Equal(notAfter, *res.CertExpiry)
+	assert.Equal(map[string]string{"hf.EnrollmentID": "user1", "hf.Type": "client"}, res.Certificate.Attributes)
+
+	// without authentication the requested signer is used, which is not enrolled here
+	r = httptest.NewRequest(http.MethodGet, "/identities/me?fly-signer=user3&caname=ca1", nil)
+	res, restErr = idclient.GetCurrent(w, r, httprouter.Params{})
+	assert.Empty(restErr)
+	assert.Equal("user3", res.Name)
+	assert.Empty(res.Username)
+	assert.Nil(res.Certificate)
+	assert.Nil(res.CertExpiry)
+}
+
+func TestIdentityGetCurrentFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetIdentity", "user1", "").Return(&mspApi.IdentityResponse{ID: "user1"}, nil)
+	mockCAClient.On("GetIdentity", "user2", "").Return(nil, fmt.Errorf("Identity 'user2' not found"))
+	mockCAClient.On("GetCAInfo").Return(&mspApi.GetCAInfoResponse{}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	idclient.(*idClientWrapper).identityMgr = &testIdentityManager{identities: map[string]msp.SigningIdentity{
+		"user1": &testSigningIdentity{id: &msp.IdentityIdentifier{MSPID: "Org1MSP", ID: "user1"}, cert: []byte("bad cert")},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/identities/me", nil)
+	_, restErr := idclient.GetCurrent(w, r, httprouter.Params{})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Must specify the signer")
+
+	r = httptest.NewRequest(http.MethodGet, "/identities/me?fly-signer=user2", nil)
+	_, restErr = idclient.GetCurrent(w, r, httprouter.Params{})
+	assert.Equal(500, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Identity 'user2' not found")
+
+	r = httptest.NewRequest(http.MethodGet, "/identities/me?fly-signer=user1", nil)
+	_, restErr = idclient.GetCurrent(w, r, httprouter.Params{})
+	assert.Equal(500, restErr.StatusCode)
+	assert.Regexp("Failed to parse the enrollment certificate of 'user1'", restErr.Error)
 }
 
 func TestIdentityList(t *testing.T) {
@@ -536,7 +1385,7 @@ func TestIdentityList(t *testing.T) {
 	config := conf.RPCConf{
 		ConfigPath: tmpCCPFile,
 	}
-	rpc, idclient, err := RPCConnect(config, 5)
+	rpc, idclient, err := RPCConnect(config, testOpenIDConf, 5)
 	assert.NoError(err)
 	assert.NotNil(rpc)
 	assert.NotNil(idclient)
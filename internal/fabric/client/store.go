@@ -17,6 +17,10 @@
 package client
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
+
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
 	"github.com/hyperledger/fabric-sdk-go/pkg/fab/keyvaluestore"
@@ -24,7 +28,40 @@ import (
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 )
 
-func newUserstore(configProvider core.ConfigProvider) (msp.UserStore, error) {
+// signerStore is the credential store of the enrolled signers, which can also list them
+type signerStore interface {
+	msp.UserStore
+	// List returns the IDs of the signers of an MSP
+	List(mspID string) ([]string, error)
+	// Delete removes the certificate of a signer
+	Delete(key msp.IdentityIdentifier) error
+}
+
+// certUserStore lists the signers from the <user>@<mspid>-cert.pem files of the SDK store
+type certUserStore struct {
+	*mspImpl.CertFileUserStore
+	path string
+}
+
+func (s *certUserStore) List(mspID string) ([]string, error) {
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	suffix := "@" + mspID + "-cert.pem"
+	var ids []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), suffix) {
+			ids = append(ids, strings.TrimSuffix(f.Name(), suffix))
+		}
+	}
+	return ids, nil
+}
+
+func newUserstore(configProvider core.ConfigProvider) (signerStore, error) {
 	configBackend, _ := configProvider()
 	identityConfig, err := mspImpl.ConfigFromBackend(configBackend...)
 	if err != nil {
@@ -44,5 +81,5 @@ func newUserstore(configProvider core.ConfigProvider) (msp.UserStore, error) {
 	if err != nil {
 		return nil, errors.Errorf("User credentials store creation failed. %s", err)
 	}
-	return userStore, nil
+	return &certUserStore{CertFileUserStore: userStore, path: clientConfig.CredentialStore.Path}, nil
 }
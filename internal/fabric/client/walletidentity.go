@@ -0,0 +1,309 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config/cryptoutil"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/cryptosuite"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImportIdentity stores an identity enrolled outside the gateway, its private key goes into the
+// keystore and its certificate into the credential store, where the RPC clients load the signers from.
+// An imported identity signs for the gateway, so the admin role is required even when the identity
+// administration is otherwise open, and an identity of the same name is only replaced with overwrite
+func (w *idClientWrapper) ImportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) (_ *identity.WalletIdentity, restErr *restutil.RestError) {
+	impreq := identity.ImportRequest{}
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&impreq)
+	if err != nil {
+		return nil, restutil.NewRestError(fmt.Sprintf("failed to decode JSON payload: %s", err), 400)
+	}
+	if impreq.Name == "" {
+		return nil, restutil.NewRestError(`missing required parameter "name"`, 400)
+	}
+	op := w.admin.start(req, adminOpImport, impreq.Name, "")
+	defer func() { op.done(restErr) }()
+	if restErr = op.authorizeExplicit(); restErr != nil {
+		return nil, restErr
+	}
+	if impreq.Certificate == "" {
+		return nil, restutil.NewRestError(`missing required parameter "certificate"`, 400)
+	}
+	if impreq.PrivateKey == "" {
+		return nil, restutil.NewRestError(`missing required parameter "privateKey"`, 400)
+	}
+	if impreq.MSPID == "" {
+		impreq.MSPID = w.mspID
+	} else if !w.isWalletMSP(impreq.MSPID) {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportMSPUnknown, impreq.MSPID).Error(), 400)
+	}
+
+	certPEM := []byte(impreq.Certificate)
+	cert, err := parseCertificate(impreq.Name, certPEM)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	keyDER, err := parsePrivateKey(impreq.Name, []byte(impreq.PrivateKey), cert)
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	if restErr = w.verifyCertificate(impreq.Name, impreq.MSPID, cert); restErr != nil {
+		return nil, restErr
+	}
+	// the signers are looked up by name, so a name is unique across the MSPs of the wallet
+	overwrite, _ := strconv.ParseBool(req.URL.Query().Get("overwrite"))
+	existing, err := w.loadUser(impreq.Name)
+	if err == nil && !overwrite {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportIdentityExists, impreq.Name).Error(), 409)
+	}
+	if err != nil && err != msp.ErrUserNotFound {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportFailed, impreq.Name, err).Error(), 500)
+	}
+
+	if _, err := w.cryptoSuite.KeyImport(keyDER, cryptosuite.GetECDSAPrivateKeyImportOpts(false)); err != nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportFailed, impreq.Name, err).Error(), 500)
+	}
+	userData := &msp.UserData{
+		ID:                    impreq.Name,
+		MSPID:                 impreq.MSPID,
+		EnrollmentCertificate: certPEM,
+	}
+	if err := w.store.Store(userData); err != nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportFailed, impreq.Name, err).Error(), 500)
+	}
+	if existing != nil && existing.MSPID != impreq.MSPID {
+		if err := w.store.Delete(msp.IdentityIdentifier{ID: existing.ID, MSPID: existing.MSPID}); err != nil {
+			return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportFailed, impreq.Name, err).Error(), 500)
+		}
+	}
+	log.Infof("Imported identity %s of MSP %s", impreq.Name, impreq.MSPID)
+	// an imported identity may replace a signer the RPC clients have cached
+	w.notifySignerUpdate(impreq.Name)
+	w.notifySignerIdUpdate(impreq.Name, "")
+
+	return &identity.WalletIdentity{
+		Name:          impreq.Name,
+		MSPID:         impreq.MSPID,
+		Certificate:   impreq.Certificate,
+		CertExpiry:    &cert.NotAfter,
+		HasPrivateKey: true,
+	}, nil
+}
+
+// verifyCertificate checks the certificate of an imported identity chains to a CA of its MSP, the
+// peers would reject the signatures of any other. The CA chain of the client org is returned by its
+// CA, the root and intermediate certificates of the other MSPs are read from the channel configs
+func (w *idClientWrapper) verifyCertificate(username, mspID string, cert *x509.Certificate) *restutil.RestError {
+	caCerts, restErr := w.mspCACerts(mspID)
+	if restErr != nil {
+		return restErr
+	}
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		// the Fabric MSP does not check the extended key usages
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for block, rest := pem.Decode(caCerts); block != nil; block, rest = pem.Decode(rest) {
+		caCert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return restutil.NewRestError(errors.Errorf(errors.WalletImportCAChainFailed, mspID, err).Error(), 500)
+		}
+		if bytes.Equal(caCert.RawIssuer, caCert.RawSubject) {
+			opts.Roots.AddCert(caCert)
+		} else {
+			opts.Intermediates.AddCert(caCert)
+		}
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return restutil.NewRestError(errors.Errorf(errors.WalletImportCertNotIssued, username, mspID, err).Error(), 400)
+	}
+	return nil
+}
+
+// mspCACerts returns the PEM encoded CA certificates of an MSP of the wallet
+func (w *idClientWrapper) mspCACerts(mspID string) ([]byte, *restutil.RestError) {
+	if mspID == w.mspID {
+		caChain, err := w.getCACert()
+		if err != nil {
+			return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportCAChainFailed, mspID, err).Error(), 500)
+		}
+		return caChain, nil
+	}
+	if w.channelMSPs == nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportCAChainFailed, mspID, "no channel client").Error(), 500)
+	}
+	for _, channel := range w.channels {
+		mspConfigs, err := w.channelMSPs(channel)
+		if err != nil {
+			return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportCAChainFailed, mspID, err).Error(), 500)
+		}
+		for _, mspConfig := range mspConfigs {
+			// only the X.509 MSPs have CAs, the idemix MSPs have a different config
+			if mspConfig.Type != 0 {
+				continue
+			}
+			fabricConfig := &mspproto.FabricMSPConfig{}
+			if err := proto.Unmarshal(mspConfig.Config, fabricConfig); err != nil {
+				return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportCAChainFailed, mspID, err).Error(), 500)
+			}
+			if fabricConfig.Name == mspID {
+				return bytes.Join(append(fabricConfig.RootCerts, fabricConfig.IntermediateCerts...), nil), nil
+			}
+		}
+	}
+	return nil, restutil.NewRestError(errors.Errorf(errors.WalletImportMSPNotInChannels, mspID).Error(), 400)
+}
+
+// isWalletMSP returns whether the identities of an MSP can be imported, which are the MSPs of the
+// orgs of the connection profile
+func (w *idClientWrapper) isWalletMSP(mspID string) bool {
+	if mspID == w.mspID {
+		return true
+	}
+	for _, other := range w.otherMSPs {
+		if mspID == other {
+			return true
+		}
+	}
+	return false
+}
+
+// loadUser loads an identity of the wallet, of the client org or of another MSP
+func (w *idClientWrapper) loadUser(username string) (*msp.UserData, error) {
+	userData, err := w.store.Load(msp.IdentityIdentifier{ID: username, MSPID: w.mspID})
+	if err != msp.ErrUserNotFound {
+		return userData, err
+	}
+	return w.loadOtherMSPUser(username)
+}
+
+// loadOtherMSPUser loads an identity imported for the MSP of another org
+func (w *idClientWrapper) loadOtherMSPUser(username string) (*msp.UserData, error) {
+	for _, mspID := range w.otherMSPs {
+		userData, err := w.store.Load(msp.IdentityIdentifier{ID: username, MSPID: mspID})
+		if err != msp.ErrUserNotFound {
+			return userData, err
+		}
+	}
+	return nil, msp.ErrUserNotFound
+}
+
+// ExportIdentity returns the public material of an identity, the private key never leaves the keystore
+func (w *idClientWrapper) ExportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.WalletIdentity, *restutil.RestError) {
+	username := params.ByName("username")
+	result, restErr := w.walletIdentity(username)
+	if restErr != nil {
+		return nil, restErr
+	}
+	// the SDK doesn't save the CACert locally, we have to retrieve it from the Fabric CA server, or
+	// from the channel config for the other MSPs
+	if cacert, restErr := w.mspCACerts(result.MSPID); restErr == nil {
+		result.CACert = string(cacert)
+	}
+	return result, nil
+}
+
+// ListWallet returns the identities of the MSPs of the connection profile in the credential store,
+// without their certificates
+func (w *idClientWrapper) ListWallet(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*identity.WalletIdentity, *restutil.RestError) {
+	var ids []string
+	for _, mspID := range append([]string{w.mspID}, w.otherMSPs...) {
+		mspIDs, err := w.store.List(mspID)
+		if err != nil {
+			return nil, restutil.NewRestError(err.Error(), 500)
+		}
+		ids = append(ids, mspIDs...)
+	}
+	result := make([]*identity.WalletIdentity, 0, len(ids))
+	for _, id := range ids {
+		walletId, restErr := w.walletIdentity(id)
+		if restErr != nil {
+			log.Warnf("Failed to read identity %s from the credential store. %s", id, restErr.Error)
+			continue
+		}
+		walletId.Certificate = ""
+		result = append(result, walletId)
+	}
+	return result, nil
+}
+
+func (w *idClientWrapper) walletIdentity(username string) (*identity.WalletIdentity, *restutil.RestError) {
+	userData, err := w.loadUser(username)
+	if err == msp.ErrUserNotFound {
+		return nil, restutil.NewRestError(errors.Errorf(errors.WalletIdentityNotFound, username).Error(), 404)
+	}
+	if err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	result := &identity.WalletIdentity{
+		Name:        username,
+		MSPID:       userData.MSPID,
+		Certificate: string(userData.EnrollmentCertificate),
+	}
+	if cert, err := parseCertificate(username, userData.EnrollmentCertificate); err != nil {
+		log.Warnf("Failed to read the expiry of the enrollment certificate of %s. %s", username, err)
+	} else {
+		result.CertExpiry = &cert.NotAfter
+	}
+	if w.cryptoSuite != nil {
+		_, err := cryptoutil.GetPrivateKeyFromCert(userData.EnrollmentCertificate, w.cryptoSuite)
+		result.HasPrivateKey = err == nil
+	}
+	return result, nil
+}
+
+// parsePrivateKey decodes a PEM encoded ECDSA private key, which must be the key of the certificate
+func parsePrivateKey(username string, keyPEM []byte, cert *x509.Certificate) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.Errorf(errors.WalletImportKeyInvalid, username, "no PEM block found")
+	}
+	var key interface{}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, errors.Errorf(errors.WalletImportKeyInvalid, username, err)
+		}
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf(errors.WalletImportKeyInvalid, username, fmt.Sprintf("unsupported key type %T", key))
+	}
+	if !ecKey.PublicKey.Equal(cert.PublicKey) {
+		return nil, errors.Errorf(errors.WalletImportKeyMismatch, username)
+	}
+	return block.Bytes, nil
+}
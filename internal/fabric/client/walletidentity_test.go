@@ -0,0 +1,373 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/authtest"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
+	mockfabricdep "github.com/hyperledger/firefly-fabconnect/mocks/fabric/dep"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSignerIdListener struct {
+	updated []string
+}
+
+func (l *testSignerIdListener) SignerIdUpdated(signer string, signerId string) {
+	l.updated = append(l.updated, signer)
+}
+
+func importTestIdentity(idclient identity.IdentityClient, body map[string]string, query ...string) (*identity.WalletIdentity, *restutil.RestError) {
+	payload, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, "/wallet/identities?"+strings.Join(query, "&"), strings.NewReader(string(payload)))
+	return idclient.ImportIdentity(httptest.NewRecorder(), r, nil)
+}
+
+func newTestCA(t *testing.T) (*ecdsa.PrivateKey, *x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca.org1.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	return key, cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newTestIssuedKey(t *testing.T, caKey *ecdsa.PrivateKey, caCert *x509.Certificate, username string) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: username},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestImportIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+	caKey, caCert, caPEM := newTestCA(t)
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetCAInfo").Return(&mspApi.GetCAInfoResponse{CAChain: caPEM}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	listener := &testSignerListener{}
+	idlistener := &testSignerIdListener{}
+	idclient.(*idClientWrapper).AddSignerUpdateListener(listener)
+	idclient.(*idClientWrapper).AddSignerIdUpdateListener(idlistener)
+
+	key, certPEM := newTestIssuedKey(t, caKey, caCert, "imported1")
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	res, restErr := importTestIdentity(idclient, map[string]string{
+		"name":        "imported1",
+		"certificate": string(certPEM),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	})
+	assert.Nil(restErr)
+	assert.Equal("imported1", res.Name)
+	assert.Equal("org1MSP", res.MSPID)
+	assert.True(res.HasPrivateKey)
+	assert.NotNil(res.CertExpiry)
+	assert.Equal([]string{"imported1"}, listener.updated)
+	assert.Equal([]string{"imported1"}, idlistener.updated)
+
+	signer, err := idclient.(*idClientWrapper).GetSigningIdentity("imported1")
+	assert.NoError(err)
+	assert.Equal(certPEM, signer.EnrollmentCertificate())
+
+	// an identity of the same name is only replaced with overwrite
+	key, certPEM = newTestIssuedKey(t, caKey, caCert, "imported1")
+	keyDER, _ = x509.MarshalPKCS8PrivateKey(key)
+	body := map[string]string{
+		"name":        "imported1",
+		"certificate": string(certPEM),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+	_, restErr = importTestIdentity(idclient, body)
+	assert.Equal(409, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "The identity 'imported1' is already in the wallet, import it with overwrite=true to replace it")
+	_, restErr = importTestIdentity(idclient, body, "overwrite=true")
+	assert.Nil(restErr)
+	signer, err = idclient.(*idClientWrapper).GetSigningIdentity("imported1")
+	assert.NoError(err)
+	assert.Equal(certPEM, signer.EnrollmentCertificate())
+
+	// the SEC 1 keys of the fabric-ca-client are accepted too
+	key, certPEM = newTestIssuedKey(t, caKey, caCert, "imported2")
+	keyDER, _ = x509.MarshalECPrivateKey(key)
+	_, restErr = importTestIdentity(idclient, map[string]string{
+		"name":        "imported2",
+		"mspId":       "org1MSP",
+		"certificate": string(certPEM),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	})
+	assert.Nil(restErr)
+
+	exported, restErr := idclient.ExportIdentity(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/identities/imported2", nil), httprouter.Params{httprouter.Param{Key: "username", Value: "imported2"}})
+	assert.Nil(restErr)
+	assert.Equal(string(certPEM), exported.Certificate)
+	assert.Equal(string(caPEM), exported.CACert)
+	assert.True(exported.HasPrivateKey)
+
+	list, restErr := idclient.ListWallet(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/identities", nil), nil)
+	assert.Nil(restErr)
+	names := map[string]*identity.WalletIdentity{}
+	for _, id := range list {
+		names[id.Name] = id
+	}
+	assert.True(names["imported1"].HasPrivateKey)
+	assert.Empty(names["imported1"].Certificate)
+	assert.Contains(names, "imported2")
+}
+
+func newTestMSPConfig(t *testing.T, mspID string, rootCerts ...[]byte) *mspproto.MSPConfig {
+	config, err := proto.Marshal(&mspproto.FabricMSPConfig{Name: mspID, RootCerts: rootCerts})
+	assert.NoError(t, err)
+	return &mspproto.MSPConfig{Config: config}
+}
+
+func TestImportIdentityOtherMSP(t *testing.T) {
+	assert := assert.New(t)
+
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+	_, _, org1PEM := newTestCA(t)
+	org2Key, org2Cert, org2PEM := newTestCA(t)
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetCAInfo").Return(&mspApi.GetCAInfoResponse{CAChain: org1PEM}, nil)
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	var channels []string
+	idclient.(*idClientWrapper).channelMSPs = func(channelId string) ([]*mspproto.MSPConfig, error) {
+		channels = append(channels, channelId)
+		return []*mspproto.MSPConfig{
+			{Type: 1},
+			newTestMSPConfig(t, "org1MSP", org1PEM),
+			newTestMSPConfig(t, "org2MSP", org2PEM),
+		}, nil
+	}
+
+	key, certPEM := newTestIssuedKey(t, org2Key, org2Cert, "imported4")
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	body := map[string]string{
+		"name":        "imported4",
+		"mspId":       "org2MSP",
+		"certificate": string(certPEM),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+	res, restErr := importTestIdentity(idclient, body)
+	assert.Nil(restErr)
+	assert.Equal("org2MSP", res.MSPID)
+	assert.Equal([]string{"default-channel"}, channels)
+
+	// the identity manager of the client org does not load it, the wallet does
+	signer, err := idclient.(*idClientWrapper).GetSigningIdentity("imported4")
+	assert.NoError(err)
+	assert.Equal("org2MSP", signer.Identifier().MSPID)
+	assert.Equal(certPEM, signer.EnrollmentCertificate())
+
+	params := httprouter.Params{httprouter.Param{Key: "username", Value: "imported4"}}
+	exported, restErr := idclient.ExportIdentity(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/identities/imported4", nil), params)
+	assert.Nil(restErr)
+	assert.Equal("org2MSP", exported.MSPID)
+	assert.Equal(string(org2PEM), exported.CACert)
+	assert.True(exported.HasPrivateKey)
+
+	list, restErr := idclient.ListWallet(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/identities", nil), nil)
+	assert.Nil(restErr)
+	found := false
+	for _, id := range list {
+		if id.Name == "imported4" {
+			found = true
+			assert.Equal("org2MSP", id.MSPID)
+		}
+	}
+	assert.True(found)
+
+	otherKey, otherCertPEM := newTestSignerKey(t, "imported5")
+	otherKeyDER, _ := x509.MarshalPKCS8PrivateKey(otherKey)
+	_, restErr = importTestIdentity(idclient, map[string]string{
+		"name":        "imported5",
+		"mspId":       "org2MSP",
+		"certificate": string(otherCertPEM),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: otherKeyDER})),
+	})
+	assert.Equal(400, restErr.StatusCode)
+	assert.Regexp("The certificate of 'imported5' is not issued by a CA of the MSP 'org2MSP'", restErr.Error)
+
+	// the names are unique across the MSPs, an identity only moves to another MSP with overwrite
+	org1Key, org1Cert, org1PEM := newTestCA(t)
+	mockCAClient.ExpectedCalls = nil
+	mockCAClient.On("GetCAInfo").Return(&mspApi.GetCAInfoResponse{CAChain: org1PEM}, nil)
+	key, certPEM = newTestIssuedKey(t, org1Key, org1Cert, "imported4")
+	keyDER, _ = x509.MarshalPKCS8PrivateKey(key)
+	body = map[string]string{
+		"name":        "imported4",
+		"certificate": string(certPEM),
+		"privateKey":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+	_, restErr = importTestIdentity(idclient, body)
+	assert.Equal(409, restErr.StatusCode)
+	_, restErr = importTestIdentity(idclient, body, "overwrite=true")
+	assert.Nil(restErr)
+	signer, err = idclient.(*idClientWrapper).GetSigningIdentity("imported4")
+	assert.NoError(err)
+	assert.Equal("org1MSP", signer.Identifier().MSPID)
+	_, err = idclient.(*idClientWrapper).store.Load(msp.IdentityIdentifier{ID: "imported4", MSPID: "org2MSP"})
+	assert.Equal(msp.ErrUserNotFound, err)
+}
+
+func TestImportIdentityFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, caPEM := newTestCA(t)
+	mockCAClient := &mockfabricdep.CAClient{}
+	mockCAClient.On("GetCAInfo").Return(&mspApi.GetCAInfoResponse{CAChain: caPEM}, nil).Once()
+	mockCAClient.On("GetCAInfo").Return(nil, fmt.Errorf("bang")).Once()
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, mockCAClient)
+	key, certPEM := newTestSignerKey(t, "imported3")
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	otherKey, _ := newTestSignerKey(t, "imported3")
+	otherKeyDER, _ := x509.MarshalPKCS8PrivateKey(otherKey)
+
+	// without an admin role nor a security module, imports are denied
+	_, restErr := importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(403, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Access denied: openId.admin.role or a security module must be configured to import identities")
+
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, `missing required parameter "name"`)
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "privateKey": keyPEM})
+	assert.EqualError(restErr.Error, `missing required parameter "certificate"`)
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM)})
+	assert.EqualError(restErr.Error, `missing required parameter "privateKey"`)
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "mspId": "org3MSP", "certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "The MSP ID 'org3MSP' of the imported identity is not the MSP of an organization of the connection profile")
+
+	idclient.(*idClientWrapper).channelMSPs = func(channelId string) ([]*mspproto.MSPConfig, error) {
+		return nil, fmt.Errorf("pop")
+	}
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "mspId": "org2MSP", "certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(500, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Failed to retrieve the CA certificates of the MSP 'org2MSP': pop")
+
+	idclient.(*idClientWrapper).channelMSPs = func(channelId string) ([]*mspproto.MSPConfig, error) {
+		return []*mspproto.MSPConfig{newTestMSPConfig(t, "org1MSP", caPEM)}, nil
+	}
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "mspId": "org2MSP", "certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "The MSP 'org2MSP' is not a member of any channel of the connection profile")
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": "bad", "privateKey": keyPEM})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Failed to parse the enrollment certificate of 'imported3': no PEM block found")
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM), "privateKey": "bad"})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Invalid private key of 'imported3', must be a PEM encoded ECDSA key: no PEM block found")
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM), "privateKey": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("bad")}))})
+	assert.Equal(400, restErr.StatusCode)
+	assert.Contains(restErr.Error.Error(), "Invalid private key of 'imported3'")
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM), "privateKey": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: otherKeyDER}))})
+	assert.Equal(400, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "The private key of 'imported3' does not match the public key of its certificate")
+
+	// the self-signed certificate is not issued by the CA of the org
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(400, restErr.StatusCode)
+	assert.Regexp("The certificate of 'imported3' is not issued by a CA of the MSP 'org1MSP': x509: certificate signed by unknown authority", restErr.Error)
+
+	_, restErr = importTestIdentity(idclient, map[string]string{"name": "imported3", "certificate": string(certPEM), "privateKey": keyPEM})
+	assert.Equal(500, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "Failed to retrieve the CA certificates of the MSP 'org1MSP': bang")
+
+	r := httptest.NewRequest(http.MethodPost, "/wallet/identities", strings.NewReader(`{"name":"imported3","unknown":true}`))
+	_, restErr = idclient.ImportIdentity(httptest.NewRecorder(), r, nil)
+	assert.Equal(400, restErr.StatusCode)
+
+	_, err := idclient.(*idClientWrapper).identityMgr.GetSigningIdentity("imported3")
+	assert.Equal(msp.ErrUserNotFound, err)
+}
+
+func TestExportIdentityWithoutKey(t *testing.T) {
+	assert := assert.New(t)
+
+	mockCAClient := mockfabricdep.CAClient{}
+	mockCAClient.On("GetCAInfo").Return(nil, fmt.Errorf("bang"))
+	idclient := newProvisioningIdClient(t, conf.ProvisioningConf{}, &mockCAClient)
+	store := newTestSignerStore()
+	idclient.(*idClientWrapper).store = store
+	_, certPEM := newTestSignerKey(t, "external1")
+	_ = store.Store(&msp.UserData{MSPID: "org1MSP", ID: "external1", EnrollmentCertificate: certPEM})
+
+	params := httprouter.Params{httprouter.Param{Key: "username", Value: "external1"}}
+	exported, restErr := idclient.ExportIdentity(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/identities/external1", nil), params)
+	assert.Nil(restErr)
+	assert.False(exported.HasPrivateKey)
+	assert.Empty(exported.CACert)
+
+	params = httprouter.Params{httprouter.Param{Key: "username", Value: "unknown"}}
+	_, restErr = idclient.ExportIdentity(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/identities/unknown", nil), params)
+	assert.Equal(404, restErr.StatusCode)
+	assert.EqualError(restErr.Error, "No identity 'unknown' in the wallet")
+}
@@ -16,6 +16,8 @@
 package dep
 
 import (
+	"time"
+
 	mspApi "github.com/hyperledger/fabric-sdk-go/pkg/msp/api"
 )
 
@@ -27,5 +29,75 @@ type CAClient interface {
 	Revoke(*mspApi.RevocationRequest) (*mspApi.RevocationResponse, error)
 	GetAllIdentities(string) ([]*mspApi.IdentityResponse, error)
 	GetIdentity(string, string) (*mspApi.IdentityResponse, error)
+	RemoveIdentity(*mspApi.RemoveIdentityRequest) (*mspApi.IdentityResponse, error)
+	GetAllAffiliations(string) (*mspApi.AffiliationResponse, error)
+	GetAffiliation(string, string) (*mspApi.AffiliationResponse, error)
+	AddAffiliation(*mspApi.AffiliationRequest) (*mspApi.AffiliationResponse, error)
+	ModifyAffiliation(*mspApi.ModifyAffiliationRequest) (*mspApi.AffiliationResponse, error)
+	RemoveAffiliation(*mspApi.AffiliationRequest) (*mspApi.AffiliationResponse, error)
 	GetCAInfo() (*mspApi.GetCAInfoResponse, error)
 }
+
+// CSREnroller enrolls identities with the certificate signing requests of their owners, which
+// the SDK CAClient cannot do as it always generates the key pair
+type CSREnroller interface {
+	EnrollCSR(*CSREnrollmentRequest) (*CSREnrollmentResponse, error)
+}
+
+type CSREnrollmentRequest struct {
+	Name   string
+	Secret string
+	CAName string
+	// CSR is the PEM encoded certificate signing request
+	CSR      []byte
+	Profile  string
+	AttrReqs []*mspApi.AttributeRequest
+}
+
+type CSREnrollmentResponse struct {
+	// Cert is the PEM encoded enrollment certificate
+	Cert []byte
+	// CAChain is the PEM encoded chain of the issuing CA
+	CAChain []byte
+	CAName  string
+}
+
+// CertificateAuditor lists the certificates issued by the Fabric CA and generates its CRL, with
+// the authorization of the registrar of the CA
+type CertificateAuditor interface {
+	GetCertificates(*CertificatesRequest) (*CertificatesResponse, error)
+	GenCRL(*GenCRLRequest) (*GenCRLResponse, error)
+}
+
+type CertificatesRequest struct {
+	ID     string
+	Serial string
+	AKI    string
+	CAName string
+	// Expired and Revoked select the expired or revoked certificates when true, and the others
+	// when false
+	Expired *bool
+	Revoked *bool
+	// NotAfterStart and NotAfterEnd select the certificates expiring in the range
+	NotAfterStart *time.Time
+	NotAfterEnd   *time.Time
+}
+
+type CertificatesResponse struct {
+	CAName string
+	// Certs are the PEM encoded certificates
+	Certs [][]byte
+}
+
+type GenCRLRequest struct {
+	CAName        string
+	RevokedAfter  *time.Time
+	RevokedBefore *time.Time
+	ExpireAfter   *time.Time
+	ExpireBefore  *time.Time
+}
+
+type GenCRLResponse struct {
+	// CRL is the PEM encoded certificate revocation list
+	CRL []byte
+}
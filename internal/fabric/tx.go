@@ -40,6 +40,8 @@ type Tx struct {
 	Hash          string
 	Receipt       *client.TxReceipt
 	Signer        string
+	// Signature is set for the transactions signed by the client, which are already endorsed
+	Signature []byte
 }
 
 func NewSendTx(msg *messages.SendTransaction, signer string) *Tx {
@@ -51,6 +53,8 @@ func NewSendTx(msg *messages.SendTransaction, signer string) *Tx {
 		Args:          msg.Args,
 		TransientMap:  msg.TransientMap,
 		Signer:        msg.Headers.Signer,
+		Hash:          msg.TxID,
+		Signature:     msg.Signature,
 	}
 }
 
@@ -59,7 +63,18 @@ func (tx *Tx) GetTXReceipt(ctx context.Context, rpc client.RPCClient) (bool, err
 	tx.lock.Lock()
 	isMined := tx.Receipt.BlockNumber > 0
 	tx.lock.Unlock()
-	return isMined, nil
+	if isMined || tx.Signature == nil {
+		return isMined, nil
+	}
+	// the submission of a transaction signed by the client returns once it is ordered
+	receipt, err := rpc.GetSubmittedTxReceipt(tx.ChannelID, tx.Signer, tx.Hash)
+	if err != nil || receipt == nil {
+		return false, err
+	}
+	tx.lock.Lock()
+	tx.Receipt = receipt
+	tx.lock.Unlock()
+	return true, nil
 }
 
 // Send sends an individual transaction
@@ -68,7 +83,11 @@ func (tx *Tx) Send(ctx context.Context, rpc client.RPCClient) error {
 
 	var receipt *client.TxReceipt
 	var err error
-	receipt, err = rpc.Invoke(tx.ChannelID, tx.Signer, tx.ChaincodeName, tx.Function, tx.Args, tx.TransientMap, tx.IsInit)
+	if tx.Signature != nil {
+		receipt, err = rpc.SubmitTransaction(tx.ChannelID, tx.Signer, tx.Hash, tx.Signature)
+	} else {
+		receipt, err = rpc.Invoke(tx.ChannelID, tx.Signer, tx.ChaincodeName, tx.Function, tx.Args, tx.TransientMap, tx.IsInit)
+	}
 	tx.lock.Lock()
 	tx.Receipt = receipt
 	tx.lock.Unlock()
@@ -0,0 +1,217 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptionAlg = "AES-256-GCM"
+	kdfScrypt     = "scrypt"
+	kdfNone       = "none"
+	keyLength     = 32
+	saltLength    = 16
+	scryptN       = 32768
+	scryptR       = 8
+	scryptP       = 1
+)
+
+// encryptedKey is a private key encrypted with the key-encryption key, bound to the label
+// of its identity
+type encryptedKey struct {
+	Alg        string `json:"alg"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// keyCipher encrypts the private keys with a key derived from the passphrase, or read from
+// the key file
+type keyCipher struct {
+	kdf        string
+	passphrase []byte
+	// salt derives the key of the private keys encrypted by this process
+	salt []byte
+	mu   sync.Mutex
+	// keys caches the derived keys by salt, scrypt being slow by design
+	keys map[string][]byte
+}
+
+// newKeyCipher returns nil when no key-encryption key is configured
+func newKeyCipher(c conf.WalletEncryptionConf) (*keyCipher, error) {
+	if c.Passphrase != "" && c.KeyFile != "" {
+		return nil, errors.Errorf(errors.WalletEncryptionConfigInvalid)
+	}
+	if c.Passphrase != "" {
+		salt := make([]byte, saltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		return &keyCipher{kdf: kdfScrypt, passphrase: []byte(c.Passphrase), salt: salt, keys: map[string][]byte{}}, nil
+	}
+	if c.KeyFile != "" {
+		key, err := readKeyFile(c.KeyFile)
+		if err != nil {
+			return nil, errors.Errorf(errors.WalletKeyFileInvalid, c.KeyFile, err)
+		}
+		return &keyCipher{kdf: kdfNone, keys: map[string][]byte{"": key}}, nil
+	}
+	return nil, nil
+}
+
+// readKeyFile accepts a raw key, or a hex or base64 encoded one
+func readKeyFile(filename string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == keyLength {
+		return content, nil
+	}
+	trimmed := string(bytes.TrimSpace(content))
+	if key, err := hex.DecodeString(trimmed); err == nil && len(key) == keyLength {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(trimmed); err == nil && len(key) == keyLength {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the key must be %d bytes, raw or hex or base64 encoded", keyLength)
+}
+
+func (c *keyCipher) key(salt []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(c.passphrase, salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	c.keys[string(salt)] = key
+	return key, nil
+}
+
+func (c *keyCipher) gcm(salt []byte) (cipher.AEAD, error) {
+	key, err := c.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *keyCipher) encrypt(label string, plaintext []byte) (*encryptedKey, error) {
+	gcm, err := c.gcm(c.salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &encryptedKey{
+		Alg:        encryptionAlg,
+		KDF:        c.kdf,
+		Salt:       c.salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(label)),
+	}, nil
+}
+
+func (c *keyCipher) decrypt(label string, e *encryptedKey) ([]byte, error) {
+	if e.Alg != encryptionAlg {
+		return nil, fmt.Errorf("unsupported algorithm %s", e.Alg)
+	}
+	if e.KDF != c.kdf {
+		return nil, fmt.Errorf("the key was encrypted with the %s key derivation, not %s", e.KDF, c.kdf)
+	}
+	gcm, err := c.gcm(e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	return gcm.Open(nil, e.Nonce, e.Ciphertext, []byte(label))
+}
+
+// encryptedStore encrypts the private keys of the identities written to the backend, and
+// decrypts them on read
+type encryptedStore struct {
+	Store
+	cipher *keyCipher
+}
+
+func (s *encryptedStore) Put(label string, content []byte) error {
+	var id walletIdentity
+	if err := json.Unmarshal(content, &id); err != nil {
+		return errors.Errorf(errors.WalletIdentityInvalid, label, err)
+	}
+	if id.Credentials.Key != "" {
+		encrypted, err := s.cipher.encrypt(label, []byte(id.Credentials.Key))
+		if err != nil {
+			return errors.Errorf(errors.WalletEncryptFailed, label, err)
+		}
+		id.Credentials.Key = ""
+		id.Credentials.EncryptedKey = encrypted
+	}
+	content, _ = json.Marshal(&id)
+	return s.Store.Put(label, content)
+}
+
+func (s *encryptedStore) Get(label string) ([]byte, error) {
+	content, err := s.Store.Get(label)
+	if err != nil {
+		return nil, err
+	}
+	var id walletIdentity
+	if err := json.Unmarshal(content, &id); err != nil {
+		return nil, errors.Errorf(errors.WalletIdentityInvalid, label, err)
+	}
+	if id.Credentials.EncryptedKey == nil {
+		log.Warnf("The private key of '%s' is stored in plaintext, run 'fabconnect wallet migrate' to encrypt it", label)
+		return content, nil
+	}
+	key, err := s.cipher.decrypt(label, id.Credentials.EncryptedKey)
+	if err != nil {
+		return nil, errors.Errorf(errors.WalletDecryptFailed, label, err)
+	}
+	id.Credentials.Key = string(key)
+	id.Credentials.EncryptedKey = nil
+	content, _ = json.Marshal(&id)
+	return content, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+)
+
+const identityFileExtension = ".id"
+
+// fileSystemStore keeps each identity in a <label>.id file, the layout of the wallets created
+// with gateway.NewFileSystemWallet
+type fileSystemStore struct {
+	path string
+}
+
+func newFileSystemStore(path string) (*fileSystemStore, error) {
+	cleanPath := filepath.Clean(path)
+	if err := os.MkdirAll(cleanPath, 0700); err != nil {
+		return nil, errors.Errorf(errors.WalletInitFailed, TypeFilesystem, err)
+	}
+	return &fileSystemStore{path: cleanPath}, nil
+}
+
+func (s *fileSystemStore) file(label string) string {
+	// the label is a file name, never a path
+	return filepath.Join(s.path, filepath.Base(label)+identityFileExtension)
+}
+
+// Put replaces the identity file atomically, so an interrupted migration leaves no partial file
+func (s *fileSystemStore) Put(label string, content []byte) error {
+	file := s.file(label)
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	return nil
+}
+
+func (s *fileSystemStore) Get(label string) ([]byte, error) {
+	return ioutil.ReadFile(s.file(label))
+}
+
+func (s *fileSystemStore) Remove(label string) error {
+	if err := os.Remove(s.file(label)); err != nil && !os.IsNotExist(err) {
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	return nil
+}
+
+func (s *fileSystemStore) Exists(label string) bool {
+	_, err := os.Stat(s.file(label))
+	return err == nil
+}
+
+func (s *fileSystemStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if !file.IsDir() && filepath.Ext(name) == identityFileExtension {
+			labels = append(labels, name[:len(name)-len(identityFileExtension)])
+		}
+	}
+	return labels, nil
+}
+
+func (s *fileSystemStore) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/kvstore"
+)
+
+// levelDBStore keeps the identities in a LevelDB, keyed by label
+type levelDBStore struct {
+	db kvstore.KVStore
+}
+
+func newLevelDBStore(path string, mocked ...kvstore.KVStore) (*levelDBStore, error) {
+	if mocked != nil {
+		// only used in tests to pass in a mocked impl
+		return &levelDBStore{db: mocked[0]}, nil
+	}
+	db := kvstore.NewLDBKeyValueStore(path)
+	if err := db.Init(); err != nil {
+		return nil, errors.Errorf(errors.WalletInitFailed, TypeLevelDB, err)
+	}
+	return &levelDBStore{db: db}, nil
+}
+
+func (s *levelDBStore) Put(label string, content []byte) error {
+	if err := s.db.Put(label, content); err != nil {
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	return nil
+}
+
+func (s *levelDBStore) Get(label string) ([]byte, error) {
+	return s.db.Get(label)
+}
+
+func (s *levelDBStore) Remove(label string) error {
+	if err := s.db.Delete(label); err != nil {
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	return nil
+}
+
+func (s *levelDBStore) Exists(label string) bool {
+	_, err := s.db.Get(label)
+	return err == nil
+}
+
+func (s *levelDBStore) List() ([]string, error) {
+	it := s.db.NewIterator()
+	defer it.Release()
+	labels := []string{}
+	for it.Next() {
+		labels = append(labels, it.Key())
+	}
+	return labels, nil
+}
+
+func (s *levelDBStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/utils"
+)
+
+const mongoConnectTimeout = 10 * 1000
+
+// mongoDatabase is the subset of mgo used by the wallet, allowing stubbing
+type mongoDatabase interface {
+	Connect(url string, timeout time.Duration) error
+	GetCollection(database string, collection string) mongoCollection
+	Close()
+}
+
+// mongoCollection is the subset of mgo used by the wallet, allowing stubbing
+type mongoCollection interface {
+	UpsertId(id interface{}, update interface{}) error
+	FindId(id interface{}, result interface{}) error
+	FindAll(result interface{}) error
+	RemoveId(id interface{}) error
+}
+
+type mgoWrapper struct {
+	session *mgo.Session
+}
+
+func (m *mgoWrapper) Connect(url string, timeout time.Duration) (err error) {
+	m.session, err = mgo.DialWithTimeout(url, timeout)
+	return
+}
+
+func (m *mgoWrapper) GetCollection(database string, collection string) mongoCollection {
+	return &collWrapper{coll: m.session.DB(database).C(collection)}
+}
+
+func (m *mgoWrapper) Close() {
+	if m.session != nil {
+		m.session.Close()
+	}
+}
+
+type collWrapper struct {
+	coll *mgo.Collection
+}
+
+func (c *collWrapper) UpsertId(id interface{}, update interface{}) error {
+	_, err := c.coll.UpsertId(id, update)
+	return err
+}
+
+func (c *collWrapper) FindId(id interface{}, result interface{}) error {
+	return c.coll.FindId(id).One(result)
+}
+
+func (c *collWrapper) FindAll(result interface{}) error {
+	return c.coll.Find(nil).All(result)
+}
+
+func (c *collWrapper) RemoveId(id interface{}) error {
+	return c.coll.RemoveId(id)
+}
+
+// walletDocument holds the JSON of an identity, keyed by label
+type walletDocument struct {
+	Label   string `bson:"_id"`
+	Content string `bson:"content"`
+}
+
+// mongoStore keeps the identities in a MongoDB collection
+type mongoStore struct {
+	mgo        mongoDatabase
+	collection mongoCollection
+}
+
+func newMongoStore(c *conf.MongoDBReceiptsConf, db mongoDatabase) (*mongoStore, error) {
+	if c.URL == "" || !utils.AllOrNoneReqd(c.URL, c.Database, c.Collection) {
+		return nil, errors.Errorf(errors.WalletMongoDBConfigMissing)
+	}
+	timeout := c.ConnectTimeoutMS
+	if timeout <= 0 {
+		timeout = mongoConnectTimeout
+	}
+	if err := db.Connect(c.URL, time.Duration(timeout)*time.Millisecond); err != nil {
+		return nil, errors.Errorf(errors.WalletInitFailed, TypeMongoDB, err)
+	}
+	return &mongoStore{
+		mgo:        db,
+		collection: db.GetCollection(c.Database, c.Collection),
+	}, nil
+}
+
+func (s *mongoStore) Put(label string, content []byte) error {
+	if err := s.collection.UpsertId(label, &walletDocument{Label: label, Content: string(content)}); err != nil {
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	return nil
+}
+
+func (s *mongoStore) Get(label string) ([]byte, error) {
+	var doc walletDocument
+	if err := s.collection.FindId(label, &doc); err != nil {
+		return nil, err
+	}
+	return []byte(doc.Content), nil
+}
+
+func (s *mongoStore) Remove(label string) error {
+	if err := s.collection.RemoveId(label); err != nil && err != mgo.ErrNotFound {
+		return errors.Errorf(errors.WalletStoreFailed, label, err)
+	}
+	return nil
+}
+
+func (s *mongoStore) Exists(label string) bool {
+	var doc walletDocument
+	return s.collection.FindId(label, &doc) == nil
+}
+
+func (s *mongoStore) List() ([]string, error) {
+	var docs []walletDocument
+	if err := s.collection.FindAll(&docs); err != nil {
+		return nil, err
+	}
+	labels := make([]string, len(docs))
+	for i, doc := range docs {
+		labels[i] = doc.Label
+	}
+	return labels, nil
+}
+
+func (s *mongoStore) Close() error {
+	s.mgo.Close()
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMongo struct {
+	connErr    error
+	url        string
+	timeout    time.Duration
+	collection *mockCollection
+	closed     bool
+}
+
+func (m *mockMongo) Connect(url string, timeout time.Duration) error {
+	m.url = url
+	m.timeout = timeout
+	return m.connErr
+}
+
+func (m *mockMongo) GetCollection(database string, collection string) mongoCollection {
+	return m.collection
+}
+
+func (m *mockMongo) Close() {
+	m.closed = true
+}
+
+type mockCollection struct {
+	docs map[string]*walletDocument
+	err  error
+}
+
+func (c *mockCollection) UpsertId(id interface{}, update interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.docs[id.(string)] = update.(*walletDocument)
+	return nil
+}
+
+func (c *mockCollection) FindId(id interface{}, result interface{}) error {
+	doc, ok := c.docs[id.(string)]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	*result.(*walletDocument) = *doc
+	return nil
+}
+
+func (c *mockCollection) FindAll(result interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	docs := []walletDocument{}
+	for _, doc := range c.docs {
+		docs = append(docs, *doc)
+	}
+	reflect.ValueOf(result).Elem().Set(reflect.ValueOf(docs))
+	return nil
+}
+
+func (c *mockCollection) RemoveId(id interface{}) error {
+	if _, ok := c.docs[id.(string)]; !ok {
+		return mgo.ErrNotFound
+	}
+	delete(c.docs, id.(string))
+	return nil
+}
+
+func TestMongoWallet(t *testing.T) {
+	assert := assert.New(t)
+
+	db := &mockMongo{collection: &mockCollection{docs: map[string]*walletDocument{}}}
+	store, err := newMongoStore(&conf.MongoDBReceiptsConf{URL: "mongodb://localhost:27017", Database: "fabconnect", Collection: "wallet"}, db)
+	assert.NoError(err)
+	assert.Equal(10*time.Second, db.timeout)
+
+	assert.NoError(store.Put("user1", []byte(`{"version":1}`)))
+	assert.True(store.Exists("user1"))
+	assert.False(store.Exists("user2"))
+	content, err := store.Get("user1")
+	assert.NoError(err)
+	assert.Equal(`{"version":1}`, string(content))
+	labels, err := store.List()
+	assert.NoError(err)
+	assert.Equal([]string{"user1"}, labels)
+	assert.NoError(store.Remove("user1"))
+	assert.NoError(store.Remove("user1"))
+	_, err = store.Get("user1")
+	assert.Equal(mgo.ErrNotFound, err)
+	assert.NoError(store.Close())
+	assert.True(db.closed)
+}
+
+func TestMongoWalletFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &conf.MongoDBReceiptsConf{URL: "mongodb://localhost:27017", Database: "fabconnect", Collection: "wallet", ConnectTimeoutMS: 100}
+	_, err := newMongoStore(&conf.MongoDBReceiptsConf{URL: "mongodb://localhost:27017"}, &mockMongo{})
+	assert.EqualError(err, "The MongoDB wallet requires rpc.wallet.mongodb.url, database and collection")
+	_, err = newMongoStore(config, &mockMongo{connErr: fmt.Errorf("pop")})
+	assert.EqualError(err, "Failed to open the mongodb wallet: pop")
+
+	store, err := newMongoStore(config, &mockMongo{collection: &mockCollection{docs: map[string]*walletDocument{}, err: fmt.Errorf("pop")}})
+	assert.NoError(err)
+	assert.EqualError(store.Put("user1", []byte("{}")), "Failed to store the identity 'user1' in the wallet: pop")
+	_, err = store.List()
+	assert.EqualError(err, "pop")
+}
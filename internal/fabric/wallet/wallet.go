@@ -0,0 +1,141 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wallet
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TypeFilesystem = "filesystem"
+	TypeLevelDB    = "leveldb"
+	TypeMongoDB    = "mongodb"
+	defaultPath    = "makeen-wallet"
+)
+
+// Store is the backing storage of the identities of a gateway.Wallet, which holds its
+// resources until closed
+type Store interface {
+	gateway.WalletStore
+	Close() error
+}
+
+// walletIdentity is the JSON form of a gateway.X509Identity, whose private key is replaced
+// by its encrypted form in the encrypted wallets
+type walletIdentity struct {
+	Version     int    `json:"version"`
+	MspID       string `json:"mspId"`
+	IDType      string `json:"type"`
+	Credentials struct {
+		Certificate  string        `json:"certificate"`
+		Key          string        `json:"privateKey,omitempty"`
+		EncryptedKey *encryptedKey `json:"encryptedPrivateKey,omitempty"`
+	} `json:"credentials"`
+}
+
+// NewStore opens the configured wallet backend, which encrypts the private keys when a
+// key-encryption key is configured
+func NewStore(c conf.WalletConf) (Store, error) {
+	cipher, err := newKeyCipher(c.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := newBackend(c)
+	if err != nil {
+		return nil, err
+	}
+	if cipher == nil {
+		log.Warnf("The private keys in the %s wallet are stored in plaintext, set rpc.wallet.encryption to encrypt them", walletType(c))
+		return backend, nil
+	}
+	return &encryptedStore{Store: backend, cipher: cipher}, nil
+}
+
+// Migrate encrypts the plaintext private keys of the wallet in place, and returns the number
+// of identities it encrypted
+func Migrate(c conf.WalletConf) (int, error) {
+	cipher, err := newKeyCipher(c.Encryption)
+	if err != nil {
+		return 0, err
+	}
+	if cipher == nil {
+		return 0, errors.Errorf(errors.WalletEncryptionNotConfigured)
+	}
+	backend, err := newBackend(c)
+	if err != nil {
+		return 0, err
+	}
+	defer backend.Close()
+	return migrate(backend, cipher)
+}
+
+func migrate(backend Store, cipher *keyCipher) (int, error) {
+	store := &encryptedStore{Store: backend, cipher: cipher}
+	labels, err := backend.List()
+	if err != nil {
+		return 0, errors.Errorf(errors.WalletStoreFailed, "", err)
+	}
+	migrated := 0
+	for _, label := range labels {
+		content, err := backend.Get(label)
+		if err != nil {
+			return migrated, errors.Errorf(errors.WalletStoreFailed, label, err)
+		}
+		var id walletIdentity
+		if err := json.Unmarshal(content, &id); err != nil {
+			return migrated, errors.Errorf(errors.WalletIdentityInvalid, label, err)
+		}
+		if id.Credentials.EncryptedKey != nil {
+			continue
+		}
+		if err := store.Put(label, content); err != nil {
+			return migrated, err
+		}
+		log.Infof("Encrypted the private key of '%s' in the wallet", label)
+		migrated++
+	}
+	return migrated, nil
+}
+
+func newBackend(c conf.WalletConf) (Store, error) {
+	path := c.Path
+	if path == "" {
+		path = defaultPath
+	}
+	switch walletType(c) {
+	case TypeFilesystem:
+		return newFileSystemStore(path)
+	case TypeLevelDB:
+		return newLevelDBStore(path)
+	case TypeMongoDB:
+		return newMongoStore(&c.MongoDB, &mgoWrapper{})
+	default:
+		return nil, errors.Errorf(errors.WalletTypeUnknown, c.Type)
+	}
+}
+
+func walletType(c conf.WalletConf) string {
+	if c.Type == "" {
+		return TypeFilesystem
+	}
+	return c.Type
+}
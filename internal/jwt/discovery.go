@@ -0,0 +1,49 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const wellKnownOpenIDConfiguration = "/.well-known/openid-configuration"
+
+// OpenIDConfiguration is the subset of the OIDC discovery document used to verify tokens
+type OpenIDConfiguration struct {
+	Issuer                string `json:"issuer"`
+	JWKSUri               string `json:"jwks_uri"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+}
+
+// Discover fetches the discovery document published by the issuer, and checks it was issued for that issuer
+func Discover(ctx context.Context, client jwk.HTTPClient, issuer string) (*OpenIDConfiguration, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+wellKnownOpenIDConfiguration, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the openid configuration of %s: %s", issuer, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the openid configuration of %s: status %d", issuer, res.StatusCode)
+	}
+
+	var config OpenIDConfiguration
+	if err := json.NewDecoder(res.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid openid configuration returned by %s: %s", issuer, err)
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("openid configuration issuer %s does not match %s", config.Issuer, issuer)
+	}
+	if config.JWKSUri == "" {
+		return nil, fmt.Errorf("openid configuration of %s has no jwks_uri", issuer)
+	}
+	return &config, nil
+}
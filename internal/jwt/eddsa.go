@@ -0,0 +1,54 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the EdDSA (Ed25519) signing method of RFC 8037,
+// which is not provided by jwt-go
+type SigningMethodEdDSA struct{}
+
+var (
+	// SigningMethodEd25519 is registered with jwt-go under the "EdDSA" alg
+	SigningMethodEd25519 = &SigningMethodEdDSA{}
+
+	errEd25519Verification = errors.New("ed25519: verification error")
+)
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify expects an ed25519.PublicKey
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errEd25519Verification
+	}
+	return nil
+}
+
+// Sign expects an ed25519.PrivateKey
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
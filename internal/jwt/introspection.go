@@ -0,0 +1,213 @@
+package jwt
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// defaultIntrospectionCacheSize bounds the active tokens cached when MaxCacheSize is not set
+const defaultIntrospectionCacheSize = 10000
+
+// IntrospectionVerifier verifies opaque access tokens with the OAuth2 token introspection
+// endpoint of the IdP (RFC 7662), authenticating with client credentials
+type IntrospectionVerifier struct {
+	HTTPClient jwk.HTTPClient
+	// Endpoint is the introspection endpoint, resolved through OIDC discovery when not set
+	Endpoint string
+	// Issuer is used to resolve Endpoint through OIDC discovery
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// ClaimsToValidate maps a claim name to its expected value, or to a list of accepted values.
+	// The "azp" claim falls back to the "client_id" of the introspection response
+	ClaimsToValidate map[string]interface{}
+	// Leeway is the clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+	// MaxCacheTime caps how long an active token is cached. Tokens are never cached past their
+	// expiry, and tokens without one are only cached when MaxCacheTime is set
+	MaxCacheTime time.Duration
+	// MaxCacheSize caps the number of tokens cached, the least recently used are evicted first
+	MaxCacheSize int
+
+	mu    sync.Mutex
+	cache map[string]*list.Element
+	// lru orders the cached results, the most recently used first
+	lru *list.List
+	now func() time.Time
+}
+
+type introspectionResult struct {
+	key    string
+	claims jwt.MapClaims
+	until  time.Time
+}
+
+// Introspect returns the claims of an active token, from the cache when it was introspected before
+func (v *IntrospectionVerifier) Introspect(ctx context.Context, token string) (jwt.MapClaims, error) {
+	now := v.clock()
+	// the tokens themselves are not kept in memory
+	hash := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(hash[:])
+
+	if claims, ok := v.cached(key, now); ok {
+		return claims, nil
+	}
+
+	claims, err := v.introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims, now); err != nil {
+		return nil, err
+	}
+	if until := v.cacheUntil(claims, now); until.After(now) {
+		v.store(&introspectionResult{key: key, claims: claims, until: until})
+	}
+	return claims, nil
+}
+
+// cached returns the claims of a token introspected before, an expired result is dropped
+func (v *IntrospectionVerifier) cached(key string, now time.Time) (jwt.MapClaims, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	element, ok := v.cache[key]
+	if !ok {
+		return nil, false
+	}
+	result := element.Value.(*introspectionResult)
+	if !now.Before(result.until) {
+		v.lru.Remove(element)
+		delete(v.cache, key)
+		return nil, false
+	}
+	v.lru.MoveToFront(element)
+	return result.claims, true
+}
+
+// store caches a result, evicting the least recently used ones past the size of the cache
+func (v *IntrospectionVerifier) store(result *introspectionResult) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cache == nil {
+		v.cache = make(map[string]*list.Element)
+		v.lru = list.New()
+	}
+	if element, ok := v.cache[result.key]; ok {
+		v.lru.Remove(element)
+	}
+	v.cache[result.key] = v.lru.PushFront(result)
+	size := v.MaxCacheSize
+	if size <= 0 {
+		size = defaultIntrospectionCacheSize
+	}
+	for v.lru.Len() > size {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.cache, oldest.Value.(*introspectionResult).key)
+	}
+}
+
+func (v *IntrospectionVerifier) introspect(ctx context.Context, token string) (jwt.MapClaims, error) {
+	endpoint, err := v.endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, with the credentials form encoded first (RFC 6749 section 2.3.1)
+	req.SetBasicAuth(url.QueryEscape(v.ClientID), url.QueryEscape(v.ClientSecret))
+	res, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect token: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to introspect token: status %d", res.StatusCode)
+	}
+
+	var claims jwt.MapClaims
+	if err := json.NewDecoder(res.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %s", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, fmt.Errorf("token is not active")
+	}
+	return claims, nil
+}
+
+func (v *IntrospectionVerifier) validate(claims jwt.MapClaims, now time.Time) error {
+	if exp, ok := numericClaim(claims, "exp"); ok && now.After(time.Unix(exp, 0).Add(v.Leeway)) {
+		return fmt.Errorf("token is expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.Leeway).Before(time.Unix(nbf, 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	for k, expected := range v.ClaimsToValidate {
+		claim := claims[k]
+		if k == "azp" && claim == nil {
+			claim = claims["client_id"]
+		}
+		if !claimMatches(claim, expected) {
+			return fmt.Errorf("claims validate failed, invalid claim: %v", k)
+		}
+	}
+	return nil
+}
+
+func (v *IntrospectionVerifier) cacheUntil(claims jwt.MapClaims, now time.Time) time.Time {
+	var until time.Time
+	if exp, ok := numericClaim(claims, "exp"); ok {
+		until = time.Unix(exp, 0)
+	}
+	if v.MaxCacheTime > 0 {
+		if max := now.Add(v.MaxCacheTime); until.IsZero() || max.Before(until) {
+			until = max
+		}
+	}
+	return until
+}
+
+// resolves the introspection endpoint once, through discovery when only the issuer is known
+func (v *IntrospectionVerifier) endpoint(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.Endpoint != "" {
+		return v.Endpoint, nil
+	}
+	if v.Issuer == "" {
+		return "", fmt.Errorf("no introspection endpoint or issuer configured")
+	}
+	config, err := Discover(ctx, v.HTTPClient, v.Issuer)
+	if err != nil {
+		return "", err
+	}
+	if config.IntrospectionEndpoint == "" {
+		return "", fmt.Errorf("openid configuration of %s has no introspection_endpoint", v.Issuer)
+	}
+	v.Endpoint = config.IntrospectionEndpoint
+	return v.Endpoint, nil
+}
+
+func (v *IntrospectionVerifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,237 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testIntrospectionServer struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	tokens   map[string]map[string]interface{}
+	requests int
+}
+
+func newTestIntrospectionServer(t *testing.T) *testIntrospectionServer {
+	s := &testIntrospectionServer{tokens: make(map[string]map[string]interface{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.server.URL,
+			"jwks_uri":               s.server.URL + "/certs",
+			"introspection_endpoint": s.server.URL + "/introspect",
+		})
+	})
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		username, password, _ := r.BasicAuth()
+		username, _ = url.QueryUnescape(username)
+		password, _ = url.QueryUnescape(password)
+		if r.Method != http.MethodPost || username != "fabconnect" || password != "s3cr:t&" {
+			w.WriteHeader(401)
+			return
+		}
+		assert.Equal(t, "access_token", r.FormValue("token_type_hint"))
+		response, ok := s.tokens[r.FormValue("token")]
+		if !ok {
+			response = map[string]interface{}{"active": false}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *testIntrospectionServer) addToken(token string, claims map[string]interface{}) {
+	s.mu.Lock()
+	s.tokens[token] = claims
+	s.mu.Unlock()
+}
+
+func (s *testIntrospectionServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *testIntrospectionServer) verifier() (*IntrospectionVerifier, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	return &IntrospectionVerifier{
+		HTTPClient:   &JWKHttpClient{},
+		Endpoint:     s.server.URL + "/introspect",
+		ClientID:     "fabconnect",
+		ClientSecret: "s3cr:t&",
+		now:          clock.Now,
+	}, clock
+}
+
+func TestIntrospectCachesUntilExpiry(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, clock := idp.verifier()
+	idp.addToken("opaque1", map[string]interface{}{
+		"active":   true,
+		"sub":      "user1-id",
+		"username": "user1",
+		"exp":      clock.Now().Add(time.Minute).Unix(),
+	})
+
+	for i := 0; i < 3; i++ {
+		claims, err := verifier.Introspect(context.Background(), "opaque1")
+		assert.NoError(err)
+		assert.Equal("user1", claims["username"])
+		assert.Equal("user1-id", claims["sub"])
+	}
+	assert.Equal(1, idp.requestCount())
+
+	// the IdP is asked again once the token expired, and the expiry is enforced
+	clock.Advance(61 * time.Second)
+	_, err := verifier.Introspect(context.Background(), "opaque1")
+	assert.Regexp("token is expired", err)
+	assert.Equal(2, idp.requestCount())
+}
+
+func TestIntrospectInactive(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, _ := idp.verifier()
+
+	for i := 0; i < 2; i++ {
+		_, err := verifier.Introspect(context.Background(), "revoked")
+		assert.Regexp("token is not active", err)
+	}
+	// inactive results are not cached
+	assert.Equal(2, idp.requestCount())
+}
+
+func TestIntrospectNoExpiry(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, clock := idp.verifier()
+	idp.addToken("opaque1", map[string]interface{}{"active": true, "sub": "user1-id"})
+
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	assert.Equal(2, idp.requestCount())
+
+	verifier.MaxCacheTime = 10 * time.Second
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	assert.Equal(3, idp.requestCount())
+	clock.Advance(11 * time.Second)
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	assert.Equal(4, idp.requestCount())
+}
+
+func TestIntrospectCacheSize(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, clock := idp.verifier()
+	verifier.MaxCacheSize = 2
+	for _, token := range []string{"opaque1", "opaque2", "opaque3"} {
+		idp.addToken(token, map[string]interface{}{"active": true, "exp": clock.Now().Add(time.Hour).Unix()})
+	}
+
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	_, _ = verifier.Introspect(context.Background(), "opaque2")
+	// opaque1 is used again, so opaque2 is the least recently used when opaque3 is cached
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	_, _ = verifier.Introspect(context.Background(), "opaque3")
+	assert.Equal(3, idp.requestCount())
+	assert.Len(verifier.cache, 2)
+
+	_, _ = verifier.Introspect(context.Background(), "opaque1")
+	assert.Equal(3, idp.requestCount())
+	_, _ = verifier.Introspect(context.Background(), "opaque2")
+	assert.Equal(4, idp.requestCount())
+	assert.Equal(2, verifier.lru.Len())
+}
+
+func TestIntrospectValidatesClaims(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, clock := idp.verifier()
+	verifier.ClaimsToValidate = map[string]interface{}{
+		"aud": []string{"fabconnect"},
+		"azp": "fabconnect-ui",
+	}
+	idp.addToken("good", map[string]interface{}{
+		"active":    true,
+		"aud":       "fabconnect",
+		"client_id": "fabconnect-ui",
+	})
+	idp.addToken("otherclient", map[string]interface{}{
+		"active":    true,
+		"aud":       "fabconnect",
+		"client_id": "other",
+	})
+	idp.addToken("future", map[string]interface{}{
+		"active":    true,
+		"aud":       "fabconnect",
+		"client_id": "fabconnect-ui",
+		"nbf":       clock.Now().Add(time.Hour).Unix(),
+	})
+
+	_, err := verifier.Introspect(context.Background(), "good")
+	assert.NoError(err)
+	_, err = verifier.Introspect(context.Background(), "otherclient")
+	assert.Regexp("invalid claim: azp", err)
+	_, err = verifier.Introspect(context.Background(), "future")
+	assert.Regexp("token is not valid yet", err)
+}
+
+func TestIntrospectDiscovery(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, _ := idp.verifier()
+	verifier.Endpoint = ""
+	verifier.Issuer = idp.server.URL
+	idp.addToken("opaque1", map[string]interface{}{"active": true})
+
+	_, err := verifier.Introspect(context.Background(), "opaque1")
+	assert.NoError(err)
+	assert.Equal(idp.server.URL+"/introspect", verifier.Endpoint)
+
+	verifier = &IntrospectionVerifier{HTTPClient: &JWKHttpClient{}}
+	_, err = verifier.Introspect(context.Background(), "opaque1")
+	assert.Regexp("no introspection endpoint or issuer configured", err)
+}
+
+func TestIntrospectFailures(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIntrospectionServer(t)
+	defer idp.server.Close()
+	verifier, _ := idp.verifier()
+
+	verifier.ClientSecret = "wrong"
+	_, err := verifier.Introspect(context.Background(), "opaque1")
+	assert.Regexp("status 401", err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("!json"))
+	}))
+	defer server.Close()
+	verifier.Endpoint = server.URL
+	_, err = verifier.Introspect(context.Background(), "opaque1")
+	assert.Regexp("invalid introspection response", err)
+
+	verifier.Endpoint = "http://localhost:1"
+	_, err = verifier.Introspect(context.Background(), "opaque1")
+	assert.Regexp("failed to introspect token", err)
+}
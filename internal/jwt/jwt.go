@@ -2,16 +2,44 @@ package jwt
 
 import (
 	"context"
-	// "crypto/ecdsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"errors"
 	"fmt"
-	"reflect"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/lestrrat-go/jwx/jwk"
 )
 
+// DefaultAlgorithms are accepted when no allowlist is configured. The symmetric HS algorithms
+// and "none" are never accepted, as the keys come from a public JWKS endpoint
+var DefaultAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// CheckAlgorithms makes sure an allowlist only contains algorithms the verifier supports
+func CheckAlgorithms(algorithms []string) error {
+	for _, alg := range algorithms {
+		supported := false
+		for _, s := range DefaultAlgorithms {
+			if alg == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("unsupported token signing algorithm: %s", alg)
+		}
+	}
+	return nil
+}
+
 // TokenVerifier provides for token validation
 type TokenVerifier interface {
 	ValidateToken(bearerToken string) (bool, error)
@@ -19,76 +47,120 @@ type TokenVerifier interface {
 
 // JwtTokenVerifier provides oidc server information
 type JwtTokenVerifier struct {
-	HTTPClient       jwk.HTTPClient
-	JWKSUri          string
+	HTTPClient jwk.HTTPClient
+	JWKSUri    string
+	// Issuer is used to resolve JWKSUri through OIDC discovery, when JWKSUri is not set
+	Issuer string
+	// ClaimsToValidate maps a claim name to its expected value, or to a list of accepted values
 	ClaimsToValidate map[string]interface{}
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+	// KeySets caches the keys across verifications, without it the keys are fetched for every token
+	KeySets *KeySetCache
+	// Algorithms is the allowlist of token signing algorithms, DefaultAlgorithms when empty
+	Algorithms []string
+	mu         sync.Mutex
 }
 
 func (j *JwtTokenVerifier) Parse(ctx context.Context, bearerToken string) (*jwt.Token, error) {
-	token, err := jwt.Parse(bearerToken, func(token *jwt.Token) (interface{}, error) {
-		if err, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			fmt.Println(err)
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		set, err := j.fetchAndCacheJWKS(ctx)
+	algorithms := j.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+	}
+	// the time based claims are checked by Verify, with the configured leeway
+	parser := &jwt.Parser{ValidMethods: algorithms, SkipClaimsValidation: true}
+	token, err := parser.Parse(bearerToken, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := j.lookupKey(ctx, keyID)
 		if err != nil {
 			return nil, err
 		}
-
-		keyID, _ := token.Header["kid"].(string)
-		if key, ok := set.LookupKeyID(keyID); ok {
-			var result rsa.PublicKey
-			err := key.Raw(&result)
-			if err != nil {
-				return nil, err
-			}
-			return &result, nil
+		// a key published for one algorithm must not be used with another
+		if alg := key.Algorithm(); alg != "" && alg != token.Method.Alg() {
+			return nil, fmt.Errorf("key %s is for %s tokens, not %s", keyID, alg, token.Method.Alg())
 		}
-
-		return nil, errors.New("unable to find key")
+		return publicKeyFor(token.Method, key)
 	})
 
 	return token, err
 }
 
+// the type of the key comes from the JWK, and has to suit the signing method of the token
+func publicKeyFor(method jwt.SigningMethod, key jwk.Key) (interface{}, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, err
+	}
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if publicKey, ok := raw.(*rsa.PublicKey); ok {
+			return publicKey, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		// ES256 requires a P-256 key, ES384 a P-384 key and ES512 a P-521 key
+		if publicKey, ok := raw.(*ecdsa.PublicKey); ok && publicKey.Curve.Params().BitSize == m.CurveBits {
+			return publicKey, nil
+		}
+	case *SigningMethodEdDSA:
+		if publicKey, ok := raw.(ed25519.PublicKey); ok {
+			return publicKey, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", method.Alg())
+	}
+	return nil, fmt.Errorf("key type %s cannot verify %s tokens", key.KeyType(), method.Alg())
+}
+
+// Verify parses the token, checks its signature, its validity period and the configured claims
+func (j *JwtTokenVerifier) Verify(ctx context.Context, bearerToken string) (*jwt.Token, error) {
+	token, err := j.Parse(ctx, bearerToken)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if err := j.validateTimes(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	if _, err := j.validateTokenByClaims(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
 // ValidateToken validates claims with given token
 func (j *JwtTokenVerifier) ValidateToken(ctx context.Context, bearerToken string) (bool, error) {
-	token, err := j.Parse(ctx, bearerToken)
+	_, err := j.Verify(ctx, bearerToken)
 	if err != nil {
 		return false, err
 	}
+	return true, nil
+}
 
-	// if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-	// 	for k, v := range claims {
-	// 		fmt.Println(k, v)
-	// 	}
-	// } else {
-	// 	fmt.Println("INVALID JWT TOKEN")
-	// }
-
-	return j.validateTokenByClaims(token)
+func (j *JwtTokenVerifier) validateTimes(claims jwt.MapClaims, now time.Time) error {
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(exp, 0).Add(j.Leeway)) {
+		return fmt.Errorf("token is expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(j.Leeway).Before(time.Unix(nbf, 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if iat, ok := numericClaim(claims, "iat"); ok && now.Add(j.Leeway).Before(time.Unix(iat, 0)) {
+		return fmt.Errorf("token used before issued")
+	}
+	return nil
 }
 
 func (j *JwtTokenVerifier) validateTokenByClaims(token *jwt.Token) (bool, error) {
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 		for k, v := range j.ClaimsToValidate {
-			claim := claims[k]
-			switch reflect.TypeOf(claim).Kind() {
-			case reflect.String:
-				if claim != v {
-					return false, fmt.Errorf("claims validate failed, invalid claim: %v", k)
-				}
-			case reflect.Slice:
-				var itemFound bool
-				for _, c := range claim.([]interface{}) {
-					if c == v {
-						itemFound = true
-						break
-					}
-				}
-				if !itemFound {
-					return false, fmt.Errorf("claims validate failed, invalid claim: %v", k)
-				}
+			if !claimMatches(claims[k], v) {
+				return false, fmt.Errorf("claims validate failed, invalid claim: %v", k)
 			}
 		}
 		return true, nil
@@ -97,10 +169,83 @@ func (j *JwtTokenVerifier) validateTokenByClaims(token *jwt.Token) (bool, error)
 	return false, fmt.Errorf("invalid token")
 }
 
-func (j *JwtTokenVerifier) fetchAndCacheJWKS(ctx context.Context) (jwk.Set, error) {
-	response, err := jwk.Fetch(ctx, j.JWKSUri, jwk.WithHTTPClient(j.HTTPClient))
+// a claim matches when any of its values (a claim like "aud" can be a single value or a list)
+// equals any of the expected values
+func claimMatches(claim interface{}, expected interface{}) bool {
+	if claim == nil {
+		return false
+	}
+	actual := toValueList(claim)
+	for _, e := range toValueList(expected) {
+		for _, a := range actual {
+			if fmt.Sprint(a) == fmt.Sprint(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toValueList(v interface{}) []interface{} {
+	switch vv := v.(type) {
+	case []interface{}:
+		return vv
+	case []string:
+		list := make([]interface{}, len(vv))
+		for i, s := range vv {
+			list[i] = s
+		}
+		return list
+	default:
+		return []interface{}{v}
+	}
+}
+
+func numericClaim(claims jwt.MapClaims, name string) (int64, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// resolves the JWKS URI once, through discovery when only the issuer is known
+func (j *JwtTokenVerifier) jwksURI(ctx context.Context) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.JWKSUri != "" {
+		return j.JWKSUri, nil
+	}
+	if j.Issuer == "" {
+		return "", fmt.Errorf("no JWKS URI or issuer configured")
+	}
+	config, err := Discover(ctx, j.HTTPClient, j.Issuer)
+	if err != nil {
+		return "", err
+	}
+	j.JWKSUri = config.JWKSUri
+	return j.JWKSUri, nil
+}
+
+func (j *JwtTokenVerifier) lookupKey(ctx context.Context, keyID string) (jwk.Key, error) {
+	uri, err := j.jwksURI(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if j.KeySets != nil {
+		return j.KeySets.LookupKeyID(ctx, uri, keyID)
+	}
+	set, err := jwk.Fetch(ctx, uri, jwk.WithHTTPClient(j.HTTPClient))
 	if err != nil {
 		return nil, err
 	}
-	return response, nil
+	if key, ok := set.LookupKeyID(keyID); ok {
+		return key, nil
+	}
+	return nil, errors.New("unable to find key")
 }
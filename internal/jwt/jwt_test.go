@@ -0,0 +1,351 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+type testIdP struct {
+	server    *httptest.Server
+	key       crypto.Signer
+	method    jwt.SigningMethod
+	kid       string
+	keyAlg    string
+	issuer    string
+	discovery int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	idp := &testIdP{key: key, method: jwt.SigningMethodRS256, kid: "key1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		idp.discovery++
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   idp.issuer,
+			"jwks_uri": idp.server.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		pub, _ := jwk.New(idp.key.Public())
+		_ = pub.Set(jwk.KeyIDKey, idp.kid)
+		if idp.keyAlg != "" {
+			_ = pub.Set(jwk.AlgorithmKey, idp.keyAlg)
+		}
+		set := jwk.NewSet()
+		set.Add(pub)
+		_ = json.NewEncoder(w).Encode(set)
+	})
+	idp.server = httptest.NewServer(mux)
+	idp.issuer = idp.server.URL
+	return idp
+}
+
+func (idp *testIdP) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(idp.method, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func (idp *testIdP) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": idp.issuer,
+		"sub": "user1-id",
+		"aud": []string{"fabconnect", "account"},
+		"azp": "fabconnect-ui",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"iat": time.Now().Unix(),
+	}
+}
+
+func (idp *testIdP) verifier() *JwtTokenVerifier {
+	return &JwtTokenVerifier{
+		HTTPClient: &JWKHttpClient{},
+		Issuer:     idp.issuer,
+		ClaimsToValidate: map[string]interface{}{
+			"iss": idp.issuer,
+			"aud": []string{"fabconnect"},
+			"azp": "fabconnect-ui",
+		},
+	}
+}
+
+func TestVerifyWithDiscovery(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	verifier := idp.verifier()
+	token, err := verifier.Verify(context.Background(), idp.sign(t, idp.claims()))
+	assert.NoError(err)
+	assert.Equal("user1-id", token.Claims.(jwt.MapClaims)["sub"])
+	assert.Equal(idp.server.URL+"/certs", verifier.JWKSUri)
+
+	ok, err := verifier.ValidateToken(context.Background(), idp.sign(t, idp.claims()))
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(1, idp.discovery)
+}
+
+func TestVerifyDiscoveryIssuerMismatch(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	verifier := idp.verifier()
+	idp.issuer = "https://other.example.com"
+	_, err := verifier.Verify(context.Background(), idp.sign(t, idp.claims()))
+	assert.Regexp("does not match", err)
+}
+
+func TestVerifyClaims(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	verifier := idp.verifier()
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		err    string
+	}{
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "invalid claim: iss"},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "account" }, "invalid claim: aud"},
+		{"missing azp", func(c jwt.MapClaims) { delete(c, "azp") }, "invalid claim: azp"},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, "token is expired"},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "token has no expiry"},
+		{"not yet valid", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Minute).Unix() }, "token is not valid yet"},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Minute).Unix() }, "token used before issued"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := idp.claims()
+			test.modify(claims)
+			_, err := verifier.Verify(context.Background(), idp.sign(t, claims))
+			assert.Regexp(t, test.err, err)
+		})
+	}
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	claims := idp.claims()
+	claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	token := idp.sign(t, claims)
+
+	verifier := idp.verifier()
+	_, err := verifier.Verify(context.Background(), token)
+	assert.Regexp("token is expired", err)
+
+	verifier.Leeway = 30 * time.Second
+	_, err = verifier.Verify(context.Background(), token)
+	assert.NoError(err)
+}
+
+func TestVerifyUnknownKey(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	verifier := idp.verifier()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims())
+	token.Header["kid"] = "unknown"
+	signed, _ := token.SignedString(idp.key)
+	_, err := verifier.Verify(context.Background(), signed)
+	assert.Regexp("unable to find key", err)
+}
+
+func TestVerifyNoIssuerOrJWKSUri(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	verifier := &JwtTokenVerifier{HTTPClient: &JWKHttpClient{}}
+	_, err := verifier.Verify(context.Background(), idp.sign(t, idp.claims()))
+	assert.Regexp("no JWKS URI or issuer configured", err)
+}
+
+func TestDiscoverFailures(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/notfound" + wellKnownOpenIDConfiguration:
+			w.WriteHeader(404)
+		case "/badjson" + wellKnownOpenIDConfiguration:
+			_, _ = w.Write([]byte("!json"))
+		default:
+			_, _ = w.Write([]byte(fmt.Sprintf(`{"issuer":"http://%s/nojwks"}`, r.Host)))
+		}
+	}))
+	defer server.Close()
+
+	_, err := Discover(context.Background(), &JWKHttpClient{}, server.URL+"/notfound")
+	assert.Regexp("status 404", err)
+	_, err = Discover(context.Background(), &JWKHttpClient{}, server.URL+"/badjson")
+	assert.Regexp("invalid openid configuration", err)
+	_, err = Discover(context.Background(), &JWKHttpClient{}, server.URL+"/nojwks")
+	assert.Regexp("has no jwks_uri", err)
+	_, err = Discover(context.Background(), &JWKHttpClient{}, "http://localhost:1")
+	assert.Regexp("failed to fetch", err)
+}
+
+func TestClaimMatches(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(claimMatches("a", "a"))
+	assert.True(claimMatches([]interface{}{"a", "b"}, "b"))
+	assert.True(claimMatches("b", []interface{}{"a", "b"}))
+	assert.True(claimMatches(float64(1), 1))
+	assert.False(claimMatches(nil, "a"))
+	assert.False(claimMatches([]interface{}{"a"}, []string{"b", "c"}))
+}
+
+func TestVerifySigningAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		key    crypto.Signer
+		method jwt.SigningMethod
+	}{
+		{rsaKey, jwt.SigningMethodRS384},
+		{rsaKey, jwt.SigningMethodPS256},
+		{rsaKey, jwt.SigningMethodPS512},
+		{p256Key, jwt.SigningMethodES256},
+		{p384Key, jwt.SigningMethodES384},
+		{p521Key, jwt.SigningMethodES512},
+		{edKey, SigningMethodEd25519},
+	}
+	for _, test := range tests {
+		t.Run(test.method.Alg(), func(t *testing.T) {
+			idp := newTestIdP(t)
+			defer idp.server.Close()
+			idp.key = test.key
+			idp.method = test.method
+
+			token, err := idp.verifier().Verify(context.Background(), idp.sign(t, idp.claims()))
+			assert.NoError(t, err)
+			assert.Equal(t, test.method.Alg(), token.Method.Alg())
+		})
+	}
+}
+
+func TestVerifyAlgorithmAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	idp.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	idp.method = jwt.SigningMethodES256
+
+	verifier := idp.verifier()
+	verifier.Algorithms = []string{"RS256"}
+	_, err := verifier.Verify(context.Background(), idp.sign(t, idp.claims()))
+	assert.Regexp("signing method ES256 is invalid", err)
+
+	verifier.Algorithms = []string{"RS256", "ES256"}
+	_, err = verifier.Verify(context.Background(), idp.sign(t, idp.claims()))
+	assert.NoError(err)
+}
+
+func TestVerifyRejectsHMAC(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+
+	// the classic key confusion attack, signing with the public key as an HMAC secret
+	pub, _ := jwk.New(idp.key.Public())
+	secret, _ := json.Marshal(pub)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.claims())
+	token.Header["kid"] = idp.kid
+	signed, _ := token.SignedString(secret)
+	_, err := idp.verifier().Verify(context.Background(), signed)
+	assert.Regexp("signing method HS256 is invalid", err)
+}
+
+func TestVerifyKeyTypeMismatch(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	verifier := idp.verifier()
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name      string
+		published crypto.Signer
+		signer    crypto.Signer
+		method    jwt.SigningMethod
+		err       string
+	}{
+		{"RSA key for ES256", idp.key, p256Key, jwt.SigningMethodES256, "key type RSA cannot verify ES256 tokens"},
+		{"P-256 key for ES384", p256Key, p384Key, jwt.SigningMethodES384, "key type EC cannot verify ES384 tokens"},
+		{"EC key for EdDSA", p256Key, edKey, SigningMethodEd25519, "key type EC cannot verify EdDSA tokens"},
+		{"Ed25519 key for RS256", edKey, idp.key, jwt.SigningMethodRS256, "key type OKP cannot verify RS256 tokens"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp.key = test.signer
+			idp.method = test.method
+			signed := idp.sign(t, idp.claims())
+			idp.key = test.published
+			_, err := verifier.Verify(context.Background(), signed)
+			assert.Regexp(t, test.err, err)
+		})
+	}
+}
+
+func TestVerifyKeyAlgorithmMismatch(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	idp.keyAlg = "RS256"
+	idp.method = jwt.SigningMethodPS256
+
+	_, err := idp.verifier().Verify(context.Background(), idp.sign(t, idp.claims()))
+	assert.Regexp("key key1 is for RS256 tokens, not PS256", err)
+}
+
+func TestCheckAlgorithms(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(CheckAlgorithms(nil))
+	assert.NoError(CheckAlgorithms([]string{"ES256", "EdDSA"}))
+	assert.Regexp("unsupported token signing algorithm: HS256", CheckAlgorithms([]string{"RS256", "HS256"}))
+	assert.Regexp("unsupported token signing algorithm: none", CheckAlgorithms([]string{"none"}))
+}
+
+func TestEdDSASigningMethod(t *testing.T) {
+	assert := assert.New(t)
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	sig, err := SigningMethodEd25519.Sign("payload", priv)
+	assert.NoError(err)
+	assert.NoError(SigningMethodEd25519.Verify("payload", sig, pub))
+	assert.Equal(errEd25519Verification, SigningMethodEd25519.Verify("tampered", sig, pub))
+	assert.Equal(jwt.ErrInvalidKeyType, SigningMethodEd25519.Verify("payload", sig, priv))
+	_, err = SigningMethodEd25519.Sign("payload", pub)
+	assert.Equal(jwt.ErrInvalidKeyType, err)
+	assert.Error(SigningMethodEd25519.Verify("payload", "!base64", pub))
+}
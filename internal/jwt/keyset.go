@@ -0,0 +1,271 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRefreshInterval    = 5 * time.Minute
+	defaultMinRefreshInterval = 30 * time.Second
+	defaultGracePeriod        = time.Hour
+)
+
+// KeySetCacheConfig tunes how long the key sets are kept for
+type KeySetCacheConfig struct {
+	// RefreshInterval is used when the JWKS endpoint does not return any caching headers
+	RefreshInterval time.Duration
+	// MinRefreshInterval rate limits the requests to the JWKS endpoint, including the
+	// on-demand refreshes triggered by tokens signed with an unknown key
+	MinRefreshInterval time.Duration
+	// GracePeriod is how long the cached keys are still served after they expired,
+	// while the JWKS endpoint cannot be reached. A negative value disables it
+	GracePeriod time.Duration
+}
+
+// KeySetCache keeps the key sets of JWKS endpoints in memory, and refreshes them in the background
+// as directed by the Cache-Control headers, so that verifying a token does not cost a round trip to the IdP
+type KeySetCache struct {
+	client  jwk.HTTPClient
+	config  KeySetCacheConfig
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	entries map[string]*keySetEntry
+	// defined to allow mocking in tests
+	now func() time.Time
+}
+
+type keySetEntry struct {
+	mu          sync.Mutex
+	set         jwk.Set
+	expiresAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// fetching is closed when the request in flight to the JWKS endpoint completes
+	fetching chan struct{}
+}
+
+// NewKeySetCache creates a cache, the background refreshes stop when the context is cancelled or
+// the cache is closed
+func NewKeySetCache(ctx context.Context, client jwk.HTTPClient, config KeySetCacheConfig) *KeySetCache {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultRefreshInterval
+	}
+	if config.MinRefreshInterval <= 0 {
+		config.MinRefreshInterval = defaultMinRefreshInterval
+	}
+	if config.GracePeriod == 0 {
+		config.GracePeriod = defaultGracePeriod
+	} else if config.GracePeriod < 0 {
+		config.GracePeriod = 0
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &KeySetCache{
+		client:  client,
+		config:  config,
+		ctx:     ctx,
+		cancel:  cancel,
+		entries: make(map[string]*keySetEntry),
+		now:     time.Now,
+	}
+}
+
+// Close stops the background refreshes, and the requests to the JWKS endpoints in flight
+func (c *KeySetCache) Close() {
+	c.cancel()
+}
+
+// Get returns the key set of the JWKS endpoint, fetching it the first time it is requested
+func (c *KeySetCache) Get(ctx context.Context, url string) (jwk.Set, error) {
+	entry, created := c.getEntry(url)
+	if created {
+		go c.refreshLoop(url, entry)
+	}
+
+	entry.mu.Lock()
+	now := c.now()
+	if entry.set != nil && now.Before(entry.expiresAt) {
+		defer entry.mu.Unlock()
+		return entry.set, nil
+	}
+	// expired or never fetched, the background refresh is not keeping up (or failing)
+	stale := entry.set == nil || now.Sub(entry.lastAttempt) >= c.config.MinRefreshInterval
+	entry.mu.Unlock()
+	if stale {
+		c.refresh(ctx, url, entry)
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.set != nil && now.Before(entry.expiresAt.Add(c.config.GracePeriod)) {
+		return entry.set, nil
+	}
+	if entry.lastErr != nil {
+		return nil, entry.lastErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("keys from %s have expired", url)
+}
+
+// LookupKeyID finds a key in the key set of the JWKS endpoint. An unknown key ID triggers a refresh,
+// to pick up keys the IdP has rotated in, as long as the endpoint was not called too recently
+func (c *KeySetCache) LookupKeyID(ctx context.Context, url, keyID string) (jwk.Key, error) {
+	set, err := c.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := set.LookupKeyID(keyID); ok {
+		return key, nil
+	}
+
+	entry, _ := c.getEntry(url)
+	entry.mu.Lock()
+	stale := c.now().Sub(entry.lastAttempt) >= c.config.MinRefreshInterval
+	entry.mu.Unlock()
+	if stale {
+		log.Infof("Key %s not found in the keys from %s, refreshing", keyID, url)
+		c.refresh(ctx, url, entry)
+	}
+	entry.mu.Lock()
+	set = entry.set
+	entry.mu.Unlock()
+	if set != nil {
+		if key, ok := set.LookupKeyID(keyID); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to find key %s", keyID)
+}
+
+func (c *KeySetCache) getEntry(url string) (*keySetEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[url]
+	if !ok {
+		entry = &keySetEntry{}
+		c.entries[url] = entry
+	}
+	return entry, !ok
+}
+
+// refresh fetches the keys without holding the entry lock, so a slow IdP does not block the
+// verifications with the cached keys. A refresh already in flight is waited for instead of
+// starting another one. A failed refresh keeps the keys fetched before
+func (c *KeySetCache) refresh(ctx context.Context, url string, entry *keySetEntry) {
+	entry.mu.Lock()
+	if fetching := entry.fetching; fetching != nil {
+		entry.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+		}
+		return
+	}
+	fetching := make(chan struct{})
+	entry.fetching = fetching
+	attempt := c.now()
+	entry.lastAttempt = attempt
+	entry.mu.Unlock()
+
+	set, maxAge, err := c.fetch(ctx, url)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.fetching = nil
+	close(fetching)
+	if err != nil {
+		log.Errorf("Failed to fetch keys from %s: %s", url, err)
+		entry.lastErr = err
+		return
+	}
+	entry.set = set
+	entry.expiresAt = attempt.Add(maxAge)
+	entry.lastErr = nil
+}
+
+func (c *KeySetCache) refreshLoop(url string, entry *keySetEntry) {
+	for {
+		entry.mu.Lock()
+		wait := entry.expiresAt.Sub(c.now())
+		if entry.lastErr != nil || wait < c.config.MinRefreshInterval {
+			wait = c.config.MinRefreshInterval
+		}
+		entry.mu.Unlock()
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		entry.mu.Lock()
+		due := !c.now().Before(entry.expiresAt) || entry.lastErr != nil
+		entry.mu.Unlock()
+		if due {
+			c.refresh(c.ctx, url, entry)
+		}
+	}
+}
+
+func (c *KeySetCache) fetch(ctx context.Context, url string) (jwk.Set, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch keys from %s: status %d", url, res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	set, err := jwk.Parse(body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid keys returned by %s: %s", url, err)
+	}
+	return set, c.maxAge(res), nil
+}
+
+// how long the response can be cached for, according to Cache-Control or Expires,
+// but never shorter than the refresh rate limit
+func (c *KeySetCache) maxAge(res *http.Response) time.Duration {
+	maxAge := c.config.RefreshInterval
+	found := false
+	for _, directive := range strings.Split(res.Header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			maxAge, found = 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				maxAge, found = time.Duration(seconds)*time.Second, true
+			}
+		}
+	}
+	if !found {
+		if expires, err := http.ParseTime(res.Header.Get("Expires")); err == nil {
+			maxAge = expires.Sub(c.now())
+		}
+	}
+	if maxAge < c.config.MinRefreshInterval {
+		maxAge = c.config.MinRefreshInterval
+	}
+	return maxAge
+}
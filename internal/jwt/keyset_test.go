@@ -0,0 +1,337 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/assert"
+)
+
+type testJWKSServer struct {
+	server       *httptest.Server
+	mu           sync.Mutex
+	keys         map[string]*rsa.PrivateKey
+	cacheControl string
+	down         bool
+	requests     int
+	// hang blocks the requests until it is closed
+	hang chan struct{}
+}
+
+func newTestJWKSServer(t *testing.T, kids ...string) *testJWKSServer {
+	s := &testJWKSServer{keys: make(map[string]*rsa.PrivateKey)}
+	for _, kid := range kids {
+		s.addKey(t, kid)
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		hang := s.hang
+		s.mu.Unlock()
+		if hang != nil {
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.down {
+			w.WriteHeader(503)
+			return
+		}
+		set := jwk.NewSet()
+		for kid, key := range s.keys {
+			pub, _ := jwk.New(&key.PublicKey)
+			_ = pub.Set(jwk.KeyIDKey, kid)
+			set.Add(pub)
+		}
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	return s
+}
+
+func (s *testJWKSServer) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+}
+
+func (s *testJWKSServer) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *testJWKSServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+func newTestKeySetCache(ctx context.Context, config KeySetCacheConfig) (*KeySetCache, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	cache := NewKeySetCache(ctx, &JWKHttpClient{}, config)
+	cache.now = clock.Now
+	return cache, clock
+}
+
+func TestKeySetCacheHonoursCacheControl(t *testing.T) {
+	assert := assert.New(t)
+	jwks := newTestJWKSServer(t, "key1")
+	defer jwks.server.Close()
+	jwks.cacheControl = "public, max-age=120"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, clock := newTestKeySetCache(ctx, KeySetCacheConfig{})
+	for i := 0; i < 5; i++ {
+		_, err := cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+		assert.NoError(err)
+	}
+	assert.Equal(1, jwks.requestCount())
+
+	clock.Advance(119 * time.Second)
+	_, err := cache.Get(ctx, jwks.server.URL)
+	assert.NoError(err)
+	assert.Equal(1, jwks.requestCount())
+
+	clock.Advance(2 * time.Second)
+	_, err = cache.Get(ctx, jwks.server.URL)
+	assert.NoError(err)
+	assert.Equal(2, jwks.requestCount())
+}
+
+func TestKeySetCacheMaxAge(t *testing.T) {
+	assert := assert.New(t)
+	cache, clock := newTestKeySetCache(context.Background(), KeySetCacheConfig{
+		RefreshInterval:    time.Minute,
+		MinRefreshInterval: 10 * time.Second,
+	})
+
+	res := &http.Response{Header: http.Header{}}
+	assert.Equal(time.Minute, cache.maxAge(res))
+	res.Header.Set("Cache-Control", "max-age=3600, must-revalidate")
+	assert.Equal(time.Hour, cache.maxAge(res))
+	res.Header.Set("Cache-Control", "no-store")
+	assert.Equal(10*time.Second, cache.maxAge(res))
+	res.Header.Set("Cache-Control", "max-age=1")
+	assert.Equal(10*time.Second, cache.maxAge(res))
+	res.Header.Del("Cache-Control")
+	res.Header.Set("Expires", clock.Now().Add(30*time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(float64(30*time.Minute), float64(cache.maxAge(res)), float64(time.Second))
+}
+
+func TestKeySetCacheRefreshesOnUnknownKeyID(t *testing.T) {
+	assert := assert.New(t)
+	jwks := newTestJWKSServer(t, "key1")
+	defer jwks.server.Close()
+	jwks.cacheControl = "max-age=3600"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, clock := newTestKeySetCache(ctx, KeySetCacheConfig{MinRefreshInterval: time.Minute})
+	_, err := cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+	assert.NoError(err)
+
+	// the IdP rotates in a new key, but the cache was refreshed too recently to look for it
+	jwks.addKey(t, "key2")
+	_, err = cache.LookupKeyID(ctx, jwks.server.URL, "key2")
+	assert.Regexp("unable to find key key2", err)
+	assert.Equal(1, jwks.requestCount())
+
+	clock.Advance(time.Minute)
+	key, err := cache.LookupKeyID(ctx, jwks.server.URL, "key2")
+	assert.NoError(err)
+	assert.Equal("key2", key.KeyID())
+	assert.Equal(2, jwks.requestCount())
+
+	// garbage key IDs cannot be used to hammer the IdP
+	for i := 0; i < 10; i++ {
+		_, err = cache.LookupKeyID(ctx, jwks.server.URL, "bogus")
+		assert.Error(err)
+	}
+	assert.Equal(2, jwks.requestCount())
+}
+
+func TestKeySetCacheGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	jwks := newTestJWKSServer(t, "key1")
+	defer jwks.server.Close()
+	jwks.cacheControl = "max-age=60"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, clock := newTestKeySetCache(ctx, KeySetCacheConfig{
+		MinRefreshInterval: 10 * time.Second,
+		GracePeriod:        10 * time.Minute,
+	})
+	_, err := cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+	assert.NoError(err)
+
+	jwks.setDown(true)
+	clock.Advance(5 * time.Minute)
+	_, err = cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+	assert.NoError(err)
+	assert.Equal(2, jwks.requestCount())
+
+	clock.Advance(6 * time.Minute)
+	_, err = cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+	assert.Regexp("status 503", err)
+
+	// the keys are served again as soon as the IdP is back
+	jwks.setDown(false)
+	clock.Advance(time.Minute)
+	_, err = cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+	assert.NoError(err)
+}
+
+func TestKeySetCacheNoGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	jwks := newTestJWKSServer(t, "key1")
+	defer jwks.server.Close()
+	jwks.cacheControl = "max-age=60"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, clock := newTestKeySetCache(ctx, KeySetCacheConfig{GracePeriod: -1})
+	_, err := cache.Get(ctx, jwks.server.URL)
+	assert.NoError(err)
+
+	jwks.setDown(true)
+	clock.Advance(61 * time.Second)
+	_, err = cache.Get(ctx, jwks.server.URL)
+	assert.Regexp("status 503", err)
+}
+
+func TestKeySetCacheSlowIdP(t *testing.T) {
+	assert := assert.New(t)
+	jwks := newTestJWKSServer(t, "key1")
+	defer jwks.server.Close()
+	jwks.cacheControl = "max-age=60"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, clock := newTestKeySetCache(ctx, KeySetCacheConfig{MinRefreshInterval: time.Minute})
+	_, err := cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+	assert.NoError(err)
+
+	hang := make(chan struct{})
+	jwks.mu.Lock()
+	jwks.hang = hang
+	jwks.mu.Unlock()
+	clock.Advance(61 * time.Second)
+	refreshed := make(chan error)
+	go func() {
+		_, err := cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+		refreshed <- err
+	}()
+	assert.Eventually(func() bool { return jwks.requestCount() == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	// the expired keys are still served in the grace period while the refresh hangs
+	verified := make(chan error)
+	go func() {
+		_, err := cache.LookupKeyID(ctx, jwks.server.URL, "key1")
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		assert.NoError(err)
+	case <-time.After(2 * time.Second):
+		assert.Fail("lookup blocked by the refresh in flight")
+	}
+
+	close(hang)
+	assert.NoError(<-refreshed)
+	assert.Equal(2, jwks.requestCount())
+}
+
+func TestKeySetCacheFirstFetchFails(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not keys"))
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache, _ := newTestKeySetCache(ctx, KeySetCacheConfig{})
+	_, err := cache.LookupKeyID(ctx, server.URL, "key1")
+	assert.Regexp("invalid keys returned", err)
+}
+
+func TestKeySetCacheBackgroundRefresh(t *testing.T) {
+	assert := assert.New(t)
+	jwks := newTestJWKSServer(t, "key1")
+	defer jwks.server.Close()
+	jwks.cacheControl = "no-cache"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := NewKeySetCache(ctx, &JWKHttpClient{}, KeySetCacheConfig{MinRefreshInterval: 20 * time.Millisecond})
+	_, err := cache.Get(ctx, jwks.server.URL)
+	assert.NoError(err)
+
+	// picked up without any request needing the new key
+	jwks.addKey(t, "key2")
+	assert.Eventually(func() bool {
+		set, err := cache.Get(ctx, jwks.server.URL)
+		if err != nil {
+			return false
+		}
+		_, ok := set.LookupKeyID("key2")
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cache.Close()
+	time.Sleep(50 * time.Millisecond)
+	count := jwks.requestCount()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(count, jwks.requestCount())
+}
+
+func TestVerifyWithKeySetCache(t *testing.T) {
+	assert := assert.New(t)
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	verifier := idp.verifier()
+	verifier.KeySets = NewKeySetCache(ctx, verifier.HTTPClient, KeySetCacheConfig{})
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(ctx, idp.sign(t, idp.claims()))
+		assert.NoError(err)
+	}
+	assert.Equal(1, idp.discovery)
+}
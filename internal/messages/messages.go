@@ -31,10 +31,18 @@ const (
 	MsgTypeDeployContract = "DeployContract"
 	// MsgTypeSendTransaction - send a transaction
 	MsgTypeSendTransaction = "SendTransaction"
+	// MsgTypeSubmitTransaction - submit a transaction prepared for an external signer, and signed by the client
+	MsgTypeSubmitTransaction = "SubmitTransaction"
 
 	MsgTypeTransactionSuccess = "TransactionSuccess"
 	MsgTypeTransactionFailure = "TransactionFailure"
 	MsgTypeQuerySuccess       = "QuerySuccess"
+	// MsgTypeBulkIdentityProgress - the progress of a bulk identity job that is still running
+	MsgTypeBulkIdentityProgress = "BulkIdentityProgress"
+	// MsgTypeBulkIdentitySuccess - a bulk identity job provisioned all its identities
+	MsgTypeBulkIdentitySuccess = "BulkIdentitySuccess"
+	// MsgTypeBulkIdentityFailure - a bulk identity job stopped with identities failed or left to provision
+	MsgTypeBulkIdentityFailure = "BulkIdentityFailure"
 	// RecordHeaderAccessToken - record header name for passing JWT token over messaging
 	RecordHeaderAccessToken = "fly-accesstoken"
 )
@@ -51,6 +59,8 @@ type CommonHeaders struct {
 	ID            string                 `json:"id,omitempty"`
 	MsgType       string                 `json:"type,omitempty"`
 	Signer        string                 `json:"signer,omitempty"`
+	Subject       string                 `json:"subject,omitempty"`
+	Caller        string                 `json:"caller,omitempty"`
 	ChannelID     string                 `json:"channel,omitempty"`
 	ChaincodeName string                 `json:"chaincode,omitempty"`
 	PayloadSchema interface{}            `json:"payloadSchema,omitempty"` // can be stringified JSON or map for JSON
@@ -126,6 +136,10 @@ type SendTransaction struct {
 	Function     string            `json:"func"`
 	Args         []string          `json:"args,omitempty"`
 	TransientMap map[string]string `json:"transientMap,omitempty"`
+	// TxID and Signature submit a transaction endorsed through /transactions/:txId/endorse, with
+	// the signature of the client over the transaction envelope
+	TxID      string `json:"txId,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
 }
 
 // DeployChaincode message instructs the bridge to install a contract
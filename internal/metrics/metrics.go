@@ -0,0 +1,49 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// SignerCertExpiry is the NotAfter of the enrollment certificate of each enrolled signer
+	SignerCertExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "fabconnect",
+		Name:      "signer_cert_expiry_timestamp_seconds",
+		Help:      "Expiry of the enrollment certificate of the signer, in seconds since the epoch",
+	}, []string{"signer", "mspid"})
+
+	// SignerReenrollments counts the automatic re-enrollments of the signers, by result
+	SignerReenrollments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "fabconnect",
+		Name:      "signer_cert_reenrollments_total",
+		Help:      "Automatic re-enrollments of the signers with an expiring enrollment certificate",
+	}, []string{"signer", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(SignerCertExpiry, SignerReenrollments)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -2,111 +2,298 @@ package openid
 
 import (
 	"context"
-	"crypto/tls"
-	"log"
+	"strings"
 
 	"github.com/Nerzal/gocloak/v13"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
 )
 
-// type OpenIdClient interface {
-// 	CreateUser(username string, realm string) *string
-// 	newOpenIdClient() (openidClientWrapper, error)
-// }
-
+// OpenidClientWrapper is the IdPProvisioner of Keycloak, using its admin REST API
 type OpenidClientWrapper struct {
-	openidClient gocloak.GoCloak
+	openidClient *gocloak.GoCloak
 	context      context.Context
 	openIdConf   conf.OpenIDConfig
-	// identityMgr    msp.IdentityManager
-	// caClient       dep.CAClient
-	// listeners      []SignerUpdateListener
+	templates    *provisioningTemplates
 }
 
 func NewOpenIdClient(o conf.OpenIDConfig) (*OpenidClientWrapper, error) {
+	templates, err := parseTemplates(o.Provisioning)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := utils.CreateTLSConfiguration(&o.TLS)
+	if err != nil {
+		return nil, err
+	}
+
 	client := gocloak.NewClient(o.Host)
 	restyClient := client.RestyClient()
-	restyClient.SetDebug(true)
-	restyClient.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+	if tlsConfig != nil {
+		restyClient.SetTLSClientConfig(tlsConfig)
+	}
 	restyClient.SetAllowGetMethodPayload(true)
-	ctx := context.Background()
 
 	openidClient := &OpenidClientWrapper{
-		openidClient: *client,
-		context:      ctx,
+		openidClient: client,
+		context:      context.Background(),
 		openIdConf:   o,
+		templates:    templates,
 	}
 
 	return openidClient, nil
 }
 
-func (o *OpenidClientWrapper) CreateUser(username string) (*string, error) {
-
+func (o *OpenidClientWrapper) login() (string, error) {
 	token, err := o.openidClient.LoginAdmin(o.context, o.openIdConf.AdminUsername, o.openIdConf.AdminPassword, o.openIdConf.AdminRealm)
 	if err != nil {
-		return nil, err
+		return "", errors.Errorf(errors.OpenIDAdminLogin, err)
 	}
+	return token.AccessToken, nil
+}
 
-	log.Println("Query Username")
-	log.Println(username)
-
-	usersParams := gocloak.GetUsersParams{
-		Username: gocloak.StringP(username),
-		// Email:    gocloak.StringP("mail@gmail.com"),
+// ProvisionUser returns the IdP user with the username of the identity, and creates it from
+// the provisioning template when it does not exist. When a step of the provisioning fails,
+// the created user is deleted again
+func (o *OpenidClientWrapper) ProvisionUser(data *ProvisioningData) (*ProvisionedUser, error) {
+	token, err := o.login()
+	if err != nil {
+		return nil, err
 	}
 
-	userId := ""
+	existing, err := o.findUser(token, data.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		log.Debugf("IdP user '%s' already exists with ID %s", data.Username, *existing.ID)
+		return &ProvisionedUser{ID: *existing.ID}, nil
+	}
 
-	users, err := o.openidClient.GetUsers(o.context, token.AccessToken, o.openIdConf.ClientRealm, usersParams)
+	user, err := o.newUser(data)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := o.openidClient.CreateUser(o.context, token, o.openIdConf.ClientRealm, *user)
+	if err != nil {
+		return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "create the user", data.Username, err)
+	}
+	provisioned := &ProvisionedUser{ID: userID, Created: true}
+	if err := o.completeUser(token, data.Username, provisioned); err != nil {
+		if rollbackErr := o.openidClient.DeleteUser(o.context, token, o.openIdConf.ClientRealm, userID); rollbackErr != nil {
+			log.Errorf("Failed to delete the partially provisioned IdP user '%s' (%s): %s", data.Username, userID, rollbackErr)
+		}
+		return nil, err
+	}
+	log.Infof("Provisioned the IdP user '%s' with ID %s", data.Username, userID)
+	return provisioned, nil
+}
 
-	log.Println("Query Users:")
-	log.Println(len(users))
+func (o *OpenidClientWrapper) GetUser(username string) (*IdPUser, error) {
+	token, err := o.login()
+	if err != nil {
+		return nil, err
+	}
+	user, err := o.findUser(token, username)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return toIdPUser(user), nil
+}
 
-	if len(users) > 0 {
+func (o *OpenidClientWrapper) ListUsers() ([]*IdPUser, error) {
+	token, err := o.login()
+	if err != nil {
+		return nil, err
+	}
+	var result []*IdPUser
+	for first := 0; ; first += listPageSize {
+		users, err := o.openidClient.GetUsers(o.context, token, o.openIdConf.ClientRealm, gocloak.GetUsersParams{
+			BriefRepresentation: gocloak.BoolP(true),
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(listPageSize),
+		})
+		if err != nil {
+			return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "list the users", "*", err)
+		}
 		for _, user := range users {
-			log.Println("COMPARE:")
-			log.Println(*user.Username)
-			log.Println(username)
-			if username == *user.Username {
-				log.Println("Existing USER:")
-				log.Println(*user.ID)
-				log.Println(*user.Username)
-				// groups := *user.Groups
-				// if len(groups) > 0 {
-				// 	fmt.Printf("%v", groups)
-				// }
-				// if len(*user.RealmRoles) > 0 {
-				// 	fmt.Printf("%v", *user.RealmRoles)
-				// }
-
-				userId = *user.ID
+			if user.ID != nil && user.Username != nil {
+				result = append(result, toIdPUser(user))
 			}
 		}
+		if len(users) < listPageSize {
+			return result, nil
+		}
 	}
+}
 
-	if userId != "" {
-		return &userId, nil
-	} else {
+func (o *OpenidClientWrapper) DisableUser(userID string) error {
+	token, err := o.login()
+	if err != nil {
+		return err
+	}
+	// Keycloak only updates the fields present in the representation
+	user := gocloak.User{ID: gocloak.StringP(userID), Enabled: gocloak.BoolP(false)}
+	if err := o.openidClient.UpdateUser(o.context, token, o.openIdConf.ClientRealm, user); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "disable the user", userID, err)
+	}
+	return nil
+}
 
-		user := gocloak.User{
-			FirstName:     gocloak.StringP(username),
-			Email:         gocloak.StringP(username + "@gmail.com"),
-			EmailVerified: gocloak.BoolP(true),
-			Enabled:       gocloak.BoolP(true),
-			Username:      gocloak.StringP(username),
+// DeleteUser removes an IdP user, used to roll back a provisioning when the enrollment fails
+func (o *OpenidClientWrapper) DeleteUser(userID string) error {
+	token, err := o.login()
+	if err != nil {
+		return err
+	}
+	if err := o.openidClient.DeleteUser(o.context, token, o.openIdConf.ClientRealm, userID); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "delete the user", userID, err)
+	}
+	return nil
+}
+
+func (o *OpenidClientWrapper) AddUserToGroup(userID, group string) error {
+	token, err := o.login()
+	if err != nil {
+		return err
+	}
+	return o.addToGroup(token, userID, userID, group)
+}
+
+func (o *OpenidClientWrapper) RemoveUserFromGroup(userID, group string) error {
+	token, err := o.login()
+	if err != nil {
+		return err
+	}
+	groupID, err := o.groupID(token, userID, group)
+	if err != nil {
+		return err
+	}
+	if err := o.openidClient.DeleteUserFromGroup(o.context, token, o.openIdConf.ClientRealm, userID, groupID); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "remove the user from the group "+group, userID, err)
+	}
+	return nil
+}
+
+func (o *OpenidClientWrapper) findUser(token, username string) (*gocloak.User, error) {
+	users, err := o.openidClient.GetUsers(o.context, token, o.openIdConf.ClientRealm, gocloak.GetUsersParams{
+		Username: gocloak.StringP(username),
+		Exact:    gocloak.BoolP(true),
+	})
+	if err != nil {
+		return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "look up the user", username, err)
+	}
+	for _, user := range users {
+		// the username query matches substrings on older Keycloak versions
+		if user.Username != nil && user.ID != nil && *user.Username == username {
+			return user, nil
 		}
+	}
+	return nil, nil
+}
 
-		userId, err = o.openidClient.CreateUser(o.context, token.AccessToken, o.openIdConf.ClientRealm, user)
-		if err != nil {
-			return nil, err
+func (o *OpenidClientWrapper) newUser(data *ProvisioningData) (*gocloak.User, error) {
+	p := o.openIdConf.Provisioning
+	fields, err := o.templates.execute(data)
+	if err != nil {
+		return nil, err
+	}
+	user := &gocloak.User{
+		Username:      gocloak.StringP(data.Username),
+		Enabled:       gocloak.BoolP(true),
+		EmailVerified: gocloak.BoolP(p.EmailVerified),
+	}
+	if fields.Email != "" {
+		user.Email = gocloak.StringP(fields.Email)
+	}
+	if fields.FirstName != "" {
+		user.FirstName = gocloak.StringP(fields.FirstName)
+	}
+	if fields.LastName != "" {
+		user.LastName = gocloak.StringP(fields.LastName)
+	}
+	if len(fields.Attributes) > 0 {
+		attributes := make(map[string][]string, len(fields.Attributes))
+		for name, value := range fields.Attributes {
+			attributes[name] = []string{value}
 		}
+		user.Attributes = &attributes
+	}
+	if len(p.RequiredActions) > 0 {
+		requiredActions := append([]string{}, p.RequiredActions...)
+		user.RequiredActions = &requiredActions
+	}
+	return user, nil
+}
+
+// completeUser adds a created user to the groups and realm roles of the template, and sets its
+// one-time password
+func (o *OpenidClientWrapper) completeUser(token, username string, provisioned *ProvisionedUser) error {
+	realm := o.openIdConf.ClientRealm
+	for _, group := range provisioningGroups(o.openIdConf) {
+		if err := o.addToGroup(token, username, provisioned.ID, group); err != nil {
+			return err
+		}
+	}
 
-		o.openidClient.AddUserToGroup(o.context, token.AccessToken, o.openIdConf.ClientRealm, userId, o.openIdConf.Group)
-		// o.openidClient.AddClientRoleToUser(o.context, token.AccessToken, o.openIdConf.ClientRealm, userId, o.openIdConf.Group)
+	if len(o.openIdConf.Provisioning.RealmRoles) > 0 {
+		roles := make([]gocloak.Role, 0, len(o.openIdConf.Provisioning.RealmRoles))
+		for _, name := range o.openIdConf.Provisioning.RealmRoles {
+			role, err := o.openidClient.GetRealmRole(o.context, token, realm, name)
+			if err != nil {
+				return errors.Errorf(errors.OpenIDProvisioningFailed, "look up the realm role "+name, username, err)
+			}
+			roles = append(roles, *role)
+		}
+		if err := o.openidClient.AddRealmRoleToUser(o.context, token, realm, provisioned.ID, roles); err != nil {
+			return errors.Errorf(errors.OpenIDProvisioningFailed, "grant the realm roles", username, err)
+		}
+	}
+
+	if o.openIdConf.Provisioning.OneTimePassword {
+		password, err := generatePassword()
+		if err != nil {
+			return errors.Errorf(errors.OpenIDProvisioningFailed, "generate the one-time password", username, err)
+		}
+		if err := o.openidClient.SetPassword(o.context, token, provisioned.ID, realm, password, true); err != nil {
+			return errors.Errorf(errors.OpenIDProvisioningFailed, "set the one-time password", username, err)
+		}
+		provisioned.OneTimePassword = password
+	}
+	return nil
+}
 
-		o.openidClient.SetPassword(o.context, token.AccessToken, userId, o.openIdConf.ClientRealm, "123456", false)
+// addToGroup adds a user to a group, the username is only used in the errors
+func (o *OpenidClientWrapper) addToGroup(token, username, userID, group string) error {
+	groupID, err := o.groupID(token, username, group)
+	if err != nil {
+		return err
+	}
+	if err := o.openidClient.AddUserToGroup(o.context, token, o.openIdConf.ClientRealm, userID, groupID); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "add the user to the group "+group, username, err)
+	}
+	return nil
+}
 
-		return &userId, nil
+func toIdPUser(user *gocloak.User) *IdPUser {
+	return &IdPUser{
+		ID:       *user.ID,
+		Username: *user.Username,
+		Email:    gocloak.PString(user.Email),
+		Enabled:  user.Enabled == nil || *user.Enabled,
 	}
+}
 
+// groupID resolves the group paths, which start with "/"
+func (o *OpenidClientWrapper) groupID(token, username, group string) (string, error) {
+	if !strings.HasPrefix(group, "/") {
+		return group, nil
+	}
+	g, err := o.openidClient.GetGroupByPath(o.context, token, o.openIdConf.ClientRealm, group)
+	if err != nil {
+		return "", errors.Errorf(errors.OpenIDProvisioningFailed, "look up the group "+group, username, err)
+	}
+	return *g.ID, nil
 }
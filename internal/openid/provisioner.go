@@ -0,0 +1,188 @@
+package openid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"text/template"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+)
+
+// The IdP provisioning providers, configured with openId.provisioning.provider
+const (
+	ProviderKeycloak = "keycloak"
+	ProviderSCIM     = "scim"
+)
+
+// listPageSize is the number of users fetched per request when listing the IdP users
+const listPageSize = 100
+
+// oneTimePasswordBytes is the entropy of the generated passwords, encoded to 22 characters
+const oneTimePasswordBytes = 16
+
+// IdPProvisioner manages the IdP users of the Fabric identities. The users are looked up by the
+// username of the identity, and addressed by their IdP ID afterwards. A group is an ID, or a
+// path starting with "/"
+type IdPProvisioner interface {
+	// ProvisionUser returns the user of the identity, and creates it from the provisioning
+	// template when it does not exist. A partially created user is deleted again on failure
+	ProvisionUser(data *ProvisioningData) (*ProvisionedUser, error)
+	// GetUser returns nil when the IdP has no user with the username
+	GetUser(username string) (*IdPUser, error)
+	ListUsers() ([]*IdPUser, error)
+	DisableUser(userID string) error
+	DeleteUser(userID string) error
+	AddUserToGroup(userID, group string) error
+	RemoveUserFromGroup(userID, group string) error
+}
+
+// ProvisioningData is what the provisioning templates are executed with, taken from the CA
+// identity being enrolled
+type ProvisioningData struct {
+	Username    string
+	Type        string
+	Affiliation string
+	Attributes  map[string]string
+}
+
+// ProvisionedUser is the IdP user of an identity
+type ProvisionedUser struct {
+	ID string
+	// Created is set when the user did not exist, and was provisioned from the template
+	Created bool
+	// OneTimePassword is the generated temporary password of a created user
+	OneTimePassword string
+}
+
+// IdPUser is a user as stored in the IdP
+type IdPUser struct {
+	ID       string
+	Username string
+	Email    string
+	Enabled  bool
+}
+
+// NewProvisioner returns the provisioner of the configured provider, or nil when no IdP is
+// configured to provision the users in
+func NewProvisioner(o conf.OpenIDConfig) (IdPProvisioner, error) {
+	switch o.Provisioning.Provider {
+	case "", ProviderKeycloak:
+		if o.Host == "" {
+			return nil, nil
+		}
+		client, err := NewOpenIdClient(o)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case ProviderSCIM:
+		client, err := NewSCIMClient(o)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	default:
+		return nil, errors.Errorf(errors.ConfigProvisioningProvider, o.Provisioning.Provider)
+	}
+}
+
+// newUserFields are the fields of a new user, from the provisioning templates
+type newUserFields struct {
+	Email      string
+	FirstName  string
+	LastName   string
+	Attributes map[string]string
+}
+
+type provisioningTemplates struct {
+	email      *template.Template
+	firstName  *template.Template
+	lastName   *template.Template
+	attributes map[string]*template.Template
+}
+
+func parseTemplates(p conf.ProvisioningConf) (*provisioningTemplates, error) {
+	t := &provisioningTemplates{attributes: make(map[string]*template.Template, len(p.Attributes))}
+	var err error
+	if t.email, err = parseTemplate("email", p.Email); err != nil {
+		return nil, err
+	}
+	if t.firstName, err = parseTemplate("firstName", p.FirstName); err != nil {
+		return nil, err
+	}
+	if t.lastName, err = parseTemplate("lastName", p.LastName); err != nil {
+		return nil, err
+	}
+	for name, text := range p.Attributes {
+		if t.attributes[name], err = parseTemplate("attribute "+name, text); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, errors.Errorf(errors.ConfigProvisioningTemplate, name, err)
+	}
+	return t, nil
+}
+
+// execute applies the templates to an identity, the empty attributes are left out
+func (t *provisioningTemplates) execute(data *ProvisioningData) (*newUserFields, error) {
+	fields := &newUserFields{Attributes: make(map[string]string, len(t.attributes))}
+	var err error
+	if fields.Email, err = execTemplate(t.email, data); err != nil {
+		return nil, err
+	}
+	if fields.FirstName, err = execTemplate(t.firstName, data); err != nil {
+		return nil, err
+	}
+	if fields.LastName, err = execTemplate(t.lastName, data); err != nil {
+		return nil, err
+	}
+	for name, at := range t.attributes {
+		value, err := execTemplate(at, data)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			fields.Attributes[name] = value
+		}
+	}
+	return fields, nil
+}
+
+func execTemplate(t *template.Template, data *ProvisioningData) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", errors.Errorf(errors.OpenIDProvisioningTemplate, t.Name(), data.Username, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// provisioningGroups are the groups of the template, after the legacy openId.group
+func provisioningGroups(o conf.OpenIDConfig) []string {
+	if o.Group == "" {
+		return o.Provisioning.Groups
+	}
+	return append([]string{o.Group}, o.Provisioning.Groups...)
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, oneTimePasswordBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
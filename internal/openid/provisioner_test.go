@@ -0,0 +1,107 @@
+package openid
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProvisioner(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewProvisioner(conf.OpenIDConfig{})
+	assert.NoError(err)
+	assert.Nil(p)
+
+	p, err = NewProvisioner(conf.OpenIDConfig{Host: "https://keycloak.example.com"})
+	assert.NoError(err)
+	assert.IsType(&OpenidClientWrapper{}, p)
+
+	p, err = NewProvisioner(conf.OpenIDConfig{Provisioning: conf.ProvisioningConf{
+		Provider: ProviderSCIM,
+		SCIM:     conf.SCIMConf{URL: "https://scim.example.com/v2"},
+	}})
+	assert.NoError(err)
+	assert.IsType(&SCIMClient{}, p)
+
+	_, err = NewProvisioner(conf.OpenIDConfig{Provisioning: conf.ProvisioningConf{Provider: ProviderSCIM}})
+	assert.EqualError(err, "The scim provisioning provider requires openId.provisioning.scim.url")
+
+	_, err = NewProvisioner(conf.OpenIDConfig{Provisioning: conf.ProvisioningConf{Provider: "ldap"}})
+	assert.EqualError(err, "Unknown IdP provisioning provider 'ldap', must be keycloak or scim")
+
+	_, err = NewProvisioner(conf.OpenIDConfig{Host: "https://keycloak.example.com", Provisioning: conf.ProvisioningConf{LastName: "{{"}})
+	assert.Regexp("Invalid provisioning template for lastName", err)
+}
+
+func TestExecuteTemplates(t *testing.T) {
+	assert := assert.New(t)
+	templates, err := parseTemplates(conf.ProvisioningConf{
+		Email:      " {{.Attributes.email}} ",
+		Attributes: map[string]string{"type": "{{.Type}}", "empty": "{{.Attributes.none}}"},
+	})
+	assert.NoError(err)
+
+	fields, err := templates.execute(&ProvisioningData{Username: "alice", Type: "client", Attributes: map[string]string{"email": "a@example.com"}})
+	assert.NoError(err)
+	assert.Equal(&newUserFields{Email: "a@example.com", Attributes: map[string]string{"type": "client"}}, fields)
+
+	templates, err = parseTemplates(conf.ProvisioningConf{FirstName: "{{.Username.Bad}}"})
+	assert.NoError(err)
+	_, err = templates.execute(&ProvisioningData{Username: "alice"})
+	assert.Regexp("Failed to apply the provisioning template for firstName to 'alice'", err)
+}
+
+func TestKeycloakUserManagement(t *testing.T) {
+	assert := assert.New(t)
+	var updated map[string]interface{}
+	var calls []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/master/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"admin-token","token_type":"bearer","expires_in":300}`))
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// substring matches, as returned by older Keycloak versions
+		_, _ = w.Write([]byte(`[{"id":"id-2","username":"alice2"},{"id":"id-1","username":"alice","email":"alice@example.com","enabled":true}]`))
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/users/id-1", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&updated)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/users/id-1/groups/group-id", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/admin/realms/fabconnect/group-by-path/org1/members", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"group-id","path":"/org1/members"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewOpenIdClient(conf.OpenIDConfig{Host: server.URL, AdminRealm: "master", ClientRealm: "fabconnect"})
+	assert.NoError(err)
+
+	user, err := client.GetUser("alice")
+	assert.NoError(err)
+	assert.Equal(&IdPUser{ID: "id-1", Username: "alice", Email: "alice@example.com", Enabled: true}, user)
+	user, err = client.GetUser("al")
+	assert.NoError(err)
+	assert.Nil(user)
+
+	assert.NoError(client.DisableUser("id-1"))
+	assert.Equal(map[string]interface{}{"id": "id-1", "enabled": false}, updated)
+
+	assert.NoError(client.AddUserToGroup("id-1", "/org1/members"))
+	assert.NoError(client.RemoveUserFromGroup("id-1", "group-id"))
+	assert.Equal([]string{http.MethodPut, http.MethodDelete}, calls)
+
+	assert.Regexp("Failed to look up the group /unknown for the IdP user 'id-1'", client.AddUserToGroup("id-1", "/unknown"))
+	assert.Regexp("Failed to delete the user for the IdP user 'id-9'", client.DeleteUser("id-9"))
+}
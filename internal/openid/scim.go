@@ -0,0 +1,351 @@
+package openid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
+	"github.com/hyperledger/firefly-fabconnect/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scimUserSchema       = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimEnterpriseSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	scimPatchOpSchema    = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimContentType      = "application/scim+json"
+	scimRequestTimeout   = 30 * time.Second
+)
+
+// SCIMClient is the IdPProvisioner of the IdPs exposing a SCIM 2.0 service (RFC 7643 and 7644).
+// The template attributes are set in the enterprise user extension, and the realm roles in the
+// roles of the user. Group paths are looked up by the display name after the "/"
+type SCIMClient struct {
+	httpClient *http.Client
+	url        string
+	token      string
+	openIdConf conf.OpenIDConfig
+	templates  *provisioningTemplates
+}
+
+type scimUser struct {
+	Schemas    []string          `json:"schemas,omitempty"`
+	ID         string            `json:"id,omitempty"`
+	UserName   string            `json:"userName"`
+	Name       *scimName         `json:"name,omitempty"`
+	Emails     []scimValue       `json:"emails,omitempty"`
+	Roles      []scimValue       `json:"roles,omitempty"`
+	Active     *bool             `json:"active,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Enterprise map[string]string `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+}
+
+type scimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimValue struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimGroup struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+type scimListResponse struct {
+	TotalResults int               `json:"totalResults"`
+	Resources    []json.RawMessage `json:"Resources"`
+}
+
+type scimPatch struct {
+	Schemas    []string          `json:"schemas"`
+	Operations []scimPatchOpItem `json:"Operations"`
+}
+
+type scimPatchOpItem struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type scimError struct {
+	Detail string `json:"detail"`
+}
+
+func NewSCIMClient(o conf.OpenIDConfig) (*SCIMClient, error) {
+	s := o.Provisioning.SCIM
+	if s.URL == "" {
+		return nil, errors.Errorf(errors.ConfigProvisioningSCIMURL)
+	}
+	templates, err := parseTemplates(o.Provisioning)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := utils.CreateTLSConfiguration(&s.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &SCIMClient{
+		httpClient: &http.Client{Transport: transport, Timeout: scimRequestTimeout},
+		url:        strings.TrimSuffix(s.URL, "/"),
+		token:      s.Token,
+		openIdConf: o,
+		templates:  templates,
+	}, nil
+}
+
+// ProvisionUser returns the SCIM user with the username of the identity, and creates it from
+// the provisioning template when it does not exist. When the user cannot be added to a group,
+// it is deleted again
+func (s *SCIMClient) ProvisionUser(data *ProvisioningData) (*ProvisionedUser, error) {
+	existing, err := s.findUser(data.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		log.Debugf("SCIM user '%s' already exists with ID %s", data.Username, existing.ID)
+		return &ProvisionedUser{ID: existing.ID}, nil
+	}
+
+	user, err := s.newUser(data)
+	if err != nil {
+		return nil, err
+	}
+	var created scimUser
+	if err := s.do(http.MethodPost, "/Users", user, &created); err != nil {
+		return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "create the user", data.Username, err)
+	}
+	provisioned := &ProvisionedUser{ID: created.ID, Created: true, OneTimePassword: user.Password}
+	for _, group := range provisioningGroups(s.openIdConf) {
+		if err := s.addToGroup(data.Username, created.ID, group); err != nil {
+			if rollbackErr := s.do(http.MethodDelete, "/Users/"+url.PathEscape(created.ID), nil, nil); rollbackErr != nil {
+				log.Errorf("Failed to delete the partially provisioned SCIM user '%s' (%s): %s", data.Username, created.ID, rollbackErr)
+			}
+			return nil, err
+		}
+	}
+	log.Infof("Provisioned the SCIM user '%s' with ID %s", data.Username, created.ID)
+	return provisioned, nil
+}
+
+func (s *SCIMClient) GetUser(username string) (*IdPUser, error) {
+	user, err := s.findUser(username)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return user.toIdPUser(), nil
+}
+
+func (s *SCIMClient) ListUsers() ([]*IdPUser, error) {
+	var result []*IdPUser
+	// startIndex is 1-based (RFC 7644 section 3.4.2.4)
+	for startIndex := 1; ; startIndex += listPageSize {
+		var list scimListResponse
+		query := fmt.Sprintf("/Users?startIndex=%d&count=%d", startIndex, listPageSize)
+		if err := s.do(http.MethodGet, query, nil, &list); err != nil {
+			return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "list the users", "*", err)
+		}
+		for _, resource := range list.Resources {
+			var user scimUser
+			if err := json.Unmarshal(resource, &user); err != nil {
+				return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "list the users", "*", err)
+			}
+			result = append(result, user.toIdPUser())
+		}
+		if len(list.Resources) == 0 || startIndex-1+len(list.Resources) >= list.TotalResults {
+			return result, nil
+		}
+	}
+}
+
+func (s *SCIMClient) DisableUser(userID string) error {
+	patch := newSCIMPatch(scimPatchOpItem{Op: "replace", Path: "active", Value: false})
+	if err := s.do(http.MethodPatch, "/Users/"+url.PathEscape(userID), patch, nil); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "disable the user", userID, err)
+	}
+	return nil
+}
+
+func (s *SCIMClient) DeleteUser(userID string) error {
+	if err := s.do(http.MethodDelete, "/Users/"+url.PathEscape(userID), nil, nil); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "delete the user", userID, err)
+	}
+	return nil
+}
+
+func (s *SCIMClient) AddUserToGroup(userID, group string) error {
+	return s.addToGroup(userID, userID, group)
+}
+
+func (s *SCIMClient) RemoveUserFromGroup(userID, group string) error {
+	groupID, err := s.groupID(userID, group)
+	if err != nil {
+		return err
+	}
+	patch := newSCIMPatch(scimPatchOpItem{Op: "remove", Path: fmt.Sprintf("members[value eq %s]", scimQuote(userID))})
+	if err := s.do(http.MethodPatch, "/Groups/"+url.PathEscape(groupID), patch, nil); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "remove the user from the group "+group, userID, err)
+	}
+	return nil
+}
+
+func (s *SCIMClient) findUser(username string) (*scimUser, error) {
+	var list scimListResponse
+	query := "/Users?filter=" + url.QueryEscape("userName eq "+scimQuote(username))
+	if err := s.do(http.MethodGet, query, nil, &list); err != nil {
+		return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "look up the user", username, err)
+	}
+	for _, resource := range list.Resources {
+		var user scimUser
+		if err := json.Unmarshal(resource, &user); err != nil {
+			return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "look up the user", username, err)
+		}
+		// userName is case insensitive in SCIM, the identities are not
+		if user.UserName == username && user.ID != "" {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *SCIMClient) newUser(data *ProvisioningData) (*scimUser, error) {
+	p := s.openIdConf.Provisioning
+	fields, err := s.templates.execute(data)
+	if err != nil {
+		return nil, err
+	}
+	active := true
+	user := &scimUser{
+		Schemas:  []string{scimUserSchema},
+		UserName: data.Username,
+		Active:   &active,
+	}
+	if fields.FirstName != "" || fields.LastName != "" {
+		user.Name = &scimName{GivenName: fields.FirstName, FamilyName: fields.LastName}
+	}
+	if fields.Email != "" {
+		user.Emails = []scimValue{{Value: fields.Email, Primary: true}}
+	}
+	for _, role := range p.RealmRoles {
+		user.Roles = append(user.Roles, scimValue{Value: role})
+	}
+	if len(fields.Attributes) > 0 {
+		user.Schemas = append(user.Schemas, scimEnterpriseSchema)
+		user.Enterprise = fields.Attributes
+	}
+	if p.OneTimePassword {
+		if user.Password, err = generatePassword(); err != nil {
+			return nil, errors.Errorf(errors.OpenIDProvisioningFailed, "generate the one-time password", data.Username, err)
+		}
+	}
+	return user, nil
+}
+
+// addToGroup adds a user to a group, the username is only used in the errors
+func (s *SCIMClient) addToGroup(username, userID, group string) error {
+	groupID, err := s.groupID(username, group)
+	if err != nil {
+		return err
+	}
+	patch := newSCIMPatch(scimPatchOpItem{Op: "add", Path: "members", Value: []scimValue{{Value: userID}}})
+	if err := s.do(http.MethodPatch, "/Groups/"+url.PathEscape(groupID), patch, nil); err != nil {
+		return errors.Errorf(errors.OpenIDProvisioningFailed, "add the user to the group "+group, username, err)
+	}
+	return nil
+}
+
+// groupID resolves the group paths, which start with "/", by their display name
+func (s *SCIMClient) groupID(username, group string) (string, error) {
+	if !strings.HasPrefix(group, "/") {
+		return group, nil
+	}
+	name := strings.TrimPrefix(group, "/")
+	var list scimListResponse
+	query := "/Groups?filter=" + url.QueryEscape("displayName eq "+scimQuote(name))
+	if err := s.do(http.MethodGet, query, nil, &list); err != nil {
+		return "", errors.Errorf(errors.OpenIDProvisioningFailed, "look up the group "+group, username, err)
+	}
+	for _, resource := range list.Resources {
+		var g scimGroup
+		if err := json.Unmarshal(resource, &g); err == nil && g.DisplayName == name && g.ID != "" {
+			return g.ID, nil
+		}
+	}
+	return "", errors.Errorf(errors.OpenIDProvisioningFailed, "look up the group "+group, username, errors.Errorf(errors.SCIMGroupNotFound, name))
+}
+
+// do sends a request to the SCIM service, and decodes the response into result when it is set
+func (s *SCIMClient) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, s.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", scimContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", scimContentType)
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var scimErr scimError
+		_ = json.NewDecoder(res.Body).Decode(&scimErr)
+		return errors.Errorf(errors.SCIMRequestFailed, method, strings.SplitN(path, "?", 2)[0], res.StatusCode, scimErr.Detail)
+	}
+	if result == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+func (user *scimUser) toIdPUser() *IdPUser {
+	idpUser := &IdPUser{
+		ID:       user.ID,
+		Username: user.UserName,
+		Enabled:  user.Active == nil || *user.Active,
+	}
+	for _, email := range user.Emails {
+		if idpUser.Email == "" || email.Primary {
+			idpUser.Email = email.Value
+		}
+	}
+	return idpUser
+}
+
+func newSCIMPatch(ops ...scimPatchOpItem) *scimPatch {
+	return &scimPatch{Schemas: []string{scimPatchOpSchema}, Operations: ops}
+}
+
+// scimQuote quotes a filter value (RFC 7644 section 3.4.2.2)
+func scimQuote(value string) string {
+	b, _ := json.Marshal(value)
+	return string(b)
+}
@@ -0,0 +1,268 @@
+package openid
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSCIMServer is a minimal in-memory SCIM 2.0 service, with the groups "members" (g1) and
+// "admins" (g2)
+type testSCIMServer struct {
+	*httptest.Server
+	mux     sync.Mutex
+	nextID  int
+	users   map[string]map[string]interface{}
+	members map[string][]string
+}
+
+var scimFilter = regexp.MustCompile(`^(userName|displayName) eq "(.*)"$`)
+
+func newTestSCIMServer() *testSCIMServer {
+	s := &testSCIMServer{
+		users:   map[string]map[string]interface{}{},
+		members: map[string][]string{"g1": {}, "g2": {}},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *testSCIMServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	w.Header().Set("Content-Type", scimContentType)
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		s.fail(w, http.StatusUnauthorized, "bad token")
+		return
+	}
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/scim/v2/"), "/")
+	switch {
+	case r.Method == http.MethodGet && segments[0] == "Users":
+		match := scimFilter.FindStringSubmatch(r.URL.Query().Get("filter"))
+		resources := []interface{}{}
+		for _, user := range s.users {
+			if strings.EqualFold(user["userName"].(string), match[2]) {
+				resources = append(resources, user)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"totalResults": len(resources), "Resources": resources})
+	case r.Method == http.MethodPost && segments[0] == "Users":
+		var user map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&user)
+		if user["userName"] == "conflict" {
+			s.fail(w, http.StatusConflict, "userName is already taken")
+			return
+		}
+		s.nextID++
+		user["id"] = fmt.Sprintf("u%d", s.nextID)
+		s.users[user["id"].(string)] = user
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(user)
+	case r.Method == http.MethodPatch && segments[0] == "Users":
+		user, ok := s.users[segments[1]]
+		if !ok {
+			s.fail(w, http.StatusNotFound, "no user")
+			return
+		}
+		var patch scimPatch
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		for _, op := range patch.Operations {
+			if op.Op == "replace" {
+				user[op.Path] = op.Value
+			}
+		}
+		_ = json.NewEncoder(w).Encode(user)
+	case r.Method == http.MethodDelete && segments[0] == "Users":
+		if _, ok := s.users[segments[1]]; !ok {
+			s.fail(w, http.StatusNotFound, "no user")
+			return
+		}
+		delete(s.users, segments[1])
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && segments[0] == "Groups":
+		match := scimFilter.FindStringSubmatch(r.URL.Query().Get("filter"))
+		resources := []interface{}{}
+		for id, name := range map[string]string{"g1": "members", "g2": "admins"} {
+			if name == match[2] {
+				resources = append(resources, map[string]string{"id": id, "displayName": name})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"totalResults": len(resources), "Resources": resources})
+	case r.Method == http.MethodPatch && segments[0] == "Groups":
+		members, ok := s.members[segments[1]]
+		if !ok {
+			s.fail(w, http.StatusNotFound, "no group")
+			return
+		}
+		var patch scimPatch
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		for _, op := range patch.Operations {
+			switch op.Op {
+			case "add":
+				for _, value := range op.Value.([]interface{}) {
+					members = append(members, value.(map[string]interface{})["value"].(string))
+				}
+			case "remove":
+				remaining := []string{}
+				for _, m := range members {
+					if op.Path != fmt.Sprintf(`members[value eq "%s"]`, m) {
+						remaining = append(remaining, m)
+					}
+				}
+				members = remaining
+			}
+		}
+		s.members[segments[1]] = members
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+
+func (s *testSCIMServer) fail(w http.ResponseWriter, status int, detail string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": fmt.Sprint(status), "detail": detail})
+}
+
+func newTestSCIMClient(t *testing.T, server *testSCIMServer, provisioning conf.ProvisioningConf) *SCIMClient {
+	provisioning.Provider = ProviderSCIM
+	provisioning.SCIM = conf.SCIMConf{URL: server.URL + "/scim/v2/", Token: "test-token"}
+	client, err := NewProvisioner(conf.OpenIDConfig{Provisioning: provisioning})
+	assert.NoError(t, err)
+	return client.(*SCIMClient)
+}
+
+func TestSCIMProvisionUser(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestSCIMServer()
+	defer server.Close()
+	client := newTestSCIMClient(t, server, conf.ProvisioningConf{
+		Email:           "{{.Username}}@example.com",
+		FirstName:       "{{.Username}}",
+		LastName:        "{{.Attributes.surname}}",
+		Attributes:      map[string]string{"organization": "{{.Affiliation}}", "department": "{{.Attributes.missing}}"},
+		Groups:          []string{"/members", "g2"},
+		RealmRoles:      []string{"fabric-client"},
+		OneTimePassword: true,
+	})
+
+	user, err := client.ProvisionUser(&ProvisioningData{
+		Username:    "alice",
+		Affiliation: "org1",
+		Attributes:  map[string]string{"surname": "Smith"},
+	})
+	assert.NoError(err)
+	assert.Equal("u1", user.ID)
+	assert.True(user.Created)
+	assert.Len(user.OneTimePassword, 22)
+
+	created := server.users["u1"]
+	assert.Equal("alice", created["userName"])
+	assert.Equal(map[string]interface{}{"givenName": "alice", "familyName": "Smith"}, created["name"])
+	assert.Equal([]interface{}{map[string]interface{}{"value": "alice@example.com", "primary": true}}, created["emails"])
+	assert.Equal([]interface{}{map[string]interface{}{"value": "fabric-client"}}, created["roles"])
+	assert.Equal(map[string]interface{}{"organization": "org1"}, created[scimEnterpriseSchema])
+	assert.Equal(user.OneTimePassword, created["password"])
+	assert.Equal([]string{"u1"}, server.members["g1"])
+	assert.Equal([]string{"u1"}, server.members["g2"])
+
+	// the existing user is returned as is
+	user, err = client.ProvisionUser(&ProvisioningData{Username: "alice"})
+	assert.NoError(err)
+	assert.Equal(&ProvisionedUser{ID: "u1"}, user)
+	assert.Len(server.users, 1)
+}
+
+func TestSCIMProvisionUserRollback(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestSCIMServer()
+	defer server.Close()
+	client := newTestSCIMClient(t, server, conf.ProvisioningConf{Groups: []string{"g1", "/unknown"}})
+
+	_, err := client.ProvisionUser(&ProvisioningData{Username: "alice"})
+	assert.EqualError(err, "Failed to look up the group /unknown for the IdP user 'alice': No SCIM group named 'unknown'")
+	assert.Empty(server.users)
+}
+
+func TestSCIMProvisionUserCreateFails(t *testing.T) {
+	server := newTestSCIMServer()
+	defer server.Close()
+	client := newTestSCIMClient(t, server, conf.ProvisioningConf{})
+
+	_, err := client.ProvisionUser(&ProvisioningData{Username: "conflict"})
+	assert.EqualError(t, err, "Failed to create the user for the IdP user 'conflict': SCIM request POST /Users failed with status 409: userName is already taken")
+}
+
+func TestSCIMBadToken(t *testing.T) {
+	server := newTestSCIMServer()
+	defer server.Close()
+	client := newTestSCIMClient(t, server, conf.ProvisioningConf{})
+	client.token = "wrong"
+
+	_, err := client.GetUser("alice")
+	assert.Regexp(t, "SCIM request GET /Users failed with status 401: bad token", err)
+}
+
+func TestSCIMGetDisableDeleteUser(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestSCIMServer()
+	defer server.Close()
+	client := newTestSCIMClient(t, server, conf.ProvisioningConf{Email: "{{.Username}}@example.com"})
+
+	user, err := client.GetUser("bob")
+	assert.NoError(err)
+	assert.Nil(user)
+
+	provisioned, err := client.ProvisionUser(&ProvisioningData{Username: "bob"})
+	assert.NoError(err)
+	user, err = client.GetUser("bob")
+	assert.NoError(err)
+	assert.Equal(&IdPUser{ID: provisioned.ID, Username: "bob", Email: "bob@example.com", Enabled: true}, user)
+	// userName is matched case insensitively by the service, but not by the client
+	user, err = client.GetUser("BOB")
+	assert.NoError(err)
+	assert.Nil(user)
+
+	err = client.DisableUser(provisioned.ID)
+	assert.NoError(err)
+	user, err = client.GetUser("bob")
+	assert.NoError(err)
+	assert.False(user.Enabled)
+
+	err = client.DeleteUser(provisioned.ID)
+	assert.NoError(err)
+	user, err = client.GetUser("bob")
+	assert.NoError(err)
+	assert.Nil(user)
+
+	err = client.DeleteUser(provisioned.ID)
+	assert.EqualError(err, "Failed to delete the user for the IdP user '"+provisioned.ID+"': SCIM request DELETE /Users/"+provisioned.ID+" failed with status 404: no user")
+	err = client.DisableUser(provisioned.ID)
+	assert.Regexp("status 404", err)
+}
+
+func TestSCIMGroupMembership(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestSCIMServer()
+	defer server.Close()
+	client := newTestSCIMClient(t, server, conf.ProvisioningConf{})
+
+	assert.NoError(client.AddUserToGroup("u1", "/admins"))
+	assert.NoError(client.AddUserToGroup("u2", "g2"))
+	assert.Equal([]string{"u1", "u2"}, server.members["g2"])
+
+	assert.NoError(client.RemoveUserFromGroup("u1", "/admins"))
+	assert.Equal([]string{"u2"}, server.members["g2"])
+
+	assert.Regexp("No SCIM group named 'unknown'", client.RemoveUserFromGroup("u1", "/unknown"))
+	assert.Regexp("status 404: no group", client.AddUserToGroup("u1", "g3"))
+	assert.Regexp("status 404: no group", client.RemoveUserFromGroup("u1", "g3"))
+}
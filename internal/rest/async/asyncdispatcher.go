@@ -98,7 +98,7 @@ func (d *asyncDispatcher) Close() {
 
 func (w *asyncDispatcher) processMsg(ctx context.Context, msg *messages.SendTransaction, ack bool) (*messages.AsyncSentMsg, int, error) {
 	switch msg.Headers.MsgType {
-	case messages.MsgTypeDeployContract, messages.MsgTypeSendTransaction:
+	case messages.MsgTypeDeployContract, messages.MsgTypeSendTransaction, messages.MsgTypeSubmitTransaction:
 		if msg.Headers.Signer == "" {
 			return nil, 400, errors.Errorf(errors.RequestHandlerInvalidMsgSignerMissing)
 		}
@@ -96,6 +96,9 @@ func (t *msgContext) Reply(replyMessage messages.ReplyWithHeaders) {
 	replyHeaders.ID = utils.UUIDv4()
 	replyHeaders.Context = t.headers.Context
 	replyHeaders.ReqID = t.headers.ID
+	replyHeaders.Subject = t.headers.Subject
+	replyHeaders.Caller = t.headers.Caller
+	replyHeaders.Signer = t.headers.Signer
 	replyHeaders.Received = t.timeReceived.UTC().Format(time.RFC3339Nano)
 	replyTime := time.Now().UTC()
 	replyHeaders.Elapsed = replyTime.Sub(t.timeReceived).Seconds()
@@ -18,7 +18,9 @@ package identity
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/hyperledger/firefly-fabconnect/internal/messages"
 	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
 	"github.com/julienschmidt/httprouter"
 )
@@ -33,7 +35,55 @@ type Identity struct {
 	Organization   string            `json:"organization,omitempty"`
 	MSPID          string            `json:"mspId,omitempty"`
 	EnrollmentCert []byte            `json:"enrollmentCert,omitempty"`
-	CACert         []byte            `json:"caCert,omitempty"`
+	// CertExpiry is the NotAfter of the enrollment certificate
+	CertExpiry *time.Time `json:"certExpiry,omitempty"`
+	CACert     []byte     `json:"caCert,omitempty"`
+}
+
+// CurrentIdentity is the signing identity the caller of GET /identities/me signs with
+type CurrentIdentity struct {
+	Identity
+	// Username is the authenticated caller, which may sign with a mapped identity
+	Username    string       `json:"username,omitempty"`
+	Certificate *Certificate `json:"certificate,omitempty"`
+}
+
+// Certificate is the decoded X.509 enrollment certificate of an identity
+type Certificate struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	Serial         string    `json:"serial"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	// Attributes are the hf.* and custom attributes of the Fabric attribute extension
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// IssuedCertificate is a certificate issued by the Fabric CA
+type IssuedCertificate struct {
+	Certificate
+	// EnrollmentID is the common name of the certificate
+	EnrollmentID string `json:"enrollmentId"`
+	// AKI is the authority key identifier, which with the serial identifies the certificate in the CA
+	AKI     string `json:"aki"`
+	Expired bool   `json:"expired"`
+	PEM     string `json:"pem"`
+}
+
+// The encodings of the CRL
+const (
+	CRLFormatPEM = "pem"
+	CRLFormatDER = "der"
+)
+
+// CRL is a certificate revocation list generated by the Fabric CA
+type CRL struct {
+	Format string
+	Data   []byte
 }
 
 type RegisterResponse struct {
@@ -47,6 +97,9 @@ type EnrollRequest struct {
 	CAName   string          `json:"caname"`
 	Profile  string          `json:"profile"`
 	AttrReqs map[string]bool `json:"attributes"`
+	// CSR is the PEM certificate signing request of an external identity, which keeps its
+	// private key and signs its transactions itself
+	CSR string `json:"csr,omitempty"`
 }
 
 type RevokeRequest struct {
@@ -59,13 +112,163 @@ type RevokeRequest struct {
 type IdentityResponse struct {
 	Name    string `json:"name"`
 	Success bool   `json:"success"`
+	// OneTimePassword is the temporary password of the IdP user created for the identity. It is
+	// only returned by the request that created the user
+	OneTimePassword string `json:"oneTimePassword,omitempty"`
+	// Certificate and CAChain are the PEM enrollment certificate and CA chain of an external
+	// identity enrolled with a CSR
+	Certificate string `json:"certificate,omitempty"`
+	CAChain     string `json:"caChain,omitempty"`
+	External    bool   `json:"external,omitempty"`
 }
 
 type RevokeResponse struct {
 	RevokedCerts []map[string]string `json:"revokedCerts"`
 	CRL          []byte              `json:"CRL"`
+	// IdPUserDisabled is set when the IdP user of the identity is disabled
+	IdPUserDisabled bool `json:"idpUserDisabled"`
+}
+
+type RemoveResponse struct {
+	Name string `json:"name"`
+	// IdPUserRemoved is set when the IdP user of the identity was deleted
+	IdPUserRemoved bool `json:"idpUserRemoved"`
 }
 
+// Affiliation is a node of the affiliation tree of the CA, with its child affiliations and the
+// identities directly under it
+type Affiliation struct {
+	Name         string         `json:"name"`
+	Affiliations []*Affiliation `json:"affiliations,omitempty"`
+	Identities   []*Identity    `json:"identities,omitempty"`
+	CAName       string         `json:"caname,omitempty"`
+}
+
+type AffiliationRequest struct {
+	Name   string `json:"name"`
+	CAName string `json:"caname"`
+	// Force creates the missing parent affiliations when adding, and updates or removes the
+	// child affiliations and identities when modifying or removing
+	Force bool `json:"force"`
+}
+
+// The kinds of drift between the CA identities and the IdP users
+const (
+	// DriftIdPUserWithoutIdentity is an enabled IdP user with no CA identity
+	DriftIdPUserWithoutIdentity = "idpUserWithoutIdentity"
+	// DriftRevokedIdentityEnabled is a revoked CA identity whose IdP user is enabled
+	DriftRevokedIdentityEnabled = "revokedIdentityEnabled"
+	// DriftIdentityWithoutIdPUser is a CA identity with no IdP user
+	DriftIdentityWithoutIdPUser = "identityWithoutIdPUser"
+)
+
+type Drift struct {
+	Username  string `json:"username"`
+	Kind      string `json:"kind"`
+	IdPUserID string `json:"idpUserId,omitempty"`
+	// Fixed is set when the IdP user was disabled
+	Fixed bool   `json:"fixed"`
+	Error string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	Identities int      `json:"identities"`
+	IdPUsers   int      `json:"idpUsers"`
+	Drifts     []*Drift `json:"drifts"`
+}
+
+// WalletIdentity is an X.509 identity the gateway signs with, enrolled by the gateway or imported
+type WalletIdentity struct {
+	Name        string `json:"name"`
+	MSPID       string `json:"mspId"`
+	Certificate string `json:"certificate,omitempty"`
+	// CertExpiry is the NotAfter of the certificate
+	CertExpiry *time.Time `json:"certExpiry,omitempty"`
+	// HasPrivateKey is set when the keystore of the gateway holds the private key of the certificate
+	HasPrivateKey bool   `json:"hasPrivateKey"`
+	CACert        string `json:"caCert,omitempty"`
+}
+
+// ImportRequest is an identity enrolled outside the gateway, for example with the fabric-ca-client
+type ImportRequest struct {
+	Name  string `json:"name"`
+	MSPID string `json:"mspId"`
+	// Certificate and PrivateKey are PEM encoded, the key is an ECDSA key in PKCS#8 or SEC 1 form
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"privateKey"`
+}
+
+// BulkIdentity is a row of a bulk provisioning request
+type BulkIdentity struct {
+	Name string `json:"name"`
+	// Secret is generated by the CA when empty
+	Secret         string            `json:"secret,omitempty"`
+	Type           string            `json:"type,omitempty"`
+	Affiliation    string            `json:"affiliation,omitempty"`
+	MaxEnrollments int               `json:"maxEnrollments,omitempty"`
+	Attributes     map[string]string `json:"attributes,omitempty"`
+	CAName         string            `json:"caname,omitempty"`
+}
+
+// The states of the rows of a bulk provisioning job
+const (
+	BulkRowPending   = "pending"
+	BulkRowSucceeded = "succeeded"
+	BulkRowFailed    = "failed"
+)
+
+// BulkRowResult is the result of a row of a bulk provisioning job
+type BulkRowResult struct {
+	// Row is the index of the identity in the request, from 1
+	Row    int    `json:"row"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Registered, Enrolled and IdPUserID record the steps already done, which are skipped when
+	// the job is resumed
+	Registered bool   `json:"registered"`
+	Enrolled   bool   `json:"enrolled"`
+	IdPUserID  string `json:"idpUserId,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BulkIdentitySecret holds the secrets generated for an identity of a bulk provisioning job
+type BulkIdentitySecret struct {
+	Name string `json:"name"`
+	// Secret is the enrollment secret generated by the CA
+	Secret          string `json:"secret,omitempty"`
+	OneTimePassword string `json:"oneTimePassword,omitempty"`
+}
+
+// BulkJobSecrets are the secrets a bulk provisioning job generated since they were last returned.
+// They are never written to the receipt of the job, and each one is only returned once
+type BulkJobSecrets struct {
+	Job        string                `json:"job"`
+	Identities []*BulkIdentitySecret `json:"identities"`
+}
+
+// BulkJobReceipt is the receipt of a bulk provisioning job, replaced as the rows are processed
+type BulkJobReceipt struct {
+	messages.ReplyCommon
+	Enroll    bool             `json:"enroll"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Rows      []*BulkRowResult `json:"rows"`
+}
+
+// ReceiptStore keeps the receipts the bulk provisioning jobs report their progress in
+type ReceiptStore interface {
+	UpdateReceipt(msgBytes []byte)
+	LookupReceipt(requestID string) (*map[string]interface{}, error)
+}
+
+// The administration operations of the REST gateway authorized with AuthorizeAdmin
+const (
+	AdminOpReadSignerMappings  = "readSignerMappings"
+	AdminOpPutSignerMapping    = "putSignerMapping"
+	AdminOpDeleteSignerMapping = "deleteSignerMapping"
+)
+
 type IdentityClient interface {
 	Register(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*RegisterResponse, *restutil.RestError)
 	Modify(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*RegisterResponse, *restutil.RestError)
@@ -74,4 +277,30 @@ type IdentityClient interface {
 	Revoke(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*RevokeResponse, *restutil.RestError)
 	List(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*Identity, *restutil.RestError)
 	Get(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*Identity, *restutil.RestError)
+	GetCurrent(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*CurrentIdentity, *restutil.RestError)
+	Remove(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*RemoveResponse, *restutil.RestError)
+	ListAffiliations(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*Affiliation, *restutil.RestError)
+	GetAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*Affiliation, *restutil.RestError)
+	AddAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*Affiliation, *restutil.RestError)
+	ModifyAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*Affiliation, *restutil.RestError)
+	RemoveAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*Affiliation, *restutil.RestError)
+	Reconcile(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*ReconcileReport, *restutil.RestError)
+	ImportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*WalletIdentity, *restutil.RestError)
+	ExportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*WalletIdentity, *restutil.RestError)
+	ListWallet(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*WalletIdentity, *restutil.RestError)
+	ListCertificates(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*IssuedCertificate, *restutil.RestError)
+	GetCRL(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*CRL, *restutil.RestError)
+	// BulkProvision starts a job registering the identities of the request, and returns its ID
+	BulkProvision(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*messages.AsyncSentMsg, *restutil.RestError)
+	// BulkSecrets returns the secrets generated by a bulk provisioning job, and forgets them
+	BulkSecrets(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*BulkJobSecrets, *restutil.RestError)
+	// SetReceiptStore sets the store the bulk provisioning jobs report their progress to
+	SetReceiptStore(ReceiptStore)
+	// AuthorizeAdmin checks the caller may run an administration operation the client does not
+	// serve itself, such as managing the signer mappings. Denials are audited
+	AuthorizeAdmin(req *http.Request, operation, target string) *restutil.RestError
+	// AuditAdmin records the result of an operation authorized by AuthorizeAdmin
+	AuditAdmin(req *http.Request, operation, target string, restErr *restutil.RestError)
+	// Close stops the background jobs of the client
+	Close()
 }
@@ -23,5 +23,7 @@ type ReceiptStorePersistence interface {
 	GetReceipts(skip, limit int, ids []string, sinceEpochMS int64, from, to, start string) (*[]map[string]interface{}, error)
 	GetReceipt(requestID string) (*map[string]interface{}, error)
 	AddReceipt(requestID string, receipt *map[string]interface{}) error
+	// UpdateReceipt replaces the receipt of a request, which becomes the most recent, or adds it
+	UpdateReceipt(requestID string, receipt *map[string]interface{}) error
 	Close()
 }
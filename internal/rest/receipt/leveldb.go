@@ -109,6 +109,31 @@ func (l *levelDBReceipts) AddReceipt(requestID string, receipt *map[string]inter
 	return err
 }
 
+// UpdateReceipt deletes the existing receipt of the request and its index entries, so the receipt
+// is added again as the most recent
+func (l *levelDBReceipts) UpdateReceipt(requestID string, receipt *map[string]interface{}) error {
+	val, err := l.store.Get(requestID)
+	if err != nil && err != kvstore.ErrorNotFound {
+		return errors.Errorf(errors.LevelDBFailedRetriveOriginalKey, requestID, err)
+	}
+	if err == nil {
+		lookupKey := string(val)
+		existing := make(map[string]interface{})
+		if content, err := l.store.Get(lookupKey); err == nil && json.Unmarshal(content, &existing) == nil {
+			_ = l.store.Delete(fmt.Sprintf("from:%s:%s", existing["from"], lookupKey))
+			if to, ok := existing["to"]; ok && to != "" {
+				_ = l.store.Delete(fmt.Sprintf("to:%s:%s", to, lookupKey))
+			}
+			// the numbers of the stored receipt are decoded as floats
+			if receivedAt, ok := existing["receivedAt"].(float64); ok {
+				_ = l.store.Delete(fmt.Sprintf("receivedAt:%d:%s", int64(receivedAt), lookupKey))
+			}
+		}
+		_ = l.store.Delete(lookupKey)
+	}
+	return l.AddReceipt(requestID, receipt)
+}
+
 // GetReceipts Returns recent receipts with skip, limit and other query parameters
 func (l *levelDBReceipts) GetReceipts(skip, limit int, ids []string, sinceEpochMS int64, from, to, start string) (*[]map[string]interface{}, error) {
 	// the application of the parameters are implemented to match mongo queries:
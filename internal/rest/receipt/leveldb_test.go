@@ -585,3 +585,41 @@ func TestGetReceiptsByLookupUnmarshalFail(t *testing.T) {
 	results := r.getReceiptsByLookupKey([]string{"key1", "key2"}, 1)
 	assert.Empty(results)
 }
+
+func TestLevelDBReceiptsUpdateReceipt(t *testing.T) {
+	assert := assert.New(t)
+
+	_, testConfig := test.Setup()
+	testConfig.Receipts.LevelDB.Path = path.Join(tmpdir, "update")
+	r := newLevelDBReceipts(&testConfig.Receipts)
+	_ = r.Init()
+	defer r.store.Close()
+
+	for i := 0; i < 2; i++ {
+		receipt := map[string]interface{}{"_id": fmt.Sprintf("r%d", i), "from": "addr1", "receivedAt": int64(1000 + i)}
+		assert.NoError(r.AddReceipt(fmt.Sprintf("r%d", i), &receipt))
+	}
+	updated := map[string]interface{}{"_id": "r0", "from": "addr1", "receivedAt": int64(2000), "prop1": "value1"}
+	assert.NoError(r.UpdateReceipt("r0", &updated))
+	created := map[string]interface{}{"_id": "r2", "receivedAt": int64(3000)}
+	assert.NoError(r.UpdateReceipt("r2", &created))
+
+	results, err := r.GetReceipts(0, 10, nil, 0, "", "", "")
+	assert.NoError(err)
+	assert.Equal(3, len(*results))
+	assert.Equal("r2", (*results)[0]["_id"])
+	assert.Equal("r0", (*results)[1]["_id"])
+	assert.Equal("value1", (*results)[1]["prop1"])
+
+	results, err = r.GetReceipts(0, 10, nil, 0, "addr1", "", "")
+	assert.NoError(err)
+	assert.Equal(2, len(*results))
+
+	results, err = r.GetReceipts(0, 10, nil, 1500, "", "", "")
+	assert.NoError(err)
+	assert.Equal(2, len(*results))
+
+	receipt, err := r.GetReceipt("r0")
+	assert.NoError(err)
+	assert.Equal("value1", (*receipt)["prop1"])
+}
@@ -96,4 +96,16 @@ func (m *memoryReceipts) AddReceipt(requestID string, receipt *map[string]interf
 	return nil
 }
 
+func (m *memoryReceipts) UpdateReceipt(requestID string, receipt *map[string]interface{}) error {
+	m.mux.Lock()
+	for curElem := m.receipts.Front(); curElem != nil; curElem = curElem.Next() {
+		if id, exists := (*curElem.Value.(*map[string]interface{}))["_id"]; exists && id == requestID {
+			m.receipts.Remove(curElem)
+			break
+		}
+	}
+	m.mux.Unlock()
+	return m.AddReceipt(requestID, receipt)
+}
+
 func (m *memoryReceipts) Close() {}
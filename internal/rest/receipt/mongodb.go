@@ -40,6 +40,7 @@ type MongoDatabase interface {
 // MongoCollection is the subset of mgo that we use, allowing stubbing
 type MongoCollection interface {
 	Insert(...interface{}) error
+	UpsertId(id interface{}, update interface{}) (*mgo.ChangeInfo, error)
 	Create(info *mgo.CollectionInfo) error
 	EnsureIndex(index mgo.Index) error
 	Find(query interface{}) MongoQuery
@@ -75,6 +76,10 @@ func (m *collWrapper) Insert(docs ...interface{}) error {
 	return m.coll.Insert(docs...)
 }
 
+func (m *collWrapper) UpsertId(id interface{}, update interface{}) (*mgo.ChangeInfo, error) {
+	return m.coll.UpsertId(id, update)
+}
+
 func (m *collWrapper) Create(info *mgo.CollectionInfo) error {
 	return m.coll.Create(info)
 }
@@ -150,6 +155,12 @@ func (m *mongoReceipts) AddReceipt(requestID string, receipt *map[string]interfa
 	return m.collection.Insert(*receipt)
 }
 
+// UpdateReceipt replaces the receipt of a request, which is sorted by its new receivedAt
+func (m *mongoReceipts) UpdateReceipt(requestID string, receipt *map[string]interface{}) (err error) {
+	_, err = m.collection.UpsertId(requestID, *receipt)
+	return err
+}
+
 // GetReceipts Returns recent receipts with skip & limit
 func (m *mongoReceipts) GetReceipts(skip, limit int, ids []string, sinceEpochMS int64, from, to, start string) (*[]map[string]interface{}, error) {
 	filter := bson.M{}
@@ -46,6 +46,7 @@ func (m *mockMongo) GetCollection(database string, collection string) MongoColle
 
 type mockCollection struct {
 	inserted       map[string]interface{}
+	upserted       map[string]interface{}
 	insertErr      error
 	collInfo       *mgo.CollectionInfo
 	collErr        error
@@ -59,6 +60,11 @@ func (m *mockCollection) Insert(payloads ...interface{}) error {
 	return m.insertErr
 }
 
+func (m *mockCollection) UpsertId(id interface{}, update interface{}) (*mgo.ChangeInfo, error) {
+	m.upserted = update.(map[string]interface{})
+	return &mgo.ChangeInfo{}, m.insertErr
+}
+
 func (m *mockCollection) Create(info *mgo.CollectionInfo) error {
 	m.collInfo = info
 	return m.collErr
@@ -385,3 +391,25 @@ func TestMongoReceiptsGetReceiptError(t *testing.T) {
 	_, err = r.GetReceipt("receipt1")
 	assert.Regexp("pop", err)
 }
+
+func TestMongoReceiptsUpdateReceipt(t *testing.T) {
+	assert := assert.New(t)
+
+	mgoMock := &mockMongo{}
+	_, testConfig := test.Setup()
+	r := &mongoReceipts{
+		config: &testConfig.Receipts,
+		mgo:    mgoMock,
+	}
+
+	err := r.Init()
+	assert.NoError(err)
+	receipt := map[string]interface{}{"_id": "key"}
+	err = r.UpdateReceipt("key", &receipt)
+	assert.NoError(err)
+	assert.Equal("key", mgoMock.collection.upserted["_id"])
+
+	mgoMock.collection.insertErr = fmt.Errorf("pop")
+	err = r.UpdateReceipt("key", &receipt)
+	assert.Regexp("pop", err)
+}
@@ -47,6 +47,8 @@ type ReceiptStore interface {
 	Init(ws.WebSocketChannels, ...api.ReceiptStorePersistence) error
 	ValidateConf() error
 	ProcessReceipt(msgBytes []byte)
+	UpdateReceipt(msgBytes []byte)
+	LookupReceipt(requestID string) (*map[string]interface{}, error)
 	GetReceipts(res http.ResponseWriter, req *http.Request, params httprouter.Params)
 	GetReceipt(res http.ResponseWriter, req *http.Request, params httprouter.Params)
 	Close()
@@ -108,26 +110,59 @@ func (r *receiptStore) extractHeaders(parsedMsg map[string]interface{}) map[stri
 }
 
 func (r *receiptStore) ProcessReceipt(msgBytes []byte) {
+	requestID, parsedMsg := r.parseReceipt(msgBytes)
+
+	// Insert the receipt into persistence - captures errors
+	if requestID != "" && r.persistence != nil {
+		r.writeReceipt(requestID, parsedMsg)
+	}
+
+}
+
+// UpdateReceipt replaces the receipt of a long running request, to report its progress. The
+// failures are logged, as the next update replaces the receipt again
+func (r *receiptStore) UpdateReceipt(msgBytes []byte) {
+	requestID, parsedMsg := r.parseReceipt(msgBytes)
+	if requestID == "" || r.persistence == nil {
+		return
+	}
+	if err := r.persistence.UpdateReceipt(requestID, &parsedMsg); err != nil {
+		log.Errorf("%s: Failed to update the receipt: %s", requestID, err)
+		return
+	}
+	if r.ws != nil {
+		r.ws.SendReply(parsedMsg)
+	}
+}
+
+// LookupReceipt returns the receipt of a request, or nil when there is none
+func (r *receiptStore) LookupReceipt(requestID string) (*map[string]interface{}, error) {
+	return r.persistence.GetReceipt(requestID)
+}
+
+// parseReceipt parses a reply message into the receipt of its request, the request ID is empty
+// when the message is not valid
+func (r *receiptStore) parseReceipt(msgBytes []byte) (string, map[string]interface{}) {
 
 	// Parse the reply as JSON
 	var parsedMsg map[string]interface{}
 	if err := json.Unmarshal(msgBytes, &parsedMsg); err != nil {
 		log.Errorf("Unable to unmarshal reply message '%s' as JSON: %s", string(msgBytes), err)
-		return
+		return "", nil
 	}
 
 	// Extract the headers
 	headers := r.extractHeaders(parsedMsg)
 	if headers == nil {
 		log.Errorf("Failed to extract request headers from '%+v'", parsedMsg)
-		return
+		return "", nil
 	}
 
 	// The one field we require is the original ID (as it's the key in MongoDB)
 	requestID := utils.GetMapString(headers, "requestId")
 	if requestID == "" {
 		log.Errorf("Failed to extract headers.requestId from '%+v'", parsedMsg)
-		return
+		return "", nil
 	}
 	reqOffset := utils.GetMapString(headers, "reqOffset")
 	msgType := utils.GetMapString(headers, "type")
@@ -141,12 +176,7 @@ func (r *receiptStore) ProcessReceipt(msgBytes []byte) {
 
 	parsedMsg["receivedAt"] = time.Now().UnixNano() / int64(time.Millisecond)
 	parsedMsg["_id"] = requestID
-
-	// Insert the receipt into persistence - captures errors
-	if requestID != "" && r.persistence != nil {
-		r.writeReceipt(requestID, parsedMsg)
-	}
-
+	return requestID, parsedMsg
 }
 
 func (r *receiptStore) writeReceipt(requestID string, receipt map[string]interface{}) {
@@ -196,7 +226,7 @@ func (r *receiptStore) GetReceipts(res http.ResponseWriter, req *http.Request, p
 	err := auth.AuthListAsyncReplies(req.Context())
 	if err != nil {
 		log.Errorf("Error querying replies: %s", err)
-		errors.RestErrReply(res, req, errors.Errorf(errors.Unauthorized), 401)
+		errors.RestErrReply(res, req, err, 403)
 		return
 	}
 
@@ -284,7 +314,7 @@ func (r *receiptStore) GetReceipt(res http.ResponseWriter, req *http.Request, pa
 	err := auth.AuthReadAsyncReplyByUUID(req.Context())
 	if err != nil {
 		log.Errorf("Error querying reply: %s", err)
-		errors.RestErrReply(res, req, errors.Errorf(errors.Unauthorized), 401)
+		errors.RestErrReply(res, req, err, 403)
 		return
 	}
 
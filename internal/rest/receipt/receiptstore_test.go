@@ -227,3 +227,59 @@ func TestMemStoreLimit(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal("reply5", (*result2)["_id"])
 }
+
+func TestReplyProcessorUpdateReceipt(t *testing.T) {
+	assert := assert.New(t)
+	r, p := newReceiptsTestStore()
+	ws := &mockws.WebSocketChannels{}
+	ws.On("SendReply", mock.Anything).Return()
+	r.ws = ws
+
+	replyMsg := &messages.ReplyCommon{}
+	replyMsg.Headers.MsgType = messages.MsgTypeBulkIdentityProgress
+	replyMsg.Headers.ReqID = "job1"
+	replyMsgBytes, _ := json.Marshal(&replyMsg)
+	r.UpdateReceipt(replyMsgBytes)
+	replyMsg.Headers.MsgType = messages.MsgTypeBulkIdentitySuccess
+	replyMsgBytes, _ = json.Marshal(&replyMsg)
+	r.UpdateReceipt(replyMsgBytes)
+	r.UpdateReceipt([]byte("!json"))
+
+	assert.Equal(1, p.receipts.Len())
+	ws.AssertNumberOfCalls(t, "SendReply", 2)
+	receipt, err := r.LookupReceipt("job1")
+	assert.NoError(err)
+	assert.Equal(messages.MsgTypeBulkIdentitySuccess, (*receipt)["headers"].(map[string]interface{})["type"])
+}
+
+func TestReplyProcessorUpdateReceiptError(t *testing.T) {
+	r, _ := newReceiptsTestStore()
+	p := &mockreceiptapi.ReceiptStorePersistence{}
+	p.On("UpdateReceipt", "job1", mock.Anything).Return(fmt.Errorf("bang!"))
+	r.persistence = p
+	ws := &mockws.WebSocketChannels{}
+	r.ws = ws
+
+	r.UpdateReceipt([]byte(`{"headers":{"requestId":"job1"}}`))
+	p.AssertExpectations(t)
+	ws.AssertNotCalled(t, "SendReply", mock.Anything)
+}
+
+func TestMemStoreUpdateReceipt(t *testing.T) {
+	assert := assert.New(t)
+	r, p := newReceiptsTestStore()
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		fakeReply := map[string]interface{}{"_id": fmt.Sprintf("reply%d", i)}
+		_ = p.AddReceipt("_id", &fakeReply)
+	}
+	updated := map[string]interface{}{"_id": "reply0", "prop1": "value1"}
+	assert.NoError(p.UpdateReceipt("reply0", &updated))
+
+	results, err := p.GetReceipts(0, 10, []string{}, 0, "", "", "")
+	assert.NoError(err)
+	assert.Equal(3, len(*results))
+	assert.Equal("reply0", (*results)[0]["_id"])
+	assert.Equal("value1", (*results)[0]["prop1"])
+}
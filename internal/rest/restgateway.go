@@ -18,6 +18,7 @@ package rest
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
@@ -26,14 +27,17 @@ import (
 	"syscall"
 	"time"
 
-	//"crypto/tls"
 	//"crypto/x509"
 
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/policy"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/signermap"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/hyperledger/firefly-fabconnect/internal/events"
 	"github.com/hyperledger/firefly-fabconnect/internal/fabric/client"
 	restasync "github.com/hyperledger/firefly-fabconnect/internal/rest/async"
+	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
 	"github.com/hyperledger/firefly-fabconnect/internal/rest/receipt"
 	restsync "github.com/hyperledger/firefly-fabconnect/internal/rest/sync"
 	"github.com/hyperledger/firefly-fabconnect/internal/tx"
@@ -57,7 +61,9 @@ type RESTGateway struct {
 	asyncDispatcher restasync.AsyncDispatcher
 	sm              events.SubscriptionManager
 	ws              ws.WebSocketServer
+	signerMappings  signermap.Store
 	rpc             client.RPCClient
+	identityClient  identity.IdentityClient
 	router          *router
 	srv             *http.Server
 	sendCond        *sync.Cond
@@ -97,15 +103,17 @@ func (g *RESTGateway) Init() error {
 		return err
 	}
 	g.rpc = rpcClient
+	g.identityClient = identityClient
 	g.processor.Init(rpcClient)
 
-	ws := ws.NewWebSocketServer()
+	ws := ws.NewWebSocketServer(g.wsAuthenticator())
 	g.ws = ws
 
 	err = g.receiptStore.Init(ws)
 	if err != nil {
 		return err
 	}
+	identityClient.SetReceiptStore(g.receiptStore)
 
 	if g.config.Events.LevelDB.Path != "" {
 		g.sm = events.NewSubscriptionManager(&g.config.Events, rpcClient, ws)
@@ -115,12 +123,81 @@ func (g *RESTGateway) Init() error {
 		}
 	}
 
-	g.router = newRouter(g.syncDispatcher, g.asyncDispatcher, identityClient, g.sm, ws, g.config.OpenID)
+	if err := g.registerSecurityModule(); err != nil {
+		return err
+	}
+	auth.RegisterServiceAccounts(g.config.Security.ServiceAccounts)
+
+	if g.config.Security.SignerMappings.LevelDB.Path != "" {
+		g.signerMappings = signermap.NewLevelDBStore(g.config.Security.SignerMappings.LevelDB.Path)
+		if err := g.signerMappings.Init(); err != nil {
+			return err
+		}
+		auth.RegisterSignerMappings(g.signerMappings)
+	}
+
+	g.router = newRouter(g.syncDispatcher, g.asyncDispatcher, identityClient, g.sm, g.signerMappings, ws, g.config.OpenID, g.config.Security)
 	g.router.addRoutes()
 
 	return nil
 }
 
+// wsAuthenticator authenticates the WebSocket connections with the auth mode of the REST routes,
+// except the token is bound to the connection
+func (g *RESTGateway) wsAuthenticator() ws.Authenticator {
+	switch g.config.Security.AuthMode {
+	case auth.AuthModeNone:
+		return nil
+	case auth.AuthModeMTLS:
+		mappings := g.config.Security.ClientCertMappings
+		return func(ctx context.Context, req *http.Request, _ string) (context.Context, error) {
+			return auth.WithClientCertContext(ctx, req.TLS, mappings)
+		}
+	default:
+		openID := g.config.OpenID
+		return func(ctx context.Context, _ *http.Request, token string) (context.Context, error) {
+			if token == "" {
+				return nil, errors.Errorf(errors.WebSocketNoToken)
+			}
+			return auth.WithAuthContext(ctx, token, openID)
+		}
+	}
+}
+
+// registerSecurityModule registers the configured plugin or policy, otherwise the access tokens
+// are verified directly against the OpenID IdP
+func (g *RESTGateway) registerSecurityModule() error {
+	security := g.config.Security
+	switch {
+	case security.PluginPath != "" && security.PolicyFile != "":
+		return errors.Errorf(errors.SecurityModuleConflict)
+	case security.PluginPath != "":
+		sm, err := auth.LoadSecurityModule(security.PluginPath)
+		if err != nil {
+			return err
+		}
+		auth.RegisterSecurityModule(sm)
+		log.Infof("Loaded the security module plugin %s", security.PluginPath)
+	case security.PolicyFile != "":
+		return g.registerPolicy()
+	}
+	return nil
+}
+
+// registerPolicy enforces the role based policy, on the callers authenticated by the OpenID IdP
+func (g *RESTGateway) registerPolicy() error {
+	p, err := policy.LoadPolicy(g.config.Security.PolicyFile)
+	if err != nil {
+		return err
+	}
+	openID := g.config.OpenID
+	auth.RegisterSecurityModule(policy.NewSecurityModule(p, func(token string) (map[string]interface{}, error) {
+		return auth.VerifyAccessToken(context.Background(), token, openID)
+	}))
+	log.Infof("Enforcing the security policy in %s", g.config.Security.PolicyFile)
+	return nil
+}
+
 func (g *RESTGateway) ValidateConf() error {
 	// HTTP and RPC configurations are mandatory
 	if g.config.HTTP.Port == 0 {
@@ -132,6 +209,56 @@ func (g *RESTGateway) ValidateConf() error {
 	if g.config.HTTP.LocalAddr == "" {
 		g.config.HTTP.LocalAddr = "0.0.0.0"
 	}
+	mode, err := auth.ResolveAuthMode(g.config.Security, g.config.OpenID)
+	if err != nil {
+		return err
+	}
+	g.config.Security.AuthMode = mode
+	if len(g.config.Security.PublicPaths) == 0 {
+		g.config.Security.PublicPaths = auth.DefaultPublicPaths
+	}
+	if mode == auth.AuthModeMTLS || g.config.HTTP.ClientCerts.Require {
+		if !g.config.HTTP.TLS.Enabled {
+			return errors.Errorf(errors.ConfigClientCertsNoTLS)
+		}
+		// never fall back to the system roots, any publicly issued certificate would be accepted
+		if g.config.HTTP.ClientCerts.CACertsFile == "" && g.config.HTTP.TLS.CACertsFile == "" {
+			return errors.Errorf(errors.ConfigClientCertsNoCA)
+		}
+	}
+	if err := auth.ValidateClientCertMappings(g.config.Security.ClientCertMappings); err != nil {
+		return err
+	}
+	if err := auth.ValidateUsernameTransforms(g.config.OpenID.UsernameTransforms); err != nil {
+		return err
+	}
+	return auth.ValidateServiceAccounts(g.config.Security.ServiceAccounts)
+}
+
+// configureClientCerts verifies the client certificates against the configured CAs, when
+// they are required or authenticate the callers. The system roots are never used
+func (g *RESTGateway) configureClientCerts(tlsConfig *tls.Config) error {
+	clientCerts := g.config.HTTP.ClientCerts
+	switch {
+	case clientCerts.Require:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case g.config.Security.AuthMode == auth.AuthModeMTLS:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil
+	}
+	caCertsFile := clientCerts.CACertsFile
+	if caCertsFile == "" {
+		caCertsFile = g.config.HTTP.TLS.CACertsFile
+	}
+	if caCertsFile == "" {
+		return errors.Errorf(errors.ConfigClientCertsNoCA)
+	}
+	pool, err := utils.LoadCertPool(caCertsFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig.ClientCAs = pool
 	return nil
 }
 
@@ -142,10 +269,14 @@ func (g *RESTGateway) Start() error {
 	//rootCAs, _ := x509.SystemCertPool()
 	//tlsConfig = &tls.Config{RootCAs: rootCAs, InsecureSkipVerify: true}
 
-	log.Printf("HERE %s", g.config.HTTP.TLS)
 	if err != nil {
 		return err
 	}
+	if tlsConfig != nil {
+		if err := g.configureClientCerts(tlsConfig); err != nil {
+			return err
+		}
+	}
 
 	g.srv = &http.Server{
 		Addr:           fmt.Sprintf("%s:%d", g.config.HTTP.LocalAddr, g.config.HTTP.Port),
@@ -216,7 +347,12 @@ func (g *RESTGateway) Shutdown() {
 	if g.sm != nil {
 		g.sm.Close()
 	}
+	if g.signerMappings != nil {
+		g.signerMappings.Close()
+	}
 	g.asyncDispatcher.Close()
+	g.identityClient.Close()
 	g.rpc.Close()
 	g.ws.Close()
+	auth.CloseVerifiers()
 }
@@ -18,6 +18,9 @@ package rest
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,19 +30,25 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/hyperledger/firefly-fabconnect/internal/auth"
 	"github.com/hyperledger/firefly-fabconnect/internal/auth/authtest"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/policy"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/signermap"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/hyperledger/firefly-fabconnect/internal/events"
 	fabtest "github.com/hyperledger/firefly-fabconnect/internal/fabric/test"
+	"github.com/hyperledger/firefly-fabconnect/internal/messages"
 	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
 	"github.com/hyperledger/firefly-fabconnect/internal/rest/test"
+	restutil "github.com/hyperledger/firefly-fabconnect/internal/rest/utils"
 	"github.com/hyperledger/firefly-fabconnect/internal/utils"
+	mocksignermap "github.com/hyperledger/firefly-fabconnect/mocks/auth/signermap"
 	mockfabric "github.com/hyperledger/firefly-fabconnect/mocks/fabric/client"
 	mockkvstore "github.com/hyperledger/firefly-fabconnect/mocks/kvstore"
 	mockidentity "github.com/hyperledger/firefly-fabconnect/mocks/rest/identity"
@@ -71,7 +80,8 @@ func teardown() {
 
 func newTestGateway(t *testing.T, mockDB ...bool) (*assert.Assertions, *RESTGateway, *sync.WaitGroup, *mockreceipt.ReceiptStorePersistence, *mockfabric.RPCClient, *mockidentity.IdentityClient) {
 	assert := assert.New(t)
-	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{Username: "user1"})
+	testConfig.Security.AuthMode = auth.AuthModePlugin
 	testConfig.HTTP.Port = lastPort
 	testConfig.HTTP.LocalAddr = "127.0.0.1"
 	testConfig.RPC.ConfigPath = path.Join(tmpdir, "ccp.yml")
@@ -112,7 +122,7 @@ func newTestGateway(t *testing.T, mockDB ...bool) (*assert.Assertions, *RESTGate
 
 	testIdentityClient := &mockidentity.IdentityClient{}
 	if mockIdentity {
-		testRouter := newRouter(g.syncDispatcher, g.asyncDispatcher, testIdentityClient, g.sm, g.ws)
+		testRouter := newRouter(g.syncDispatcher, g.asyncDispatcher, testIdentityClient, g.sm, nil, g.ws, g.config.OpenID, g.config.Security)
 		testRouter.addRoutes()
 		g.router = testRouter
 	}
@@ -270,6 +280,28 @@ func TestIdentitiesEndpoints(t *testing.T) {
 	assert.Equal(6, len(result1))
 	assert.Equal("acme", result1["attributes"].(map[string]interface{})["org"])
 
+	// GET /identities/me
+	testIdentityClient.On("GetCurrent", mock.Anything, mock.Anything, mock.Anything).Return(&identity.CurrentIdentity{
+		Identity:    *mockResult,
+		Username:    "user1",
+		Certificate: &identity.Certificate{Serial: "1a2b3c"},
+	}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/identities/me", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	resultMe := utils.DecodePayload(bodyBytes).(map[string]interface{})
+	assert.Equal("user1", resultMe["username"])
+	assert.Equal("1a2b3c", resultMe["certificate"].(map[string]interface{})["serial"])
+
+	// GET /metrics
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/metrics", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	assert.Regexp("^text/plain", resp.Header.Get("Content-Type"))
+
 	mockResult1 := &identity.RegisterResponse{
 		Name:   "user1",
 		Secret: "supersecret",
@@ -368,11 +400,158 @@ func TestIdentitiesEndpoints(t *testing.T) {
 	assert.Equal(200, resp.StatusCode)
 	bodyBytes, _ = io.ReadAll(resp.Body)
 	result6 := utils.DecodePayload(bodyBytes).(map[string]interface{})
-	assert.Equal(2, len(result6))
+	assert.Equal(3, len(result6))
 	assert.Equal(1, len(result6["revokedCerts"].([]interface{})))
 	cert := result6["revokedCerts"].([]interface{})[0]
 	assert.Equal("d9925622f0d513c1c6a776f60b79895a785ba9ee", cert.(map[string]interface{})["aki"])
 
+	// DELETE /identities/:id
+	testIdentityClient.On("Remove", mock.Anything, mock.Anything, mock.Anything).Return(&identity.RemoveResponse{Name: "user1", IdPUserRemoved: true}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/identities/user1", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodDelete, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	result7 := utils.DecodePayload(bodyBytes).(map[string]interface{})
+	assert.Equal("user1", result7["name"])
+	assert.Equal(true, result7["idpUserRemoved"])
+
+	// GET /affiliations
+	testIdentityClient.On("ListAffiliations", mock.Anything, mock.Anything, mock.Anything).Return(&identity.Affiliation{
+		Affiliations: []*identity.Affiliation{{Name: "org1"}},
+	}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/affiliations", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	result9 := utils.DecodePayload(bodyBytes).(map[string]interface{})
+	assert.Equal("org1", result9["affiliations"].([]interface{})[0].(map[string]interface{})["name"])
+
+	// GET, PUT and DELETE /affiliations/:affiliation
+	testIdentityClient.On("GetAffiliation", mock.Anything, mock.Anything, mock.Anything).Return(&identity.Affiliation{Name: "org1"}, nil).Once()
+	testIdentityClient.On("ModifyAffiliation", mock.Anything, mock.Anything, mock.Anything).Return(&identity.Affiliation{Name: "org2"}, nil).Once()
+	testIdentityClient.On("RemoveAffiliation", mock.Anything, mock.Anything, mock.Anything).Return(nil, restutil.NewRestError("Affiliation removal is disabled", 500)).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/affiliations/org1", g.config.HTTP.Port))
+	for method, status := range map[string]int{http.MethodGet: 200, http.MethodPut: 200, http.MethodDelete: 500} {
+		req = &http.Request{URL: url, Method: method, Header: header}
+		resp, _ = http.DefaultClient.Do(req)
+		assert.Equal(status, resp.StatusCode, method)
+	}
+
+	// POST /affiliations
+	testIdentityClient.On("AddAffiliation", mock.Anything, mock.Anything, mock.Anything).Return(&identity.Affiliation{Name: "org2"}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/affiliations", g.config.HTTP.Port))
+	req = &http.Request{
+		URL:    url,
+		Method: http.MethodPost,
+		Header: header,
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte(`{"name":"org2"}`))),
+	}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+
+	// POST /idp/reconcile
+	testIdentityClient.On("Reconcile", mock.Anything, mock.Anything, mock.Anything).Return(nil, restutil.NewRestError("No IdP is configured to provision the users in", 405)).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/idp/reconcile", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodPost, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(405, resp.StatusCode)
+	testIdentityClient.On("Reconcile", mock.Anything, mock.Anything, mock.Anything).Return(&identity.ReconcileReport{
+		Identities: 2,
+		Drifts:     []*identity.Drift{{Username: "user2", Kind: identity.DriftIdentityWithoutIdPUser}},
+	}, nil).Once()
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	result8 := utils.DecodePayload(bodyBytes).(map[string]interface{})
+	assert.Equal("identityWithoutIdPUser", result8["drifts"].([]interface{})[0].(map[string]interface{})["kind"])
+
+	// POST and GET /wallet/identities
+	testIdentityClient.On("ImportIdentity", mock.Anything, mock.Anything, mock.Anything).Return(&identity.WalletIdentity{Name: "user5", MSPID: "org1MSP", HasPrivateKey: true}, nil).Once()
+	testIdentityClient.On("ListWallet", mock.Anything, mock.Anything, mock.Anything).Return([]*identity.WalletIdentity{{Name: "user5", MSPID: "org1MSP", HasPrivateKey: true}}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/wallet/identities", g.config.HTTP.Port))
+	req = &http.Request{
+		URL:    url,
+		Method: http.MethodPost,
+		Header: header,
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte(`{"name":"user5"}`))),
+	}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	result10 := utils.DecodePayload(bodyBytes).([]interface{})
+	assert.Equal(true, result10[0].(map[string]interface{})["hasPrivateKey"])
+
+	// GET /wallet/identities/:username
+	testIdentityClient.On("ExportIdentity", mock.Anything, mock.Anything, mock.Anything).Return(nil, restutil.NewRestError("No identity 'user6' in the wallet", 404)).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/wallet/identities/user6", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(404, resp.StatusCode)
+
+	// GET /certificates
+	testIdentityClient.On("ListCertificates", mock.Anything, mock.Anything, mock.Anything).Return([]*identity.IssuedCertificate{{EnrollmentID: "user1", AKI: "a1b2"}}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/certificates?id=user1", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	result11 := utils.DecodePayload(bodyBytes).([]interface{})
+	assert.Equal("a1b2", result11[0].(map[string]interface{})["aki"])
+
+	// GET /crl
+	testIdentityClient.On("GetCRL", mock.Anything, mock.Anything, mock.Anything).Return(&identity.CRL{Format: identity.CRLFormatDER, Data: []byte("crl")}, nil).Once()
+	testIdentityClient.On("GetCRL", mock.Anything, mock.Anything, mock.Anything).Return(nil, restutil.NewRestError("Unknown CRL format 'json', must be pem or der", 400)).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/crl?format=der", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal("application/pkix-crl", resp.Header.Get("Content-Type"))
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	assert.Equal("crl", string(bodyBytes))
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(400, resp.StatusCode)
+
+	// POST /identities/bulk
+	testIdentityClient.On("BulkProvision", mock.Anything, mock.Anything, mock.Anything).Return(&messages.AsyncSentMsg{Sent: true, Request: "job1"}, nil).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/identities/bulk?enroll=true", g.config.HTTP.Port))
+	req = &http.Request{
+		URL:    url,
+		Method: http.MethodPost,
+		Header: header,
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte("name\nuser7\n"))),
+	}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(202, resp.StatusCode)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	result12 := utils.DecodePayload(bodyBytes).(map[string]interface{})
+	assert.Equal("job1", result12["id"])
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/identities/user7", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodPost, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(404, resp.StatusCode)
+
+	// POST /identities/bulk/secrets
+	testIdentityClient.On("BulkSecrets", mock.Anything, mock.Anything, mock.Anything).Return(&identity.BulkJobSecrets{Job: "job1", Identities: []*identity.BulkIdentitySecret{{Name: "user7", Secret: "secret7"}}}, nil).Once()
+	testIdentityClient.On("BulkSecrets", mock.Anything, mock.Anything, mock.Anything).Return(nil, restutil.NewRestError("No secrets of bulk identity job 'job1' are left to return", 404)).Once()
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/identities/bulk/secrets?job=job1", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodPost, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(200, resp.StatusCode)
+	secrets := &identity.BulkJobSecrets{}
+	_ = json.NewDecoder(resp.Body).Decode(secrets)
+	assert.Equal("secret7", secrets.Identities[0].Secret)
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(404, resp.StatusCode)
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/identities/user7/secrets", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodPost, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(404, resp.StatusCode)
+
 	g.srv.Close()
 	wg.Wait()
 	auth.RegisterSecurityModule(nil)
@@ -391,12 +570,21 @@ func TestQueryEndpoints(t *testing.T) {
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	assert.Equal("{\"error\":\"Must specify the channel\"}", string(bodyBytes))
 
+	// the authenticated caller signs as itself without a signer
 	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/chainInfo?fly-channel=default-channel", g.config.HTTP.Port))
 	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
 	resp, _ = http.DefaultClient.Do(req)
-	assert.Equal(400, resp.StatusCode)
+	assert.Equal(200, resp.StatusCode)
+
+	// unless the auth context has no username
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/chainInfo?fly-channel=default-channel&fly-signer=user1", g.config.HTTP.Port))
+	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
+	resp, _ = http.DefaultClient.Do(req)
+	assert.Equal(403, resp.StatusCode)
 	bodyBytes, _ = io.ReadAll(resp.Body)
-	assert.Equal("{\"error\":\"Must specify the signer\"}", string(bodyBytes))
+	assert.Equal("{\"error\":\"The authenticated caller has no username to sign as\"}", string(bodyBytes))
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{Username: "user1"})
 
 	url, _ = url.Parse(fmt.Sprintf("http://localhost:%d/transactions/3144a3ad43dcc11374832bbb71561320de81fd80d69cc8e26a9ea7d3240a5e84?fly-channel=default-channel&fly-signer=user1", g.config.HTTP.Port))
 	req = &http.Request{URL: url, Method: http.MethodGet, Header: header}
@@ -467,6 +655,106 @@ func TestQueryEndpoints(t *testing.T) {
 	auth.RegisterSecurityModule(nil)
 }
 
+func TestSecurityPolicy(t *testing.T) {
+	assert, g, wg, _, _, _ := newTestGateway(t)
+	p, err := policy.ParsePolicy([]byte(`
+rules:
+  - roles: [auditor]
+    routes: ["GET /chaininfo", "POST /query"]
+    channels: [default-channel]
+`))
+	assert.NoError(err)
+	auth.RegisterSecurityModule(policy.NewSecurityModule(p, func(token string) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"sub":                "auditor-id",
+			"preferred_username": "user1",
+			"realm_access":       map[string]interface{}{"roles": []interface{}{"auditor"}},
+		}, nil
+	}))
+	header := http.Header{
+		"authorization": []string{"bearer auditor"},
+	}
+	call := func(method, path string) (int, string) {
+		url, _ := url.Parse(fmt.Sprintf("http://localhost:%d%s", g.config.HTTP.Port, path))
+		req := &http.Request{URL: url, Method: method, Header: header, Body: ioutil.NopCloser(bytes.NewReader([]byte("{}")))}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(err)
+		var errResp errors.RestErrMsg
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return resp.StatusCode, errResp.Message
+	}
+
+	status, _ := call(http.MethodGet, "/chaininfo?fly-channel=default-channel&fly-signer=user1")
+	assert.Equal(200, status)
+
+	status, msg := call(http.MethodGet, "/chaininfo?fly-channel=other-channel&fly-signer=user1")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: channel 'other-channel' is not allowed on GET /chaininfo for 'user1'", msg)
+
+	status, msg = call(http.MethodPost, "/transactions?fly-channel=default-channel&fly-signer=user1&fly-chaincode=asset_transfer")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: POST /transactions is not allowed for 'user1'", msg)
+
+	status, msg = call(http.MethodGet, "/eventstreams")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: GET /eventstreams is not allowed", msg)
+
+	status, msg = call(http.MethodGet, "/receipts")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: GET /receipts is not allowed", msg)
+
+	status, msg = call(http.MethodPost, "/transactions/prepare?fly-channel=default-channel&fly-signer=user1")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: POST /transactions/prepare is not allowed for 'user1'", msg)
+
+	status, msg = call(http.MethodPost, "/identities/bulk")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: POST /identities/bulk is not allowed", msg)
+
+	status, _ = call(http.MethodPost, "/identities/other")
+	assert.Equal(404, status)
+
+	status, msg = call(http.MethodGet, "/identities/me")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: GET /identities/me is not allowed", msg)
+
+	status, msg = call(http.MethodGet, "/identities/user2")
+	assert.Equal(403, status)
+	assert.Regexp("Access denied: GET /identities/:username is not allowed", msg)
+
+	g.srv.Close()
+	wg.Wait()
+	auth.RegisterSecurityModule(nil)
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	assert := assert.New(t)
+	policyFile := path.Join(tmpdir, "policy.yaml")
+	_ = ioutil.WriteFile(policyFile, []byte(`rules: [{roles: [admin], routes: ["* *"], eventStreams: true, receipts: true}]`), 0644)
+
+	g := NewRESTGateway(&conf.RESTGatewayConf{Security: conf.SecurityConf{PolicyFile: policyFile}})
+	assert.NoError(g.registerPolicy())
+	_, err := auth.WithAuthContext(context.Background(), "token", conf.OpenIDConfig{})
+	assert.Regexp("No OpenID issuer or JWKS URI configured", err)
+	auth.RegisterSecurityModule(nil)
+
+	g.config.Security.PolicyFile = path.Join(tmpdir, "missing.yaml")
+	assert.Regexp("Failed to load security policy", g.registerPolicy())
+}
+
+func TestRegisterSecurityModule(t *testing.T) {
+	assert := assert.New(t)
+
+	g := NewRESTGateway(&conf.RESTGatewayConf{})
+	assert.NoError(g.registerSecurityModule())
+
+	g.config.Security = conf.SecurityConf{PluginPath: path.Join(tmpdir, "missing.so"), PolicyFile: path.Join(tmpdir, "policy.yaml")}
+	assert.Regexp("Only one of security.pluginPath and security.policyFile", g.registerSecurityModule())
+
+	g.config.Security.PolicyFile = ""
+	assert.Regexp("Failed to load plugin", g.registerSecurityModule())
+}
+
 func TestReceiptsAPI(t *testing.T) {
 	assert, g, wg, testStorePersistence, _, _ := newTestGateway(t, true)
 	header := http.Header{
@@ -1201,7 +1489,7 @@ func TestStartStatusStopNoKafkaHandlerMissingToken(t *testing.T) {
 		wg.Done()
 	}()
 
-	url, _ := url.Parse(fmt.Sprintf("http://localhost:%d/status", g.config.HTTP.Port))
+	url, _ := url.Parse(fmt.Sprintf("http://localhost:%d/receipts", g.config.HTTP.Port))
 	var resp *http.Response
 	for i := 0; i < 5; i++ {
 		time.Sleep(200 * time.Millisecond)
@@ -1219,12 +1507,274 @@ func TestStartStatusStopNoKafkaHandlerMissingToken(t *testing.T) {
 	err = json.NewDecoder(resp.Body).Decode(&errResp)
 	assert.Equal("Unauthorized", errResp.Message)
 
+	// the probes do not need a token
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/status", g.config.HTTP.Port))
+	assert.NoError(err)
+	assert.Equal(200, resp.StatusCode)
+
+	// the metrics are not public by default
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/metrics", g.config.HTTP.Port))
+	assert.NoError(err)
+	assert.Equal(401, resp.StatusCode)
+
 	g.srv.Close()
 	wg.Wait()
 	auth.RegisterSecurityModule(nil)
 
 }
 
+func TestAuthModes(t *testing.T) {
+	assert := assert.New(t)
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+
+	serve := func(security conf.SecurityConf, target string, header http.Header) (int, string) {
+		r := newRouter(nil, nil, nil, nil, nil, nil, conf.OpenIDConfig{}, security)
+		r.httpRouter.GET("/status", r.statusHandler)
+		r.httpRouter.GET("/ws", r.statusHandler)
+		r.httpRouter.GET("/whoami", func(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+			username, _ := req.Context().Value(auth.ContextKeyUsername).(string)
+			_, _ = res.Write([]byte(username))
+		})
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header = header
+		res := httptest.NewRecorder()
+		r.newAccessTokenContextHandler().ServeHTTP(res, req)
+		return res.Code, res.Body.String()
+	}
+	noToken := http.Header{}
+	token := http.Header{"Authorization": []string{"Bearer testat"}}
+
+	code, _ := serve(conf.SecurityConf{AuthMode: auth.AuthModeJWT}, "/status", noToken)
+	assert.Equal(200, code)
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModeJWT}, "/ws?x=1", noToken)
+	assert.Equal(200, code)
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModeJWT}, "/whoami", noToken)
+	assert.Equal(401, code)
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModePlugin}, "/whoami", token)
+	assert.Equal(200, code)
+
+	// only the configured paths are public
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModeJWT, PublicPaths: []string{"/whoami"}}, "/status", noToken)
+	assert.Equal(401, code)
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModeJWT, PublicPaths: []string{"/whoami"}}, "/whoami?verbose=true", noToken)
+	assert.Equal(200, code)
+
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModeNone}, "/whoami", noToken)
+	assert.Equal(200, code)
+
+	// without a verified client certificate
+	code, _ = serve(conf.SecurityConf{AuthMode: auth.AuthModeMTLS}, "/whoami", token)
+	assert.Equal(401, code)
+}
+
+func TestSignerMappingsAPI(t *testing.T) {
+	assert := assert.New(t)
+	store := &mocksignermap.Store{}
+	idClient := &mockidentity.IdentityClient{}
+	idClient.On("AuthorizeAdmin", mock.Anything, mock.Anything, "mallory").Return(restutil.NewRestError("Access denied", 403))
+	idClient.On("AuthorizeAdmin", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	idClient.On("AuditAdmin", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	r := newRouter(nil, nil, idClient, nil, store, nil, conf.OpenIDConfig{}, conf.SecurityConf{})
+	r.addRoutes()
+	call := func(method, target, body string) (int, string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		res := httptest.NewRecorder()
+		r.httpRouter.ServeHTTP(res, req)
+		return res.Code, res.Body.String()
+	}
+	alice := &signermap.Mapping{User: "alice", Identities: []signermap.Identity{{Signer: "alice-ch1", Channels: []string{"ch1"}}}}
+
+	store.On("List").Return([]*signermap.Mapping{alice}, nil).Once()
+	code, body := call(http.MethodGet, "/signermappings", "")
+	assert.Equal(200, code)
+	assert.Regexp(`"signer": "alice-ch1"`, body)
+	store.On("List").Return(nil, fmt.Errorf("pop")).Once()
+	code, _ = call(http.MethodGet, "/signermappings", "")
+	assert.Equal(500, code)
+
+	store.On("Get", "alice").Return(alice, nil).Once()
+	code, body = call(http.MethodGet, "/signermappings/alice", "")
+	assert.Equal(200, code)
+	assert.Regexp(`"user": "alice"`, body)
+	store.On("Get", "bob").Return(nil, nil)
+	code, body = call(http.MethodGet, "/signermappings/bob", "")
+	assert.Equal(404, code)
+	assert.Regexp("No signer mapping found for user 'bob'", body)
+	store.On("Get", "carol").Return(nil, fmt.Errorf("pop"))
+	code, _ = call(http.MethodGet, "/signermappings/carol", "")
+	assert.Equal(500, code)
+
+	// the user of the path applies
+	store.On("Put", mock.MatchedBy(func(m *signermap.Mapping) bool { return m.User == "alice" })).Return(nil).Once()
+	code, body = call(http.MethodPut, "/signermappings/alice", `{"user":"mallory","identities":[{"signer":"alice-ch1","channels":["ch1"]}]}`)
+	assert.Equal(200, code)
+	assert.Regexp(`"user": "alice"`, body)
+	code, body = call(http.MethodPut, "/signermappings/alice", `{"identities":[]}`)
+	assert.Equal(400, code)
+	assert.Regexp("must have a user and at least one identity", body)
+	code, body = call(http.MethodPut, "/signermappings/alice", `!json`)
+	assert.Equal(400, code)
+	assert.Regexp("Invalid signer mapping", body)
+	store.On("Put", mock.Anything).Return(fmt.Errorf("pop")).Once()
+	code, _ = call(http.MethodPut, "/signermappings/alice", `{"identities":[{"signer":"alice-ch1"}]}`)
+	assert.Equal(500, code)
+
+	store.On("Get", "alice").Return(alice, nil)
+	store.On("Delete", "alice").Return(nil).Once()
+	code, body = call(http.MethodDelete, "/signermappings/alice", "")
+	assert.Equal(200, code)
+	assert.Regexp(`"deleted": "true"`, body)
+	store.On("Delete", "alice").Return(fmt.Errorf("pop")).Once()
+	code, _ = call(http.MethodDelete, "/signermappings/alice", "")
+	assert.Equal(500, code)
+	code, _ = call(http.MethodDelete, "/signermappings/bob", "")
+	assert.Equal(404, code)
+	code, _ = call(http.MethodDelete, "/signermappings/carol", "")
+	assert.Equal(500, code)
+	idClient.AssertCalled(t, "AuditAdmin", mock.Anything, identity.AdminOpPutSignerMapping, "alice", (*restutil.RestError)(nil))
+	idClient.AssertCalled(t, "AuditAdmin", mock.Anything, identity.AdminOpDeleteSignerMapping, "bob", mock.Anything)
+
+	// the caller is not allowed to manage the mappings
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		code, body = call(method, "/signermappings/mallory", `{"identities":[{"signer":"alice-ch1"}]}`)
+		assert.Equal(403, code)
+		assert.Regexp("Access denied", body)
+	}
+	store.AssertNotCalled(t, "Get", "mallory")
+	store.AssertNotCalled(t, "Delete", "mallory")
+
+	// without an identity client
+	r = newRouter(nil, nil, nil, nil, store, nil, conf.OpenIDConfig{}, conf.SecurityConf{})
+	r.addRoutes()
+	code, body = call(http.MethodGet, "/signermappings", "")
+	assert.Equal(403, code)
+	assert.Regexp("must be configured to manage signer mappings", body)
+
+	// without a store
+	r = newRouter(nil, nil, nil, nil, nil, nil, conf.OpenIDConfig{}, conf.SecurityConf{})
+	r.addRoutes()
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		code, body = call(method, "/signermappings/alice", "{}")
+		assert.Equal(405, code)
+		assert.Regexp("Signer mappings are not configured", body)
+	}
+	code, _ = call(http.MethodGet, "/signermappings", "")
+	assert.Equal(405, code)
+}
+
+func TestWebSocketAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+
+	g := NewRESTGateway(&conf.RESTGatewayConf{Security: conf.SecurityConf{AuthMode: auth.AuthModeNone}})
+	assert.Nil(g.wsAuthenticator())
+
+	g.config.Security.AuthMode = auth.AuthModePlugin
+	authenticate := g.wsAuthenticator()
+	_, err := authenticate(context.Background(), req, "")
+	assert.Regexp("No access token", err)
+	_, err = authenticate(context.Background(), req, "badone")
+	assert.Regexp("badness", err)
+	ctx, err := authenticate(context.Background(), req, "testat")
+	assert.NoError(err)
+	assert.Equal("verified", auth.GetAuthContext(ctx))
+
+	g.config.Security.AuthMode = auth.AuthModeMTLS
+	_, err = g.wsAuthenticator()(context.Background(), req, "testat")
+	assert.Regexp("No verified TLS client certificate", err)
+}
+
+func TestValidateConfAuthMode(t *testing.T) {
+	assert := assert.New(t)
+	g := NewRESTGateway(&conf.RESTGatewayConf{
+		HTTP: conf.HTTPConf{Port: 8080},
+		RPC:  conf.RPCConf{ConfigPath: "ccp.yml"},
+	})
+	assert.Regexp("security.authMode must be configured", g.ValidateConf())
+
+	g.config.Security.AuthMode = auth.AuthModeNone
+	assert.NoError(g.ValidateConf())
+	assert.Equal(auth.AuthModeNone, g.config.Security.AuthMode)
+	assert.Equal(auth.DefaultPublicPaths, g.config.Security.PublicPaths)
+
+	g.config.OpenID.UsernameTransforms = []string{"reverse"}
+	assert.Regexp("openId.usernameTransforms cannot be configured with the 'none' auth mode", g.ValidateConf())
+
+	g.config.OpenID.Issuer = "https://idp.example.com/realms/org1"
+	g.config.Security.AuthMode = auth.AuthModeJWT
+	assert.Regexp("Unknown username transform 'reverse'", g.ValidateConf())
+
+	g.config.Security.AuthMode = "basic"
+	assert.Regexp("Unknown auth mode 'basic'", g.ValidateConf())
+}
+
+func TestValidateConfClientCerts(t *testing.T) {
+	assert := assert.New(t)
+	g := NewRESTGateway(&conf.RESTGatewayConf{
+		HTTP:     conf.HTTPConf{Port: 8080},
+		RPC:      conf.RPCConf{ConfigPath: "ccp.yml"},
+		Security: conf.SecurityConf{AuthMode: auth.AuthModeMTLS},
+	})
+	assert.Regexp("Client certificates can only be verified when TLS is enabled", g.ValidateConf())
+
+	g.config.Security.AuthMode = auth.AuthModeNone
+	g.config.HTTP.ClientCerts.Require = true
+	assert.Regexp("Client certificates can only be verified when TLS is enabled", g.ValidateConf())
+
+	g.config.HTTP.TLS.Enabled = true
+	g.config.Security.AuthMode = auth.AuthModeMTLS
+	assert.Regexp("http.clientCerts.caCertsFile or http.tls.caCertsFile must be configured", g.ValidateConf())
+
+	g.config.HTTP.TLS.CACertsFile = "ca.pem"
+	g.config.Security.ClientCertMappings = []conf.ClientCertMapping{{Subject: "CN=*"}}
+	assert.Regexp("Client certificate mapping 0 must have", g.ValidateConf())
+
+	g.config.Security.ClientCertMappings[0].Signer = "user1"
+	assert.NoError(g.ValidateConf())
+
+	g.config.Security.ServiceAccounts = []conf.ServiceAccount{{Username: "service-account-billing"}}
+	assert.Regexp("Service account 0 must have a username and at least one act-as signer", g.ValidateConf())
+}
+
+func TestConfigureClientCerts(t *testing.T) {
+	assert := assert.New(t)
+	g := NewRESTGateway(&conf.RESTGatewayConf{})
+	rootCAs := x509.NewCertPool()
+
+	tlsConfig := &tls.Config{RootCAs: rootCAs}
+	assert.NoError(g.configureClientCerts(tlsConfig))
+	assert.Equal(tls.NoClientCert, tlsConfig.ClientAuth)
+	assert.Nil(tlsConfig.ClientCAs)
+
+	// the system roots are not a fallback for the client certificates
+	g.config.Security.AuthMode = auth.AuthModeMTLS
+	assert.Regexp("http.clientCerts.caCertsFile or http.tls.caCertsFile must be configured", g.configureClientCerts(tlsConfig))
+	assert.Nil(tlsConfig.ClientCAs)
+
+	g.config.HTTP.TLS.CACertsFile = "../../test/fixture/org1/ca.pem"
+	assert.NoError(g.configureClientCerts(tlsConfig))
+	assert.Equal(tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	assert.NotNil(tlsConfig.ClientCAs)
+	assert.NotEqual(rootCAs, tlsConfig.ClientCAs)
+
+	g.config.HTTP.ClientCerts.Require = true
+	g.config.HTTP.ClientCerts.CACertsFile = "missing.pem"
+	assert.Regexp("Failed to load the CA certificates in missing.pem", g.configureClientCerts(tlsConfig))
+	assert.Equal(tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	_ = ioutil.WriteFile(caFile, []byte("not a certificate"), 0644)
+	g.config.HTTP.ClientCerts.CACertsFile = caFile
+	assert.Regexp("no PEM certificates", g.configureClientCerts(tlsConfig))
+
+	g.config.HTTP.ClientCerts.CACertsFile = "../../test/fixture/org1/ca.pem"
+	assert.NoError(g.configureClientCerts(tlsConfig))
+	assert.NotEqual(rootCAs, tlsConfig.ClientCAs)
+}
+
 func TestStartWithBadTLS(t *testing.T) {
 	assert := assert.New(t)
 
@@ -17,16 +17,19 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/signermap"
 	"github.com/hyperledger/firefly-fabconnect/internal/conf"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/hyperledger/firefly-fabconnect/internal/events"
 	"github.com/hyperledger/firefly-fabconnect/internal/messages"
+	"github.com/hyperledger/firefly-fabconnect/internal/metrics"
 	restasync "github.com/hyperledger/firefly-fabconnect/internal/rest/async"
 	"github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
 	restsync "github.com/hyperledger/firefly-fabconnect/internal/rest/sync"
@@ -48,12 +51,14 @@ type router struct {
 	asyncDispatcher restasync.AsyncDispatcher
 	identityClient  identity.IdentityClient
 	subManager      events.SubscriptionManager
+	signerMappings  signermap.Store
 	ws              ws.WebSocketServer
 	httpRouter      *httprouter.Router
 	config          conf.OpenIDConfig
+	security        conf.SecurityConf
 }
 
-func newRouter(syncDispatcher restsync.SyncDispatcher, asyncDispatcher restasync.AsyncDispatcher, idClient identity.IdentityClient, sm events.SubscriptionManager, ws ws.WebSocketServer, cf conf.OpenIDConfig) *router {
+func newRouter(syncDispatcher restsync.SyncDispatcher, asyncDispatcher restasync.AsyncDispatcher, idClient identity.IdentityClient, sm events.SubscriptionManager, signerMappings signermap.Store, ws ws.WebSocketServer, cf conf.OpenIDConfig, security conf.SecurityConf) *router {
 	r := httprouter.New()
 	cors.Default().Handler(r)
 	return &router{
@@ -61,9 +66,11 @@ func newRouter(syncDispatcher restsync.SyncDispatcher, asyncDispatcher restasync
 		asyncDispatcher: asyncDispatcher,
 		identityClient:  idClient,
 		subManager:      sm,
+		signerMappings:  signerMappings,
 		ws:              ws,
 		httpRouter:      r,
 		config:          cf,
+		security:        security,
 	}
 }
 
@@ -71,74 +78,143 @@ func (r *router) addRoutes() {
 	r.httpRouter.GET("/api", r.serveSwaggerUI)
 	r.httpRouter.GET("/spec.yaml", r.serveSwagger)
 
-	r.httpRouter.POST("/identities", r.registerUser)
-	r.httpRouter.PUT("/identities/:username", r.modifyUser)
-	r.httpRouter.POST("/identities/:username/enroll", r.enrollUser)
-	r.httpRouter.POST("/identities/:username/reenroll", r.reenrollUser)
-	r.httpRouter.POST("/identities/:username/revoke", r.revokeUser)
-	r.httpRouter.GET("/identities", r.listUsers)
-	r.httpRouter.GET("/identities/:username", r.getUser)
-	// r.httpRouter.GET("/identities/currentUser", r.getCurrentUser)
-
-	r.httpRouter.GET("/chaininfo", r.queryChainInfo)
-	r.httpRouter.GET("/blocks/:blockNumber", r.queryBlock)
-	r.httpRouter.GET("/blockByTxId/:txId", r.queryBlockByTxId)
-
-	r.httpRouter.POST("/query", r.queryChaincode)
-	r.httpRouter.POST("/transactions", r.sendTransaction)
-	r.httpRouter.GET("/transactions/:txId", r.getTransaction)
-	r.httpRouter.GET("/receipts", r.handleReceipts)
-	r.httpRouter.GET("/receipts/:id", r.handleReceipts)
-
-	r.httpRouter.POST("/eventstreams", r.createStream)
-	r.httpRouter.PATCH("/eventstreams/:streamId", r.updateStream)
-	r.httpRouter.GET("/eventstreams", r.listStreams)
-	r.httpRouter.GET("/eventstreams/:streamId", r.getStream)
-	r.httpRouter.DELETE("/eventstreams/:streamId", r.deleteStream)
-	r.httpRouter.POST("/eventstreams/:streamId/suspend", r.suspendStream)
-	r.httpRouter.POST("/eventstreams/:streamId/resume", r.resumeStream)
-	r.httpRouter.POST("/subscriptions", r.createSubscription)
-	r.httpRouter.GET("/subscriptions", r.listSubscription)
-	r.httpRouter.GET("/subscriptions/:subscriptionId", r.getSubscription)
-	r.httpRouter.DELETE("/subscriptions/:subscriptionId", r.deleteSubscription)
-	r.httpRouter.POST("/subscriptions/:subscriptionId/reset", r.resetSubscription)
+	r.handle(http.MethodPost, "/identities", r.registerUser)
+	r.handleLiteral(http.MethodPost, "/identities/:username", "username", "bulk", r.bulkProvision, nil)
+	r.handleLiteral(http.MethodPost, "/identities/:username/secrets", "username", "bulk", r.bulkSecrets, nil)
+	r.handle(http.MethodPut, "/identities/:username", r.modifyUser)
+	r.handle(http.MethodPost, "/identities/:username/enroll", r.enrollUser)
+	r.handle(http.MethodPost, "/identities/:username/reenroll", r.reenrollUser)
+	r.handle(http.MethodPost, "/identities/:username/revoke", r.revokeUser)
+	r.handle(http.MethodGet, "/identities", r.listUsers)
+	r.handleLiteral(http.MethodGet, "/identities/:username", "username", "me", r.getCurrentUser, r.getUser)
+	r.handle(http.MethodDelete, "/identities/:username", r.removeUser)
+	r.handle(http.MethodPost, "/idp/reconcile", r.reconcileUsers)
+	r.handle(http.MethodGet, "/affiliations", r.listAffiliations)
+	r.handle(http.MethodPost, "/affiliations", r.addAffiliation)
+	r.handle(http.MethodGet, "/affiliations/:affiliation", r.getAffiliation)
+	r.handle(http.MethodPut, "/affiliations/:affiliation", r.modifyAffiliation)
+	r.handle(http.MethodDelete, "/affiliations/:affiliation", r.removeAffiliation)
+	r.handle(http.MethodPost, "/wallet/identities", r.importIdentity)
+	r.handle(http.MethodGet, "/wallet/identities", r.listWallet)
+	r.handle(http.MethodGet, "/wallet/identities/:username", r.exportIdentity)
+	r.handle(http.MethodGet, "/certificates", r.listCertificates)
+	r.handle(http.MethodGet, "/crl", r.getCRL)
+
+	r.handle(http.MethodGet, "/chaininfo", r.queryChainInfo)
+	r.handle(http.MethodGet, "/blocks/:blockNumber", r.queryBlock)
+	r.handle(http.MethodGet, "/blockByTxId/:txId", r.queryBlockByTxId)
+
+	r.handle(http.MethodPost, "/query", r.queryChaincode)
+	r.handle(http.MethodPost, "/transactions", r.sendTransaction)
+	r.handle(http.MethodGet, "/transactions/:txId", r.getTransaction)
+	r.handleLiteral(http.MethodPost, "/transactions/:txId", "txId", "prepare", r.prepareTransaction, nil)
+	r.handle(http.MethodPost, "/transactions/:txId/endorse", r.endorseTransaction)
+	r.handle(http.MethodPost, "/transactions/:txId/submit", r.submitTransaction)
+	r.handle(http.MethodGet, "/receipts", r.handleReceipts)
+	r.handle(http.MethodGet, "/receipts/:id", r.handleReceipts)
+
+	r.handle(http.MethodPost, "/eventstreams", r.eventStreamsHandle(r.createStream))
+	r.handle(http.MethodPatch, "/eventstreams/:streamId", r.eventStreamsHandle(r.updateStream))
+	r.handle(http.MethodGet, "/eventstreams", r.eventStreamsHandle(r.listStreams))
+	r.handle(http.MethodGet, "/eventstreams/:streamId", r.eventStreamsHandle(r.getStream))
+	r.handle(http.MethodDelete, "/eventstreams/:streamId", r.eventStreamsHandle(r.deleteStream))
+	r.handle(http.MethodPost, "/eventstreams/:streamId/suspend", r.eventStreamsHandle(r.suspendStream))
+	r.handle(http.MethodPost, "/eventstreams/:streamId/resume", r.eventStreamsHandle(r.resumeStream))
+	r.handle(http.MethodPost, "/subscriptions", r.eventStreamsHandle(r.createSubscription))
+	r.handle(http.MethodGet, "/subscriptions", r.eventStreamsHandle(r.listSubscription))
+	r.handle(http.MethodGet, "/subscriptions/:subscriptionId", r.eventStreamsHandle(r.getSubscription))
+	r.handle(http.MethodDelete, "/subscriptions/:subscriptionId", r.eventStreamsHandle(r.deleteSubscription))
+	r.handle(http.MethodPost, "/subscriptions/:subscriptionId/reset", r.eventStreamsHandle(r.resetSubscription))
+
+	r.handle(http.MethodGet, "/signermappings", r.listSignerMappings)
+	r.handle(http.MethodGet, "/signermappings/:user", r.getSignerMapping)
+	r.handle(http.MethodPut, "/signermappings/:user", r.putSignerMapping)
+	r.handle(http.MethodDelete, "/signermappings/:user", r.deleteSignerMapping)
 
 	r.httpRouter.GET("/ws", r.wsHandler)
 	r.httpRouter.GET("/status", r.statusHandler)
+	r.httpRouter.Handler(http.MethodGet, "/metrics", metrics.Handler())
 }
 
-func (r *router) newAccessTokenContextHandler() http.Handler {
-	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-
-		// Extract an access token from bearer token (only - no support for query params)
-		accessToken := "token"
-
-		// fmt.Println(req.RequestURI)
-		// fmt.Println(req.Body)
+// handle registers a route that the security module authorizes before calling the handler
+func (r *router) handle(method, path string, handle httprouter.Handle) {
+	route := method + " " + path
+	r.httpRouter.Handle(method, path, func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		ctx := auth.WithRoute(req.Context(), route)
+		if err := auth.AuthRPC(ctx, route); err != nil {
+			errors.RestErrReply(res, req, err, 403)
+			return
+		}
+		handle(res, req.WithContext(ctx), params)
+	})
+}
 
-		// body, err := utils.ParseJSONPayload(req)
+// handleLiteral serves a literal path, such as /transactions/prepare, that httprouter cannot register
+// next to the wildcard path of the same method. The other values of the wildcard are served by the
+// wildcard handler, or not found without one, and the route given to the security module is the
+// literal or the wildcard one, so policies can target the literal path alone
+func (r *router) handleLiteral(method, path, param, literal string, handle, wildcard httprouter.Handle) {
+	literalRoute := method + " " + strings.Replace(path, ":"+param, literal, 1)
+	wildcardRoute := method + " " + path
+	r.httpRouter.Handle(method, path, func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		route, h := literalRoute, handle
+		if params.ByName(param) != literal {
+			if wildcard == nil {
+				http.NotFound(res, req)
+				return
+			}
+			route, h = wildcardRoute, wildcard
+		}
+		ctx := auth.WithRoute(req.Context(), route)
+		if err := auth.AuthRPC(ctx, route); err != nil {
+			errors.RestErrReply(res, req, err, 403)
+			return
+		}
+		h(res, req.WithContext(ctx), params)
+	})
+}
 
-		// for name, values := range req.Header {
-		// 	// Loop over all values for the name.
-		// 	for _, value := range values {
-		// 		fmt.Println(name, value)
-		// 	}
-		// }
+// eventStreamsHandle additionally requires the event streams permission of the security module
+func (r *router) eventStreamsHandle(handle httprouter.Handle) httprouter.Handle {
+	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if err := auth.AuthEventStreams(req.Context()); err != nil {
+			errors.RestErrReply(res, req, err, 403)
+			return
+		}
+		handle(res, req, params)
+	}
+}
 
-		hSplit := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
-		if len(hSplit) == 2 && strings.ToLower(hSplit[0]) == "bearer" {
-			accessToken = hSplit[1]
+func (r *router) newAccessTokenContextHandler() http.Handler {
+	publicPaths := r.security.PublicPaths
+	if len(publicPaths) == 0 {
+		publicPaths = auth.DefaultPublicPaths
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if r.security.AuthMode == auth.AuthModeNone || auth.IsPublicPath(publicPaths, req.URL.Path) {
+			r.httpRouter.ServeHTTP(res, req)
+			return
 		}
 
-		authCtx, err := auth.WithAuthContext(req.Context(), req.RequestURI, accessToken, r.config)
+		var authCtx context.Context
+		var err error
+		if r.security.AuthMode == auth.AuthModeMTLS {
+			authCtx, err = auth.WithClientCertContext(req.Context(), req.TLS, r.security.ClientCertMappings)
+		} else {
+			// Extract an access token from bearer token (only - no support for query params)
+			accessToken := "token"
+			hSplit := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+			if len(hSplit) == 2 && strings.ToLower(hSplit[0]) == "bearer" {
+				accessToken = hSplit[1]
+			}
+			authCtx, err = auth.WithAuthContext(req.Context(), accessToken, r.config)
+		}
 		if err != nil {
 			log.Errorf("Error getting auth context: %s", err)
 			errors.RestErrReply(res, req, fmt.Errorf("Unauthorized"), 401)
 			return
 		}
 
-		//fmt.Println(req.Context().Value(auth.ContextKeyAccessToken))
-		// fmt.Println(authCtx.Value(auth.ContextKeyUsername))
 		r.httpRouter.ServeHTTP(res, req.WithContext(authCtx))
 	})
 }
@@ -209,6 +285,32 @@ func (r *router) sendTransaction(res http.ResponseWriter, req *http.Request, par
 		errors.RestErrReply(res, req, err.Error, err.StatusCode)
 		return
 	}
+	r.dispatchTransaction(res, req, msg, opts)
+}
+
+func (r *router) prepareTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	// the phases before the submission are always synchronous
+	r.syncDispatcher.PrepareTransaction(res, req, params)
+}
+
+func (r *router) endorseTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	r.syncDispatcher.EndorseTransaction(res, req, params)
+}
+
+func (r *router) submitTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	msg, opts, err := restutil.BuildSignedTxMessage(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	r.dispatchTransaction(res, req, msg, opts)
+}
+
+func (r *router) dispatchTransaction(res http.ResponseWriter, req *http.Request, msg *messages.SendTransaction, opts *restutil.TxOpts) {
 	if opts.Sync {
 		r.syncDispatcher.DispatchMsgSync(req.Context(), res, req, msg)
 	} else {
@@ -231,6 +333,28 @@ func (r *router) registerUser(res http.ResponseWriter, req *http.Request, params
 	marshalAndReply(res, req, result)
 }
 
+func (r *router) bulkProvision(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	result, err := r.identityClient.BulkProvision(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	restAsyncReply(res, req, result)
+}
+
+func (r *router) bulkSecrets(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+
+	result, err := r.identityClient.BulkSecrets(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
 func (r *router) modifyUser(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	log.Infof("--> %s %s", req.Method, req.URL)
 
@@ -295,17 +419,142 @@ func (r *router) getUser(res http.ResponseWriter, req *http.Request, params http
 	marshalAndReply(res, req, result)
 }
 
-// func (r *router) getCurrentUser(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
-// 	log.Infof("--> %s %s", req.Method, req.URL)
-// 	currentUser := req.Context().Value(auth.ContextKeyUsername).(string)
-// 	req.RequestURI = "/identities/" + currentUser
-// 	result, err := r.identityClient.Get(res, req, params)
-// 	if err != nil {
-// 		errors.RestErrReply(res, req, err.Error, err.StatusCode)
-// 		return
-// 	}
-// 	marshalAndReply(res, req, result)
-// }
+func (r *router) removeUser(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.Remove(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) reconcileUsers(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.Reconcile(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) listAffiliations(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.ListAffiliations(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) getAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.GetAffiliation(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) addAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.AddAffiliation(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) modifyAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.ModifyAffiliation(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) removeAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.RemoveAffiliation(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) importIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.ImportIdentity(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) listWallet(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.ListWallet(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) exportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.ExportIdentity(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) listCertificates(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.ListCertificates(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) getCRL(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.GetCRL(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	log.Infof("<-- %s %s [%d]", req.Method, req.URL, 200)
+	if result.Format == identity.CRLFormatDER {
+		res.Header().Set("Content-Type", "application/pkix-crl")
+	} else {
+		res.Header().Set("Content-Type", "application/x-pem-file")
+	}
+	res.WriteHeader(200)
+	_, _ = res.Write(result.Data)
+}
+
+func (r *router) getCurrentUser(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	result, err := r.identityClient.GetCurrent(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
 
 func (r *router) handleReceipts(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	r.asyncDispatcher.HandleReceipts(res, req, params)
@@ -483,6 +732,132 @@ func (r *router) resetSubscription(res http.ResponseWriter, req *http.Request, p
 	marshalAndReply(res, req, result)
 }
 
+func (r *router) listSignerMappings(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	if r.signerMappings == nil {
+		errors.RestErrReply(res, req, errors.Errorf(errors.SignerMappingsNotConfigured), 405)
+		return
+	}
+	if !r.authorizeAdmin(res, req, identity.AdminOpReadSignerMappings, "*") {
+		return
+	}
+
+	result, err := r.signerMappings.List()
+	if err != nil {
+		errors.RestErrReply(res, req, err, 500)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) getSignerMapping(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	if r.signerMappings == nil {
+		errors.RestErrReply(res, req, errors.Errorf(errors.SignerMappingsNotConfigured), 405)
+		return
+	}
+	user := params.ByName("user")
+	if !r.authorizeAdmin(res, req, identity.AdminOpReadSignerMappings, user) {
+		return
+	}
+
+	result, err := r.signerMappings.Get(user)
+	if err != nil {
+		errors.RestErrReply(res, req, err, 500)
+		return
+	}
+	if result == nil {
+		errors.RestErrReply(res, req, errors.Errorf(errors.SignerMappingNotFound, user), 404)
+		return
+	}
+	marshalAndReply(res, req, result)
+}
+
+func (r *router) putSignerMapping(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	if r.signerMappings == nil {
+		errors.RestErrReply(res, req, errors.Errorf(errors.SignerMappingsNotConfigured), 405)
+		return
+	}
+	user := params.ByName("user")
+	if !r.authorizeAdmin(res, req, identity.AdminOpPutSignerMapping, user) {
+		return
+	}
+
+	mapping, restErr := r.putMapping(req, user)
+	r.identityClient.AuditAdmin(req, identity.AdminOpPutSignerMapping, user, restErr)
+	if restErr != nil {
+		errors.RestErrReply(res, req, restErr.Error, restErr.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, mapping)
+}
+
+func (r *router) putMapping(req *http.Request, user string) (*signermap.Mapping, *restutil.RestError) {
+	var mapping signermap.Mapping
+	if err := json.NewDecoder(req.Body).Decode(&mapping); err != nil {
+		return nil, restutil.NewRestError(errors.Errorf(errors.SignerMappingBadJSON, err).Error(), 400)
+	}
+	// the user is the one of the path
+	mapping.User = user
+	if err := mapping.Validate(); err != nil {
+		return nil, restutil.NewRestError(err.Error(), 400)
+	}
+	if err := r.signerMappings.Put(&mapping); err != nil {
+		return nil, restutil.NewRestError(err.Error(), 500)
+	}
+	return &mapping, nil
+}
+
+func (r *router) deleteSignerMapping(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	log.Infof("--> %s %s", req.Method, req.URL)
+	if r.signerMappings == nil {
+		errors.RestErrReply(res, req, errors.Errorf(errors.SignerMappingsNotConfigured), 405)
+		return
+	}
+	user := params.ByName("user")
+	if !r.authorizeAdmin(res, req, identity.AdminOpDeleteSignerMapping, user) {
+		return
+	}
+
+	restErr := r.deleteMapping(user)
+	r.identityClient.AuditAdmin(req, identity.AdminOpDeleteSignerMapping, user, restErr)
+	if restErr != nil {
+		errors.RestErrReply(res, req, restErr.Error, restErr.StatusCode)
+		return
+	}
+	marshalAndReply(res, req, map[string]string{"user": user, "deleted": "true"})
+}
+
+func (r *router) deleteMapping(user string) *restutil.RestError {
+	mapping, err := r.signerMappings.Get(user)
+	if err != nil {
+		return restutil.NewRestError(err.Error(), 500)
+	}
+	if mapping == nil {
+		return restutil.NewRestError(errors.Errorf(errors.SignerMappingNotFound, user).Error(), 404)
+	}
+	if err := r.signerMappings.Delete(user); err != nil {
+		return restutil.NewRestError(err.Error(), 500)
+	}
+	return nil
+}
+
+// authorizeAdmin checks the caller may manage the signer mappings, which select the Fabric
+// identities the users sign with. It requires the identity administration role, or a security
+// module authorizing the route, and is denied otherwise
+func (r *router) authorizeAdmin(res http.ResponseWriter, req *http.Request, operation, target string) bool {
+	if r.identityClient == nil {
+		errors.RestErrReply(res, req, errors.Errorf(errors.IdentityAdminRoleNotConfigured, "manage signer mappings"), 403)
+		return false
+	}
+	if restErr := r.identityClient.AuthorizeAdmin(req, operation, target); restErr != nil {
+		errors.RestErrReply(res, req, restErr.Error, restErr.StatusCode)
+		return false
+	}
+	return true
+}
+
 func restAsyncReply(res http.ResponseWriter, req *http.Request, asyncResponse *messages.AsyncSentMsg) {
 	resBytes, _ := json.Marshal(asyncResponse)
 	status := 202 // accepted
@@ -45,6 +45,8 @@ type SyncDispatcher interface {
 	GetChainInfo(res http.ResponseWriter, req *http.Request, params httprouter.Params)
 	GetBlock(res http.ResponseWriter, req *http.Request, params httprouter.Params)
 	GetBlockByTxId(res http.ResponseWriter, req *http.Request, params httprouter.Params)
+	PrepareTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params)
+	EndorseTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params)
 }
 
 type syncDispatcher struct {
@@ -100,6 +102,9 @@ func (t *syncTxInflight) Reply(replyMessage messages.ReplyWithHeaders) {
 	replyHeaders.ID = utils.UUIDv4()
 	replyHeaders.Context = headers.Context
 	replyHeaders.ReqID = headers.ID
+	replyHeaders.Subject = headers.Subject
+	replyHeaders.Caller = headers.Caller
+	replyHeaders.Signer = headers.Signer
 	replyHeaders.Received = t.timeReceived.UTC().Format(time.RFC3339Nano)
 	replyTime := time.Now().UTC()
 	replyHeaders.Elapsed = replyTime.Sub(t.timeReceived).Seconds()
@@ -290,6 +295,41 @@ func (d *syncDispatcher) GetBlockByTxId(res http.ResponseWriter, req *http.Reque
 	sendReply(res, req, reply)
 }
 
+func (d *syncDispatcher) PrepareTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	msg, err := restutil.BuildPrepareTxMessage(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+
+	result, err1 := d.processor.GetRPCClient().PrepareTransaction(msg.Headers.ChannelID, msg.Headers.Signer, msg.Headers.ChaincodeName, msg.Function, msg.Args, msg.TransientMap, msg.IsInit)
+	if err1 != nil {
+		errors.RestErrReply(res, req, err1, 500)
+		return
+	}
+	log.Infof("Prepared transaction %s [chaincode=%s, func=%s] for signer %s", result.TransactionID, msg.Headers.ChaincodeName, msg.Function, msg.Headers.Signer)
+	sendReply(res, req, result)
+}
+
+func (d *syncDispatcher) EndorseTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	start := time.Now().UTC()
+	msg, _, err := restutil.BuildSignedTxMessage(res, req, params)
+	if err != nil {
+		errors.RestErrReply(res, req, err.Error, err.StatusCode)
+		return
+	}
+
+	result, err1 := d.processor.GetRPCClient().EndorseTransaction(msg.Headers.ChannelID, msg.Headers.Signer, msg.TxID, msg.Signature)
+	callTime := time.Now().UTC().Sub(start)
+	if err1 != nil {
+		log.Warnf("Endorsement of transaction %s failed: %s [%.2fs]", msg.TxID, err1, callTime.Seconds())
+		errors.RestErrReply(res, req, err1, 500)
+		return
+	}
+	log.Infof("Endorsed transaction %s [%.2fs]", msg.TxID, callTime.Seconds())
+	sendReply(res, req, result)
+}
+
 func sendReply(res http.ResponseWriter, req *http.Request, content interface{}) {
 	reply, _ := json.MarshalIndent(content, "", "  ")
 	log.Infof("<-- %s %s [%d]", req.Method, req.URL, 200)
@@ -47,6 +47,9 @@ func Setup() (string, *conf.RESTGatewayConf) {
   },
   "rpc": {
     "configPath": "/test-config-path"
+  },
+  "security": {
+    "authMode": "none"
   }
 }`
 	var testConfigJSONBad = `{
@@ -67,6 +70,9 @@ func Setup() (string, *conf.RESTGatewayConf) {
   },
   "rpc": {
     "configPath": "/test-config-path"
+  },
+  "security": {
+    "authMode": "none"
   }
 }`
 	var testRPCConfig = `name: "test profile"
@@ -17,6 +17,7 @@
 package util
 
 import (
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -41,6 +42,8 @@ type TxOpts struct {
 // these fly-* parameters are supported:
 //   - signer, channel, chaincode
 //
+// the signer must be resolved with getSigner, which applies the identity of the caller
+//
 // precedence order:
 //   - "headers" in body > query parameters > http headers
 //
@@ -51,10 +54,6 @@ type TxOpts struct {
 func getFlyParam(name string, body map[string]interface{}, req *http.Request) string {
 	valStr := ""
 
-	if name == "signer" {
-		valStr = req.Context().Value(auth.ContextKeyUsername).(string)
-		return valStr
-	}
 	// first look inside the "headers" section in the body
 	s := body["headers"]
 	if s != nil {
@@ -77,6 +76,40 @@ func getFlyParam(name string, body map[string]interface{}, req *http.Request) st
 	return valStr
 }
 
+// getSigner resolves the signer of the request. Authenticated callers sign as themselves, or with
+// one of the identities of their signer mapping, unless they are service accounts selecting one
+// of the signers they may act as
+func getSigner(body map[string]interface{}, req *http.Request, channel string) (string, *RestError) {
+	signer, err := auth.ResolveSigner(req.Context(), getFlyParam("signer", body, req), channel)
+	if err != nil {
+		return "", NewRestError(err.Error(), 403)
+	}
+	if signer == "" {
+		return "", NewRestError("Must specify the signer", 400)
+	}
+	return signer, nil
+}
+
+// GetRequestSigner resolves the signer of a request without a body, from the fly-signer and
+// fly-channel query parameters or headers
+func GetRequestSigner(req *http.Request) (string, *RestError) {
+	if err := req.ParseForm(); err != nil {
+		return "", NewRestError(err.Error(), 400)
+	}
+	var body map[string]interface{}
+	return getSigner(body, req, getFlyParam("channel", body, req))
+}
+
+// authorizeFabricCall lets the security module check the channel, chaincode and function
+// targeted through the REST route being called
+func authorizeFabricCall(req *http.Request, channel, chaincode, function string) *RestError {
+	ctx := req.Context()
+	if err := auth.AuthRPC(ctx, auth.GetRoute(ctx), channel, chaincode, function); err != nil {
+		return NewRestError(err.Error(), 403)
+	}
+	return nil
+}
+
 func getQueryParamNoCase(name string, req *http.Request) []string {
 	name = strings.ToLower(name)
 	for k, vs := range req.Form {
@@ -102,9 +135,9 @@ func BuildQueryMessage(res http.ResponseWriter, req *http.Request, params httpro
 	if channel == "" {
 		return nil, NewRestError("Must specify the channel", 400)
 	}
-	signer := getFlyParam("signer", body, req)
-	if signer == "" {
-		return nil, NewRestError("Must specify the signer", 400)
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, restErr
 	}
 	chaincode := getFlyParam("chaincode", body, req)
 	if chaincode == "" {
@@ -123,6 +156,9 @@ func BuildQueryMessage(res http.ResponseWriter, req *http.Request, params httpro
 	if msg.Function == "" {
 		return nil, NewRestError("Target chaincode function must not be empty", 400)
 	}
+	if err := authorizeFabricCall(req, channel, chaincode, msg.Function); err != nil {
+		return nil, err
+	}
 	argsVal, err := processArgs(body)
 	if err != nil {
 		return nil, NewRestError(err.Error(), 400)
@@ -156,9 +192,13 @@ func BuildTxByIdMessage(res http.ResponseWriter, req *http.Request, params httpr
 	if channel == "" {
 		return nil, NewRestError("Must specify the channel", 400)
 	}
-	signer := getFlyParam("signer", body, req)
-	if signer == "" {
-		return nil, NewRestError("Must specify the signer", 400)
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	if err := authorizeFabricCall(req, channel, "", ""); err != nil {
+		return nil, err
 	}
 
 	msg := messages.GetTxById{}
@@ -181,9 +221,13 @@ func BuildGetChainInfoMessage(res http.ResponseWriter, req *http.Request, params
 	if channel == "" {
 		return nil, NewRestError("Must specify the channel", 400)
 	}
-	signer := getFlyParam("signer", body, req)
-	if signer == "" {
-		return nil, NewRestError("Must specify the signer", 400)
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	if err := authorizeFabricCall(req, channel, "", ""); err != nil {
+		return nil, err
 	}
 
 	msg := messages.GetChainInfo{}
@@ -205,9 +249,13 @@ func BuildGetBlockMessage(res http.ResponseWriter, req *http.Request, params htt
 	if channel == "" {
 		return nil, NewRestError("Must specify the channel", 400)
 	}
-	signer := getFlyParam("signer", body, req)
-	if signer == "" {
-		return nil, NewRestError("Must specify the signer", 400)
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	if err := authorizeFabricCall(req, channel, "", ""); err != nil {
+		return nil, err
 	}
 
 	msg := messages.GetBlock{}
@@ -244,9 +292,13 @@ func BuildGetBlockByTxIdMessage(res http.ResponseWriter, req *http.Request, para
 	if channel == "" {
 		return nil, NewRestError("Must specify the channel", 400)
 	}
-	signer := getFlyParam("signer", body, req)
-	if signer == "" {
-		return nil, NewRestError("Must specify the signer", 400)
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, restErr
+	}
+
+	if err := authorizeFabricCall(req, channel, "", ""); err != nil {
+		return nil, err
 	}
 
 	msg := messages.GetBlockByTxId{}
@@ -266,15 +318,87 @@ func BuildTxMessage(res http.ResponseWriter, req *http.Request, params httproute
 	if err != nil {
 		return nil, nil, NewRestError(err.Error(), 400)
 	}
+	return buildTxMessage(body, req)
+}
+
+// BuildPrepareTxMessage reads a transaction to prepare for an external signer, which is created
+// with the certificate the CA issued to the signer
+func BuildPrepareTxMessage(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*messages.SendTransaction, *RestError) {
+	body, err := utils.ParseJSONPayload(req)
+	if err != nil {
+		return nil, NewRestError(err.Error(), 400)
+	}
+	err = req.ParseForm()
+	if err != nil {
+		return nil, NewRestError(err.Error(), 400)
+	}
+	msg, _, restErr := buildTxMessage(body, req)
+	if restErr != nil {
+		return nil, restErr
+	}
+	return msg, nil
+}
+
+// BuildSignedTxMessage reads the signature of the client over a phase of a prepared transaction.
+// The message submits the transaction, the endorsement only uses its headers and signature
+func BuildSignedTxMessage(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*messages.SendTransaction, *TxOpts, *RestError) {
+	body, err := utils.ParseJSONPayload(req)
+	if err != nil {
+		return nil, nil, NewRestError(err.Error(), 400)
+	}
+	err = req.ParseForm()
+	if err != nil {
+		return nil, nil, NewRestError(err.Error(), 400)
+	}
+
+	channel := getFlyParam("channel", body, req)
+	if channel == "" {
+		return nil, nil, NewRestError("Must specify the channel", 400)
+	}
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, nil, restErr
+	}
+	if err := authorizeFabricCall(req, channel, "", ""); err != nil {
+		return nil, nil, err
+	}
+	signature, _ := body["signature"].(string)
+	if signature == "" {
+		return nil, nil, NewRestError("Must specify the signature", 400)
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, nil, NewRestError(fmt.Sprintf("Invalid base64 signature: %s", err), 400)
+	}
+
+	msg := messages.SendTransaction{}
+	msg.Headers.ID = getFlyParam("id", body, req) // this could be empty
+	msg.Headers.MsgType = messages.MsgTypeSubmitTransaction
+	msg.Headers.ChannelID = channel
+	msg.Headers.Signer = signer
+	msg.Headers.Subject = auth.GetSubject(req.Context())
+	if caller := auth.GetUsername(req.Context()); caller != signer {
+		msg.Headers.Caller = caller
+	}
+	msg.TxID = params.ByName("txId")
+	msg.Signature = signatureBytes
 
+	opts, restErr := buildTxOpts(body, req)
+	if restErr != nil {
+		return nil, nil, restErr
+	}
+	return &msg, opts, nil
+}
+
+func buildTxMessage(body map[string]interface{}, req *http.Request) (*messages.SendTransaction, *TxOpts, *RestError) {
 	msgId := getFlyParam("id", body, req)
 	channel := getFlyParam("channel", body, req)
 	if channel == "" {
 		return nil, nil, NewRestError("Must specify the channel", 400)
 	}
-	signer := getFlyParam("signer", body, req)
-	if signer == "" {
-		return nil, nil, NewRestError("Must specify the signer", 400)
+	signer, restErr := getSigner(body, req, channel)
+	if restErr != nil {
+		return nil, nil, restErr
 	}
 	chaincode := getFlyParam("chaincode", body, req)
 	if chaincode == "" {
@@ -287,6 +411,12 @@ func BuildTxMessage(res http.ResponseWriter, req *http.Request, params httproute
 	msg.Headers.ChannelID = channel
 	msg.Headers.Signer = signer
 	msg.Headers.ChaincodeName = chaincode
+	// the receipt is only delivered to the WebSocket connections of the caller
+	msg.Headers.Subject = auth.GetSubject(req.Context())
+	if caller := auth.GetUsername(req.Context()); caller != signer {
+		// a service account acting as another signer
+		msg.Headers.Caller = caller
+	}
 	isInitVal := body["init"]
 	if isInitVal != nil {
 		strVal, ok := isInitVal.(string)
@@ -304,6 +434,9 @@ func BuildTxMessage(res http.ResponseWriter, req *http.Request, params httproute
 	if msg.Function == "" {
 		return nil, nil, NewRestError("Must specify target chaincode function", 400)
 	}
+	if err := authorizeFabricCall(req, channel, chaincode, msg.Function); err != nil {
+		return nil, nil, err
+	}
 	argsVal, err := processArgs(body)
 	if err != nil {
 		return nil, nil, NewRestError(err.Error(), 400)
@@ -318,6 +451,14 @@ func BuildTxMessage(res http.ResponseWriter, req *http.Request, params httproute
 		}
 	}
 
+	opts, restErr := buildTxOpts(body, req)
+	if restErr != nil {
+		return nil, nil, restErr
+	}
+	return &msg, opts, nil
+}
+
+func buildTxOpts(body map[string]interface{}, req *http.Request) (*TxOpts, *RestError) {
 	opts := TxOpts{}
 	opts.Sync = true
 	opts.Ack = true
@@ -325,7 +466,7 @@ func BuildTxMessage(res http.ResponseWriter, req *http.Request, params httproute
 	if syncVal != "" {
 		sync, err := strconv.ParseBool(syncVal)
 		if err != nil {
-			return nil, nil, NewRestError(err.Error(), 400)
+			return nil, NewRestError(err.Error(), 400)
 		}
 		opts.Sync = sync
 	}
@@ -333,12 +474,11 @@ func BuildTxMessage(res http.ResponseWriter, req *http.Request, params httproute
 	if noAckVal != "" {
 		noack, err := strconv.ParseBool(noAckVal)
 		if err != nil {
-			return nil, nil, NewRestError(err.Error(), 400)
+			return nil, NewRestError(err.Error(), 400)
 		}
 		opts.Ack = !noack
 	}
-
-	return &msg, &opts, nil
+	return &opts, nil
 }
 
 func processArgs(body map[string]interface{}) ([]string, error) {
@@ -17,9 +17,17 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/conf"
+	"github.com/hyperledger/firefly-fabconnect/internal/messages"
+	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -581,3 +589,85 @@ func TestProcessArgsTypesInvalidIntegerArray(t *testing.T) {
 	_, err := processArgs(body)
 	assert.ErrorContains(err, "Expected: integer, given: string")
 }
+
+func TestBuildTxMessageActAs(t *testing.T) {
+	assert := assert.New(t)
+	auth.RegisterServiceAccounts([]conf.ServiceAccount{{Username: "service-account-billing", ActAs: []string{"alice"}}})
+	defer auth.RegisterServiceAccounts(nil)
+
+	newRequest := func(username, signer string) *http.Request {
+		req := httptest.NewRequest("POST", "/transactions?fly-channel=default-channel&fly-chaincode=asset_transfer", strings.NewReader(payloadNoSchema))
+		req.Header.Set("Content-Type", "application/json")
+		if signer != "" {
+			req.Header.Set("x-firefly-signer", signer)
+		}
+		ctx := context.WithValue(req.Context(), auth.ContextKeyUsername, username)
+		ctx = context.WithValue(ctx, auth.ContextKeySubID, username+"-sub")
+		return req.WithContext(ctx)
+	}
+
+	msg, _, restErr := BuildTxMessage(nil, newRequest("user1", ""), nil)
+	assert.Nil(restErr)
+	assert.Equal("user1", msg.Headers.Signer)
+	assert.Equal("user1-sub", msg.Headers.Subject)
+	assert.Empty(msg.Headers.Caller)
+
+	msg, _, restErr = BuildTxMessage(nil, newRequest("service-account-billing", "alice"), nil)
+	assert.Nil(restErr)
+	assert.Equal("alice", msg.Headers.Signer)
+	assert.Equal("service-account-billing-sub", msg.Headers.Subject)
+	assert.Equal("service-account-billing", msg.Headers.Caller)
+
+	_, _, restErr = BuildTxMessage(nil, newRequest("user1", "alice"), nil)
+	assert.Equal(403, restErr.StatusCode)
+	assert.Regexp("'user1' is not allowed to act as signer 'alice'", restErr.Error)
+}
+
+func TestBuildPrepareTxMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/transactions/prepare?fly-channel=default-channel&fly-chaincode=asset_transfer", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(context.WithValue(req.Context(), auth.ContextKeyUsername, "user1"))
+	}
+
+	msg, restErr := BuildPrepareTxMessage(nil, newRequest(`{"func":"CreateAsset","args":["asset1"]}`), nil)
+	assert.Nil(restErr)
+	assert.Equal("user1", msg.Headers.Signer)
+	assert.Equal("asset_transfer", msg.Headers.ChaincodeName)
+	assert.Equal([]string{"asset1"}, msg.Args)
+
+	// the certificate of the signer is not taken from the request
+	msg, restErr = BuildPrepareTxMessage(nil, newRequest(`{"func":"CreateAsset","args":[],"certificate":"-----BEGIN CERTIFICATE-----"}`), nil)
+	assert.Nil(restErr)
+	assert.Equal("CreateAsset", msg.Function)
+
+	_, restErr = BuildPrepareTxMessage(nil, newRequest(`!json`), nil)
+	assert.Equal(400, restErr.StatusCode)
+}
+
+func TestBuildSignedTxMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/transactions/tx1/submit?fly-channel=default-channel&fly-sync=false", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(context.WithValue(req.Context(), auth.ContextKeyUsername, "user1"))
+	}
+	params := httprouter.Params{{Key: "txId", Value: "tx1"}}
+
+	msg, opts, restErr := BuildSignedTxMessage(nil, newRequest(`{"signature":"MEUCIQ=="}`), params)
+	assert.Nil(restErr)
+	assert.Equal(messages.MsgTypeSubmitTransaction, msg.Headers.MsgType)
+	assert.Equal("default-channel", msg.Headers.ChannelID)
+	assert.Equal("user1", msg.Headers.Signer)
+	assert.Equal("tx1", msg.TxID)
+	assert.Equal([]byte{0x30, 0x45, 0x02, 0x21}, msg.Signature)
+	assert.False(opts.Sync)
+
+	_, _, restErr = BuildSignedTxMessage(nil, newRequest(`{}`), params)
+	assert.Equal("Must specify the signature", restErr.Error.Error())
+	_, _, restErr = BuildSignedTxMessage(nil, newRequest(`{"signature":"!"}`), params)
+	assert.Regexp("Invalid base64 signature", restErr.Error)
+}
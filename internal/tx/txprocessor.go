@@ -106,7 +106,7 @@ func (p *txProcessor) OnMessage(txContext TxContext) {
 	headers := txContext.Headers()
 	log.Debugf("Processing %+v", headers)
 	switch headers.MsgType {
-	case messages.MsgTypeSendTransaction:
+	case messages.MsgTypeSendTransaction, messages.MsgTypeSubmitTransaction:
 		var sendTransactionMsg messages.SendTransaction
 		if unmarshalErr = txContext.Unmarshal(&sendTransactionMsg); unmarshalErr != nil {
 			break
@@ -33,8 +33,6 @@ func CreateTLSConfiguration(tlsConfig *conf.TLSConfig) (t *tls.Config, err error
 		err = errors.Errorf(errors.ConfigTLSCertOrKey)
 		return
 	}
-	
-	log.Printf("HERE %s", tlsConfig.ClientCertsFile)
 
 	mutualAuth := tlsConfig.ClientCertsFile != "" && tlsConfig.ClientKeyFile != ""
 	log.Debugf("Kafka TLS Enabled=%t Insecure=%t MutualAuth=%t ClientCertsFile=%s PrivateKeyFile=%s CACertsFile=%s",
@@ -71,3 +69,16 @@ func CreateTLSConfiguration(tlsConfig *conf.TLSConfig) (t *tls.Config, err error
 	}
 	return
 }
+
+// LoadCertPool reads a PEM bundle of CA certificates into a pool
+func LoadCertPool(caCertsFile string) (*x509.CertPool, error) {
+	caCert, err := ioutil.ReadFile(caCertsFile)
+	if err != nil {
+		return nil, errors.Errorf(errors.ConfigTLSCACertsLoad, caCertsFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.Errorf(errors.ConfigTLSCACertsLoad, caCertsFile, "no PEM certificates")
+	}
+	return pool, nil
+}
@@ -17,6 +17,8 @@
 package ws
 
 import (
+	"context"
+	"net/http"
 	"reflect"
 	"strings"
 	"sync"
@@ -25,32 +27,41 @@ import (
 	ws "github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
 	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/hyperledger/firefly-fabconnect/internal/utils"
 )
 
 type webSocketConnection struct {
-	id        string
-	server    *webSocketServer
-	conn      *ws.Conn
-	mux       sync.Mutex
-	closed    bool
-	topics    map[string]*webSocketTopic
-	broadcast chan interface{}
-	newTopic  chan bool
-	receive   chan error
-	closing   chan struct{}
+	id          string
+	ctx         context.Context
+	req         *http.Request
+	token       string
+	expiryTimer *time.Timer
+	server      *webSocketServer
+	conn        *ws.Conn
+	mux         sync.Mutex
+	closed      bool
+	topics      map[string]*webSocketTopic
+	broadcast   chan interface{}
+	newTopic    chan bool
+	receive     chan error
+	closing     chan struct{}
 }
 
 type webSocketCommandMessage struct {
 	Type    string `json:"type,omitempty"`
 	Topic   string `json:"topic,omitempty"`
 	Message string `json:"message,omitempty"`
+	Token   string `json:"token,omitempty"`
 }
 
-func newConnection(server *webSocketServer, conn *ws.Conn) *webSocketConnection {
+// newConnection starts a connection, which is not authenticated yet when the context is nil
+func newConnection(ctx context.Context, server *webSocketServer, conn *ws.Conn, req *http.Request) *webSocketConnection {
 	wsc := &webSocketConnection{
 		id:        utils.UUIDv4(),
+		ctx:       ctx,
+		req:       req,
 		server:    server,
 		conn:      conn,
 		newTopic:  make(chan bool),
@@ -59,6 +70,12 @@ func newConnection(server *webSocketServer, conn *ws.Conn) *webSocketConnection
 		receive:   make(chan error),
 		closing:   make(chan struct{}),
 	}
+	if ctx == nil {
+		time.AfterFunc(server.processingTimeout, wsc.checkAuthenticated)
+	} else {
+		wsc.token, _ = tokenFromRequest(req)
+		wsc.scheduleExpiry()
+	}
 	go wsc.listen()
 	go wsc.sender()
 	return wsc
@@ -70,6 +87,9 @@ func (c *webSocketConnection) close() {
 		c.closed = true
 		c.conn.Close()
 		close(c.closing)
+		if c.expiryTimer != nil {
+			c.expiryTimer.Stop()
+		}
 	}
 	c.mux.Unlock()
 
@@ -146,12 +166,33 @@ func (c *webSocketConnection) listen() {
 		}
 		log.Debugf("WS/%s: Received: %+v", c.id, msg)
 
+		msgType := strings.ToLower(msg.Type)
+		if msgType == "auth" {
+			if err := c.authenticate(msg.Token); err != nil {
+				c.sendError("", err)
+			}
+			continue
+		}
+		ctx := c.authContext()
+		if ctx == nil {
+			c.sendError(msg.Topic, errors.Errorf(errors.WebSocketNotAuthenticated))
+			continue
+		}
+
 		t := c.server.getTopic(msg.Topic)
-		switch strings.ToLower(msg.Type) {
+		switch msgType {
 		case "listen":
 			log.Debugf("Client requested listening on topic: \"%s\"", t.topic)
+			if err := auth.AuthRPCSubscribe(ctx, auth.WebSocketNamespace, t.topic); err != nil {
+				c.sendError(t.topic, err)
+				continue
+			}
 			c.listenTopic(t)
 		case "listenreplies":
+			if err := auth.AuthListAsyncReplies(ctx); err != nil {
+				c.sendError("", err)
+				continue
+			}
 			c.listenReplies()
 		case "ack":
 			c.handleAckOrError(t, nil)
@@ -163,6 +204,106 @@ func (c *webSocketConnection) listen() {
 	}
 }
 
+// authContext is the context of the authenticated caller, or nil before the client authenticates
+func (c *webSocketConnection) authContext() context.Context {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.ctx
+}
+
+// authenticate binds a token sent in an "auth" frame to the connection. A client refreshes its token
+// before it expires with another "auth" frame, which must be for the same subject
+func (c *webSocketConnection) authenticate(token string) error {
+	if c.server.authenticate == nil {
+		return nil
+	}
+	ctx, err := c.server.authenticate(context.Background(), c.req, token)
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.ctx != nil && auth.GetSubject(ctx) != auth.GetSubject(c.ctx) {
+		return errors.Errorf(errors.WebSocketSubjectChanged, auth.GetSubject(c.ctx), auth.GetSubject(ctx))
+	}
+	c.ctx = ctx
+	c.token = token
+	c.scheduleExpiryLocked()
+	log.Infof("WS/%s: Authenticated '%s'", c.id, auth.GetSubject(ctx))
+	return nil
+}
+
+func (c *webSocketConnection) scheduleExpiry() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.scheduleExpiryLocked()
+}
+
+func (c *webSocketConnection) scheduleExpiryLocked() {
+	if c.expiryTimer != nil {
+		c.expiryTimer.Stop()
+	}
+	if expiry := auth.GetExpiry(c.ctx); !expiry.IsZero() && !c.closed && c.server.authenticate != nil {
+		c.expiryTimer = time.AfterFunc(time.Until(expiry), c.revalidate)
+	}
+}
+
+// revalidate checks the token bound to the connection once it expires, and closes the connection
+// unless the client sent a new one
+func (c *webSocketConnection) revalidate() {
+	c.mux.Lock()
+	token := c.token
+	c.mux.Unlock()
+	ctx, err := c.server.authenticate(context.Background(), c.req, token)
+	if err == nil && auth.GetExpiry(ctx).After(time.Now()) {
+		// accepted within the clock skew
+		c.mux.Lock()
+		c.ctx = ctx
+		c.scheduleExpiryLocked()
+		c.mux.Unlock()
+		return
+	}
+	log.Infof("WS/%s: Token expired", c.id)
+	c.closeWithReason(errors.Errorf(errors.WebSocketTokenExpired))
+}
+
+func (c *webSocketConnection) checkAuthenticated() {
+	if c.authContext() == nil {
+		log.Errorf("WS/%s: Not authenticated after %.2f seconds. Closing connection", c.id, c.server.processingTimeout.Seconds())
+		c.closeWithReason(errors.Errorf(errors.WebSocketNotAuthenticated))
+	}
+}
+
+// closeWithReason tells the client why the connection is closed, in the close frame
+func (c *webSocketConnection) closeWithReason(err error) {
+	msg := ws.FormatCloseMessage(ws.ClosePolicyViolation, err.Error())
+	_ = c.conn.WriteControl(ws.CloseMessage, msg, time.Now().Add(time.Second))
+	c.close()
+}
+
+// acceptsReply checks the caller of the connection made the request of a reply.
+// Without authentication every reply is delivered, otherwise a reply without a subject is not
+func (c *webSocketConnection) acceptsReply(message interface{}) bool {
+	if c.server.authenticate == nil {
+		return true
+	}
+	ctx := c.authContext()
+	if ctx == nil {
+		return false
+	}
+	subject := replySubject(message)
+	return subject != "" && subject == auth.GetSubject(ctx)
+}
+
+// sendError tells the client a command was rejected
+func (c *webSocketConnection) sendError(topic string, err error) {
+	log.Errorf("WS/%s: Command rejected: %s", c.id, err)
+	select {
+	case c.broadcast <- &webSocketCommandMessage{Type: "error", Topic: topic, Message: err.Error()}:
+	case <-c.closing:
+	}
+}
+
 func (c *webSocketConnection) handleAckOrError(t *webSocketTopic, err error) {
 	isError := err != nil
 	select {
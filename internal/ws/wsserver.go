@@ -17,16 +17,27 @@
 package ws
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/hyperledger/firefly-fabconnect/internal/errors"
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// tokenProtocol is offered by the client in the Sec-WebSocket-Protocol header, followed by the token
+	tokenProtocol = "access_token"
+	// tokenQueryParam is the query parameter of the token, when not passed in a header or an auth frame
+	tokenQueryParam = "access_token"
+)
+
 // WebSocketChannels is provided to allow us to do a blocking send to a namespace that will complete once a client connects on it
 // We also provide a channel to listen on for closing of the connection, to allow a select to wake on a blocking send
 type WebSocketChannels interface {
@@ -34,6 +45,11 @@ type WebSocketChannels interface {
 	SendReply(message interface{})
 }
 
+// Authenticator verifies the token presented by a client, and returns the context of the authenticated
+// caller. The request is the upgrade request of the connection. The token is empty when the client did
+// not pass one with the upgrade request, the client must then send it in an "auth" frame
+type Authenticator func(ctx context.Context, req *http.Request, token string) (context.Context, error)
+
 // WebSocketServer is the full server interface with the init call
 type WebSocketServer interface {
 	WebSocketChannels
@@ -51,6 +67,7 @@ type webSocketServer struct {
 	replyChannel      chan interface{}
 	upgrader          *websocket.Upgrader
 	connections       map[string]*webSocketConnection
+	authenticate      Authenticator
 }
 
 type webSocketTopic struct {
@@ -61,9 +78,11 @@ type webSocketTopic struct {
 	closingChannel   chan struct{}
 }
 
-// NewWebSocketServer create a new server with a simplified interface
-func NewWebSocketServer() WebSocketServer {
+// NewWebSocketServer create a new server with a simplified interface. The connections are not
+// authenticated when the authenticator is nil
+func NewWebSocketServer(authenticate Authenticator) WebSocketServer {
 	s := &webSocketServer{
+		authenticate:      authenticate,
 		connections:       make(map[string]*webSocketConnection),
 		topics:            make(map[string]*webSocketTopic),
 		topicMap:          make(map[string]map[string]*webSocketConnection),
@@ -82,17 +101,54 @@ func NewWebSocketServer() WebSocketServer {
 }
 
 func (s *webSocketServer) NewConnection(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	token, offered := tokenFromRequest(r)
+	var responseHeader http.Header
+	if offered {
+		// the client only accepts the upgrade if one of its protocols is selected
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": []string{tokenProtocol}}
+	}
+
+	authCtx := r.Context()
+	if s.authenticate != nil {
+		var err error
+		authCtx, err = s.authenticate(context.Background(), r, token)
+		if err != nil {
+			if token != "" {
+				log.Errorf("WebSocket authentication failed: %s", err)
+				errors.RestErrReply(w, r, fmt.Errorf("Unauthorized"), 401)
+				return
+			}
+			// the client has to authenticate with its first frame
+			authCtx = nil
+		}
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Errorf("WebSocket upgrade failed: %s", err)
 		return
 	}
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	c := newConnection(s, conn)
+	c := newConnection(authCtx, s, conn, r)
 	s.connections[c.id] = c
 }
 
+// tokenFromRequest extracts the token from the Sec-WebSocket-Protocol header, where it follows the
+// "access_token" protocol, or from the access_token query parameter
+func tokenFromRequest(r *http.Request) (token string, offered bool) {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == tokenProtocol {
+			if i+1 < len(protocols) {
+				return protocols[i+1], true
+			}
+			return "", true
+		}
+	}
+	return strings.TrimSpace(r.URL.Query().Get(tokenQueryParam)), false
+}
+
 func (s *webSocketServer) cycleTopic(t *webSocketTopic) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
@@ -215,12 +271,28 @@ func (s *webSocketServer) processReplies() {
 	for {
 		message := <-s.replyChannel
 		s.mux.Lock()
-		wsconns := getConnListFromMap(s.replyMap)
+		wsconns := make([]*webSocketConnection, 0, len(s.replyMap))
+		for _, c := range s.replyMap {
+			if c.acceptsReply(message) {
+				wsconns = append(wsconns, c)
+			}
+		}
 		s.mux.Unlock()
 		s.broadcastToConnections(wsconns, message)
 	}
 }
 
+// replySubject extracts the subject of the caller that submitted the request of a reply
+func replySubject(message interface{}) string {
+	if m, ok := message.(map[string]interface{}); ok {
+		if headers, ok := m["headers"].(map[string]interface{}); ok {
+			subject, _ := headers["subject"].(string)
+			return subject
+		}
+	}
+	return ""
+}
+
 func (s *webSocketServer) broadcastToConnections(connections []*webSocketConnection, message interface{}) {
 	for _, c := range connections {
 		c.broadcast <- message
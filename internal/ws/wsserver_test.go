@@ -17,6 +17,8 @@
 package ws
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -25,13 +27,19 @@ import (
 	"time"
 
 	ws "github.com/gorilla/websocket"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth"
+	"github.com/hyperledger/firefly-fabconnect/internal/auth/authtest"
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func newTestWebSocketServer() (*webSocketServer, *httptest.Server) {
-	s := NewWebSocketServer().(*webSocketServer)
+	return newTestAuthWebSocketServer(nil)
+}
+
+func newTestAuthWebSocketServer(authenticate Authenticator) (*webSocketServer, *httptest.Server) {
+	s := NewWebSocketServer(authenticate).(*webSocketServer)
 	r := &httprouter.Router{}
 	r.GET("/ws", s.NewConnection)
 	ts := httptest.NewServer(r)
@@ -352,3 +360,267 @@ func TestSendReply(t *testing.T) {
 	_ = c.ReadJSON(&val)
 	assert.Equal("Hello World", val)
 }
+
+func TestListenDeniedBySecurityModule(t *testing.T) {
+	assert := assert.New(t)
+	auth.RegisterSecurityModule(&authtest.TestSecurityModule{})
+	defer auth.RegisterSecurityModule(nil)
+
+	w, ts := newTestWebSocketServer()
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+	assert.NoError(err)
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "listen",
+		Topic: "topic1",
+	})
+	var msg webSocketCommandMessage
+	err = c.ReadJSON(&msg)
+	assert.NoError(err)
+	assert.Equal("error", msg.Type)
+	assert.Equal("topic1", msg.Topic)
+	assert.Equal("No auth context", msg.Message)
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type: "listenreplies",
+	})
+	err = c.ReadJSON(&msg)
+	assert.NoError(err)
+	assert.Equal("error", msg.Type)
+	assert.Equal("No auth context", msg.Message)
+
+	w.mux.Lock()
+	assert.Empty(w.topicMap["topic1"])
+	assert.Empty(w.replyMap)
+	w.mux.Unlock()
+
+	w.Close()
+}
+
+// testAuthenticator accepts the tokens "user1" and "user2", "anonymous" without a subject, and "expiring" until the expiry
+func testAuthenticator(expiry time.Time) Authenticator {
+	return func(ctx context.Context, req *http.Request, token string) (context.Context, error) {
+		switch token {
+		case "anonymous":
+			return context.WithValue(ctx, auth.ContextKeyAuthContext, token), nil
+		case "user1", "user2":
+		case "expiring":
+			if time.Now().After(expiry) {
+				return nil, fmt.Errorf("token expired")
+			}
+			ctx = context.WithValue(ctx, auth.ContextKeyExpiry, expiry)
+		default:
+			return nil, fmt.Errorf("invalid token")
+		}
+		ctx = context.WithValue(ctx, auth.ContextKeyAuthContext, token)
+		ctx = context.WithValue(ctx, auth.ContextKeySubID, token+"-id")
+		return ctx, nil
+	}
+}
+
+func testReply(subject, id string) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":     id,
+		"headers": map[string]interface{}{"subject": subject},
+	}
+}
+
+func TestAuthTokenInProtocolHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	w, ts := newTestAuthWebSocketServer(testAuthenticator(time.Time{}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), http.Header{"Sec-WebSocket-Protocol": []string{"access_token, user1"}})
+	assert.NoError(err)
+	assert.Equal("access_token", c.Subprotocol())
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type: "listenReplies",
+	})
+	for {
+		w.mux.Lock()
+		n := len(w.replyMap)
+		w.mux.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// only the receipts of the requests made by the caller are delivered
+	w.SendReply(testReply("user2-id", "reply1"))
+	w.SendReply(testReply("", "reply2"))
+	w.SendReply(testReply("user1-id", "reply3"))
+
+	var val map[string]interface{}
+	_ = c.ReadJSON(&val)
+	assert.Equal("reply3", val["_id"])
+
+	w.Close()
+}
+
+func TestAuthNoSubjectReceivesNoReplies(t *testing.T) {
+	assert := assert.New(t)
+
+	w, ts := newTestAuthWebSocketServer(testAuthenticator(time.Time{}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), http.Header{"Sec-WebSocket-Protocol": []string{"access_token, anonymous"}})
+	assert.NoError(err)
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type: "listenReplies",
+	})
+	for {
+		w.mux.Lock()
+		n := len(w.replyMap)
+		w.mux.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a reply without a subject is not delivered to a caller without one either
+	w.SendReply(testReply("", "reply1"))
+	w.SendReply(testReply("user1-id", "reply2"))
+
+	_ = c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var val map[string]interface{}
+	err = c.ReadJSON(&val)
+	assert.Error(err)
+
+	w.Close()
+}
+
+func TestAuthTokenInQueryParam(t *testing.T) {
+	assert := assert.New(t)
+
+	w, ts := newTestAuthWebSocketServer(testAuthenticator(time.Time{}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	u.RawQuery = "access_token=bad"
+	_, res, err := ws.DefaultDialer.Dial(u.String(), nil)
+	assert.Error(err)
+	assert.Equal(401, res.StatusCode)
+
+	u.RawQuery = "access_token=user1"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+	assert.NoError(err)
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "listen",
+		Topic: "topic1",
+	})
+	s, _, _, _ := w.GetChannels("topic1")
+	s <- "Hello World"
+	var val string
+	_ = c.ReadJSON(&val)
+	assert.Equal("Hello World", val)
+
+	w.Close()
+}
+
+func TestAuthFrame(t *testing.T) {
+	assert := assert.New(t)
+
+	w, ts := newTestAuthWebSocketServer(testAuthenticator(time.Time{}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+	assert.NoError(err)
+
+	var msg webSocketCommandMessage
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "listen",
+		Topic: "topic1",
+	})
+	_ = c.ReadJSON(&msg)
+	assert.Equal("error", msg.Type)
+	assert.Regexp("Not authenticated", msg.Message)
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "auth",
+		Token: "bad",
+	})
+	_ = c.ReadJSON(&msg)
+	assert.Equal("invalid token", msg.Message)
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "auth",
+		Token: "user1",
+	})
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "auth",
+		Token: "user2",
+	})
+	_ = c.ReadJSON(&msg)
+	assert.Equal("The token of 'user1-id' cannot be replaced by a token of 'user2-id'", msg.Message)
+
+	_ = c.WriteJSON(&webSocketCommandMessage{
+		Type:  "listen",
+		Topic: "topic1",
+	})
+	s, _, _, _ := w.GetChannels("topic1")
+	s <- "Hello World"
+	var val string
+	_ = c.ReadJSON(&val)
+	assert.Equal("Hello World", val)
+
+	w.Close()
+}
+
+func TestAuthFrameTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	w, ts := newTestAuthWebSocketServer(testAuthenticator(time.Time{}))
+	defer ts.Close()
+	w.processingTimeout = 10 * time.Millisecond
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+	assert.NoError(err)
+
+	_, _, err = c.ReadMessage()
+	assert.Regexp("Not authenticated", err)
+
+	w.Close()
+}
+
+func TestAuthTokenExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	w, ts := newTestAuthWebSocketServer(testAuthenticator(time.Now().Add(200 * time.Millisecond)))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	u.RawQuery = "access_token=expiring"
+	c, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+	assert.NoError(err)
+
+	_, _, err = c.ReadMessage()
+	assert.Regexp("Access token expired", err)
+
+	w.Close()
+}
@@ -32,6 +32,52 @@ func (_m *RPCClient) Close() error {
 	return r0
 }
 
+// EndorseTransaction provides a mock function with given fields: channelId, signer, txId, signature
+func (_m *RPCClient) EndorseTransaction(channelId string, signer string, txId string, signature []byte) (*client.PreparedTx, error) {
+	ret := _m.Called(channelId, signer, txId, signature)
+
+	var r0 *client.PreparedTx
+	if rf, ok := ret.Get(0).(func(string, string, string, []byte) *client.PreparedTx); ok {
+		r0 = rf(channelId, signer, txId, signature)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.PreparedTx)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, []byte) error); ok {
+		r1 = rf(channelId, signer, txId, signature)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSubmittedTxReceipt provides a mock function with given fields: channelId, signer, txId
+func (_m *RPCClient) GetSubmittedTxReceipt(channelId string, signer string, txId string) (*client.TxReceipt, error) {
+	ret := _m.Called(channelId, signer, txId)
+
+	var r0 *client.TxReceipt
+	if rf, ok := ret.Get(0).(func(string, string, string) *client.TxReceipt); ok {
+		r0 = rf(channelId, signer, txId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.TxReceipt)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string) error); ok {
+		r1 = rf(channelId, signer, txId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Invoke provides a mock function with given fields: channelId, signer, chaincodeName, method, args, transientMap, isInit
 func (_m *RPCClient) Invoke(channelId string, signer string, chaincodeName string, method string, args []string, transientMap map[string]string, isInit bool) (*client.TxReceipt, error) {
 	ret := _m.Called(channelId, signer, chaincodeName, method, args, transientMap, isInit)
@@ -55,6 +101,29 @@ func (_m *RPCClient) Invoke(channelId string, signer string, chaincodeName strin
 	return r0, r1
 }
 
+// PrepareTransaction provides a mock function with given fields: channelId, signer, chaincodeName, method, args, transientMap, isInit
+func (_m *RPCClient) PrepareTransaction(channelId string, signer string, chaincodeName string, method string, args []string, transientMap map[string]string, isInit bool) (*client.PreparedTx, error) {
+	ret := _m.Called(channelId, signer, chaincodeName, method, args, transientMap, isInit)
+
+	var r0 *client.PreparedTx
+	if rf, ok := ret.Get(0).(func(string, string, string, string, []string, map[string]string, bool) *client.PreparedTx); ok {
+		r0 = rf(channelId, signer, chaincodeName, method, args, transientMap, isInit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.PreparedTx)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, string, []string, map[string]string, bool) error); ok {
+		r1 = rf(channelId, signer, chaincodeName, method, args, transientMap, isInit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Query provides a mock function with given fields: channelId, signer, chaincodeName, method, args, strongread
 func (_m *RPCClient) Query(channelId string, signer string, chaincodeName string, method string, args []string, strongread bool) ([]byte, error) {
 	ret := _m.Called(channelId, signer, chaincodeName, method, args, strongread)
@@ -188,6 +257,29 @@ func (_m *RPCClient) QueryTransaction(channelId string, signer string, txId stri
 	return r0, r1
 }
 
+// SubmitTransaction provides a mock function with given fields: channelId, signer, txId, signature
+func (_m *RPCClient) SubmitTransaction(channelId string, signer string, txId string, signature []byte) (*client.TxReceipt, error) {
+	ret := _m.Called(channelId, signer, txId, signature)
+
+	var r0 *client.TxReceipt
+	if rf, ok := ret.Get(0).(func(string, string, string, []byte) *client.TxReceipt); ok {
+		r0 = rf(channelId, signer, txId, signature)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*client.TxReceipt)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, []byte) error); ok {
+		r1 = rf(channelId, signer, txId, signature)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // SubscribeEvent provides a mock function with given fields: subInfo, since
 func (_m *RPCClient) SubscribeEvent(subInfo *api.SubscriptionInfo, since uint64) (*client.RegistrationWrapper, <-chan *fab.BlockEvent, <-chan *fab.CCEvent, error) {
 	ret := _m.Called(subInfo, since)
@@ -13,6 +13,29 @@ type CAClient struct {
 	mock.Mock
 }
 
+// AddAffiliation provides a mock function with given fields: _a0
+func (_m *CAClient) AddAffiliation(_a0 *api.AffiliationRequest) (*api.AffiliationResponse, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *api.AffiliationResponse
+	if rf, ok := ret.Get(0).(func(*api.AffiliationRequest) *api.AffiliationResponse); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.AffiliationResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*api.AffiliationRequest) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Enroll provides a mock function with given fields: _a0
 func (_m *CAClient) Enroll(_a0 *api.EnrollmentRequest) error {
 	ret := _m.Called(_a0)
@@ -27,6 +50,52 @@ func (_m *CAClient) Enroll(_a0 *api.EnrollmentRequest) error {
 	return r0
 }
 
+// GetAffiliation provides a mock function with given fields: _a0, _a1
+func (_m *CAClient) GetAffiliation(_a0 string, _a1 string) (*api.AffiliationResponse, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 *api.AffiliationResponse
+	if rf, ok := ret.Get(0).(func(string, string) *api.AffiliationResponse); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.AffiliationResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAllAffiliations provides a mock function with given fields: _a0
+func (_m *CAClient) GetAllAffiliations(_a0 string) (*api.AffiliationResponse, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *api.AffiliationResponse
+	if rf, ok := ret.Get(0).(func(string) *api.AffiliationResponse); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.AffiliationResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAllIdentities provides a mock function with given fields: _a0
 func (_m *CAClient) GetAllIdentities(_a0 string) ([]*api.IdentityResponse, error) {
 	ret := _m.Called(_a0)
@@ -96,6 +165,29 @@ func (_m *CAClient) GetIdentity(_a0 string, _a1 string) (*api.IdentityResponse,
 	return r0, r1
 }
 
+// ModifyAffiliation provides a mock function with given fields: _a0
+func (_m *CAClient) ModifyAffiliation(_a0 *api.ModifyAffiliationRequest) (*api.AffiliationResponse, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *api.AffiliationResponse
+	if rf, ok := ret.Get(0).(func(*api.ModifyAffiliationRequest) *api.AffiliationResponse); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.AffiliationResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*api.ModifyAffiliationRequest) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ModifyIdentity provides a mock function with given fields: _a0
 func (_m *CAClient) ModifyIdentity(_a0 *api.IdentityRequest) (*api.IdentityResponse, error) {
 	ret := _m.Called(_a0)
@@ -154,6 +246,52 @@ func (_m *CAClient) Register(_a0 *api.RegistrationRequest) (string, error) {
 	return r0, r1
 }
 
+// RemoveAffiliation provides a mock function with given fields: _a0
+func (_m *CAClient) RemoveAffiliation(_a0 *api.AffiliationRequest) (*api.AffiliationResponse, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *api.AffiliationResponse
+	if rf, ok := ret.Get(0).(func(*api.AffiliationRequest) *api.AffiliationResponse); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.AffiliationResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*api.AffiliationRequest) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RemoveIdentity provides a mock function with given fields: _a0
+func (_m *CAClient) RemoveIdentity(_a0 *api.RemoveIdentityRequest) (*api.IdentityResponse, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *api.IdentityResponse
+	if rf, ok := ret.Get(0).(func(*api.RemoveIdentityRequest) *api.IdentityResponse); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.IdentityResponse)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*api.RemoveIdentityRequest) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Revoke provides a mock function with given fields: _a0
 func (_m *CAClient) Revoke(_a0 *api.RevocationRequest) (*api.RevocationResponse, error) {
 	ret := _m.Called(_a0)
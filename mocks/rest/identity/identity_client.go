@@ -5,6 +5,7 @@ package mockidentity
 import (
 	http "net/http"
 
+	messages "github.com/hyperledger/firefly-fabconnect/internal/messages"
 	identity "github.com/hyperledger/firefly-fabconnect/internal/rest/identity"
 	httprouter "github.com/julienschmidt/httprouter"
 
@@ -18,6 +19,107 @@ type IdentityClient struct {
 	mock.Mock
 }
 
+// AddAffiliation provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) AddAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.Affiliation
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.Affiliation); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.Affiliation)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// AuditAdmin provides a mock function with given fields: req, operation, target, restErr
+func (_m *IdentityClient) AuditAdmin(req *http.Request, operation string, target string, restErr *util.RestError) {
+	_m.Called(req, operation, target, restErr)
+}
+
+// AuthorizeAdmin provides a mock function with given fields: req, operation, target
+func (_m *IdentityClient) AuthorizeAdmin(req *http.Request, operation string, target string) *util.RestError {
+	ret := _m.Called(req, operation, target)
+
+	var r0 *util.RestError
+	if rf, ok := ret.Get(0).(func(*http.Request, string, string) *util.RestError); ok {
+		r0 = rf(req, operation, target)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*util.RestError)
+		}
+	}
+
+	return r0
+}
+
+// BulkProvision provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) BulkProvision(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*messages.AsyncSentMsg, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *messages.AsyncSentMsg
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *messages.AsyncSentMsg); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*messages.AsyncSentMsg)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// BulkSecrets provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) BulkSecrets(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.BulkJobSecrets, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.BulkJobSecrets
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.BulkJobSecrets); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.BulkJobSecrets)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// Close provides a mock function with given fields:
+func (_m *IdentityClient) Close() {
+	_m.Called()
+}
+
 // Enroll provides a mock function with given fields: res, req, params
 func (_m *IdentityClient) Enroll(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.IdentityResponse, *util.RestError) {
 	ret := _m.Called(res, req, params)
@@ -43,6 +145,31 @@ func (_m *IdentityClient) Enroll(res http.ResponseWriter, req *http.Request, par
 	return r0, r1
 }
 
+// ExportIdentity provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) ExportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.WalletIdentity, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.WalletIdentity
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.WalletIdentity); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.WalletIdentity)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
 // Get provides a mock function with given fields: res, req, params
 func (_m *IdentityClient) Get(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Identity, *util.RestError) {
 	ret := _m.Called(res, req, params)
@@ -68,6 +195,106 @@ func (_m *IdentityClient) Get(res http.ResponseWriter, req *http.Request, params
 	return r0, r1
 }
 
+// GetAffiliation provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) GetAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.Affiliation
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.Affiliation); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.Affiliation)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// GetCRL provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) GetCRL(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.CRL, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.CRL
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.CRL); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.CRL)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// GetCurrent provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) GetCurrent(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.CurrentIdentity, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.CurrentIdentity
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.CurrentIdentity); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.CurrentIdentity)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// ImportIdentity provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) ImportIdentity(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.WalletIdentity, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.WalletIdentity
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.WalletIdentity); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.WalletIdentity)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
 // List provides a mock function with given fields: res, req, params
 func (_m *IdentityClient) List(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*identity.Identity, *util.RestError) {
 	ret := _m.Called(res, req, params)
@@ -93,6 +320,81 @@ func (_m *IdentityClient) List(res http.ResponseWriter, req *http.Request, param
 	return r0, r1
 }
 
+// ListAffiliations provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) ListAffiliations(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.Affiliation
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.Affiliation); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.Affiliation)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// ListCertificates provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) ListCertificates(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*identity.IssuedCertificate, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 []*identity.IssuedCertificate
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) []*identity.IssuedCertificate); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*identity.IssuedCertificate)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// ListWallet provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) ListWallet(res http.ResponseWriter, req *http.Request, params httprouter.Params) ([]*identity.WalletIdentity, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 []*identity.WalletIdentity
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) []*identity.WalletIdentity); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*identity.WalletIdentity)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
 // Modify provides a mock function with given fields: res, req, params
 func (_m *IdentityClient) Modify(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.RegisterResponse, *util.RestError) {
 	ret := _m.Called(res, req, params)
@@ -118,6 +420,56 @@ func (_m *IdentityClient) Modify(res http.ResponseWriter, req *http.Request, par
 	return r0, r1
 }
 
+// ModifyAffiliation provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) ModifyAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.Affiliation
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.Affiliation); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.Affiliation)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// Reconcile provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) Reconcile(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.ReconcileReport, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.ReconcileReport
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.ReconcileReport); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.ReconcileReport)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
 // Reenroll provides a mock function with given fields: res, req, params
 func (_m *IdentityClient) Reenroll(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.IdentityResponse, *util.RestError) {
 	ret := _m.Called(res, req, params)
@@ -168,6 +520,56 @@ func (_m *IdentityClient) Register(res http.ResponseWriter, req *http.Request, p
 	return r0, r1
 }
 
+// Remove provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) Remove(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.RemoveResponse, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.RemoveResponse
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.RemoveResponse); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.RemoveResponse)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
+// RemoveAffiliation provides a mock function with given fields: res, req, params
+func (_m *IdentityClient) RemoveAffiliation(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.Affiliation, *util.RestError) {
+	ret := _m.Called(res, req, params)
+
+	var r0 *identity.Affiliation
+	if rf, ok := ret.Get(0).(func(http.ResponseWriter, *http.Request, httprouter.Params) *identity.Affiliation); ok {
+		r0 = rf(res, req, params)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*identity.Affiliation)
+		}
+	}
+
+	var r1 *util.RestError
+	if rf, ok := ret.Get(1).(func(http.ResponseWriter, *http.Request, httprouter.Params) *util.RestError); ok {
+		r1 = rf(res, req, params)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*util.RestError)
+		}
+	}
+
+	return r0, r1
+}
+
 // Revoke provides a mock function with given fields: res, req, params
 func (_m *IdentityClient) Revoke(res http.ResponseWriter, req *http.Request, params httprouter.Params) (*identity.RevokeResponse, *util.RestError) {
 	ret := _m.Called(res, req, params)
@@ -192,3 +594,8 @@ func (_m *IdentityClient) Revoke(res http.ResponseWriter, req *http.Request, par
 
 	return r0, r1
 }
+
+// SetReceiptStore provides a mock function with given fields: _a0
+func (_m *IdentityClient) SetReceiptStore(_a0 identity.ReceiptStore) {
+	_m.Called(_a0)
+}
@@ -55,11 +55,39 @@ func (_m *ReceiptStore) Init(_a0 ws.WebSocketChannels, _a1 ...api.ReceiptStorePe
 	return r0
 }
 
+// LookupReceipt provides a mock function with given fields: requestID
+func (_m *ReceiptStore) LookupReceipt(requestID string) (*map[string]interface{}, error) {
+	ret := _m.Called(requestID)
+
+	var r0 *map[string]interface{}
+	if rf, ok := ret.Get(0).(func(string) *map[string]interface{}); ok {
+		r0 = rf(requestID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*map[string]interface{})
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(requestID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ProcessReceipt provides a mock function with given fields: msgBytes
 func (_m *ReceiptStore) ProcessReceipt(msgBytes []byte) {
 	_m.Called(msgBytes)
 }
 
+// UpdateReceipt provides a mock function with given fields: msgBytes
+func (_m *ReceiptStore) UpdateReceipt(msgBytes []byte) {
+	_m.Called(msgBytes)
+}
+
 // ValidateConf provides a mock function with given fields:
 func (_m *ReceiptStore) ValidateConf() error {
 	ret := _m.Called()
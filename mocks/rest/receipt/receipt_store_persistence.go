@@ -88,6 +88,20 @@ func (_m *ReceiptStorePersistence) Init() error {
 	return r0
 }
 
+// UpdateReceipt provides a mock function with given fields: requestID, _a1
+func (_m *ReceiptStorePersistence) UpdateReceipt(requestID string, _a1 *map[string]interface{}) error {
+	ret := _m.Called(requestID, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *map[string]interface{}) error); ok {
+		r0 = rf(requestID, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ValidateConf provides a mock function with given fields:
 func (_m *ReceiptStorePersistence) ValidateConf() error {
 	ret := _m.Called()
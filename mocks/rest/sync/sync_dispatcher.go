@@ -21,6 +21,11 @@ func (_m *SyncDispatcher) DispatchMsgSync(ctx context.Context, res http.Response
 	_m.Called(ctx, res, req, msg)
 }
 
+// EndorseTransaction provides a mock function with given fields: res, req, params
+func (_m *SyncDispatcher) EndorseTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	_m.Called(res, req, params)
+}
+
 // GetBlock provides a mock function with given fields: res, req, params
 func (_m *SyncDispatcher) GetBlock(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	_m.Called(res, req, params)
@@ -41,6 +46,11 @@ func (_m *SyncDispatcher) GetTxById(res http.ResponseWriter, req *http.Request,
 	_m.Called(res, req, params)
 }
 
+// PrepareTransaction provides a mock function with given fields: res, req, params
+func (_m *SyncDispatcher) PrepareTransaction(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	_m.Called(res, req, params)
+}
+
 // QueryChaincode provides a mock function with given fields: res, req, params
 func (_m *SyncDispatcher) QueryChaincode(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	_m.Called(res, req, params)
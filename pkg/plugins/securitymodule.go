@@ -16,6 +16,8 @@
 
 package plugins
 
+import "time"
+
 // EventOperation enumerates operation types on events
 type EventOperation int
 
@@ -38,3 +40,27 @@ type SecurityModule interface {
 	// AuthReadAsyncReplyByUUID - Authorization plugpoint for getting an individual reply by UUID (containing an individual receipt/error)
 	AuthReadAsyncReplyByUUID(authCtx interface{}) error
 }
+
+// Identity can be implemented by the auth context returned from VerifyToken, to identify the caller.
+// The username is used to sign transactions on behalf of the caller, like the preferred_username of a JWT,
+// after the openId.usernameTransforms. The username claim of the Claims takes precedence when implemented
+type Identity interface {
+	// Subject is the unique ID of the caller
+	Subject() string
+	// Username is the name of the caller
+	Username() string
+}
+
+// Expiring can be implemented by the auth context returned from VerifyToken, when the token expires.
+// Long lived connections, such as WebSockets, must present a new token by then
+type Expiring interface {
+	// ExpiresAt is the expiry time of the token
+	ExpiresAt() time.Time
+}
+
+// Claims can be implemented by the auth context returned from VerifyToken, to expose the claims of
+// the token. The roles the identity administration is gated by are read from them
+type Claims interface {
+	// TokenClaims are the claims of the verified token
+	TokenClaims() map[string]interface{}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Kaleido
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Sample security module plugin, built with:
+//
+//	go build -buildmode=plugin -o securitymodule.so ./test/plugins/securitymodule
+//
+// It accepts "sample:<username>" tokens. The "admin" user can do anything, the other
+// users can only make read-only calls, listen on the WebSocket topic named after them
+// and read the receipts
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/firefly-fabconnect/pkg/plugins"
+)
+
+const tokenPrefix = "sample:"
+
+type identity struct {
+	username string
+}
+
+func (i *identity) Subject() string {
+	return "sample|" + i.username
+}
+
+func (i *identity) Username() string {
+	return i.username
+}
+
+type securityModule struct{}
+
+// SecurityModule is the export loaded by fabconnect
+var SecurityModule plugins.SecurityModule = &securityModule{}
+
+func (sm *securityModule) VerifyToken(token string) (interface{}, error) {
+	if !strings.HasPrefix(token, tokenPrefix) || len(token) == len(tokenPrefix) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return &identity{username: strings.TrimPrefix(token, tokenPrefix)}, nil
+}
+
+func (sm *securityModule) AuthRPC(authCtx interface{}, method string, args ...interface{}) error {
+	id, err := toIdentity(authCtx)
+	if err != nil {
+		return err
+	}
+	if id.username == "admin" || strings.HasPrefix(method, "GET ") || method == "POST /query" {
+		return nil
+	}
+	return fmt.Errorf("%s is not allowed for %s", method, id.username)
+}
+
+func (sm *securityModule) AuthRPCSubscribe(authCtx interface{}, namespace string, channel interface{}, args ...interface{}) error {
+	id, err := toIdentity(authCtx)
+	if err != nil {
+		return err
+	}
+	if id.username == "admin" || channel == id.username {
+		return nil
+	}
+	return fmt.Errorf("topic %v is not allowed for %s", channel, id.username)
+}
+
+func (sm *securityModule) AuthEventStreams(authCtx interface{}) error {
+	id, err := toIdentity(authCtx)
+	if err != nil {
+		return err
+	}
+	if id.username == "admin" {
+		return nil
+	}
+	return fmt.Errorf("event streams are not allowed for %s", id.username)
+}
+
+func (sm *securityModule) AuthListAsyncReplies(authCtx interface{}) error {
+	_, err := toIdentity(authCtx)
+	return err
+}
+
+func (sm *securityModule) AuthReadAsyncReplyByUUID(authCtx interface{}) error {
+	_, err := toIdentity(authCtx)
+	return err
+}
+
+func toIdentity(authCtx interface{}) (*identity, error) {
+	id, ok := authCtx.(*identity)
+	if !ok {
+		return nil, fmt.Errorf("unexpected auth context: %v", authCtx)
+	}
+	return id, nil
+}
+
+func main() {}